@@ -15,6 +15,7 @@
 package flow
 
 import (
+	"context"
 	"database/sql"
 	"errors"
 	"math"
@@ -81,14 +82,21 @@ var AccessContexts _AccessContexts
 // New creates a new access context with the globally-unique name
 // given.
 func (_AccessContexts) New(otx *sql.Tx, name string) (AccessContextID, error) {
+	return AccessContexts.NewContext(context.Background(), otx, name)
+}
+
+// NewContext is the same as `New`, but runs its queries under the
+// given context.
+func (_AccessContexts) NewContext(ctx context.Context, otx *sql.Tx, name string) (AccessContextID, error) {
 	name = strings.TrimSpace(name)
 	if name == "" {
 		return 0, errors.New("access context name should be non-empty")
 	}
 
 	var tx *sql.Tx
+	var err error
 	if otx == nil {
-		tx, err := db.Begin()
+		tx, err = db.BeginTx(ctx, nil)
 		if err != nil {
 			return 0, err
 		}
@@ -98,11 +106,7 @@ func (_AccessContexts) New(otx *sql.Tx, name string) (AccessContextID, error) {
 	}
 
 	q := `INSERT INTO wf_access_contexts(name, active) VALUES(?, 1)`
-	res, err := tx.Exec(q, name)
-	if err != nil {
-		return 0, err
-	}
-	acID, err := res.LastInsertId()
+	acID, err := execInsert(ctx, tx, q, name)
 	if err != nil {
 		return 0, err
 	}
@@ -123,6 +127,12 @@ func (_AccessContexts) New(otx *sql.Tx, name string) (AccessContextID, error) {
 // `limit` elements.  A value of `0` for `offset` fetches from the
 // beginning, while a value of `0` for `limit` fetches until the end.
 func (_AccessContexts) List(prefix string, offset, limit int64) ([]*AccessContext, error) {
+	return AccessContexts.ListContext(context.Background(), prefix, offset, limit)
+}
+
+// ListContext is the same as `List`, but runs its queries under the
+// given context.
+func (_AccessContexts) ListContext(ctx context.Context, prefix string, offset, limit int64) ([]*AccessContext, error) {
 	if offset < 0 || limit < 0 {
 		return nil, errors.New("offset and limit should be non-negative integers")
 	}
@@ -142,7 +152,7 @@ func (_AccessContexts) List(prefix string, offset, limit int64) ([]*AccessContex
 		ORDER BY id
 		LIMIT ? OFFSET ?
 		`
-		rows, err = db.Query(q, limit, offset)
+		rows, err = db.QueryContext(ctx, rebind(q), limit, offset)
 	} else {
 		q = `
 		SELECT id, name, active
@@ -151,7 +161,7 @@ func (_AccessContexts) List(prefix string, offset, limit int64) ([]*AccessContex
 		ORDER BY id
 		LIMIT ? OFFSET ?
 		`
-		rows, err = db.Query(q, prefix+"%", limit, offset)
+		rows, err = db.QueryContext(ctx, rebind(q), prefix+"%", limit, offset)
 	}
 
 	if err != nil {
@@ -182,6 +192,12 @@ func (_AccessContexts) List(prefix string, offset, limit int64) ([]*AccessContex
 // `limit` elements.  A value of `0` for `offset` fetches from the
 // beginning, while a value of `0` for `limit` fetches until the end.
 func (_AccessContexts) ListByGroup(gid GroupID, offset, limit int64) ([]*AccessContext, error) {
+	return AccessContexts.ListByGroupContext(context.Background(), gid, offset, limit)
+}
+
+// ListByGroupContext is the same as `ListByGroup`, but runs its
+// queries under the given context.
+func (_AccessContexts) ListByGroupContext(ctx context.Context, gid GroupID, offset, limit int64) ([]*AccessContext, error) {
 	if offset < 0 || limit < 0 {
 		return nil, errors.New("offset and limit should be non-negative integers")
 	}
@@ -197,7 +213,7 @@ func (_AccessContexts) ListByGroup(gid GroupID, offset, limit int64) ([]*AccessC
 	ORDER BY agh.ac_id
 	LIMIT ? OFFSET ?
 	`
-	rows, err := db.Query(q, gid, limit, offset)
+	rows, err := db.QueryContext(ctx, rebind(q), gid, limit, offset)
 	if err != nil {
 		return nil, err
 	}
@@ -226,6 +242,12 @@ func (_AccessContexts) ListByGroup(gid GroupID, offset, limit int64) ([]*AccessC
 // `limit` elements.  A value of `0` for `offset` fetches from the
 // beginning, while a value of `0` for `limit` fetches until the end.
 func (_AccessContexts) ListByUser(uid UserID, offset, limit int64) ([]*AccessContext, error) {
+	return AccessContexts.ListByUserContext(context.Background(), uid, offset, limit)
+}
+
+// ListByUserContext is the same as `ListByUser`, but runs its queries
+// under the given context.
+func (_AccessContexts) ListByUserContext(ctx context.Context, uid UserID, offset, limit int64) ([]*AccessContext, error) {
 	if offset < 0 || limit < 0 {
 		return nil, errors.New("offset and limit should be non-negative integers")
 	}
@@ -247,7 +269,7 @@ func (_AccessContexts) ListByUser(uid UserID, offset, limit int64) ([]*AccessCon
 	ORDER BY agh.ac_id
 	LIMIT ? OFFSET ?
 	`
-	rows, err := db.Query(q, uid, limit, offset)
+	rows, err := db.QueryContext(ctx, rebind(q), uid, limit, offset)
 	if err != nil {
 		return nil, err
 	}
@@ -272,15 +294,24 @@ func (_AccessContexts) ListByUser(uid UserID, offset, limit int64) ([]*AccessCon
 // Get fetches the requested access context that determines how the
 // workflows that operate in its context run.
 func (_AccessContexts) Get(id AccessContextID) (*AccessContext, error) {
+	return AccessContexts.GetContext(context.Background(), id)
+}
+
+// GetContext is the same as `Get`, but runs its queries under the
+// given context.
+func (_AccessContexts) GetContext(ctx context.Context, id AccessContextID) (*AccessContext, error) {
 	q := `
 	SELECT id, name, active
 	FROM wf_access_contexts
 	WHERE id = ?
 	`
-	res := db.QueryRow(q, id)
+	res := db.QueryRowContext(ctx, rebind(q), id)
 	var elem AccessContext
 	err := res.Scan(&elem.ID, &elem.Name, &elem.Active)
 	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrNotFound
+		}
 		return nil, err
 	}
 
@@ -290,14 +321,21 @@ func (_AccessContexts) Get(id AccessContextID) (*AccessContext, error) {
 // Rename changes the name of the given access context to the
 // specified new name.
 func (_AccessContexts) Rename(otx *sql.Tx, id AccessContextID, name string) error {
+	return AccessContexts.RenameContext(context.Background(), otx, id, name)
+}
+
+// RenameContext is the same as `Rename`, but runs its queries under
+// the given context.
+func (_AccessContexts) RenameContext(ctx context.Context, otx *sql.Tx, id AccessContextID, name string) error {
 	name = strings.TrimSpace(name)
 	if name == "" {
 		return errors.New("access context name should be non-empty")
 	}
 
 	var tx *sql.Tx
+	var err error
 	if otx == nil {
-		tx, err := db.Begin()
+		tx, err = db.BeginTx(ctx, nil)
 		if err != nil {
 			return err
 		}
@@ -311,7 +349,7 @@ func (_AccessContexts) Rename(otx *sql.Tx, id AccessContextID, name string) erro
 	SET name = ?
 	WHERE id = ?
 	`
-	_, err := tx.Exec(q, name, id)
+	_, err = tx.ExecContext(ctx, rebind(q), name, id)
 	if err != nil {
 		return err
 	}
@@ -329,14 +367,21 @@ func (_AccessContexts) Rename(otx *sql.Tx, id AccessContextID, name string) erro
 // SetActive updates the given access context with the new active
 // status.
 func (_AccessContexts) SetActive(otx *sql.Tx, id AccessContextID, active bool) error {
+	return AccessContexts.SetActiveContext(context.Background(), otx, id, active)
+}
+
+// SetActiveContext is the same as `SetActive`, but runs its queries
+// under the given context.
+func (_AccessContexts) SetActiveContext(ctx context.Context, otx *sql.Tx, id AccessContextID, active bool) error {
 	act := 0
 	if active {
 		act = 1
 	}
 
 	var tx *sql.Tx
+	var err error
 	if otx == nil {
-		tx, err := db.Begin()
+		tx, err = db.BeginTx(ctx, nil)
 		if err != nil {
 			return err
 		}
@@ -350,7 +395,7 @@ func (_AccessContexts) SetActive(otx *sql.Tx, id AccessContextID, active bool) e
 	SET active = ?
 	WHERE id = ?
 	`
-	_, err := tx.Exec(q, act, id)
+	_, err = tx.ExecContext(ctx, rebind(q), act, id)
 	if err != nil {
 		return err
 	}
@@ -368,6 +413,12 @@ func (_AccessContexts) SetActive(otx *sql.Tx, id AccessContextID, active bool) e
 // GroupRoles retrieves the groups --> roles mapping for this access
 // context.
 func (_AccessContexts) GroupRoles(id AccessContextID, gids []GroupID, offset, limit int64) (map[GroupID]*AcGroupRoles, error) {
+	return AccessContexts.GroupRolesContext(context.Background(), id, gids, offset, limit)
+}
+
+// GroupRolesContext is the same as `GroupRoles`, but runs its queries
+// under the given context.
+func (_AccessContexts) GroupRolesContext(ctx context.Context, id AccessContextID, gids []GroupID, offset, limit int64) (map[GroupID]*AcGroupRoles, error) {
 	if id <= 0 {
 		return nil, errors.New("access context ID should be a positive integer")
 	}
@@ -399,11 +450,11 @@ func (_AccessContexts) GroupRoles(id AccessContextID, gids []GroupID, offset, li
 	ORDER BY agrs.group_id
 	LIMIT ? OFFSET ?
 	`
-	stmt, err := db.Prepare(q)
+	stmt, err := db.PrepareContext(ctx, rebind(q))
 	if err != nil {
 		return nil, err
 	}
-	rows, err := stmt.Query(args...)
+	rows, err := stmt.QueryContext(ctx, args...)
 	if err != nil {
 		return nil, err
 	}
@@ -437,16 +488,66 @@ func (_AccessContexts) GroupRoles(id AccessContextID, gids []GroupID, offset, li
 	return grs, nil
 }
 
+// groupsWithRoles answers the groups that hold any of the given roles
+// in the specified access context, in ascending order.
+func groupsWithRoles(ctx context.Context, otx *sql.Tx, id AccessContextID, roles []RoleID) ([]GroupID, error) {
+	q := `
+	SELECT DISTINCT group_id
+	FROM wf_ac_group_roles
+	WHERE ac_id = ?
+	AND role_id IN (?` + strings.Repeat(",?", len(roles)-1) + `)
+	ORDER BY group_id
+	`
+	args := make([]interface{}, 0, len(roles)+1)
+	args = append(args, id)
+	for _, rid := range roles {
+		args = append(args, rid)
+	}
+
+	var rows *sql.Rows
+	var err error
+	if otx == nil {
+		rows, err = db.QueryContext(ctx, rebind(q), args...)
+	} else {
+		rows, err = otx.QueryContext(ctx, rebind(q), args...)
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	gids := make([]GroupID, 0, 4)
+	for rows.Next() {
+		var gid GroupID
+		if err = rows.Scan(&gid); err != nil {
+			return nil, err
+		}
+		gids = append(gids, gid)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return gids, nil
+}
+
 // AddGroupRole assigns the specified role to the given group, if it
 // is not already assigned.
 func (_AccessContexts) AddGroupRole(otx *sql.Tx, id AccessContextID, gid GroupID, rid RoleID) error {
+	return AccessContexts.AddGroupRoleContext(context.Background(), otx, id, gid, rid)
+}
+
+// AddGroupRoleContext is the same as `AddGroupRole`, but runs its
+// queries under the given context.
+func (_AccessContexts) AddGroupRoleContext(ctx context.Context, otx *sql.Tx, id AccessContextID, gid GroupID, rid RoleID) error {
 	if gid <= 0 || rid <= 0 {
 		return errors.New("group ID and role ID should be positive integers")
 	}
 
 	var tx *sql.Tx
+	var err error
 	if otx == nil {
-		tx, err := db.Begin()
+		tx, err = db.BeginTx(ctx, nil)
 		if err != nil {
 			return err
 		}
@@ -455,7 +556,7 @@ func (_AccessContexts) AddGroupRole(otx *sql.Tx, id AccessContextID, gid GroupID
 		tx = otx
 	}
 
-	_, err := tx.Exec(`INSERT INTO wf_ac_group_roles(ac_id, group_id, role_id) VALUES(?, ?, ?)`, id, gid, rid)
+	_, err = tx.ExecContext(ctx, rebind(`INSERT INTO wf_ac_group_roles(ac_id, group_id, role_id) VALUES(?, ?, ?)`), id, gid, rid)
 	if err != nil {
 		return err
 	}
@@ -472,13 +573,20 @@ func (_AccessContexts) AddGroupRole(otx *sql.Tx, id AccessContextID, gid GroupID
 
 // RemoveGroupRole unassigns the specified role from the given group.
 func (_AccessContexts) RemoveGroupRole(otx *sql.Tx, id AccessContextID, gid GroupID, rid RoleID) error {
+	return AccessContexts.RemoveGroupRoleContext(context.Background(), otx, id, gid, rid)
+}
+
+// RemoveGroupRoleContext is the same as `RemoveGroupRole`, but runs
+// its queries under the given context.
+func (_AccessContexts) RemoveGroupRoleContext(ctx context.Context, otx *sql.Tx, id AccessContextID, gid GroupID, rid RoleID) error {
 	if gid <= 0 || rid <= 0 {
 		return errors.New("group ID and role ID should be positive integers")
 	}
 
 	var tx *sql.Tx
+	var err error
 	if otx == nil {
-		tx, err := db.Begin()
+		tx, err = db.BeginTx(ctx, nil)
 		if err != nil {
 			return err
 		}
@@ -487,7 +595,7 @@ func (_AccessContexts) RemoveGroupRole(otx *sql.Tx, id AccessContextID, gid Grou
 		tx = otx
 	}
 
-	_, err := tx.Exec(`DELETE FROM wf_ac_group_roles WHERE ac_id = ? AND group_id = ? AND role_id = ?`, id, gid, rid)
+	_, err = tx.ExecContext(ctx, rebind(`DELETE FROM wf_ac_group_roles WHERE ac_id = ? AND group_id = ? AND role_id = ?`), id, gid, rid)
 	if err != nil {
 		return err
 	}
@@ -504,6 +612,12 @@ func (_AccessContexts) RemoveGroupRole(otx *sql.Tx, id AccessContextID, gid Grou
 
 // Groups retrieves the users included in this access context.
 func (_AccessContexts) Groups(id AccessContextID, offset, limit int64) (map[GroupID]*AcGroup, error) {
+	return AccessContexts.GroupsContext(context.Background(), id, offset, limit)
+}
+
+// GroupsContext is the same as `Groups`, but runs its queries under
+// the given context.
+func (_AccessContexts) GroupsContext(ctx context.Context, id AccessContextID, offset, limit int64) (map[GroupID]*AcGroup, error) {
 	if offset < 0 || limit < 0 {
 		return nil, errors.New("offset and limit should be non-negative integers")
 	}
@@ -520,7 +634,7 @@ func (_AccessContexts) Groups(id AccessContextID, offset, limit int64) (map[Grou
 	ORDER BY auh.group_id
 	LIMIT ? OFFSET ?
 	`
-	rows, err := db.Query(q, id, limit, offset)
+	rows, err := db.QueryContext(ctx, rebind(q), id, limit, offset)
 	if err != nil {
 		return nil, err
 	}
@@ -547,13 +661,20 @@ func (_AccessContexts) Groups(id AccessContextID, offset, limit int64) (map[Grou
 // specified reporting authority within the hierarchy of this access
 // context.
 func (_AccessContexts) AddGroup(otx *sql.Tx, id AccessContextID, gid, reportsTo GroupID) error {
+	return AccessContexts.AddGroupContext(context.Background(), otx, id, gid, reportsTo)
+}
+
+// AddGroupContext is the same as `AddGroup`, but runs its queries
+// under the given context.
+func (_AccessContexts) AddGroupContext(ctx context.Context, otx *sql.Tx, id AccessContextID, gid, reportsTo GroupID) error {
 	if gid <= 0 || reportsTo < 0 {
 		return errors.New("group ID should be a positive integer; reporting authority ID should be a non-negative integer")
 	}
 
 	var tx *sql.Tx
+	var err error
 	if otx == nil {
-		tx, err := db.Begin()
+		tx, err = db.BeginTx(ctx, nil)
 		if err != nil {
 			return err
 		}
@@ -563,7 +684,7 @@ func (_AccessContexts) AddGroup(otx *sql.Tx, id AccessContextID, gid, reportsTo
 	}
 
 	q := `INSERT INTO wf_ac_group_hierarchy(ac_id, group_id, reports_to) VALUES (?, ?, ?)`
-	_, err := tx.Exec(q, id, gid, reportsTo)
+	_, err = tx.ExecContext(ctx, rebind(q), id, gid, reportsTo)
 	if err != nil {
 		return err
 	}
@@ -580,13 +701,20 @@ func (_AccessContexts) AddGroup(otx *sql.Tx, id AccessContextID, gid, reportsTo
 
 // DeleteGroup removes the given group from this access context.
 func (_AccessContexts) DeleteGroup(otx *sql.Tx, id AccessContextID, gid GroupID) error {
+	return AccessContexts.DeleteGroupContext(context.Background(), otx, id, gid)
+}
+
+// DeleteGroupContext is the same as `DeleteGroup`, but runs its
+// queries under the given context.
+func (_AccessContexts) DeleteGroupContext(ctx context.Context, otx *sql.Tx, id AccessContextID, gid GroupID) error {
 	if gid <= 0 {
 		return errors.New("user ID should be positive integer")
 	}
 
 	var tx *sql.Tx
+	var err error
 	if otx == nil {
-		tx, err := db.Begin()
+		tx, err = db.BeginTx(ctx, nil)
 		if err != nil {
 			return err
 		}
@@ -596,7 +724,7 @@ func (_AccessContexts) DeleteGroup(otx *sql.Tx, id AccessContextID, gid GroupID)
 	}
 
 	q := `DELETE FROM wf_ac_group_hierarchy WHERE ac_id = ? AND group_id = ?`
-	_, err := tx.Exec(q, id, gid)
+	_, err = tx.ExecContext(ctx, rebind(q), id, gid)
 	if err != nil {
 		return err
 	}
@@ -614,13 +742,19 @@ func (_AccessContexts) DeleteGroup(otx *sql.Tx, id AccessContextID, gid GroupID)
 // GroupReportsTo answers the group to whom the given group reports to,
 // within this access context.
 func (_AccessContexts) GroupReportsTo(id AccessContextID, uid GroupID) (GroupID, error) {
+	return AccessContexts.GroupReportsToContext(context.Background(), id, uid)
+}
+
+// GroupReportsToContext is the same as `GroupReportsTo`, but runs its
+// queries under the given context.
+func (_AccessContexts) GroupReportsToContext(ctx context.Context, id AccessContextID, uid GroupID) (GroupID, error) {
 	q := `
 	SELECT reports_to
 	FROM wf_ac_group_hierarchy
 	WHERE ac_id = ?
 	AND group_id = ?
 	`
-	row := db.QueryRow(q, id, uid)
+	row := db.QueryRowContext(ctx, rebind(q), id, uid)
 	var repID int64
 	err := row.Scan(&repID)
 	if err != nil {
@@ -633,13 +767,19 @@ func (_AccessContexts) GroupReportsTo(id AccessContextID, uid GroupID) (GroupID,
 // GroupReportees answers a list of the groups who report to the given
 // group, within this access context.
 func (_AccessContexts) GroupReportees(id AccessContextID, uid GroupID) ([]GroupID, error) {
+	return AccessContexts.GroupReporteesContext(context.Background(), id, uid)
+}
+
+// GroupReporteesContext is the same as `GroupReportees`, but runs its
+// queries under the given context.
+func (_AccessContexts) GroupReporteesContext(ctx context.Context, id AccessContextID, uid GroupID) ([]GroupID, error) {
 	q := `
 	SELECT group_id
 	FROM wf_ac_group_hierarchy
 	WHERE ac_id = ?
 	AND reports_to = ?
 	`
-	rows, err := db.Query(q, id, uid)
+	rows, err := db.QueryContext(ctx, rebind(q), id, uid)
 	if err != nil {
 		return nil, err
 	}
@@ -664,13 +804,20 @@ func (_AccessContexts) GroupReportees(id AccessContextID, uid GroupID) ([]GroupI
 // ChangeReporting reassigns the group to a different reporting
 // authority.
 func (_AccessContexts) ChangeReporting(otx *sql.Tx, id AccessContextID, gid, reportsTo GroupID) error {
+	return AccessContexts.ChangeReportingContext(context.Background(), otx, id, gid, reportsTo)
+}
+
+// ChangeReportingContext is the same as `ChangeReporting`, but runs
+// its queries under the given context.
+func (_AccessContexts) ChangeReportingContext(ctx context.Context, otx *sql.Tx, id AccessContextID, gid, reportsTo GroupID) error {
 	if gid <= 0 || reportsTo < 0 {
 		return errors.New("group ID should be positive integer; reporting authority ID should be a non-negative integer")
 	}
 
 	var tx *sql.Tx
+	var err error
 	if otx == nil {
-		tx, err := db.Begin()
+		tx, err = db.BeginTx(ctx, nil)
 		if err != nil {
 			return err
 		}
@@ -685,7 +832,7 @@ func (_AccessContexts) ChangeReporting(otx *sql.Tx, id AccessContextID, gid, rep
 	WHERE ac_id = ?
 	AND group_id = ?
 	`
-	_, err := tx.Exec(q, reportsTo, id, gid)
+	_, err = tx.ExecContext(ctx, rebind(q), reportsTo, id, gid)
 	if err != nil {
 		return err
 	}
@@ -703,6 +850,12 @@ func (_AccessContexts) ChangeReporting(otx *sql.Tx, id AccessContextID, gid, rep
 // IncludesGroup answers `true` if the given group is included in this
 // access context.
 func (_AccessContexts) IncludesGroup(id AccessContextID, gid GroupID) (bool, error) {
+	return AccessContexts.IncludesGroupContext(context.Background(), id, gid)
+}
+
+// IncludesGroupContext is the same as `IncludesGroup`, but runs its
+// queries under the given context.
+func (_AccessContexts) IncludesGroupContext(ctx context.Context, id AccessContextID, gid GroupID) (bool, error) {
 	if gid <= 0 {
 		return false, errors.New("group ID should be a positive integer")
 	}
@@ -714,7 +867,7 @@ func (_AccessContexts) IncludesGroup(id AccessContextID, gid GroupID) (bool, err
 	AND group_id = ?
 	`
 	var repTo int64
-	row := db.QueryRow(q, id, gid)
+	row := db.QueryRowContext(ctx, rebind(q), id, gid)
 	err := row.Scan(&repTo)
 	if err != nil {
 		if err == sql.ErrNoRows {
@@ -729,6 +882,12 @@ func (_AccessContexts) IncludesGroup(id AccessContextID, gid GroupID) (bool, err
 // IncludesUser answers `true` if the given user is included in this
 // access context.
 func (_AccessContexts) IncludesUser(id AccessContextID, uid UserID) (bool, error) {
+	return AccessContexts.IncludesUserContext(context.Background(), id, uid)
+}
+
+// IncludesUserContext is the same as `IncludesUser`, but runs its
+// queries under the given context.
+func (_AccessContexts) IncludesUserContext(ctx context.Context, id AccessContextID, uid UserID) (bool, error) {
 	if uid <= 0 {
 		return false, errors.New("user ID should be a positive integer")
 	}
@@ -745,7 +904,7 @@ func (_AccessContexts) IncludesUser(id AccessContextID, uid UserID) (bool, error
 	)
 	`
 	var count int64
-	row := db.QueryRow(q, id, uid)
+	row := db.QueryRowContext(ctx, rebind(q), id, uid)
 	err := row.Scan(&count)
 	if err != nil {
 		return false, err
@@ -761,6 +920,12 @@ func (_AccessContexts) IncludesUser(id AccessContextID, uid UserID) (bool, error
 // UserPermissions answers a list of the permissions available to the
 // given user in this access context.
 func (_AccessContexts) UserPermissions(id AccessContextID, uid UserID) (map[DocTypeID][]DocAction, error) {
+	return AccessContexts.UserPermissionsContext(context.Background(), id, uid)
+}
+
+// UserPermissionsContext is the same as `UserPermissions`, but runs
+// its queries under the given context.
+func (_AccessContexts) UserPermissionsContext(ctx context.Context, id AccessContextID, uid UserID) (map[DocTypeID][]DocAction, error) {
 	if uid <= 0 {
 		return nil, errors.New("user ID should be a positive integer")
 	}
@@ -772,7 +937,7 @@ func (_AccessContexts) UserPermissions(id AccessContextID, uid UserID) (map[DocT
 	WHERE acpv.ac_id = ?
 	AND acpv.user_id = ?
 	`
-	rows, err := db.Query(q, id, uid)
+	rows, err := db.QueryContext(ctx, rebind(q), id, uid)
 	if err != nil {
 		return nil, err
 	}
@@ -805,6 +970,13 @@ func (_AccessContexts) UserPermissions(id AccessContextID, uid UserID) (map[DocT
 // available on the given document type, to the given user, in this
 // access context.
 func (_AccessContexts) UserPermissionsByDocType(id AccessContextID, dtype DocTypeID, uid UserID) ([]DocAction, error) {
+	return AccessContexts.UserPermissionsByDocTypeContext(context.Background(), id, dtype, uid)
+}
+
+// UserPermissionsByDocTypeContext is the same as
+// `UserPermissionsByDocType`, but runs its queries under the given
+// context.
+func (_AccessContexts) UserPermissionsByDocTypeContext(ctx context.Context, id AccessContextID, dtype DocTypeID, uid UserID) ([]DocAction, error) {
 	if id <= 0 || dtype <= 0 || uid <= 0 {
 		return nil, errors.New("all identifiers should be positive integers")
 	}
@@ -817,7 +989,7 @@ func (_AccessContexts) UserPermissionsByDocType(id AccessContextID, dtype DocTyp
 	AND acpv.doctype_id = ?
 	AND acpv.user_id = ?
 	`
-	rows, err := db.Query(q, id, dtype, uid)
+	rows, err := db.QueryContext(ctx, rebind(q), id, dtype, uid)
 	if err != nil {
 		return nil, err
 	}
@@ -843,6 +1015,12 @@ func (_AccessContexts) UserPermissionsByDocType(id AccessContextID, dtype DocTyp
 // GroupPermissions answers a list of the permissions available to the
 // given user in this access context.
 func (_AccessContexts) GroupPermissions(id AccessContextID, gid GroupID) (map[DocTypeID][]DocAction, error) {
+	return AccessContexts.GroupPermissionsContext(context.Background(), id, gid)
+}
+
+// GroupPermissionsContext is the same as `GroupPermissions`, but runs
+// its queries under the given context.
+func (_AccessContexts) GroupPermissionsContext(ctx context.Context, id AccessContextID, gid GroupID) (map[DocTypeID][]DocAction, error) {
 	if gid <= 0 {
 		return nil, errors.New("group ID should be a positive integer")
 	}
@@ -854,7 +1032,7 @@ func (_AccessContexts) GroupPermissions(id AccessContextID, gid GroupID) (map[Do
 	WHERE acpv.ac_id = ?
 	AND acpv.group_id = ?
 	`
-	rows, err := db.Query(q, id, gid)
+	rows, err := db.QueryContext(ctx, rebind(q), id, gid)
 	if err != nil {
 		return nil, err
 	}
@@ -887,6 +1065,13 @@ func (_AccessContexts) GroupPermissions(id AccessContextID, gid GroupID) (map[Do
 // available on the given document type, to the given user, in this
 // access context.
 func (_AccessContexts) GroupPermissionsByDocType(id AccessContextID, dtype DocTypeID, gid GroupID) ([]DocAction, error) {
+	return AccessContexts.GroupPermissionsByDocTypeContext(context.Background(), id, dtype, gid)
+}
+
+// GroupPermissionsByDocTypeContext is the same as
+// `GroupPermissionsByDocType`, but runs its queries under the given
+// context.
+func (_AccessContexts) GroupPermissionsByDocTypeContext(ctx context.Context, id AccessContextID, dtype DocTypeID, gid GroupID) ([]DocAction, error) {
 	if id <= 0 || dtype <= 0 || gid <= 0 {
 		return nil, errors.New("all identifiers should be positive integers")
 	}
@@ -899,7 +1084,7 @@ func (_AccessContexts) GroupPermissionsByDocType(id AccessContextID, dtype DocTy
 	AND acpv.doctype_id = ?
 	AND acpv.group_id = ?
 	`
-	rows, err := db.Query(q, id, dtype, gid)
+	rows, err := db.QueryContext(ctx, rebind(q), id, dtype, gid)
 	if err != nil {
 		return nil, err
 	}
@@ -926,6 +1111,12 @@ func (_AccessContexts) GroupPermissionsByDocType(id AccessContextID, dtype DocTy
 // requested action enabled on the specified document type; `false`
 // otherwise.
 func (_AccessContexts) UserHasPermission(id AccessContextID, uid UserID, dtype DocTypeID, action DocActionID) (bool, error) {
+	return AccessContexts.UserHasPermissionContext(context.Background(), id, uid, dtype, action)
+}
+
+// UserHasPermissionContext is the same as `UserHasPermission`, but
+// runs its queries under the given context.
+func (_AccessContexts) UserHasPermissionContext(ctx context.Context, id AccessContextID, uid UserID, dtype DocTypeID, action DocActionID) (bool, error) {
 	if uid <= 0 || dtype <= 0 || action <= 0 {
 		return false, errors.New("invalid user ID or document type or document action")
 	}
@@ -938,7 +1129,7 @@ func (_AccessContexts) UserHasPermission(id AccessContextID, uid UserID, dtype D
 	AND docaction_id = ?
 	LIMIT 1
 	`
-	row := db.QueryRow(q, id, uid, dtype, action)
+	row := db.QueryRowContext(ctx, rebind(q), id, uid, dtype, action)
 	var roleID int64
 	err := row.Scan(&roleID)
 	if err != nil {
@@ -966,7 +1157,7 @@ func (ac *AccessContext) GroupHasPermission(id AccessContextID, gid GroupID, dty
 	AND docaction_id = ?
 	LIMIT 1
 	`
-	row := db.QueryRow(q, id, gid, dtype, action)
+	row := db.QueryRow(rebind(q), id, gid, dtype, action)
 	var roleID int64
 	err := row.Scan(&roleID)
 	if err != nil {
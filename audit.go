@@ -0,0 +1,139 @@
+// (c) Copyright 2015-2017 JONNALAGADDA Srinivas
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package flow
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"time"
+)
+
+// AuditEntry records a single application of an event to a document,
+// that resulted in a state transition.
+//
+// Entries of a document form a hash chain : each entry's hash covers
+// its own contents and the hash of the document's preceding entry.
+// Altering or removing an entry, therefore, breaks the chain.
+type AuditEntry struct {
+	ID       int64       `json:"ID"`       // Unique identifier of this entry
+	Event    DocEventID  `json:"Event"`    // Event that was applied
+	DocType  DocTypeID   `json:"DocType"`  // Type of the document
+	DocID    DocumentID  `json:"DocID"`    // Document that transitioned
+	From     DocStateID  `json:"From"`     // State before the event
+	To       DocStateID  `json:"To"`       // State after the event
+	Action   DocActionID `json:"Action"`   // Action of the event
+	Actor    GroupID     `json:"Actor"`    // Group (singleton) who caused the event
+	Ctime    time.Time   `json:"Ctime"`    // Time of application
+	PrevHash string      `json:"PrevHash"` // Hash of the preceding entry of this document, if any
+	Hash     string      `json:"Hash"`     // Hash of this entry
+}
+
+// computeHash answers the hash of this entry's contents, chained to
+// its preceding entry.
+func (e *AuditEntry) computeHash() string {
+	s := fmt.Sprintf("%s|%d|%d|%d|%d|%d|%d|%d|%d", e.PrevHash, e.Event, e.DocType, e.DocID,
+		e.From, e.To, e.Action, e.Actor, e.Ctime.Unix())
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
+
+// writeAudit records the application of the given event, in the
+// given transaction.
+func writeAudit(ctx context.Context, otx *sql.Tx, event *DocEvent, tstate DocStateID) error {
+	e := AuditEntry{
+		Event:   event.ID,
+		DocType: event.DocType,
+		DocID:   event.DocID,
+		From:    event.State,
+		To:      tstate,
+		Action:  event.Action,
+		Actor:   event.Group,
+		Ctime:   time.Now().UTC().Truncate(time.Second),
+	}
+
+	q := `
+	SELECT hash
+	FROM wf_audit_log
+	WHERE doctype_id = ?
+	AND doc_id = ?
+	ORDER BY id DESC
+	LIMIT 1
+	`
+	err := otx.QueryRowContext(ctx, rebind(q), e.DocType, e.DocID).Scan(&e.PrevHash)
+	if err != nil && err != sql.ErrNoRows {
+		return err
+	}
+	e.Hash = e.computeHash()
+
+	q = `
+	INSERT INTO wf_audit_log(docevent_id, doctype_id, doc_id, from_state_id, to_state_id, docaction_id, group_id, ctime, prev_hash, hash)
+	VALUES(?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`
+	_, err = otx.ExecContext(ctx, rebind(q), e.Event, e.DocType, e.DocID, e.From, e.To, e.Action, e.Actor, e.Ctime, e.PrevHash, e.Hash)
+	return err
+}
+
+// AuditTrail answers the audit entries of the given document, in
+// chronological order.
+//
+// The hash chain of the entries is verified.  Should it be broken,
+// the entries are answered together with `ErrAuditTrailTampered`.
+func (_Workflows) AuditTrail(dtype DocTypeID, doc DocumentID) ([]*AuditEntry, error) {
+	return Workflows.AuditTrailContext(context.Background(), dtype, doc)
+}
+
+// AuditTrailContext is the same as `AuditTrail`, but runs its queries
+// under the given context.
+func (_Workflows) AuditTrailContext(ctx context.Context, dtype DocTypeID, doc DocumentID) ([]*AuditEntry, error) {
+	q := `
+	SELECT id, docevent_id, doctype_id, doc_id, from_state_id, to_state_id, docaction_id, group_id, ctime, prev_hash, hash
+	FROM wf_audit_log
+	WHERE doctype_id = ?
+	AND doc_id = ?
+	ORDER BY id
+	`
+	rows, err := db.QueryContext(ctx, rebind(q), dtype, doc)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ary := make([]*AuditEntry, 0, 10)
+	for rows.Next() {
+		var elem AuditEntry
+		err = rows.Scan(&elem.ID, &elem.Event, &elem.DocType, &elem.DocID, &elem.From, &elem.To,
+			&elem.Action, &elem.Actor, &elem.Ctime, &elem.PrevHash, &elem.Hash)
+		if err != nil {
+			return nil, err
+		}
+		ary = append(ary, &elem)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	prev := ""
+	for _, e := range ary {
+		if e.PrevHash != prev || e.computeHash() != e.Hash {
+			return ary, ErrAuditTrailTampered
+		}
+		prev = e.Hash
+	}
+
+	return ary, nil
+}
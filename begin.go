@@ -0,0 +1,96 @@
+// (c) Copyright 2015-2017 JONNALAGADDA Srinivas
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package flow
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sync"
+)
+
+// BeginSelector answers the state in which a new document should
+// begin, among the states of its workflow -- depending on the
+// document's origin, for instance.  It is invoked in the transaction
+// that creates the document, after the document is inserted, so that
+// it can read the document's data.  Answering `0` begins the document
+// in the workflow's own begin state, while answering an error fails
+// the creation.
+type BeginSelector func(tx *sql.Tx, doc DocumentID) (DocStateID, error)
+
+// selectors holds the registered begin selectors, by workflow.
+var selectors struct {
+	sync.RWMutex
+	m map[WorkflowID]BeginSelector
+}
+
+// RegisterBeginSelector associates the given selector with the given
+// workflow version.  A `nil` selector removes any that is registered.
+//
+// Root documents created under this version begin in the state that
+// the selector answers, which must have a node in this version.
+// Without a selector, documents begin in the workflow's begin state.
+func (_Workflows) RegisterBeginSelector(wid WorkflowID, fn BeginSelector) {
+	selectors.Lock()
+	defer selectors.Unlock()
+
+	if fn == nil {
+		delete(selectors.m, wid)
+		return
+	}
+	if selectors.m == nil {
+		selectors.m = make(map[WorkflowID]BeginSelector)
+	}
+	selectors.m[wid] = fn
+}
+
+// selectBeginState consults the selector registered for the given
+// workflow, if any, and moves the given new document into the state
+// that it answers.  The state in which the document begins is
+// answered; it is `bstate` in the absence of a selector.
+func selectBeginState(ctx context.Context, tx *sql.Tx, wid WorkflowID, dtype DocTypeID, doc DocumentID, bstate DocStateID) (DocStateID, error) {
+	selectors.RLock()
+	fn := selectors.m[wid]
+	selectors.RUnlock()
+
+	if fn == nil {
+		return bstate, nil
+	}
+	ds, err := fn(tx, doc)
+	if err != nil {
+		return 0, err
+	}
+	if ds == 0 || ds == bstate {
+		return bstate, nil
+	}
+
+	var n int64
+	q := `SELECT COUNT(*) FROM wf_workflow_nodes WHERE workflow_id = ? AND docstate_id = ?`
+	err = tx.QueryRowContext(ctx, rebind(q), wid, ds).Scan(&n)
+	if err != nil {
+		return 0, err
+	}
+	if n == 0 {
+		return 0, fmt.Errorf("selected begin state %d has no node in workflow %d", ds, wid)
+	}
+
+	q = `UPDATE ` + DocTypes.docStorName(dtype) + ` SET docstate_id = ? WHERE id = ?`
+	_, err = tx.ExecContext(ctx, rebind(q), ds, doc)
+	if err != nil {
+		return 0, err
+	}
+
+	return ds, nil
+}
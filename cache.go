@@ -0,0 +1,197 @@
+// (c) Copyright 2015-2017 JONNALAGADDA Srinivas
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package flow
+
+import (
+	"container/list"
+	"database/sql"
+	"sync"
+)
+
+// nodeKey identifies the node of a workflow version that handles a
+// document state.
+type nodeKey struct {
+	wid   WorkflowID
+	state DocStateID
+}
+
+// nodeLRU is a bounded cache of nodes, by workflow version and state.
+// The least recently used node is evicted when it is full.
+//
+// Nodes are held by value, and a fresh copy is answered upon each
+// hit, so that callers cannot alter the cached nodes.
+//
+// A workflow version whose nodes a transaction is altering is held :
+// its nodes are neither answered from, nor added to, the cache until
+// the transaction ends.  Each discard advances the generation of the
+// cache, so that a node read before the discard is not added after it.
+type nodeLRU struct {
+	sync.Mutex
+	size int
+	ll   *list.List // Of `*Node`, most recently used first
+	m    map[nodeKey]*list.Element
+	gen  uint64             // Advanced upon each discard
+	held map[WorkflowID]int // Count of the transactions altering each version
+}
+
+// nodeCache is the node cache of the installed engine; it is `nil` unless
+// enabled through `WithNodeCache`.
+var nodeCache *nodeLRU
+
+// newNodeLRU answers a cache of the given size, or `nil` for a size
+// that is not positive.
+func newNodeLRU(size int) *nodeLRU {
+	if size <= 0 {
+		return nil
+	}
+	return &nodeLRU{size: size, ll: list.New(), m: make(map[nodeKey]*list.Element, size), held: make(map[WorkflowID]int)}
+}
+
+// get answers a copy of the cached node of the given workflow version
+// that handles the given state, if any.  Upon a miss, the generation
+// answered should be given to `put` together with the node read.
+func (c *nodeLRU) get(wid WorkflowID, state DocStateID) (*Node, uint64, bool) {
+	if c == nil {
+		return nil, 0, false
+	}
+
+	c.Lock()
+	defer c.Unlock()
+
+	el, ok := c.m[nodeKey{wid, state}]
+	if !ok {
+		return nil, c.gen, false
+	}
+	c.ll.MoveToFront(el)
+	n := *el.Value.(*Node)
+	return &n, c.gen, true
+}
+
+// isHeld answers `true` if a transaction is altering the nodes of the
+// given workflow version.
+func (c *nodeLRU) isHeld(wid WorkflowID) bool {
+	if c == nil {
+		return false
+	}
+
+	c.Lock()
+	defer c.Unlock()
+
+	return c.held[wid] > 0
+}
+
+// put caches a copy of the given node, read at the given generation,
+// evicting the least recently used node if the cache is full.  The
+// node is not cached if its workflow version is held, or if nodes
+// have been discarded since it was read.
+func (c *nodeLRU) put(n *Node, gen uint64) {
+	if c == nil {
+		return
+	}
+
+	c.Lock()
+	defer c.Unlock()
+
+	if gen != c.gen || c.held[n.Wflow] > 0 {
+		return
+	}
+	k := nodeKey{n.Wflow, n.State}
+	cp := *n
+	if el, ok := c.m[k]; ok {
+		el.Value = &cp
+		c.ll.MoveToFront(el)
+		return
+	}
+	c.m[k] = c.ll.PushFront(&cp)
+	if c.ll.Len() > c.size {
+		el := c.ll.Back()
+		c.ll.Remove(el)
+		old := el.Value.(*Node)
+		delete(c.m, nodeKey{old.Wflow, old.State})
+	}
+}
+
+// hold discards the cached nodes of the given workflow version, and
+// keeps them out of the cache until a matching `release`.
+func (c *nodeLRU) hold(wid WorkflowID) {
+	if c == nil {
+		return
+	}
+
+	c.Lock()
+	defer c.Unlock()
+
+	c.held[wid]++
+	c.discard(wid)
+}
+
+// release undoes a `hold` of the given workflow version, once the
+// transaction altering its nodes has ended.  Its nodes are discarded
+// again, since they may have been changed.
+func (c *nodeLRU) release(wid WorkflowID) {
+	if c == nil {
+		return
+	}
+
+	c.Lock()
+	defer c.Unlock()
+
+	if c.held[wid] > 1 {
+		c.held[wid]--
+	} else {
+		delete(c.held, wid)
+	}
+	c.discard(wid)
+}
+
+// invalidate removes the cached nodes of the given workflow version.
+func (c *nodeLRU) invalidate(wid WorkflowID) {
+	if c == nil {
+		return
+	}
+
+	c.Lock()
+	defer c.Unlock()
+
+	c.discard(wid)
+}
+
+// discard removes the cached nodes of the given workflow version, and
+// advances the generation.  The caller should hold the lock.
+func (c *nodeLRU) discard(wid WorkflowID) {
+	c.gen++
+	for k, el := range c.m {
+		if k.wid == wid {
+			c.ll.Remove(el)
+			delete(c.m, k)
+		}
+	}
+}
+
+// alterNodes holds the cached nodes of the given workflow version
+// while a transaction alters them, and answers a function that
+// releases them.  With no transaction given, the caller should defer
+// that function past its own commit.  A given transaction releases
+// them when it ends, if it was begun through `WithTx`; other such
+// transactions are beyond our view, and the version stays held.
+func alterNodes(otx *sql.Tx, wid WorkflowID) func() {
+	nodeCache.hold(wid)
+	if otx == nil {
+		return func() { nodeCache.release(wid) }
+	}
+
+	afterTx(otx, func(bool) { nodeCache.release(wid) })
+	return func() {}
+}
@@ -0,0 +1,341 @@
+// (c) Copyright 2015-2017 JONNALAGADDA Srinivas
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package flow
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"sort"
+)
+
+// DefinitionVersion is the version of the format of exported workflow
+// definitions produced by this package.
+const DefinitionVersion = 1
+
+// DefinitionRef refers to a master entity -- document type, state,
+// action or access context -- in an exported workflow definition.
+// Both the identifier and the name are recorded, so that the
+// definition can be imported either as-is, or by name into a
+// different database.
+type DefinitionRef struct {
+	ID   int64  `json:"ID"`
+	Name string `json:"Name"`
+}
+
+// NodeDefinition is the exported form of a workflow node.
+type NodeDefinition struct {
+	Name          string         `json:"Name"`
+	State         DefinitionRef  `json:"DocState"`
+	NodeType      NodeType       `json:"NodeType"`
+	AccessContext *DefinitionRef `json:"AccessContext,omitempty"`
+}
+
+// TransitionDefinition is the exported form of a single state
+// transition of a workflow.
+type TransitionDefinition struct {
+	From   DefinitionRef `json:"From"`
+	Action DefinitionRef `json:"Action"`
+	To     DefinitionRef `json:"To"`
+}
+
+// WorkflowDefinition is the portable form of a workflow : its primary
+// information, its nodes and its state transitions.
+//
+// Nodes are ordered by name, and transitions by the names of their
+// source states and actions, so that the exported form of an
+// unchanged workflow is stable.
+type WorkflowDefinition struct {
+	Version     int                    `json:"Version"`
+	Name        string                 `json:"Name"`
+	DocType     DefinitionRef          `json:"DocType"`
+	BeginState  DefinitionRef          `json:"BeginState"`
+	Active      bool                   `json:"Active"`
+	Nodes       []NodeDefinition       `json:"Nodes"`
+	Transitions []TransitionDefinition `json:"Transitions"`
+}
+
+// Export answers the definition of the given workflow, including its
+// nodes and transitions, as an indented JSON document.  The output is
+// deterministic, and hence suitable for keeping under version control.
+func (_Workflows) Export(wid WorkflowID) ([]byte, error) {
+	return Workflows.ExportContext(context.Background(), wid)
+}
+
+// ExportContext is the same as `Export`, but runs its queries under
+// the given context.
+func (_Workflows) ExportContext(ctx context.Context, wid WorkflowID) ([]byte, error) {
+	def, err := Workflows.definition(ctx, wid)
+	if err != nil {
+		return nil, err
+	}
+
+	return json.MarshalIndent(def, "", "  ")
+}
+
+// definition assembles the portable definition of the given workflow.
+func (_Workflows) definition(ctx context.Context, wid WorkflowID) (*WorkflowDefinition, error) {
+	wf, err := Workflows.GetContext(ctx, wid)
+	if err != nil {
+		return nil, err
+	}
+
+	def := &WorkflowDefinition{
+		Version:     DefinitionVersion,
+		Name:        wf.Name,
+		DocType:     DefinitionRef{ID: int64(wf.DocType.ID), Name: wf.DocType.Name},
+		BeginState:  DefinitionRef{ID: int64(wf.BeginState.ID), Name: wf.BeginState.Name},
+		Active:      wf.Active,
+		Nodes:       []NodeDefinition{},
+		Transitions: []TransitionDefinition{},
+	}
+
+	q := `
+	SELECT wn.name, wn.type, dsm.id, dsm.name, ac.id, ac.name
+	FROM wf_workflow_nodes wn
+	JOIN wf_docstates_master dsm ON dsm.id = wn.docstate_id
+	LEFT JOIN wf_access_contexts ac ON ac.id = wn.ac_id
+	WHERE wn.workflow_id = ?
+	`
+	rows, err := db.QueryContext(ctx, rebind(q), wid)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var elem NodeDefinition
+		var acID sql.NullInt64
+		var acName sql.NullString
+		err = rows.Scan(&elem.Name, &elem.NodeType, &elem.State.ID, &elem.State.Name, &acID, &acName)
+		if err != nil {
+			return nil, err
+		}
+		if acID.Valid {
+			elem.AccessContext = &DefinitionRef{ID: acID.Int64, Name: acName.String}
+		}
+		def.Nodes = append(def.Nodes, elem)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	q = `
+	SELECT dsm1.id, dsm1.name, dam.id, dam.name, dsm2.id, dsm2.name
+	FROM wf_docstate_transitions dst
+	JOIN wf_docstates_master dsm1 ON dsm1.id = dst.from_state_id
+	JOIN wf_docactions_master dam ON dam.id = dst.docaction_id
+	JOIN wf_docstates_master dsm2 ON dsm2.id = dst.to_state_id
+	WHERE dst.doctype_id = ?
+	AND dst.version = ?
+	`
+	trows, err := db.QueryContext(ctx, rebind(q), wf.DocType.ID, wf.Version)
+	if err != nil {
+		return nil, err
+	}
+	defer trows.Close()
+
+	for trows.Next() {
+		var elem TransitionDefinition
+		err = trows.Scan(&elem.From.ID, &elem.From.Name, &elem.Action.ID, &elem.Action.Name, &elem.To.ID, &elem.To.Name)
+		if err != nil {
+			return nil, err
+		}
+		def.Transitions = append(def.Transitions, elem)
+	}
+	if err = trows.Err(); err != nil {
+		return nil, err
+	}
+
+	def.sort()
+	return def, nil
+}
+
+// sort orders the nodes and transitions of this definition by name.
+func (def *WorkflowDefinition) sort() {
+	sort.Slice(def.Nodes, func(i, j int) bool {
+		return def.Nodes[i].Name < def.Nodes[j].Name
+	})
+	sort.Slice(def.Transitions, func(i, j int) bool {
+		ti, tj := def.Transitions[i], def.Transitions[j]
+		if ti.From.Name != tj.From.Name {
+			return ti.From.Name < tj.From.Name
+		}
+		return ti.Action.Name < tj.Action.Name
+	})
+}
+
+// Import creates a new workflow from the given definition, as
+// produced by `Export`.  The workflow, its nodes and any transitions
+// not already defined for its document type are all inserted in a
+// single transaction; nothing is committed if any part fails.
+//
+// The document type, states, actions and access contexts referenced
+// in the definition must already exist, with the recorded
+// identifiers.  Use `ImportByName` to resolve them by name instead.
+func (_Workflows) Import(otx *sql.Tx, data []byte) (WorkflowID, error) {
+	return Workflows.ImportContext(context.Background(), otx, data, false)
+}
+
+// ImportByName is the same as `Import`, except that the referenced
+// document type, states, actions and access contexts are looked up by
+// their names.  This allows a definition exported from one database to
+// be imported into another, where identifiers may differ.
+func (_Workflows) ImportByName(otx *sql.Tx, data []byte) (WorkflowID, error) {
+	return Workflows.ImportContext(context.Background(), otx, data, true)
+}
+
+// ImportContext is the same as `Import` -- or `ImportByName`, when
+// `byName` is `true` -- but runs its queries under the given context.
+func (_Workflows) ImportContext(ctx context.Context, otx *sql.Tx, data []byte, byName bool) (WorkflowID, error) {
+	var def WorkflowDefinition
+	err := json.Unmarshal(data, &def)
+	if err != nil {
+		return 0, err
+	}
+	if def.Version != DefinitionVersion {
+		return 0, fmt.Errorf("unsupported workflow definition version : %d", def.Version)
+	}
+
+	var tx *sql.Tx
+	if otx == nil {
+		tx, err = db.BeginTx(ctx, nil)
+		if err != nil {
+			return 0, err
+		}
+		defer tx.Rollback()
+	} else {
+		tx = otx
+	}
+
+	r := &defResolver{ctx: ctx, tx: tx, byName: byName}
+	dtype := DocTypeID(r.resolve("wf_doctypes_master", "document type", def.DocType))
+	bstate := DocStateID(r.resolve("wf_docstates_master", "document state", def.BeginState))
+	for i := range def.Nodes {
+		n := &def.Nodes[i]
+		n.State.ID = r.resolve("wf_docstates_master", "document state", n.State)
+		if n.AccessContext != nil {
+			n.AccessContext.ID = r.resolve("wf_access_contexts", "access context", *n.AccessContext)
+		}
+	}
+	for i := range def.Transitions {
+		t := &def.Transitions[i]
+		t.From.ID = r.resolve("wf_docstates_master", "document state", t.From)
+		t.Action.ID = r.resolve("wf_docactions_master", "document action", t.Action)
+		t.To.ID = r.resolve("wf_docstates_master", "document state", t.To)
+	}
+	if r.err != nil {
+		return 0, r.err
+	}
+
+	wid, err := Workflows.NewContext(ctx, tx, def.Name, dtype, bstate)
+	if err != nil {
+		return 0, err
+	}
+	if !def.Active {
+		err = Workflows.SetActiveContext(ctx, tx, wid, false)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	for _, n := range def.Nodes {
+		var ac AccessContextID
+		if n.AccessContext != nil {
+			ac = AccessContextID(n.AccessContext.ID)
+		}
+		_, err = addNode(ctx, tx, dtype, DocStateID(n.State.ID), ac, wid, n.Name, n.NodeType)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	// Transitions defined on the document type before its workflow
+	// may already be present in the target database.
+	version, err := versionOf(ctx, tx, wid)
+	if err != nil {
+		return 0, err
+	}
+	q := `
+	SELECT COUNT(*) FROM wf_docstate_transitions
+	WHERE doctype_id = ?
+	AND version = ?
+	AND from_state_id = ?
+	AND docaction_id = ?
+	AND to_state_id = ?
+	`
+	for _, t := range def.Transitions {
+		var n int64
+		err = tx.QueryRowContext(ctx, rebind(q), dtype, version, t.From.ID, t.Action.ID, t.To.ID).Scan(&n)
+		if err != nil {
+			return 0, err
+		}
+		if n > 0 {
+			continue
+		}
+
+		err = addTransition(ctx, tx, dtype, version, DocStateID(t.From.ID), DocActionID(t.Action.ID), DocStateID(t.To.ID))
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	if otx == nil {
+		err = tx.Commit()
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	return wid, nil
+}
+
+// defResolver resolves the master entity references in a workflow
+// definition to identifiers in the registered database.  The first
+// error encountered is retained, and subsequent resolutions are
+// skipped.
+type defResolver struct {
+	ctx    context.Context
+	tx     *sql.Tx
+	byName bool
+	err    error
+}
+
+// resolve answers the identifier of the referenced entity in the
+// given master table.  `what` names the kind of entity, for errors.
+func (r *defResolver) resolve(table, what string, ref DefinitionRef) int64 {
+	if r.err != nil {
+		return 0
+	}
+
+	var id int64
+	var err error
+	if r.byName {
+		q := "SELECT id FROM " + table + " WHERE name = ?"
+		err = r.tx.QueryRowContext(r.ctx, rebind(q), ref.Name).Scan(&id)
+	} else {
+		q := "SELECT id FROM " + table + " WHERE id = ?"
+		err = r.tx.QueryRowContext(r.ctx, rebind(q), ref.ID).Scan(&id)
+	}
+	switch {
+	case err == sql.ErrNoRows:
+		r.err = fmt.Errorf("unknown %s in workflow definition : %d (%s)", what, ref.ID, ref.Name)
+	case err != nil:
+		r.err = err
+	}
+
+	return id
+}
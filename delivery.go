@@ -0,0 +1,166 @@
+// (c) Copyright 2015-2017 JONNALAGADDA Srinivas
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package flow
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// asyncMessages is set when messages should be delivered into
+// mailboxes through the outbox, after their transitions commit.
+var asyncMessages bool
+
+// DeliverPending delivers at most `batchSize` of the messages awaiting
+// asynchronous delivery into their recipients' mailboxes, oldest
+// first.  It answers the number delivered, and whether more remain, so
+// that a worker can call it repeatedly, until none remain.
+//
+// Each delivery is committed on its own.  Should one fail, it remains
+// in the outbox, to be retried by a later call.  A message is posted
+// into each mailbox only once, even if delivered more than once.
+//
+// See `WithAsyncMessages`.
+func (_Mailboxes) DeliverPending(batchSize int) (int, bool, error) {
+	return Mailboxes.DeliverPendingContext(context.Background(), batchSize)
+}
+
+// DeliverPendingContext is the same as `DeliverPending`, but runs its
+// queries under the given context.
+//
+// The context is checked before each message is delivered.  Upon its
+// cancellation, messages already delivered remain so, and the count of
+// those is answered together with the context's error.
+func (_Mailboxes) DeliverPendingContext(ctx context.Context, batchSize int) (int, bool, error) {
+	if batchSize <= 0 {
+		return 0, false, errors.New("batch size must be a positive integer")
+	}
+
+	q := `
+	SELECT id
+	FROM wf_message_outbox
+	ORDER BY id
+	LIMIT ?
+	`
+	// One more than needed, to learn if more remain.
+	rows, err := db.QueryContext(ctx, rebind(q), batchSize+1)
+	if err != nil {
+		return 0, false, err
+	}
+	defer rows.Close()
+
+	ary := make([]int64, 0, batchSize+1)
+	for rows.Next() {
+		var id int64
+		if err = rows.Scan(&id); err != nil {
+			return 0, false, err
+		}
+		ary = append(ary, id)
+	}
+	if err = rows.Err(); err != nil {
+		return 0, false, err
+	}
+	rows.Close()
+
+	more := false
+	if len(ary) > batchSize {
+		ary, more = ary[:batchSize], true
+	}
+
+	count := 0
+	for _, id := range ary {
+		if err = ctx.Err(); err != nil {
+			return count, true, err
+		}
+		err = inTx(ctx, nil, func(tx *sql.Tx) error {
+			return deliverPost(ctx, tx, id)
+		})
+		if err != nil {
+			return count, true, err
+		}
+		count++
+	}
+
+	return count, more, nil
+}
+
+// deliverPost posts the given outbox entry into its mailbox, and
+// removes it from the outbox, in the given transaction.  An entry
+// already removed -- by a concurrent worker -- is skipped.
+func deliverPost(ctx context.Context, tx *sql.Tx, id int64) error {
+	q := `SELECT id FROM wf_message_outbox WHERE id = ? FOR UPDATE`
+	err := tx.QueryRowContext(ctx, rebind(q), id).Scan(&id)
+	switch {
+	case err == sql.ErrNoRows:
+		return nil
+	case err != nil:
+		return err
+	}
+
+	q = `
+	INSERT INTO wf_mailboxes(group_id, message_id, unread, ctime)
+	SELECT mo.group_id, mo.message_id, 1, mo.ctime
+	FROM wf_message_outbox mo
+	WHERE mo.id = ?
+	AND NOT EXISTS (
+		SELECT 1 FROM wf_mailboxes mbs
+		WHERE mbs.group_id = mo.group_id
+		AND mbs.message_id = mo.message_id
+	)
+	`
+	_, err = tx.ExecContext(ctx, rebind(q), id)
+	if err != nil {
+		return err
+	}
+
+	q = `DELETE FROM wf_message_outbox WHERE id = ?`
+	_, err = tx.ExecContext(ctx, rebind(q), id)
+	return err
+}
+
+// RunDelivery delivers messages awaiting asynchronous delivery, in
+// batches of the given size, until the given context is done.  When
+// the outbox is drained, or a delivery fails, it waits for the given
+// interval before trying again.  Failures are logged.
+//
+// It is intended to be run on a goroutine of its own, by the
+// application, and answers the context's error once it is done.
+func (_Mailboxes) RunDelivery(ctx context.Context, interval time.Duration, batchSize int) error {
+	if interval <= 0 {
+		return errors.New("delivery interval must be positive")
+	}
+	if batchSize <= 0 {
+		return errors.New("batch size must be a positive integer")
+	}
+
+	for {
+		_, more, err := Mailboxes.DeliverPendingContext(ctx, batchSize)
+		if err != nil && ctx.Err() == nil {
+			logger.Errorf("message delivery failed : %v", err)
+		}
+		if err == nil && more {
+			continue
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+
+		case <-time.After(interval):
+		}
+	}
+}
@@ -0,0 +1,154 @@
+// (c) Copyright 2015-2017 JONNALAGADDA Srinivas
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package flow
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Dialect enumerates the SQL dialects that `flow` can speak to the
+// registered database.
+type Dialect uint8
+
+const (
+	// DialectMySQL : `?` placeholders; generated keys through `LastInsertId()`
+	DialectMySQL Dialect = iota
+	// DialectPostgres : `$n` placeholders; generated keys through `RETURNING id`
+	DialectPostgres
+)
+
+// dialect is the SQL dialect of the registered database.
+var dialect = DialectMySQL
+
+// SetDialect specifies the SQL dialect of the database handle given
+// to `RegisterDB`.  The default is `DialectMySQL`.
+//
+// N.B. This should be set once, during initialisation, before any
+// other operation in `flow`.
+func SetDialect(d Dialect) error {
+	switch d {
+	case DialectMySQL, DialectPostgres:
+		installMu.Lock()
+		dialect = d
+		engine.dialect = d
+		installMu.Unlock()
+		return nil
+
+	default:
+		return fmt.Errorf("unknown SQL dialect : %d", d)
+	}
+}
+
+// tablePrefix is prefixed to the names of all the tables of `flow`.
+var tablePrefix string
+
+// rebind rewrites the `?` placeholders in the given query, as
+// required by the registered dialect, and prefixes the names of the
+// tables of `flow` -- those beginning with `wf_` -- with the table
+// prefix of the engine.  Text inside quoted string literals is left
+// alone.
+func rebind(q string) string {
+	if dialect != DialectPostgres && tablePrefix == "" {
+		return q
+	}
+
+	var b strings.Builder
+	b.Grow(len(q) + 16)
+	n := 0
+	quoted := false
+	for i := 0; i < len(q); i++ {
+		c := q[i]
+		switch {
+		case c == '\'':
+			quoted = !quoted
+
+		case c == '?' && !quoted && dialect == DialectPostgres:
+			n++
+			b.WriteByte('$')
+			b.WriteString(strconv.Itoa(n))
+			continue
+
+		case c == 'w' && !quoted && tablePrefix != "" && isTableName(q, i):
+			b.WriteString(tablePrefix)
+		}
+		b.WriteByte(c)
+	}
+
+	return b.String()
+}
+
+// isTableName answers `true` if a table name of `flow` begins at the
+// given position in the given query.  Column names that happen to
+// begin with `wf_` are not table names.
+func isTableName(q string, i int) bool {
+	if !strings.HasPrefix(q[i:], "wf_") || (i > 0 && isIdentByte(q[i-1])) {
+		return false
+	}
+	j := i
+	for j < len(q) && isIdentByte(q[j]) {
+		j++
+	}
+	return q[i:j] != "wf_version"
+}
+
+// isIdentByte answers `true` if the given byte can be part of an
+// unquoted SQL identifier.
+func isIdentByte(c byte) bool {
+	return c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9'
+}
+
+// returningID answers the form of the given `INSERT` statement that
+// yields the generated ID of the new row, as a result set, under the
+// registered dialect.  Dialects that report generated keys through
+// `LastInsertId()` answer the statement unaltered.
+func returningID(q string) string {
+	if dialect != DialectPostgres {
+		return q
+	}
+
+	return strings.TrimRight(q, " \t\n") + "\n\tRETURNING id"
+}
+
+// execInsert runs the given `INSERT` statement in the given
+// transaction, and answers the generated ID of the new row.
+func execInsert(ctx context.Context, tx *sql.Tx, q string, args ...interface{}) (int64, error) {
+	if dialect == DialectPostgres {
+		var id int64
+		row := tx.QueryRowContext(ctx, rebind(returningID(q)), args...)
+		err := row.Scan(&id)
+		if err != nil {
+			return 0, err
+		}
+		return id, nil
+	}
+
+	res, err := tx.ExecContext(ctx, rebind(q), args...)
+	if err != nil {
+		return 0, err
+	}
+	return res.LastInsertId()
+}
+
+// likePrefix answers a `LIKE` pattern that matches strings beginning
+// with the given prefix, taken literally.  The pattern must be used
+// with `ESCAPE '!'`, which all supported dialects understand.
+func likePrefix(prefix string) string {
+	r := strings.NewReplacer("!", "!!", "%", "!%", "_", "!_")
+	return r.Replace(prefix) + "%"
+}
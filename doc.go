@@ -17,7 +17,7 @@ package flow
 
 import (
 	"database/sql"
-	"log"
+	"errors"
 )
 
 const (
@@ -31,21 +31,19 @@ var blobsDir string
 
 //
 
-func init() {
-	f := log.Flags()
-	log.SetFlags(f | log.Lmicroseconds | log.Lshortfile)
-}
-
 // RegisterDB provides an already initialised database handle to `flow`.
+// It is the same as `Open`, retaining the dialect set through
+// `SetDialect`.  Hence, it too answers `ErrEngineOpen` when an engine
+// is already open.
 //
 // N.B. This method **MUST** be called before anything else in `flow`.
 func RegisterDB(sdb *sql.DB) error {
 	if sdb == nil {
-		log.Fatal("given database handle is `nil`")
+		return errors.New("given database handle is `nil`")
 	}
-	db = sdb
 
-	return nil
+	_, err := Open(sdb, WithDialect(dialect))
+	return err
 }
 
 // SetBlobsDir specifies the base directory inside which blob files
@@ -60,7 +58,7 @@ func RegisterDB(sdb *sql.DB) error {
 // corresponding documents get corrupted.
 func SetBlobsDir(base string) error {
 	if base == "" {
-		log.Fatal("given base directory path is empty")
+		return errors.New("given base directory path is empty")
 	}
 	blobsDir = base
 
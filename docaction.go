@@ -15,6 +15,7 @@
 package flow
 
 import (
+	"context"
 	"database/sql"
 	"errors"
 	"math"
@@ -56,14 +57,21 @@ var DocActions _DocActions
 
 // New creates and registers a new document action in the system.
 func (_DocActions) New(otx *sql.Tx, name string, reconfirm bool) (DocActionID, error) {
+	return DocActions.NewContext(context.Background(), otx, name, reconfirm)
+}
+
+// NewContext is the same as `New`, but runs its queries under the
+// given context.
+func (_DocActions) NewContext(ctx context.Context, otx *sql.Tx, name string, reconfirm bool) (DocActionID, error) {
 	name = strings.TrimSpace(name)
 	if name == "" {
 		return 0, errors.New("document action cannot be empty")
 	}
 
 	var tx *sql.Tx
+	var err error
 	if otx == nil {
-		tx, err := db.Begin()
+		tx, err = db.BeginTx(ctx, nil)
 		if err != nil {
 			return 0, err
 		}
@@ -72,21 +80,16 @@ func (_DocActions) New(otx *sql.Tx, name string, reconfirm bool) (DocActionID, e
 		tx = otx
 	}
 
-	var res sql.Result
-	var err error
+	var aid int64
+	q := "INSERT INTO wf_docactions_master(name, reconfirm) VALUES(?, ?)"
 	if reconfirm {
-		res, err = tx.Exec("INSERT INTO wf_docactions_master(name, reconfirm) VALUES(?, ?)", name, 1)
+		aid, err = execInsert(ctx, tx, q, name, 1)
 	} else {
-		res, err = tx.Exec("INSERT INTO wf_docactions_master(name, reconfirm) VALUES(?, ?)", name, 0)
+		aid, err = execInsert(ctx, tx, q, name, 0)
 	}
 	if err != nil {
 		return 0, err
 	}
-	var aid int64
-	aid, err = res.LastInsertId()
-	if err != nil {
-		return 0, err
-	}
 
 	if otx == nil {
 		err = tx.Commit()
@@ -105,6 +108,12 @@ func (_DocActions) New(otx *sql.Tx, name string, reconfirm bool) (DocActionID, e
 // `limit` elements.  A value of `0` for `offset` fetches from the
 // beginning, while a value of `0` for `limit` fetches until the end.
 func (_DocActions) List(offset, limit int64) ([]*DocAction, error) {
+	return DocActions.ListContext(context.Background(), offset, limit)
+}
+
+// ListContext is the same as `List`, but runs its queries under the
+// given context.
+func (_DocActions) ListContext(ctx context.Context, offset, limit int64) ([]*DocAction, error) {
 	if offset < 0 || limit < 0 {
 		return nil, errors.New("offset and limit must be non-negative integers")
 	}
@@ -118,7 +127,7 @@ func (_DocActions) List(offset, limit int64) ([]*DocAction, error) {
 	ORDER BY id
 	LIMIT ? OFFSET ?
 	`
-	rows, err := db.Query(q, limit, offset)
+	rows, err := db.QueryContext(ctx, rebind(q), limit, offset)
 	if err != nil {
 		return nil, err
 	}
@@ -142,14 +151,23 @@ func (_DocActions) List(offset, limit int64) ([]*DocAction, error) {
 
 // Get retrieves the document action for the given ID.
 func (_DocActions) Get(id DocActionID) (*DocAction, error) {
+	return DocActions.GetContext(context.Background(), id)
+}
+
+// GetContext is the same as `Get`, but runs its queries under the
+// given context.
+func (_DocActions) GetContext(ctx context.Context, id DocActionID) (*DocAction, error) {
 	if id <= 0 {
 		return nil, errors.New("ID should be a positive integer")
 	}
 
 	var elem DocAction
-	row := db.QueryRow("SELECT id, name, reconfirm FROM wf_docactions_master WHERE id = ?", id)
+	row := db.QueryRowContext(ctx, rebind("SELECT id, name, reconfirm FROM wf_docactions_master WHERE id = ?"), id)
 	err := row.Scan(&elem.ID, &elem.Name, &elem.Reconfirm)
 	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrNotFound
+		}
 		return nil, err
 	}
 
@@ -159,15 +177,24 @@ func (_DocActions) Get(id DocActionID) (*DocAction, error) {
 // GetByName answers the document action, if one such with the given
 // name is registered; `nil` and the error, otherwise.
 func (_DocActions) GetByName(name string) (*DocAction, error) {
+	return DocActions.GetByNameContext(context.Background(), name)
+}
+
+// GetByNameContext is the same as `GetByName`, but runs its queries
+// under the given context.
+func (_DocActions) GetByNameContext(ctx context.Context, name string) (*DocAction, error) {
 	name = strings.TrimSpace(name)
 	if name == "" {
 		return nil, errors.New("document action cannot be empty")
 	}
 
 	var elem DocAction
-	row := db.QueryRow("SELECT id, name, reconfirm FROM wf_docactions_master WHERE name = ?", name)
+	row := db.QueryRowContext(ctx, rebind("SELECT id, name, reconfirm FROM wf_docactions_master WHERE name = ?"), name)
 	err := row.Scan(&elem.ID, &elem.Name, &elem.Reconfirm)
 	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrNotFound
+		}
 		return nil, err
 	}
 
@@ -176,14 +203,21 @@ func (_DocActions) GetByName(name string) (*DocAction, error) {
 
 // Rename renames the given document action.
 func (_DocActions) Rename(otx *sql.Tx, id DocActionID, name string) error {
+	return DocActions.RenameContext(context.Background(), otx, id, name)
+}
+
+// RenameContext is the same as `Rename`, but runs its queries under
+// the given context.
+func (_DocActions) RenameContext(ctx context.Context, otx *sql.Tx, id DocActionID, name string) error {
 	name = strings.TrimSpace(name)
 	if name == "" {
 		return errors.New("name cannot be empty")
 	}
 
 	var tx *sql.Tx
+	var err error
 	if otx == nil {
-		tx, err := db.Begin()
+		tx, err = db.BeginTx(ctx, nil)
 		if err != nil {
 			return err
 		}
@@ -192,7 +226,7 @@ func (_DocActions) Rename(otx *sql.Tx, id DocActionID, name string) error {
 		tx = otx
 	}
 
-	_, err := tx.Exec("UPDATE wf_docactions_master SET name = ? WHERE id = ?", name, id)
+	_, err = tx.ExecContext(ctx, rebind("UPDATE wf_docactions_master SET name = ? WHERE id = ?"), name, id)
 	if err != nil {
 		return err
 	}
@@ -15,6 +15,7 @@
 package flow
 
 import (
+	"context"
 	"database/sql"
 	"errors"
 	"fmt"
@@ -34,8 +35,54 @@ const (
 	EventStatusApplied
 	// EventStatusPending selects only those events that are pending application.
 	EventStatusPending
+	// EventStatusCancelled selects only those events that were cancelled before application.
+	EventStatusCancelled
 )
 
+// The life cycle of an event is simple : it is created pending, and is
+// then either applied or cancelled.  Neither of the latter can change
+// further.
+
+// CanChangeTo answers `nil` if an event in this status can be moved
+// into the given status.  Otherwise, it answers the error that
+// explains why not.
+func (s EventStatus) CanChangeTo(t EventStatus) error {
+	switch s {
+	case EventStatusPending:
+		if t == EventStatusApplied || t == EventStatusCancelled {
+			return nil
+		}
+		return fmt.Errorf("a pending event cannot change into status : %d", t)
+
+	case EventStatusApplied:
+		return ErrDocEventAlreadyApplied
+
+	case EventStatusCancelled:
+		return ErrDocEventCancelled
+
+	default:
+		return fmt.Errorf("unknown event status : %d", s)
+	}
+}
+
+// parseEventStatus answers the status represented by the given
+// database code.
+func parseEventStatus(code string) (EventStatus, error) {
+	switch code {
+	case "A":
+		return EventStatusApplied, nil
+
+	case "P":
+		return EventStatusPending, nil
+
+	case "C":
+		return EventStatusCancelled, nil
+
+	default:
+		return 0, fmt.Errorf("unknown event status : %s", code)
+	}
+}
+
 // DocEventID is the type of unique document event identifiers.
 type DocEventID int64
 
@@ -56,25 +103,21 @@ type DocEvent struct {
 	Text    string      `json:"Text"`      // Comment or other content
 	Ctime   time.Time   `json:"Ctime"`     // Time at which the event occurred
 	Status  EventStatus `json:"Status"`    // Status of this event
+
+	IdempotencyKey string `json:"IdempotencyKey,omitempty"` // Client-supplied key of this event, if any
 }
 
 // StatusInDB answers the status of this event.
 func (e *DocEvent) StatusInDB() (EventStatus, error) {
 	var dstatus string
-	row := db.QueryRow("SELECT status FROM wf_docevents WHERE id = ?", e.ID)
+	row := db.QueryRow(rebind("SELECT status FROM wf_docevents WHERE id = ?"), e.ID)
 	err := row.Scan(&dstatus)
 	if err != nil {
 		return 0, err
 	}
-	switch dstatus {
-	case "A":
-		e.Status = EventStatusApplied
-
-	case "P":
-		e.Status = EventStatusPending
-
-	default:
-		return 0, fmt.Errorf("unknown event status : %s", dstatus)
+	e.Status, err = parseEventStatus(dstatus)
+	if err != nil {
+		return 0, err
 	}
 
 	return e.Status, nil
@@ -95,10 +138,25 @@ type DocEventsNewInput struct {
 	DocActionID        // Action performed by `Group`; required
 	GroupID            // Group (user) who performed the action that raised this event; required
 	Text        string // Any comments or notes; required
+
+	// IdempotencyKey identifies repeated submissions of the same event
+	// by a client, such as retried HTTP requests; optional.  Keys are
+	// unique per (root) document.
+	IdempotencyKey string
 }
 
+// maxIdempotencyKeyLen is the length of the `idem_key` column.
+const maxIdempotencyKeyLen = 100
+
 // New creates and initialises an event that transforms the document
 // that it refers to.
+//
+// When an idempotency key is given, and the document already has an
+// event with that key, no new event is created; the identifier of the
+// existing event is answered instead.  The existing event should have
+// the same state, action and group, failing which
+// `ErrDocEventKeyConflict` is answered.  Applying such an event again
+// answers the result of its original application; see `ApplyEvent`.
 func (_DocEvents) New(otx *sql.Tx, input *DocEventsNewInput) (DocEventID, error) {
 	if input.DocTypeID <= 0 || input.DocumentID <= 0 || input.DocStateID <= 0 || input.DocActionID <= 0 || input.GroupID <= 0 {
 		return 0, errors.New("all identifiers should be positive integers")
@@ -106,10 +164,14 @@ func (_DocEvents) New(otx *sql.Tx, input *DocEventsNewInput) (DocEventID, error)
 	if input.Text == "" {
 		return 0, errors.New("please add comments or notes")
 	}
+	if len(input.IdempotencyKey) > maxIdempotencyKeyLen {
+		return 0, fmt.Errorf("idempotency key should not exceed %d bytes", maxIdempotencyKeyLen)
+	}
 
 	var tx *sql.Tx
+	var err error
 	if otx == nil {
-		tx, err := db.Begin()
+		tx, err = db.Begin()
 		if err != nil {
 			return 0, err
 		}
@@ -133,18 +195,21 @@ func (_DocEvents) New(otx *sql.Tx, input *DocEventsNewInput) (DocEventID, error)
 		input.DocumentID = rdid
 	}
 
+	// A repeated submission answers the original event.
+	eid, err := DocEvents.byIdempotencyKey(context.Background(), tx, input)
+	if err != nil || eid > 0 {
+		return eid, err
+	}
+
 	// Register the event using the root document.
 
 	q := `
-	INSERT INTO wf_docevents(doctype_id, doc_id, docstate_id, docaction_id, group_id, data, ctime, status)
-	VALUES(?, ?, ?, ?, ?, ?, NOW(), 'P')
+	INSERT INTO wf_docevents(doctype_id, doc_id, docstate_id, docaction_id, group_id, data, ctime, status, idem_key)
+	VALUES(?, ?, ?, ?, ?, ?, NOW(), 'P', ?)
 	`
-	res, err := tx.Exec(q, input.DocTypeID, input.DocumentID, input.DocStateID, input.DocActionID, input.GroupID, input.Text)
-	if err != nil {
-		return 0, err
-	}
+	key := sql.NullString{String: input.IdempotencyKey, Valid: input.IdempotencyKey != ""}
 	var id int64
-	id, err = res.LastInsertId()
+	id, err = execInsert(context.Background(), tx, q, input.DocTypeID, input.DocumentID, input.DocStateID, input.DocActionID, input.GroupID, input.Text, key)
 	if err != nil {
 		return 0, err
 	}
@@ -159,6 +224,148 @@ func (_DocEvents) New(otx *sql.Tx, input *DocEventsNewInput) (DocEventID, error)
 	return DocEventID(id), nil
 }
 
+// byIdempotencyKey answers the event on the given input's document
+// that has the input's idempotency key, or `0` if there is none.  The
+// input should already refer to the root document.
+func (_DocEvents) byIdempotencyKey(ctx context.Context, otx *sql.Tx, input *DocEventsNewInput) (DocEventID, error) {
+	if input.IdempotencyKey == "" {
+		return 0, nil
+	}
+
+	q := `
+	SELECT id, docstate_id, docaction_id, group_id
+	FROM wf_docevents
+	WHERE doctype_id = ?
+	AND doc_id = ?
+	AND idem_key = ?
+	`
+	var eid DocEventID
+	var ds DocStateID
+	var da DocActionID
+	var gid GroupID
+	err := otx.QueryRowContext(ctx, rebind(q), input.DocTypeID, input.DocumentID, input.IdempotencyKey).Scan(&eid, &ds, &da, &gid)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return 0, nil
+		}
+		return 0, err
+	}
+	if ds != input.DocStateID || da != input.DocActionID || gid != input.GroupID {
+		return 0, ErrDocEventKeyConflict
+	}
+
+	return eid, nil
+}
+
+// Raise is the same as `New`, except that the event is first checked
+// for consistency, and is answered in full.  The group must exist, the
+// document -- or its root, for a child document -- must be in the
+// given state, and a transition must be defined out of that state upon
+// the given action.  Inconsistent events are thus refused when raised,
+// rather than when applied.
+//
+// An event on a document in parallel branches may name the state of
+// any of its active branches.
+func (_DocEvents) Raise(otx *sql.Tx, input *DocEventsNewInput) (*DocEvent, error) {
+	return DocEvents.RaiseContext(context.Background(), otx, input)
+}
+
+// RaiseContext is the same as `Raise`, but runs its queries under the
+// given context.
+func (_DocEvents) RaiseContext(ctx context.Context, otx *sql.Tx, input *DocEventsNewInput) (*DocEvent, error) {
+	if input.DocTypeID <= 0 || input.DocumentID <= 0 || input.DocStateID <= 0 || input.DocActionID <= 0 || input.GroupID <= 0 {
+		return nil, errors.New("all identifiers should be positive integers")
+	}
+	if input.Text == "" {
+		return nil, errors.New("please add comments or notes")
+	}
+	if _, err := Groups.Get(input.GroupID); err != nil {
+		return nil, fmt.Errorf("group %d : %w", input.GroupID, err)
+	}
+
+	var tx *sql.Tx
+	var err error
+	if otx == nil {
+		tx, err = db.BeginTx(ctx, nil)
+		if err != nil {
+			return nil, err
+		}
+		defer tx.Rollback()
+	} else {
+		tx = otx
+	}
+
+	dtype, did := input.DocTypeID, input.DocumentID
+	doc, err := Documents.GetContext(ctx, tx, dtype, did)
+	if err != nil {
+		return nil, err
+	}
+	rdtid, rdid, err := doc.Path.Root()
+	if err != nil {
+		return nil, err
+	}
+	if rdid > 0 {
+		dtype, did = rdtid, rdid
+		doc, err = Documents.GetContext(ctx, tx, dtype, did)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// A repeated submission may find the document transitioned
+	// already; it is answered the original event.
+	rin := *input
+	rin.DocTypeID, rin.DocumentID = dtype, did
+	eid, err := DocEvents.byIdempotencyKey(ctx, tx, &rin)
+	if err != nil {
+		return nil, err
+	}
+	if eid > 0 {
+		return DocEvents.get(ctx, tx, eid)
+	}
+
+	branches, err := Documents.activeStates(ctx, tx, dtype, did)
+	if err != nil {
+		return nil, err
+	}
+	if len(branches) > 0 {
+		if !containsState(branches, input.DocStateID) {
+			return nil, ErrDocEventStateMismatch
+		}
+	} else if doc.State.ID != input.DocStateID {
+		return nil, ErrDocEventStateMismatch
+	}
+	wf, err := Documents.WorkflowContext(ctx, tx, dtype, did)
+	if err != nil {
+		return nil, err
+	}
+	targets, err := actionTargets(ctx, tx, wf.ID, input.DocStateID, input.DocActionID)
+	if err != nil {
+		return nil, err
+	}
+	if len(targets) == 0 {
+		return nil, &ErrNoTransition{State: input.DocStateID, Action: input.DocActionID}
+	}
+
+	eid, err = DocEvents.New(tx, input)
+	if err != nil {
+		return nil, err
+	}
+	ev, err := DocEvents.get(ctx, tx, eid)
+	if err != nil {
+		return nil, err
+	}
+
+	if otx == nil {
+		err = tx.Commit()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return ev, nil
+}
+
 // DocEventsListInput specifies a set of filter conditions to narrow
 // down document listings.
 type DocEventsListInput struct {
@@ -190,7 +397,7 @@ func (_DocEvents) List(input *DocEventsListInput, offset, limit int64) ([]*DocEv
 	// Base query.
 
 	q := `
-	SELECT de.id, de.doctype_id, de.doc_id, de.docstate_id, de.docaction_id, de.group_id, de.data, de.ctime, de.status
+	SELECT de.id, de.doctype_id, de.doc_id, de.docstate_id, de.docaction_id, de.group_id, de.data, de.ctime, de.status, de.idem_key
 	FROM wf_docevents de
 	`
 
@@ -222,6 +429,9 @@ func (_DocEvents) List(input *DocEventsListInput, offset, limit int64) ([]*DocEv
 	case EventStatusPending:
 		where = append(where, `status = 'P'`)
 
+	case EventStatusCancelled:
+		where = append(where, `status = 'C'`)
+
 	default:
 		return nil, fmt.Errorf("unknown event status specified in filter : %d", input.Status)
 	}
@@ -255,37 +465,108 @@ func (_DocEvents) List(input *DocEventsListInput, offset, limit int64) ([]*DocEv
 	LIMIT ? OFFSET ?
 	`
 	args = append(args, limit, offset)
-	rows, err := db.Query(q, args...)
+	rows, err := db.Query(rebind(q), args...)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 
-	var text sql.NullString
+	return scanDocEvents(rows)
+}
+
+// ListForDocument answers the events raised on the given document, in
+// chronological order, to build its activity timeline.  Only events
+// in one of the given statuses are answered; all events are answered
+// if none is given, or if `EventStatusAll` is among them.
+//
+// The first `offset` events are skipped, and not more than `limit` are
+// answered.  A value of `0` for `limit` fetches until the end.
+func (_DocEvents) ListForDocument(dtype DocTypeID, doc DocumentID, statuses []EventStatus, offset, limit int64) ([]*DocEvent, error) {
+	return DocEvents.ListForDocumentContext(context.Background(), dtype, doc, statuses, offset, limit)
+}
+
+// ListForDocumentContext is the same as `ListForDocument`, but runs
+// its queries under the given context.
+func (_DocEvents) ListForDocumentContext(ctx context.Context, dtype DocTypeID, doc DocumentID, statuses []EventStatus, offset, limit int64) ([]*DocEvent, error) {
+	if dtype <= 0 || doc <= 0 {
+		return nil, errors.New("document type and document ID should be positive integers")
+	}
+	if offset < 0 || limit < 0 {
+		return nil, errors.New("offset and limit must be non-negative integers")
+	}
+	if limit == 0 {
+		limit = math.MaxInt64
+	}
+
+	q := `
+	SELECT id, doctype_id, doc_id, docstate_id, docaction_id, group_id, data, ctime, status, idem_key
+	FROM wf_docevents
+	WHERE doctype_id = ?
+	AND doc_id = ?
+	`
+	args := []interface{}{dtype, doc}
+
+	codes := make([]string, 0, len(statuses))
+	all := len(statuses) == 0
+	for _, st := range statuses {
+		switch st {
+		case EventStatusAll:
+			all = true
+
+		case EventStatusApplied:
+			codes = append(codes, `'A'`)
+
+		case EventStatusPending:
+			codes = append(codes, `'P'`)
+
+		case EventStatusCancelled:
+			codes = append(codes, `'C'`)
+
+		default:
+			return nil, fmt.Errorf("unknown event status specified in filter : %d", st)
+		}
+	}
+	if !all {
+		q += `AND status IN (` + strings.Join(codes, `, `) + `)
+		`
+	}
+
+	q += `ORDER BY ctime, id
+	LIMIT ? OFFSET ?
+	`
+	args = append(args, limit, offset)
+	rows, err := db.QueryContext(ctx, rebind(q), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanDocEvents(rows)
+}
+
+// scanDocEvents reads the events in the given result set, whose
+// columns are those read by `List`.
+func scanDocEvents(rows *sql.Rows) ([]*DocEvent, error) {
+	var text, key sql.NullString
 	var dstatus string
 	ary := make([]*DocEvent, 0, 10)
 	for rows.Next() {
 		var elem DocEvent
-		err = rows.Scan(&elem.ID, &elem.DocType, &elem.DocID, &elem.State, &elem.Action, &elem.Group, &text, &elem.Ctime, &dstatus)
+		err := rows.Scan(&elem.ID, &elem.DocType, &elem.DocID, &elem.State, &elem.Action, &elem.Group, &text, &elem.Ctime, &dstatus, &key)
 		if err != nil {
 			return nil, err
 		}
 		if text.Valid {
 			elem.Text = text.String
 		}
-		switch dstatus {
-		case "A":
-			elem.Status = EventStatusApplied
-
-		case "P":
-			elem.Status = EventStatusPending
-
-		default:
-			return nil, fmt.Errorf("unknown event status : %s", dstatus)
+		elem.IdempotencyKey = key.String
+		elem.Status, err = parseEventStatus(dstatus)
+		if err != nil {
+			return nil, err
 		}
 		ary = append(ary, &elem)
 	}
-	if err = rows.Err(); err != nil {
+	if err := rows.Err(); err != nil {
 		return nil, err
 	}
 
@@ -299,32 +580,109 @@ func (_DocEvents) Get(eid DocEventID) (*DocEvent, error) {
 		return nil, errors.New("event ID should be a positive integer")
 	}
 
-	var text sql.NullString
+	return DocEvents.get(context.Background(), nil, eid)
+}
+
+// get answers the requested event, reading it in the given
+// transaction, if one is given.
+func (_DocEvents) get(ctx context.Context, otx *sql.Tx, eid DocEventID) (*DocEvent, error) {
+	var text, key sql.NullString
 	var dstatus string
 	var elem DocEvent
 	q := `
-	SELECT id, doctype_id, doc_id, docstate_id, docaction_id, group_id, data, ctime, status
+	SELECT id, doctype_id, doc_id, docstate_id, docaction_id, group_id, data, ctime, status, idem_key
 	FROM wf_docevents
 	WHERE id = ?
 	`
-	row := db.QueryRow(q, eid)
-	err := row.Scan(&elem.ID, &elem.DocType, &elem.DocID, &elem.State, &elem.Action, &elem.Group, &text, &elem.Ctime, &dstatus)
+	var row *sql.Row
+	if otx == nil {
+		row = db.QueryRowContext(ctx, rebind(q), eid)
+	} else {
+		row = otx.QueryRowContext(ctx, rebind(q), eid)
+	}
+	err := row.Scan(&elem.ID, &elem.DocType, &elem.DocID, &elem.State, &elem.Action, &elem.Group, &text, &elem.Ctime, &dstatus, &key)
 	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrNotFound
+		}
 		return nil, err
 	}
 	if text.Valid {
 		elem.Text = text.String
 	}
-	switch dstatus {
-	case "A":
-		elem.Status = EventStatusApplied
+	elem.IdempotencyKey = key.String
+	elem.Status, err = parseEventStatus(dstatus)
+	if err != nil {
+		return nil, err
+	}
 
-	case "P":
-		elem.Status = EventStatusPending
+	return &elem, nil
+}
 
-	default:
-		return nil, fmt.Errorf("unknown event status : %s", dstatus)
+// Cancel marks the given pending event as cancelled, so that it can no
+// longer be applied.  Events that have already been applied or
+// cancelled cannot be cancelled.
+//
+// N.B. Messages are posted only as an event is applied, in the same
+// transaction; a pending event, hence, has no messages in any mailbox
+// to be withdrawn upon cancellation.
+func (_DocEvents) Cancel(otx *sql.Tx, eid DocEventID) error {
+	return DocEvents.CancelContext(context.Background(), otx, eid)
+}
+
+// CancelContext is the same as `Cancel`, but runs its queries under the
+// given context.
+func (_DocEvents) CancelContext(ctx context.Context, otx *sql.Tx, eid DocEventID) error {
+	var tx *sql.Tx
+	var err error
+	if otx == nil {
+		tx, err = db.BeginTx(ctx, nil)
+		if err != nil {
+			return err
+		}
+		defer tx.Rollback()
+	} else {
+		tx = otx
 	}
 
-	return &elem, nil
+	status, err := eventStatusTx(ctx, tx, eid)
+	if err != nil {
+		return err
+	}
+	err = status.CanChangeTo(EventStatusCancelled)
+	if err != nil {
+		return err
+	}
+
+	q := `UPDATE wf_docevents SET status = 'C' WHERE id = ?`
+	_, err = tx.ExecContext(ctx, rebind(q), eid)
+	if err != nil {
+		return err
+	}
+
+	if otx == nil {
+		err = tx.Commit()
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// eventStatusTx answers the status of the given event as recorded in
+// the database, locking its row for the remainder of the given
+// transaction.
+func eventStatusTx(ctx context.Context, tx *sql.Tx, eid DocEventID) (EventStatus, error) {
+	var dstatus string
+	q := `SELECT status FROM wf_docevents WHERE id = ? FOR UPDATE`
+	err := tx.QueryRowContext(ctx, rebind(q), eid).Scan(&dstatus)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return 0, ErrNotFound
+		}
+		return 0, err
+	}
+
+	return parseEventStatus(dstatus)
 }
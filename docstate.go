@@ -15,6 +15,7 @@
 package flow
 
 import (
+	"context"
 	"database/sql"
 	"errors"
 	"math"
@@ -51,14 +52,21 @@ var DocStates _DocStates
 // New creates an enumerated state as defined by the consuming
 // application.
 func (_DocStates) New(otx *sql.Tx, name string) (DocStateID, error) {
+	return DocStates.NewContext(context.Background(), otx, name)
+}
+
+// NewContext is the same as `New`, but runs its queries under the
+// given context.
+func (_DocStates) NewContext(ctx context.Context, otx *sql.Tx, name string) (DocStateID, error) {
 	name = strings.TrimSpace(name)
 	if name == "" {
 		return 0, errors.New("name cannot be empty")
 	}
 
 	var tx *sql.Tx
+	var err error
 	if otx == nil {
-		tx, err := db.Begin()
+		tx, err = db.BeginTx(ctx, nil)
 		if err != nil {
 			return 0, err
 		}
@@ -67,12 +75,8 @@ func (_DocStates) New(otx *sql.Tx, name string) (DocStateID, error) {
 		tx = otx
 	}
 
-	res, err := tx.Exec("INSERT INTO wf_docstates_master(name) VALUES(?)", name)
-	if err != nil {
-		return 0, err
-	}
 	var id int64
-	id, err = res.LastInsertId()
+	id, err = execInsert(ctx, tx, "INSERT INTO wf_docstates_master(name) VALUES(?)", name)
 	if err != nil {
 		return 0, err
 	}
@@ -94,6 +98,12 @@ func (_DocStates) New(otx *sql.Tx, name string) (DocStateID, error) {
 // `limit` elements.  A value of `0` for `offset` fetches from the
 // beginning, while a value of `0` for `limit` fetches until the end.
 func (_DocStates) List(offset, limit int64) ([]*DocState, error) {
+	return DocStates.ListContext(context.Background(), offset, limit)
+}
+
+// ListContext is the same as `List`, but runs its queries under the
+// given context.
+func (_DocStates) ListContext(ctx context.Context, offset, limit int64) ([]*DocState, error) {
 	if offset < 0 || limit < 0 {
 		return nil, errors.New("offset and limit must be non-negative integers")
 	}
@@ -107,7 +117,7 @@ func (_DocStates) List(offset, limit int64) ([]*DocState, error) {
 	ORDER BY id
 	LIMIT ? OFFSET ?
 	`
-	rows, err := db.Query(q, limit, offset)
+	rows, err := db.QueryContext(ctx, rebind(q), limit, offset)
 	if err != nil {
 		return nil, err
 	}
@@ -131,6 +141,12 @@ func (_DocStates) List(offset, limit int64) ([]*DocState, error) {
 
 // Get retrieves the document state for the given ID.
 func (_DocStates) Get(id DocStateID) (*DocState, error) {
+	return DocStates.GetContext(context.Background(), id)
+}
+
+// GetContext is the same as `Get`, but runs its queries under the
+// given context.
+func (_DocStates) GetContext(ctx context.Context, id DocStateID) (*DocState, error) {
 	if id <= 0 {
 		return nil, errors.New("ID should be a positive integer")
 	}
@@ -141,9 +157,12 @@ func (_DocStates) Get(id DocStateID) (*DocState, error) {
 	FROM wf_docstates_master
 	WHERE id = ?
 	`
-	row := db.QueryRow(q, id)
+	row := db.QueryRowContext(ctx, rebind(q), id)
 	err := row.Scan(&elem.Name)
 	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrNotFound
+		}
 		return nil, err
 	}
 
@@ -154,15 +173,24 @@ func (_DocStates) Get(id DocStateID) (*DocState, error) {
 // GetByName answers the document state, if one with the given name is
 // registered; `nil` and the error, otherwise.
 func (_DocStates) GetByName(name string) (*DocState, error) {
+	return DocStates.GetByNameContext(context.Background(), name)
+}
+
+// GetByNameContext is the same as `GetByName`, but runs its queries
+// under the given context.
+func (_DocStates) GetByNameContext(ctx context.Context, name string) (*DocState, error) {
 	name = strings.TrimSpace(name)
 	if name == "" {
 		return nil, errors.New("document state name should be non-empty")
 	}
 
 	var elem DocState
-	row := db.QueryRow("SELECT id, name FROM wf_docstates_master WHERE name = ?", name)
+	row := db.QueryRowContext(ctx, rebind("SELECT id, name FROM wf_docstates_master WHERE name = ?"), name)
 	err := row.Scan(&elem.ID, &elem.Name)
 	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrNotFound
+		}
 		return nil, err
 	}
 
@@ -171,14 +199,113 @@ func (_DocStates) GetByName(name string) (*DocState, error) {
 
 // Rename renames the given document state.
 func (_DocStates) Rename(otx *sql.Tx, id DocStateID, name string) error {
+	return DocStates.RenameContext(context.Background(), otx, id, name)
+}
+
+// RenameContext is the same as `Rename`, but runs its queries under
+// the given context.
+func (_DocStates) RenameContext(ctx context.Context, otx *sql.Tx, id DocStateID, name string) error {
 	name = strings.TrimSpace(name)
 	if name == "" {
 		return errors.New("name cannot be empty")
 	}
 
 	var tx *sql.Tx
+	var err error
+	if otx == nil {
+		tx, err = db.BeginTx(ctx, nil)
+		if err != nil {
+			return err
+		}
+		defer tx.Rollback()
+	} else {
+		tx = otx
+	}
+
+	_, err = tx.ExecContext(ctx, rebind("UPDATE wf_docstates_master SET name = ? WHERE id = ?"), name, id)
+	if err != nil {
+		return err
+	}
+
+	if otx == nil {
+		err = tx.Commit()
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// NewWithParent is the same as `New`, except that the new state is
+// grouped under the given parent state.  Reports can then roll the
+// new state up into its parent -- "Legal Review" into "In Review",
+// for instance.
+func (_DocStates) NewWithParent(otx *sql.Tx, name string, parent DocStateID) (DocStateID, error) {
+	return DocStates.NewWithParentContext(context.Background(), otx, name, parent)
+}
+
+// NewWithParentContext is the same as `NewWithParent`, but runs its
+// queries under the given context.
+func (_DocStates) NewWithParentContext(ctx context.Context, otx *sql.Tx, name string, parent DocStateID) (DocStateID, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return 0, errors.New("name cannot be empty")
+	}
+	if parent <= 0 {
+		return 0, errors.New("parent ID should be a positive integer")
+	}
+
+	var tx *sql.Tx
+	var err error
+	if otx == nil {
+		tx, err = db.BeginTx(ctx, nil)
+		if err != nil {
+			return 0, err
+		}
+		defer tx.Rollback()
+	} else {
+		tx = otx
+	}
+
+	if _, err = parentOf(ctx, tx, parent); err != nil {
+		return 0, err
+	}
+	var id int64
+	id, err = execInsert(ctx, tx, "INSERT INTO wf_docstates_master(name, parent_id) VALUES(?, ?)", name, parent)
+	if err != nil {
+		return 0, err
+	}
+
+	if otx == nil {
+		err = tx.Commit()
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	return DocStateID(id), nil
+}
+
+// SetParent groups the given document state under the given parent
+// state.  A parent of `0` removes the state from its group.  A parent
+// that is the state itself, or one of its descendants, is refused with
+// `ErrDocStateCycle`.
+func (_DocStates) SetParent(otx *sql.Tx, id, parent DocStateID) error {
+	return DocStates.SetParentContext(context.Background(), otx, id, parent)
+}
+
+// SetParentContext is the same as `SetParent`, but runs its queries
+// under the given context.
+func (_DocStates) SetParentContext(ctx context.Context, otx *sql.Tx, id, parent DocStateID) error {
+	if id <= 0 || parent < 0 {
+		return errors.New("state ID should be a positive integer")
+	}
+
+	var tx *sql.Tx
+	var err error
 	if otx == nil {
-		tx, err := db.Begin()
+		tx, err = db.BeginTx(ctx, nil)
 		if err != nil {
 			return err
 		}
@@ -187,7 +314,25 @@ func (_DocStates) Rename(otx *sql.Tx, id DocStateID, name string) error {
 		tx = otx
 	}
 
-	_, err := tx.Exec("UPDATE wf_docstates_master SET name = ? WHERE id = ?", name, id)
+	if _, err = parentOf(ctx, tx, id); err != nil {
+		return err
+	}
+	var pid interface{}
+	if parent > 0 {
+		// Walk up from the new parent; the state must not be met.
+		for ds := parent; ds > 0; {
+			if ds == id {
+				return ErrDocStateCycle
+			}
+			ds, err = parentOf(ctx, tx, ds)
+			if err != nil {
+				return err
+			}
+		}
+		pid = parent
+	}
+
+	_, err = tx.ExecContext(ctx, rebind("UPDATE wf_docstates_master SET parent_id = ? WHERE id = ?"), pid, id)
 	if err != nil {
 		return err
 	}
@@ -201,3 +346,108 @@ func (_DocStates) Rename(otx *sql.Tx, id DocStateID, name string) error {
 
 	return nil
 }
+
+// Parent answers the parent of the given document state, or `0` if it
+// has none.
+func (_DocStates) Parent(id DocStateID) (DocStateID, error) {
+	return DocStates.ParentContext(context.Background(), id)
+}
+
+// ParentContext is the same as `Parent`, but runs its queries under
+// the given context.
+func (_DocStates) ParentContext(ctx context.Context, id DocStateID) (DocStateID, error) {
+	if id <= 0 {
+		return 0, errors.New("ID should be a positive integer")
+	}
+
+	return parentOf(ctx, nil, id)
+}
+
+// Children answers the document states grouped directly under the
+// given state, in the order of their identifiers.
+func (_DocStates) Children(id DocStateID) ([]*DocState, error) {
+	return DocStates.ChildrenContext(context.Background(), id)
+}
+
+// ChildrenContext is the same as `Children`, but runs its queries
+// under the given context.
+func (_DocStates) ChildrenContext(ctx context.Context, id DocStateID) ([]*DocState, error) {
+	if id <= 0 {
+		return nil, errors.New("ID should be a positive integer")
+	}
+
+	q := `
+	SELECT id, name
+	FROM wf_docstates_master
+	WHERE parent_id = ?
+	ORDER BY id
+	`
+	rows, err := db.QueryContext(ctx, rebind(q), id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ary := make([]*DocState, 0, 4)
+	for rows.Next() {
+		var elem DocState
+		err = rows.Scan(&elem.ID, &elem.Name)
+		if err != nil {
+			return nil, err
+		}
+		ary = append(ary, &elem)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return ary, nil
+}
+
+// IsDescendant answers `true` if state `a` is grouped, directly or
+// through intermediate states, under state `b`.  A state is not its
+// own descendant.
+func (_DocStates) IsDescendant(a, b DocStateID) (bool, error) {
+	return DocStates.IsDescendantContext(context.Background(), a, b)
+}
+
+// IsDescendantContext is the same as `IsDescendant`, but runs its
+// queries under the given context.
+func (_DocStates) IsDescendantContext(ctx context.Context, a, b DocStateID) (bool, error) {
+	if a <= 0 || b <= 0 {
+		return false, errors.New("state IDs should be positive integers")
+	}
+
+	ds, err := parentOf(ctx, nil, a)
+	for err == nil && ds > 0 {
+		if ds == b {
+			return true, nil
+		}
+		ds, err = parentOf(ctx, nil, ds)
+	}
+	return false, err
+}
+
+// parentOf answers the parent of the given document state, or `0` if
+// it has none.  It answers `ErrNotFound` for an unknown state.  The
+// given transaction is used, if any.
+func parentOf(ctx context.Context, otx *sql.Tx, id DocStateID) (DocStateID, error) {
+	q := `SELECT parent_id FROM wf_docstates_master WHERE id = ?`
+	var row *sql.Row
+	if otx == nil {
+		row = db.QueryRowContext(ctx, rebind(q), id)
+	} else {
+		row = otx.QueryRowContext(ctx, rebind(q), id)
+	}
+
+	var pid sql.NullInt64
+	err := row.Scan(&pid)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return 0, ErrNotFound
+		}
+		return 0, err
+	}
+
+	return DocStateID(pid.Int64), nil
+}
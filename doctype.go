@@ -15,7 +15,9 @@
 package flow
 
 import (
+	"context"
 	"database/sql"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"math"
@@ -65,14 +67,21 @@ func (_DocTypes) docStorName(dtid DocTypeID) string {
 
 // New creates and registers a new document type in the system.
 func (_DocTypes) New(otx *sql.Tx, name string) (DocTypeID, error) {
+	return DocTypes.NewContext(context.Background(), otx, name)
+}
+
+// NewContext is the same as `New`, but runs its queries under the
+// given context.
+func (_DocTypes) NewContext(ctx context.Context, otx *sql.Tx, name string) (DocTypeID, error) {
 	name = strings.TrimSpace(name)
 	if name == "" {
 		return 0, errors.New("name cannot be empty")
 	}
 
 	var tx *sql.Tx
+	var err error
 	if otx == nil {
-		tx, err := db.Begin()
+		tx, err = db.BeginTx(ctx, nil)
 		if err != nil {
 			return 0, err
 		}
@@ -81,30 +90,33 @@ func (_DocTypes) New(otx *sql.Tx, name string) (DocTypeID, error) {
 		tx = otx
 	}
 
-	res, err := tx.Exec("INSERT INTO wf_doctypes_master(name) VALUES(?)", name)
-	if err != nil {
-		return 0, err
-	}
 	var id int64
-	id, err = res.LastInsertId()
+	id, err = execInsert(ctx, tx, "INSERT INTO wf_doctypes_master(name) VALUES(?)", name)
 	if err != nil {
 		return 0, err
 	}
 
 	tbl := DocTypes.docStorName(DocTypeID(id))
 	q := `DROP TABLE IF EXISTS ` + tbl
-	res, err = tx.Exec(q)
+	_, err = tx.ExecContext(ctx, rebind(q))
 	if err != nil {
 		return 0, err
 	}
+	idCol := `id INT NOT NULL AUTO_INCREMENT`
+	if dialect == DialectPostgres {
+		idCol = `id SERIAL NOT NULL`
+	}
 	q = `
 	CREATE TABLE ` + tbl + ` (
-		id INT NOT NULL AUTO_INCREMENT,
+		` + idCol + `,
 		path VARCHAR(1000) NOT NULL,
 		ac_id INT NOT NULL,
 		docstate_id INT NOT NULL,
+		wf_version INT NOT NULL DEFAULT 1,
+		version INT NOT NULL DEFAULT 1,
 		group_id INT NOT NULL,
 		ctime TIMESTAMP NOT NULL,
+		state_since TIMESTAMP NULL,
 		title VARCHAR(250) NULL,
 		data TEXT NOT NULL,
 		PRIMARY KEY (id),
@@ -113,7 +125,7 @@ func (_DocTypes) New(otx *sql.Tx, name string) (DocTypeID, error) {
 		FOREIGN KEY (group_id) REFERENCES wf_groups_master(id)
 	)
 	`
-	res, err = tx.Exec(q)
+	_, err = tx.ExecContext(ctx, rebind(q))
 	if err != nil {
 		return 0, err
 	}
@@ -134,6 +146,12 @@ func (_DocTypes) New(otx *sql.Tx, name string) (DocTypeID, error) {
 // `limit` elements.  A value of `0` for `offset` fetches from the
 // beginning, while a value of `0` for `limit` fetches until the end.
 func (_DocTypes) List(offset, limit int64) ([]*DocType, error) {
+	return DocTypes.ListContext(context.Background(), offset, limit)
+}
+
+// ListContext is the same as `List`, but runs its queries under the
+// given context.
+func (_DocTypes) ListContext(ctx context.Context, offset, limit int64) ([]*DocType, error) {
 	if offset < 0 || limit < 0 {
 		return nil, errors.New("offset and limit must be non-negative integers")
 	}
@@ -147,7 +165,7 @@ func (_DocTypes) List(offset, limit int64) ([]*DocType, error) {
 	ORDER BY id
 	LIMIT ? OFFSET ?
 	`
-	rows, err := db.Query(q, limit, offset)
+	rows, err := db.QueryContext(ctx, rebind(q), limit, offset)
 	if err != nil {
 		return nil, err
 	}
@@ -171,14 +189,23 @@ func (_DocTypes) List(offset, limit int64) ([]*DocType, error) {
 
 // Get retrieves the document type for the given ID.
 func (_DocTypes) Get(id DocTypeID) (*DocType, error) {
+	return DocTypes.GetContext(context.Background(), id)
+}
+
+// GetContext is the same as `Get`, but runs its queries under the
+// given context.
+func (_DocTypes) GetContext(ctx context.Context, id DocTypeID) (*DocType, error) {
 	if id <= 0 {
 		return nil, errors.New("ID should be a positive integer")
 	}
 
 	var elem DocType
-	row := db.QueryRow("SELECT id, name FROM wf_doctypes_master WHERE id = ?", id)
+	row := db.QueryRowContext(ctx, rebind("SELECT id, name FROM wf_doctypes_master WHERE id = ?"), id)
 	err := row.Scan(&elem.ID, &elem.Name)
 	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrNotFound
+		}
 		return nil, err
 	}
 
@@ -188,15 +215,24 @@ func (_DocTypes) Get(id DocTypeID) (*DocType, error) {
 // GetByName answers the document type, if one with the given name is
 // registered; `nil` and the error, otherwise.
 func (_DocTypes) GetByName(name string) (*DocType, error) {
+	return DocTypes.GetByNameContext(context.Background(), name)
+}
+
+// GetByNameContext is the same as `GetByName`, but runs its queries
+// under the given context.
+func (_DocTypes) GetByNameContext(ctx context.Context, name string) (*DocType, error) {
 	name = strings.TrimSpace(name)
 	if name == "" {
 		return nil, errors.New("document type cannot be empty")
 	}
 
 	var elem DocType
-	row := db.QueryRow("SELECT id, name FROM wf_doctypes_master WHERE name = ?", name)
+	row := db.QueryRowContext(ctx, rebind("SELECT id, name FROM wf_doctypes_master WHERE name = ?"), name)
 	err := row.Scan(&elem.ID, &elem.Name)
 	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrNotFound
+		}
 		return nil, err
 	}
 
@@ -205,14 +241,21 @@ func (_DocTypes) GetByName(name string) (*DocType, error) {
 
 // Rename renames the given document type.
 func (_DocTypes) Rename(otx *sql.Tx, id DocTypeID, name string) error {
+	return DocTypes.RenameContext(context.Background(), otx, id, name)
+}
+
+// RenameContext is the same as `Rename`, but runs its queries under
+// the given context.
+func (_DocTypes) RenameContext(ctx context.Context, otx *sql.Tx, id DocTypeID, name string) error {
 	name = strings.TrimSpace(name)
 	if name == "" {
 		return errors.New("name cannot be empty")
 	}
 
 	var tx *sql.Tx
+	var err error
 	if otx == nil {
-		tx, err := db.Begin()
+		tx, err = db.BeginTx(ctx, nil)
 		if err != nil {
 			return err
 		}
@@ -221,7 +264,7 @@ func (_DocTypes) Rename(otx *sql.Tx, id DocTypeID, name string) error {
 		tx = otx
 	}
 
-	_, err := tx.Exec("UPDATE wf_doctypes_master SET name = ? WHERE id = ?", name, id)
+	_, err = tx.ExecContext(ctx, rebind("UPDATE wf_doctypes_master SET name = ? WHERE id = ?"), name, id)
 	if err != nil {
 		return err
 	}
@@ -236,11 +279,96 @@ func (_DocTypes) Rename(otx *sql.Tx, id DocTypeID, name string) error {
 	return nil
 }
 
+// SetCancelState specifies the state into which documents of the
+// given type are moved, when they are terminated through
+// `Workflows.Terminate`.  Specifying `0` removes the cancel state, so
+// that documents of this type cannot be terminated.
+func (_DocTypes) SetCancelState(otx *sql.Tx, dtype DocTypeID, state DocStateID) error {
+	return DocTypes.SetCancelStateContext(context.Background(), otx, dtype, state)
+}
+
+// SetCancelStateContext is the same as `SetCancelState`, but runs its
+// queries under the given context.
+func (_DocTypes) SetCancelStateContext(ctx context.Context, otx *sql.Tx, dtype DocTypeID, state DocStateID) error {
+	if dtype <= 0 || state < 0 {
+		return errors.New("document type ID should be a positive integer, and state ID non-negative")
+	}
+	ds := sql.NullInt64{Int64: int64(state), Valid: state > 0}
+
+	var tx *sql.Tx
+	var err error
+	if otx == nil {
+		tx, err = db.BeginTx(ctx, nil)
+		if err != nil {
+			return err
+		}
+		defer tx.Rollback()
+	} else {
+		tx = otx
+	}
+
+	if ds.Valid {
+		err = checkMasters(ctx, tx, dtype, []DocStateID{state}, nil)
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err = tx.ExecContext(ctx, rebind(`UPDATE wf_doctypes_master SET cancel_state_id = ? WHERE id = ?`), ds, dtype)
+	if err != nil {
+		return err
+	}
+
+	if otx == nil {
+		err = tx.Commit()
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// CancelState answers the state into which documents of the given
+// type are moved when terminated, or `0` if none is specified.
+func (_DocTypes) CancelState(dtype DocTypeID) (DocStateID, error) {
+	return DocTypes.CancelStateContext(context.Background(), dtype)
+}
+
+// CancelStateContext is the same as `CancelState`, but runs its
+// queries under the given context.
+func (_DocTypes) CancelStateContext(ctx context.Context, dtype DocTypeID) (DocStateID, error) {
+	return DocTypes.cancelState(ctx, nil, dtype)
+}
+
+// cancelState answers the cancel state of the given document type,
+// reading it in the given transaction, if any.
+func (_DocTypes) cancelState(ctx context.Context, otx *sql.Tx, dtype DocTypeID) (DocStateID, error) {
+	q := `SELECT cancel_state_id FROM wf_doctypes_master WHERE id = ?`
+	var ds sql.NullInt64
+	var err error
+	if otx == nil {
+		err = db.QueryRowContext(ctx, rebind(q), dtype).Scan(&ds)
+	} else {
+		err = otx.QueryRowContext(ctx, rebind(q), dtype).Scan(&ds)
+	}
+	switch {
+	case err == sql.ErrNoRows:
+		return 0, ErrNotFound
+	case err != nil:
+		return 0, err
+	}
+
+	return DocStateID(ds.Int64), nil
+}
+
 // Transition holds the information of which action results in which
 // state.
 type Transition struct {
-	Upon DocAction // If user/system has performed this action
-	To   DocState  // Document transitions into this state
+	From     DocState        // When document is in this state
+	Upon     DocAction       // If user/system has performed this action
+	To       DocState        // Document transitions into this state
+	Metadata json.RawMessage // Client-defined attributes, if any; opaque to `flow`
 }
 
 // TransitionMap holds the state transitions defined for this document
@@ -251,25 +379,59 @@ type TransitionMap struct {
 	Transitions map[DocActionID]Transition
 }
 
+// latestVersion answers the latest version of the workflow of the
+// given document type.  Transitions defined on the document type
+// belong to that version; those defined before the workflow itself
+// belong to its first version.
+func latestVersion(ctx context.Context, otx *sql.Tx, dtype DocTypeID) (int, error) {
+	q := `SELECT COALESCE(MAX(version), 1) FROM wf_workflows WHERE doctype_id = ?`
+	var row *sql.Row
+	if otx == nil {
+		row = db.QueryRowContext(ctx, rebind(q), dtype)
+	} else {
+		row = otx.QueryRowContext(ctx, rebind(q), dtype)
+	}
+
+	var version int
+	err := row.Scan(&version)
+	if err != nil {
+		return 0, err
+	}
+
+	return version, nil
+}
+
 // Transitions answers the possible document states into which a
-// document currently in the given state can transition.
+// document currently in the given state can transition, in the latest
+// version of the workflow of the given document type.
 func (_DocTypes) Transitions(dtype DocTypeID, from DocStateID) (map[DocStateID]*TransitionMap, error) {
+	return DocTypes.TransitionsContext(context.Background(), dtype, from)
+}
+
+// TransitionsContext is the same as `Transitions`, but runs its
+// queries under the given context.
+func (_DocTypes) TransitionsContext(ctx context.Context, dtype DocTypeID, from DocStateID) (map[DocStateID]*TransitionMap, error) {
+	version, err := latestVersion(ctx, nil, dtype)
+	if err != nil {
+		return nil, err
+	}
+
 	q := `
-	SELECT dst.from_state_id, dsm1.name, dst.docaction_id, dam.name, dam.reconfirm, dst.to_state_id, dsm2.name
+	SELECT dst.from_state_id, dsm1.name, dst.docaction_id, dam.name, dam.reconfirm, dst.to_state_id, dsm2.name, dst.metadata
 	FROM wf_docstate_transitions dst
 	JOIN wf_docstates_master dsm1 ON dsm1.id = dst.from_state_id
 	JOIN wf_docstates_master dsm2 ON dsm2.id = dst.to_state_id
 	JOIN wf_docactions_master dam ON dam.id = dst.docaction_id
 	WHERE dst.doctype_id = ?
+	AND dst.version = ?
 	`
 	var rows *sql.Rows
-	var err error
 	if from > 0 {
 		q += `AND dst.from_state_id = ?
 		`
-		rows, err = db.Query(q, dtype, from)
+		rows, err = db.QueryContext(ctx, rebind(q), dtype, version, from)
 	} else {
-		rows, err = db.Query(q, dtype)
+		rows, err = db.QueryContext(ctx, rebind(q), dtype, version)
 	}
 
 	if err != nil {
@@ -281,11 +443,17 @@ func (_DocTypes) Transitions(dtype DocTypeID, from DocStateID) (map[DocStateID]*
 	for rows.Next() {
 		var dsfrom DocState
 		var t Transition
-		err := rows.Scan(&dsfrom.ID, &dsfrom.Name, &t.Upon.ID, &t.Upon.Name, &t.Upon.Reconfirm, &t.To.ID, &t.To.Name)
+		var md sql.NullString
+		err := rows.Scan(&dsfrom.ID, &dsfrom.Name, &t.Upon.ID, &t.Upon.Name, &t.Upon.Reconfirm, &t.To.ID, &t.To.Name, &md)
 		if err != nil {
 			return nil, err
 		}
 
+		t.From = dsfrom
+		if md.Valid {
+			t.Metadata = json.RawMessage(md.String)
+		}
+
 		var elem *TransitionMap
 		ok := false
 		if elem, ok = res[dsfrom.ID]; !ok {
@@ -305,16 +473,17 @@ func (_DocTypes) Transitions(dtype DocTypeID, from DocStateID) (map[DocStateID]*
 }
 
 // _Transitions answers the possible document states into which a
-// document currently in the given state can transition.  Only
-// identifiers are answered in the map.
-func (_DocTypes) _Transitions(dtype DocTypeID, state DocStateID) (map[DocActionID]DocStateID, error) {
+// document currently in the given state can transition, in the given
+// workflow.  Only identifiers are answered in the map.
+func (_DocTypes) _Transitions(ctx context.Context, wid WorkflowID, state DocStateID) (map[DocActionID]DocStateID, error) {
 	q := `
-	SELECT docaction_id, to_state_id
-	FROM wf_docstate_transitions
-	WHERE doctype_id = ?
-	AND from_state_id = ?
+	SELECT dst.docaction_id, dst.to_state_id
+	FROM wf_docstate_transitions dst
+	JOIN wf_workflows wf ON wf.doctype_id = dst.doctype_id AND wf.version = dst.version
+	WHERE wf.id = ?
+	AND dst.from_state_id = ?
 	`
-	rows, err := db.Query(q, dtype, state)
+	rows, err := db.QueryContext(ctx, rebind(q), wid, state)
 	if err != nil {
 		return nil, err
 	}
@@ -338,12 +507,34 @@ func (_DocTypes) _Transitions(dtype DocTypeID, state DocStateID) (map[DocActionI
 }
 
 // AddTransition associates a target document state with a document
-// action performed on documents in the given current state.
+// action performed on documents in the given current state.  The
+// transition is added to the latest version of the workflow of the
+// document type; earlier versions are not altered.
+//
+// The document type, both the states and the action must already be
+// defined; an error naming the unknown ones is answered otherwise.
+// Adding a transition that is already defined answers
+// `*ErrDuplicateTransition`, as does adding one upon an action that
+// already leads out of the state into another target state.
+//
+// N.B. An action may lead out of a state into several target states
+// only when the state's node is a fork, which enters all of them, or
+// when the additional transitions are guarded; see `RegisterGuard`.
+// A guard must therefore be registered before its transition is
+// added.
 func (_DocTypes) AddTransition(otx *sql.Tx, dtype DocTypeID, state DocStateID,
+	action DocActionID, toState DocStateID) error {
+	return DocTypes.AddTransitionContext(context.Background(), otx, dtype, state, action, toState)
+}
+
+// AddTransitionContext is the same as `AddTransition`, but runs its
+// queries under the given context.
+func (_DocTypes) AddTransitionContext(ctx context.Context, otx *sql.Tx, dtype DocTypeID, state DocStateID,
 	action DocActionID, toState DocStateID) error {
 	var tx *sql.Tx
+	var err error
 	if otx == nil {
-		tx, err := db.Begin()
+		tx, err = db.BeginTx(ctx, nil)
 		if err != nil {
 			return err
 		}
@@ -352,11 +543,11 @@ func (_DocTypes) AddTransition(otx *sql.Tx, dtype DocTypeID, state DocStateID,
 		tx = otx
 	}
 
-	q := `
-	INSERT INTO wf_docstate_transitions(doctype_id, from_state_id, docaction_id, to_state_id)
-	VALUES(?, ?, ?, ?)
-	`
-	_, err := tx.Exec(q, dtype, state, action, toState)
+	version, err := latestVersion(ctx, tx, dtype)
+	if err != nil {
+		return err
+	}
+	err = addTransition(ctx, tx, dtype, version, state, action, toState)
 	if err != nil {
 		return err
 	}
@@ -371,12 +562,187 @@ func (_DocTypes) AddTransition(otx *sql.Tx, dtype DocTypeID, state DocStateID,
 	return nil
 }
 
+// addTransition adds the given transition to the given version of the
+// workflow of the given document type, within the given transaction.
+func addTransition(ctx context.Context, tx *sql.Tx, dtype DocTypeID, version int, state DocStateID,
+	action DocActionID, toState DocStateID) error {
+	err := checkMasters(ctx, tx, dtype, []DocStateID{state, toState}, []DocActionID{action})
+	if err != nil {
+		return err
+	}
+
+	// Report a duplicate distinctly, rather than as a driver-specific
+	// constraint violation.
+	var n int64
+	q := `
+	SELECT COUNT(*) FROM wf_docstate_transitions
+	WHERE doctype_id = ?
+	AND version = ?
+	AND from_state_id = ?
+	AND docaction_id = ?
+	AND to_state_id = ?
+	`
+	err = tx.QueryRowContext(ctx, rebind(q), dtype, version, state, action, toState).Scan(&n)
+	if err != nil {
+		return err
+	}
+	if n > 0 {
+		return &ErrDuplicateTransition{State: state, Action: action, To: toState}
+	}
+
+	seq, err := transitionSeq(ctx, tx, dtype, version, state, action, toState)
+	if err != nil {
+		return err
+	}
+
+	q = `
+	INSERT INTO wf_docstate_transitions(doctype_id, version, from_state_id, docaction_id, to_state_id, seq)
+	VALUES(?, ?, ?, ?, ?, ?)
+	`
+	_, err = tx.ExecContext(ctx, rebind(q), dtype, version, state, action, toState, seq)
+	return err
+}
+
+// transitionSeq answers the sequence number under which the given
+// transition should be recorded.  The primary transition of an action
+// out of a state has the number `0`; the schema admits only one such.
+// Those out of a fork, and guarded ones, are numbered after the
+// existing ones.
+func transitionSeq(ctx context.Context, tx *sql.Tx, dtype DocTypeID, version int, state DocStateID,
+	action DocActionID, toState DocStateID) (int, error) {
+	var ntype sql.NullString
+	q := `
+	SELECT wn.type
+	FROM wf_workflow_nodes wn
+	JOIN wf_workflows wf ON wf.id = wn.workflow_id
+	WHERE wf.doctype_id = ?
+	AND wf.version = ?
+	AND wn.docstate_id = ?
+	`
+	err := tx.QueryRowContext(ctx, rebind(q), dtype, version, state).Scan(&ntype)
+	if err != nil && err != sql.ErrNoRows {
+		return 0, err
+	}
+	fork := NodeType(ntype.String) == NodeTypeFork
+
+	guards.RLock()
+	guarded := guards.m[guardKey{dtype, state, action, toState}] != nil
+	guards.RUnlock()
+
+	var minSeq, maxSeq sql.NullInt64
+	q = `
+	SELECT MIN(seq), MAX(seq) FROM wf_docstate_transitions
+	WHERE doctype_id = ?
+	AND version = ?
+	AND from_state_id = ?
+	AND docaction_id = ?
+	`
+	err = tx.QueryRowContext(ctx, rebind(q), dtype, version, state, action).Scan(&minSeq, &maxSeq)
+	if err != nil {
+		return 0, err
+	}
+
+	switch {
+	case guarded:
+		if maxSeq.Valid {
+			return int(maxSeq.Int64) + 1, nil
+		}
+		return 1, nil
+
+	case fork && maxSeq.Valid:
+		return int(maxSeq.Int64) + 1, nil
+
+	case fork, !minSeq.Valid, minSeq.Int64 > 0:
+		return 0, nil
+	}
+
+	var existing DocStateID
+	q = `
+	SELECT to_state_id FROM wf_docstate_transitions
+	WHERE doctype_id = ?
+	AND version = ?
+	AND from_state_id = ?
+	AND docaction_id = ?
+	AND seq = 0
+	`
+	err = tx.QueryRowContext(ctx, rebind(q), dtype, version, state, action).Scan(&existing)
+	if err != nil {
+		return 0, err
+	}
+	return 0, &ErrDuplicateTransition{State: state, Action: action, To: toState, Existing: existing}
+}
+
+// checkMasters verifies that the given document type, states and
+// actions are all defined.  It answers an error naming all those that
+// are not.
+func checkMasters(ctx context.Context, tx *sql.Tx, dtype DocTypeID, states []DocStateID, actions []DocActionID) error {
+	exists := func(table string, id int64) (bool, error) {
+		var n int64
+		q := `SELECT COUNT(*) FROM ` + table + ` WHERE id = ?`
+		err := tx.QueryRowContext(ctx, rebind(q), id).Scan(&n)
+		if err != nil {
+			return false, fmt.Errorf("looking up %d in %s : %w", id, table, err)
+		}
+		return n > 0, nil
+	}
+
+	var msgs []string
+	ok, err := exists("wf_doctypes_master", int64(dtype))
+	if err != nil {
+		return err
+	}
+	if !ok {
+		msgs = append(msgs, fmt.Sprintf("unknown document type : %d", dtype))
+	}
+
+	var bad []string
+	for _, ds := range states {
+		ok, err = exists("wf_docstates_master", int64(ds))
+		if err != nil {
+			return err
+		}
+		if !ok {
+			bad = append(bad, fmt.Sprintf("%d", ds))
+		}
+	}
+	if len(bad) > 0 {
+		msgs = append(msgs, "unknown document states : "+strings.Join(bad, ", "))
+	}
+
+	bad = bad[:0]
+	for _, da := range actions {
+		ok, err = exists("wf_docactions_master", int64(da))
+		if err != nil {
+			return err
+		}
+		if !ok {
+			bad = append(bad, fmt.Sprintf("%d", da))
+		}
+	}
+	if len(bad) > 0 {
+		msgs = append(msgs, "unknown document actions : "+strings.Join(bad, ", "))
+	}
+
+	if len(msgs) > 0 {
+		return errors.New(strings.Join(msgs, "; "))
+	}
+	return nil
+}
+
 // RemoveTransition disassociates a target document state with a
-// document action performed on documents in the given current state.
+// document action performed on documents in the given current state,
+// in the latest version of the workflow of the document type.
 func (_DocTypes) RemoveTransition(otx *sql.Tx, dtype DocTypeID, state DocStateID, action DocActionID) error {
+	return DocTypes.RemoveTransitionContext(context.Background(), otx, dtype, state, action)
+}
+
+// RemoveTransitionContext is the same as `RemoveTransition`, but runs
+// its queries under the given context.
+func (_DocTypes) RemoveTransitionContext(ctx context.Context, otx *sql.Tx, dtype DocTypeID, state DocStateID, action DocActionID) error {
 	var tx *sql.Tx
+	var err error
 	if otx == nil {
-		tx, err := db.Begin()
+		tx, err = db.BeginTx(ctx, nil)
 		if err != nil {
 			return err
 		}
@@ -385,13 +751,19 @@ func (_DocTypes) RemoveTransition(otx *sql.Tx, dtype DocTypeID, state DocStateID
 		tx = otx
 	}
 
+	version, err := latestVersion(ctx, tx, dtype)
+	if err != nil {
+		return err
+	}
+
 	q := `
 	DELETE FROM wf_docstate_transitions
 	WHERE doctype_id = ?
+	AND version = ?
 	AND from_state_id =?
 	AND docaction_id = ?
 	`
-	_, err := tx.Exec(q, dtype, state, action)
+	_, err = tx.ExecContext(ctx, rebind(q), dtype, version, state, action)
 	if err != nil {
 		return err
 	}
@@ -405,3 +777,126 @@ func (_DocTypes) RemoveTransition(otx *sql.Tx, dtype DocTypeID, state DocStateID
 
 	return nil
 }
+
+// SetTransitionMetadata attaches the given JSON value to the specified
+// transition, replacing any attached earlier.  The value is opaque to
+// `flow`, and is answered with the transition by `Transitions` and
+// `Workflows.Transitions`.  An empty value removes the metadata.
+//
+// `ErrNotFound` is answered if the transition is not defined in the
+// latest version of the workflow of the document type.
+func (_DocTypes) SetTransitionMetadata(otx *sql.Tx, dtype DocTypeID, state DocStateID,
+	action DocActionID, toState DocStateID, data json.RawMessage) error {
+	return DocTypes.SetTransitionMetadataContext(context.Background(), otx, dtype, state, action, toState, data)
+}
+
+// SetTransitionMetadataContext is the same as
+// `SetTransitionMetadata`, but runs its queries under the given
+// context.
+func (_DocTypes) SetTransitionMetadataContext(ctx context.Context, otx *sql.Tx, dtype DocTypeID, state DocStateID,
+	action DocActionID, toState DocStateID, data json.RawMessage) error {
+	md := sql.NullString{String: string(data), Valid: len(data) > 0}
+	if md.Valid && !json.Valid(data) {
+		return errors.New("transition metadata should be valid JSON")
+	}
+
+	var tx *sql.Tx
+	var err error
+	if otx == nil {
+		tx, err = db.BeginTx(ctx, nil)
+		if err != nil {
+			return err
+		}
+		defer tx.Rollback()
+	} else {
+		tx = otx
+	}
+
+	version, err := latestVersion(ctx, tx, dtype)
+	if err != nil {
+		return err
+	}
+
+	// Rows left unchanged by an update need not be reported as
+	// affected; the transition's existence is checked explicitly.
+	var n int64
+	q := `
+	SELECT COUNT(*) FROM wf_docstate_transitions
+	WHERE doctype_id = ?
+	AND version = ?
+	AND from_state_id = ?
+	AND docaction_id = ?
+	AND to_state_id = ?
+	`
+	err = tx.QueryRowContext(ctx, rebind(q), dtype, version, state, action, toState).Scan(&n)
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrNotFound
+	}
+
+	q = `
+	UPDATE wf_docstate_transitions SET metadata = ?
+	WHERE doctype_id = ?
+	AND version = ?
+	AND from_state_id = ?
+	AND docaction_id = ?
+	AND to_state_id = ?
+	`
+	_, err = tx.ExecContext(ctx, rebind(q), md, dtype, version, state, action, toState)
+	if err != nil {
+		return err
+	}
+
+	if otx == nil {
+		err = tx.Commit()
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// GetTransitionMetadata answers the JSON value attached to the
+// specified transition, or `nil` if none is attached.  `ErrNotFound`
+// is answered if the transition is not defined in the latest version
+// of the workflow of the document type.
+func (_DocTypes) GetTransitionMetadata(dtype DocTypeID, state DocStateID,
+	action DocActionID, toState DocStateID) (json.RawMessage, error) {
+	return DocTypes.GetTransitionMetadataContext(context.Background(), dtype, state, action, toState)
+}
+
+// GetTransitionMetadataContext is the same as
+// `GetTransitionMetadata`, but runs its queries under the given
+// context.
+func (_DocTypes) GetTransitionMetadataContext(ctx context.Context, dtype DocTypeID, state DocStateID,
+	action DocActionID, toState DocStateID) (json.RawMessage, error) {
+	version, err := latestVersion(ctx, nil, dtype)
+	if err != nil {
+		return nil, err
+	}
+
+	q := `
+	SELECT metadata FROM wf_docstate_transitions
+	WHERE doctype_id = ?
+	AND version = ?
+	AND from_state_id = ?
+	AND docaction_id = ?
+	AND to_state_id = ?
+	`
+	var md sql.NullString
+	err = db.QueryRowContext(ctx, rebind(q), dtype, version, state, action, toState).Scan(&md)
+	switch {
+	case err == sql.ErrNoRows:
+		return nil, ErrNotFound
+	case err != nil:
+		return nil, err
+	}
+
+	if !md.Valid {
+		return nil, nil
+	}
+	return json.RawMessage(md.String), nil
+}
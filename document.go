@@ -15,6 +15,7 @@
 package flow
 
 import (
+	"context"
 	"crypto/sha1"
 	"database/sql"
 	"errors"
@@ -146,6 +147,8 @@ type Document struct {
 
 	Title string `json:"Title"`          // Human-readable title; applicable only for root documents
 	Data  string `json:"Data,omitempty"` // Primary content of the document
+
+	Version int64 `json:"Version"` // Incremented upon each state transition of this document
 }
 
 // Unexported type, only for convenience methods.
@@ -175,9 +178,19 @@ type DocumentsNewInput struct {
 // determined in the scope of the access context applicable to the
 // current state of the document.
 //
+// A root document begins in the begin state of the latest active
+// version of its type's workflow, unless a selector registered through
+// `Workflows.RegisterBeginSelector` chooses another.
+//
 // N.B. Blobs, tags and children documents have to be associated with
 // this document, if needed, through appropriate separate calls.
 func (_Documents) New(otx *sql.Tx, input *DocumentsNewInput) (DocumentID, error) {
+	return Documents.NewContext(context.Background(), otx, input)
+}
+
+// NewContext is the same as `New`, but runs its queries under the
+// given context.
+func (_Documents) NewContext(ctx context.Context, otx *sql.Tx, input *DocumentsNewInput) (DocumentID, error) {
 	if input.DocTypeID <= 0 || input.AccessContextID <= 0 || input.GroupID <= 0 {
 		return 0, errors.New("all identifiers should be positive integers")
 	}
@@ -185,11 +198,23 @@ func (_Documents) New(otx *sql.Tx, input *DocumentsNewInput) (DocumentID, error)
 		return 0, errors.New("document's body should be non-empty")
 	}
 
-	var dsid int64
-	var path DocPath
+	var tx *sql.Tx
 	var err error
+	if otx == nil {
+		tx, err = db.BeginTx(ctx, nil)
+		if err != nil {
+			return 0, err
+		}
+		defer tx.Rollback()
+	} else {
+		tx = otx
+	}
+
+	var dsid, wfid int64
+	wfv := 1
+	var path DocPath
 	if input.ParentID > 0 {
-		pdoc, err := Documents.Get(nil, input.ParentType, input.ParentID)
+		pdoc, err := Documents.GetContext(ctx, tx, input.ParentType, input.ParentID)
 		if err != nil {
 			return 0, err
 		}
@@ -200,13 +225,15 @@ func (_Documents) New(otx *sql.Tx, input *DocumentsNewInput) (DocumentID, error)
 		dsid = 1 // `__RESERVED_CHILD_STATE__`
 	} else {
 		q := `
-		SELECT docstate_id
+		SELECT id, docstate_id, version
 		FROM wf_workflows
 		WHERE doctype_id = ?
 		AND active = 1
+		ORDER BY version DESC
+		LIMIT 1
 		`
-		row := db.QueryRow(q, input.DocTypeID)
-		err = row.Scan(&dsid)
+		row := tx.QueryRowContext(ctx, rebind(q), input.DocTypeID)
+		err = row.Scan(&wfid, &dsid, &wfv)
 		if err != nil {
 			switch {
 			case err == sql.ErrNoRows:
@@ -218,28 +245,23 @@ func (_Documents) New(otx *sql.Tx, input *DocumentsNewInput) (DocumentID, error)
 		}
 	}
 
-	var tx *sql.Tx
-	if otx == nil {
-		tx, err := db.Begin()
-		if err != nil {
-			return 0, err
-		}
-		defer tx.Rollback()
-	} else {
-		tx = otx
-	}
-
 	tbl := DocTypes.docStorName(input.DocTypeID)
-	q2 := `INSERT INTO ` + tbl + `(path, ac_id, docstate_id, group_id, ctime, title, data)
-	VALUES (?, ?, ?, ?, NOW(), ?, ?)
+	q2 := `INSERT INTO ` + tbl + `(path, ac_id, docstate_id, wf_version, group_id, ctime, state_since, title, data)
+	VALUES (?, ?, ?, ?, ?, NOW(), ?, ?, ?)
 	`
-	res, err := tx.Exec(q2, string(path), input.AccessContextID, dsid, input.GroupID, input.Title, input.Data)
+	id, err := execInsert(ctx, tx, q2, string(path), input.AccessContextID, dsid, wfv, input.GroupID, time.Now().UTC(), input.Title, input.Data)
 	if err != nil {
 		return 0, err
 	}
-	id, err := res.LastInsertId()
-	if err != nil {
-		return 0, err
+	if input.ParentID == 0 {
+		ds, err := selectBeginState(ctx, tx, WorkflowID(wfid), input.DocTypeID, DocumentID(id), DocStateID(dsid))
+		if err != nil {
+			return 0, err
+		}
+		err = enterState(ctx, tx, input.DocTypeID, DocumentID(id), ds)
+		if err != nil {
+			return 0, err
+		}
 	}
 
 	if input.ParentID > 0 {
@@ -247,7 +269,7 @@ func (_Documents) New(otx *sql.Tx, input *DocumentsNewInput) (DocumentID, error)
 		INSERT INTO wf_document_children(parent_doctype_id, parent_id, child_doctype_id, child_id)
 		VALUES (?, ?, ?, ?)
 		`
-		res, err = tx.Exec(q2, input.ParentType, input.ParentID, input.DocTypeID, id)
+		_, err = tx.ExecContext(ctx, rebind(q2), input.ParentType, input.ParentID, input.DocTypeID, id)
 		if err != nil {
 			return 0, err
 		}
@@ -348,7 +370,7 @@ func (_Documents) List(input *DocumentsListInput, offset, limit int64) ([]*Docum
 
 	// Fetch document data.
 
-	rows, err := db.Query(q, args...)
+	rows, err := db.Query(rebind(q), args...)
 	if err != nil {
 		return nil, err
 	}
@@ -365,7 +387,7 @@ func (_Documents) List(input *DocumentsListInput, offset, limit int64) ([]*Docum
 
 		elem.DocType.ID = input.DocTypeID
 		q2 := `SELECT name FROM wf_doctypes_master WHERE id = ?`
-		row2 := db.QueryRow(q2, input.DocTypeID)
+		row2 := db.QueryRow(rebind(q2), input.DocTypeID)
 		err = row2.Scan(&elem.DocType.Name)
 		if err != nil {
 			return nil, err
@@ -383,16 +405,72 @@ func (_Documents) List(input *DocumentsListInput, offset, limit int64) ([]*Docum
 	return ary, nil
 }
 
+// ListByState answers the identifiers of the root documents of the
+// given type that are currently in the given state, in ascending
+// order.  This is useful for building work queues.
+//
+// As with `Workflows.List`, a value of `0` for `limit` fetches until
+// the end.
+func (_Documents) ListByState(dtype DocTypeID, state DocStateID, offset, limit int64) ([]DocumentID, error) {
+	return Documents.ListByStateContext(context.Background(), dtype, state, offset, limit)
+}
+
+// ListByStateContext is the same as `ListByState`, but runs its
+// queries under the given context.
+func (_Documents) ListByStateContext(ctx context.Context, dtype DocTypeID, state DocStateID, offset, limit int64) ([]DocumentID, error) {
+	if offset < 0 || limit < 0 {
+		return nil, errors.New("offset and limit must be non-negative integers")
+	}
+	if limit == 0 {
+		limit = math.MaxInt64
+	}
+
+	q := `
+	SELECT id
+	FROM ` + DocTypes.docStorName(dtype) + `
+	WHERE docstate_id = ?
+	AND path = ''
+	ORDER BY id
+	LIMIT ? OFFSET ?
+	`
+	rows, err := db.QueryContext(ctx, rebind(q), state, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ary := make([]DocumentID, 0, 10)
+	for rows.Next() {
+		var id DocumentID
+		err = rows.Scan(&id)
+		if err != nil {
+			return nil, err
+		}
+		ary = append(ary, id)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return ary, nil
+}
+
 // Get initialises a document by reading from the database.
 //
 // N.B. This retrieves the primary data of the document.  Other
 // information viz. blobs, tags and children documents have to be
 // fetched separately.
 func (_Documents) Get(otx *sql.Tx, dtype DocTypeID, id DocumentID) (*Document, error) {
+	return Documents.GetContext(context.Background(), otx, dtype, id)
+}
+
+// GetContext is the same as `Get`, but runs its queries under the
+// given context.
+func (_Documents) GetContext(ctx context.Context, otx *sql.Tx, dtype DocTypeID, id DocumentID) (*Document, error) {
 	tbl := DocTypes.docStorName(dtype)
 	var elem Document
 	q := `
-	SELECT docs.path, docs.ac_id, docs.group_id, gm.name, docs.ctime, docs.title, docs.data, docs.docstate_id, dsm.name
+	SELECT docs.path, docs.ac_id, docs.group_id, gm.name, docs.ctime, docs.title, docs.data, docs.docstate_id, dsm.name, docs.version
 	FROM ` + tbl + ` AS docs
 	JOIN wf_groups_master gm ON gm.id = docs.group_id
 	JOIN wf_docstates_master dsm ON docs.docstate_id = dsm.id
@@ -401,16 +479,19 @@ func (_Documents) Get(otx *sql.Tx, dtype DocTypeID, id DocumentID) (*Document, e
 
 	var row *sql.Row
 	if otx == nil {
-		row = db.QueryRow(q, id)
+		row = db.QueryRowContext(ctx, rebind(q), id)
 	} else {
-		row = otx.QueryRow(q, id)
+		row = otx.QueryRowContext(ctx, rebind(q), id)
 	}
-	err := row.Scan(&elem.Path, &elem.AccCtx.ID, &elem.Group.ID, &elem.Group.Name, &elem.Ctime, &elem.Title, &elem.Data, &elem.State.ID, &elem.State.Name)
+	err := row.Scan(&elem.Path, &elem.AccCtx.ID, &elem.Group.ID, &elem.Group.Name, &elem.Ctime, &elem.Title, &elem.Data, &elem.State.ID, &elem.State.Name, &elem.Version)
 	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrNotFound
+		}
 		return nil, err
 	}
 	q = `SELECT name FROM wf_doctypes_master WHERE id = ?`
-	row = db.QueryRow(q, dtype)
+	row = db.QueryRowContext(ctx, rebind(q), dtype)
 	err = row.Scan(&elem.DocType.Name)
 	if err != nil {
 		return nil, err
@@ -421,6 +502,74 @@ func (_Documents) Get(otx *sql.Tx, dtype DocTypeID, id DocumentID) (*Document, e
 	return &elem, nil
 }
 
+// CurrentState answers the current state of the given document.
+//
+// N.B. A document that has forked remains in the fork's state, which
+// is what is answered.  Use `ActiveStates` to learn the states of its
+// parallel branches.
+func (_Documents) CurrentState(otx *sql.Tx, dtype DocTypeID, id DocumentID) (DocStateID, error) {
+	return Documents.CurrentStateContext(context.Background(), otx, dtype, id)
+}
+
+// CurrentStateContext is the same as `CurrentState`, but runs its
+// queries under the given context.
+func (_Documents) CurrentStateContext(ctx context.Context, otx *sql.Tx, dtype DocTypeID, id DocumentID) (DocStateID, error) {
+	q := `SELECT docstate_id FROM ` + DocTypes.docStorName(dtype) + ` WHERE id = ?`
+
+	var row *sql.Row
+	if otx == nil {
+		row = db.QueryRowContext(ctx, rebind(q), id)
+	} else {
+		row = otx.QueryRowContext(ctx, rebind(q), id)
+	}
+	var state DocStateID
+	err := row.Scan(&state)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return 0, ErrNotFound
+		}
+		return 0, err
+	}
+
+	return state, nil
+}
+
+// Workflow answers the workflow that governs the given document.
+// This is the version of its document type's workflow under which the
+// document was created, which may have been archived since.
+func (_Documents) Workflow(otx *sql.Tx, dtype DocTypeID, id DocumentID) (*Workflow, error) {
+	return Documents.WorkflowContext(context.Background(), otx, dtype, id)
+}
+
+// WorkflowContext is the same as `Workflow`, but runs its queries
+// under the given context.
+func (_Documents) WorkflowContext(ctx context.Context, otx *sql.Tx, dtype DocTypeID, id DocumentID) (*Workflow, error) {
+	q := `
+	SELECT wf.id
+	FROM wf_workflows wf
+	JOIN ` + DocTypes.docStorName(dtype) + ` docs ON docs.wf_version = wf.version
+	WHERE wf.doctype_id = ?
+	AND docs.id = ?
+	`
+
+	var row *sql.Row
+	if otx == nil {
+		row = db.QueryRowContext(ctx, rebind(q), dtype, id)
+	} else {
+		row = otx.QueryRowContext(ctx, rebind(q), dtype, id)
+	}
+	var wid WorkflowID
+	err := row.Scan(&wid)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+
+	return Workflows.GetContext(ctx, wid)
+}
+
 // GetParent answers the parent document of the specified document.
 func (_Documents) GetParent(otx *sql.Tx, dtype DocTypeID, id DocumentID) (*Document, error) {
 	q := `
@@ -432,9 +581,9 @@ func (_Documents) GetParent(otx *sql.Tx, dtype DocTypeID, id DocumentID) (*Docum
 	`
 	var row *sql.Row
 	if otx == nil {
-		row = db.QueryRow(q, dtype, id)
+		row = db.QueryRow(rebind(q), dtype, id)
 	} else {
-		row = otx.QueryRow(q, dtype, id)
+		row = otx.QueryRow(rebind(q), dtype, id)
 	}
 	var ptid, pid int64
 	err := row.Scan(&ptid, &pid)
@@ -448,23 +597,76 @@ func (_Documents) GetParent(otx *sql.Tx, dtype DocTypeID, id DocumentID) (*Docum
 	return Documents.Get(otx, DocTypeID(ptid), DocumentID(pid))
 }
 
+// workflowVersion answers the version of its document type's
+// workflow, under which the given document was created.
+func (_Documents) workflowVersion(ctx context.Context, otx *sql.Tx, dtype DocTypeID, id DocumentID) (int, error) {
+	var wv int
+	q := `SELECT wf_version FROM ` + DocTypes.docStorName(dtype) + ` WHERE id = ?`
+	err := otx.QueryRowContext(ctx, rebind(q), id).Scan(&wv)
+	if err != nil {
+		return 0, err
+	}
+
+	return wv, nil
+}
+
+// lockDocs is set when document rows should be locked as events are
+// applied to them.
+var lockDocs bool
+
+// lock locks the row of the given document until the end of the given
+// transaction.  Both supported dialects understand `FOR UPDATE`.
+func (_Documents) lock(ctx context.Context, otx *sql.Tx, dtype DocTypeID, id DocumentID) error {
+	var did DocumentID
+	q := `SELECT id FROM ` + DocTypes.docStorName(dtype) + ` WHERE id = ? FOR UPDATE`
+	err := otx.QueryRowContext(ctx, rebind(q), id).Scan(&did)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return ErrNotFound
+		}
+		return err
+	}
+
+	return nil
+}
+
 // setState sets the new state of the document.
 //
+// The document should still be at the given version, as read by the
+// caller; `ErrConcurrentModification` is answered otherwise.  The
+// version is then incremented.
+//
 // This method is not exported.  It is used internally by `Workflow`
 // to move the document along the workflow, into a new document state.
-func (_Documents) setState(otx *sql.Tx, dtype DocTypeID, id DocumentID, state DocStateID, ac AccessContextID) error {
+func (_Documents) setState(ctx context.Context, otx *sql.Tx, dtype DocTypeID, id DocumentID, state DocStateID, ac AccessContextID, version int64) error {
 	tbl := DocTypes.docStorName(dtype)
 
 	var q string
+	var res sql.Result
 	var err error
+	// The time of entry is compared with that given to
+	// `ProcessTimeouts`; both are by the application's clock, in UTC.
+	now := time.Now().UTC()
 	if ac > 0 {
-		q = `UPDATE ` + tbl + ` SET docstate_id = ?, ac_id = ? WHERE id = ?`
-		_, err = otx.Exec(q, state, ac, id)
+		q = `UPDATE ` + tbl + ` SET docstate_id = ?, ac_id = ?, state_since = ?, version = version + 1 WHERE id = ? AND version = ?`
+		res, err = otx.ExecContext(ctx, rebind(q), state, ac, now, id, version)
 	} else {
-		q = `UPDATE ` + tbl + ` SET docstate_id = ? WHERE id = ?`
-		_, err = otx.Exec(q, state, id)
+		q = `UPDATE ` + tbl + ` SET docstate_id = ?, state_since = ?, version = version + 1 WHERE id = ? AND version = ?`
+		res, err = otx.ExecContext(ctx, rebind(q), state, now, id, version)
+	}
+	if err != nil {
+		return err
 	}
-	return err
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrConcurrentModification
+	}
+
+	logger.Infof("document %d/%d changed state to %d", dtype, id, state)
+	return enterState(ctx, otx, dtype, id, state)
 }
 
 // SetTitle sets the title of the document.
@@ -479,7 +681,7 @@ func (_Documents) SetTitle(otx *sql.Tx, dtype DocTypeID, id DocumentID, title st
 	var path DocPath
 	var dgroup GroupID
 	q := `SELECT path, group_id FROM ` + tbl + ` WHERE id = ?`
-	row := db.QueryRow(q, id)
+	row := db.QueryRow(rebind(q), id)
 	err := row.Scan(&path, &dgroup)
 	if err != nil {
 		return err
@@ -490,7 +692,7 @@ func (_Documents) SetTitle(otx *sql.Tx, dtype DocTypeID, id DocumentID, title st
 
 	var tx *sql.Tx
 	if otx == nil {
-		tx, err := db.Begin()
+		tx, err = db.Begin()
 		if err != nil {
 			return err
 		}
@@ -500,7 +702,7 @@ func (_Documents) SetTitle(otx *sql.Tx, dtype DocTypeID, id DocumentID, title st
 	}
 
 	q = `UPDATE ` + tbl + ` SET title = ?, ctime = NOW() WHERE id = ?`
-	_, err = tx.Exec(q, title, id)
+	_, err = tx.Exec(rebind(q), title, id)
 	if err != nil {
 		return err
 	}
@@ -523,8 +725,9 @@ func (_Documents) SetData(otx *sql.Tx, dtype DocTypeID, id DocumentID, data stri
 	tbl := DocTypes.docStorName(dtype)
 
 	var tx *sql.Tx
+	var err error
 	if otx == nil {
-		tx, err := db.Begin()
+		tx, err = db.Begin()
 		if err != nil {
 			return err
 		}
@@ -534,7 +737,7 @@ func (_Documents) SetData(otx *sql.Tx, dtype DocTypeID, id DocumentID, data stri
 	}
 
 	q := `UPDATE ` + tbl + ` SET data = ?, ctime = NOW() WHERE id = ?`
-	_, err := tx.Exec(q, data, id)
+	_, err = tx.Exec(rebind(q), data, id)
 	if err != nil {
 		return err
 	}
@@ -558,7 +761,7 @@ func (_Documents) Blobs(dtype DocTypeID, id DocumentID) ([]*Blob, error) {
 	WHERE doctype_id = ?
 	AND doc_id = ?
 	`
-	rows, err := db.Query(q, dtype, id)
+	rows, err := db.Query(rebind(q), dtype, id)
 	if err != nil {
 		return nil, err
 	}
@@ -595,7 +798,7 @@ func (_Documents) GetBlob(dtype DocTypeID, id DocumentID, blob *Blob) error {
 	AND doc_id = ?
 	AND sha1sum = ?
 	`
-	row := db.QueryRow(q, dtype, id, blob.SHA1Sum)
+	row := db.QueryRow(rebind(q), dtype, id, blob.SHA1Sum)
 	var b Blob
 	err := row.Scan(&b.Name, &b.Path)
 	if err != nil {
@@ -667,7 +870,7 @@ func (_Documents) AddBlob(otx *sql.Tx, dtype DocTypeID, id DocumentID, blob *Blo
 
 	var tx *sql.Tx
 	if otx == nil {
-		tx, err := db.Begin()
+		tx, err = db.Begin()
 		if err != nil {
 			return err
 		}
@@ -682,7 +885,7 @@ func (_Documents) AddBlob(otx *sql.Tx, dtype DocTypeID, id DocumentID, blob *Blo
 	INSERT INTO wf_document_blobs(doctype_id, doc_id, name, path, sha1sum)
 	VALUES(?, ?, ?, ?, ?)
 	`
-	_, err = tx.Exec(q, dtype, id, blob.Name, bpath, csum)
+	_, err = tx.Exec(rebind(q), dtype, id, blob.Name, bpath, csum)
 	if err != nil {
 		return err
 	}
@@ -705,8 +908,9 @@ func (_Documents) DeleteBlob(otx *sql.Tx, dtype DocTypeID, id DocumentID, sha1 s
 	}
 
 	var tx *sql.Tx
+	var err error
 	if otx == nil {
-		tx, err := db.Begin()
+		tx, err = db.Begin()
 		if err != nil {
 			return err
 		}
@@ -721,8 +925,8 @@ func (_Documents) DeleteBlob(otx *sql.Tx, dtype DocTypeID, id DocumentID, sha1 s
 	WHERE sha1sum = ?
 	`
 	var count int64
-	row := tx.QueryRow(q, sha1)
-	err := row.Scan(&count)
+	row := tx.QueryRow(rebind(q), sha1)
+	err = row.Scan(&count)
 	if err != nil {
 		return err
 	}
@@ -735,7 +939,7 @@ func (_Documents) DeleteBlob(otx *sql.Tx, dtype DocTypeID, id DocumentID, sha1 s
 		AND sha1sum = ?
 		`
 		var path string
-		row = tx.QueryRow(q, dtype, id, sha1)
+		row = tx.QueryRow(rebind(q), dtype, id, sha1)
 		err = row.Scan(&path)
 		if err != nil {
 			return err
@@ -753,7 +957,7 @@ func (_Documents) DeleteBlob(otx *sql.Tx, dtype DocTypeID, id DocumentID, sha1 s
 	AND doc_id = ?
 	AND sha1sum = ?
 	`
-	_, err = tx.Exec(q, dtype, id, sha1)
+	_, err = tx.Exec(rebind(q), dtype, id, sha1)
 	if err != nil {
 		return err
 	}
@@ -777,7 +981,7 @@ func (_Documents) Tags(dtype DocTypeID, id DocumentID) ([]string, error) {
 	WHERE doctype_id = ?
 	AND doc_id = ?
 	`
-	rows, err := db.Query(q, dtype, id)
+	rows, err := db.Query(rebind(q), dtype, id)
 	if err != nil {
 		return nil, err
 	}
@@ -815,7 +1019,7 @@ func (_Documents) AddTags(otx *sql.Tx, dtype DocTypeID, id DocumentID, tags ...s
 	LIMIT 1
 	`
 	var tid int64
-	row := db.QueryRow(q, dtype, id)
+	row := db.QueryRow(rebind(q), dtype, id)
 	err := row.Scan(&tid)
 	if err == nil {
 		return ErrDocumentIsChild
@@ -826,7 +1030,7 @@ func (_Documents) AddTags(otx *sql.Tx, dtype DocTypeID, id DocumentID, tags ...s
 
 	var tx *sql.Tx
 	if otx == nil {
-		tx, err := db.Begin()
+		tx, err = db.Begin()
 		if err != nil {
 			return err
 		}
@@ -844,7 +1048,7 @@ func (_Documents) AddTags(otx *sql.Tx, dtype DocTypeID, id DocumentID, tags ...s
 	for _, tag := range tags {
 		tag = strings.TrimSpace(tag)
 		tag = strings.ToLower(tag)
-		_, err = tx.Exec(q, dtype, id, tag)
+		_, err = tx.Exec(rebind(q), dtype, id, tag)
 		if err != nil {
 			return err
 		}
@@ -869,8 +1073,9 @@ func (_Documents) RemoveTag(otx *sql.Tx, dtype DocTypeID, id DocumentID, tag str
 	tag = strings.ToLower(tag)
 
 	var tx *sql.Tx
+	var err error
 	if otx == nil {
-		tx, err := db.Begin()
+		tx, err = db.Begin()
 		if err != nil {
 			return err
 		}
@@ -886,7 +1091,7 @@ func (_Documents) RemoveTag(otx *sql.Tx, dtype DocTypeID, id DocumentID, tag str
 	AND doc_id = ?
 	AND tag = ?
 	`
-	_, err := tx.Exec(q, dtype, id, tag)
+	_, err = tx.Exec(rebind(q), dtype, id, tag)
 	if err != nil {
 		return err
 	}
@@ -917,7 +1122,7 @@ func (_Documents) ChildrenIDs(dtype DocTypeID, id DocumentID) ([]struct {
 	WHERE parent_doctype_id = ?
 	AND parent_id = ?
 	`
-	rows, err := db.Query(q, dtype, id)
+	rows, err := db.Query(rebind(q), dtype, id)
 	if err != nil {
 		return nil, err
 	}
@@ -0,0 +1,80 @@
+// (c) Copyright 2015-2017 JONNALAGADDA Srinivas
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package flow
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// ToDOT answers the graph of the given workflow as Graphviz DOT
+// source.  Document states form the vertices, labelled with their
+// names, and transitions the edges, labelled with the names of their
+// actions.  The begin state is highlighted.
+func (_Workflows) ToDOT(wid WorkflowID) (string, error) {
+	return Workflows.ToDOTContext(context.Background(), wid)
+}
+
+// ToDOTContext is the same as `ToDOT`, but runs its queries under the
+// given context.
+func (_Workflows) ToDOTContext(ctx context.Context, wid WorkflowID) (string, error) {
+	wf, err := Workflows.GetContext(ctx, wid)
+	if err != nil {
+		return "", err
+	}
+	ts, err := Workflows.TransitionsContext(ctx, wf.DocType.ID)
+	if err != nil {
+		return "", err
+	}
+
+	return dotSource(wf, ts), nil
+}
+
+// dotSource renders the given workflow and transitions as DOT.
+// Vertices appear in the order of their first mention, beginning with
+// the begin state, so that the output is stable.
+func dotSource(wf *Workflow, ts []Transition) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "digraph %s {\n", dotQuote(wf.Name))
+	b.WriteString("\trankdir=LR;\n")
+
+	seen := map[DocStateID]bool{wf.BeginState.ID: true}
+	fmt.Fprintf(&b, "\ts%d [label=%s, style=\"bold,filled\", fillcolor=lightgrey];\n", wf.BeginState.ID, dotQuote(wf.BeginState.Name))
+	vertex := func(ds DocState) {
+		if seen[ds.ID] {
+			return
+		}
+		seen[ds.ID] = true
+		fmt.Fprintf(&b, "\ts%d [label=%s];\n", ds.ID, dotQuote(ds.Name))
+	}
+	for _, t := range ts {
+		vertex(t.From)
+		vertex(t.To)
+	}
+
+	for _, t := range ts {
+		fmt.Fprintf(&b, "\ts%d -> s%d [label=%s];\n", t.From.ID, t.To.ID, dotQuote(t.Upon.Name))
+	}
+
+	b.WriteString("}\n")
+	return b.String()
+}
+
+// dotQuote answers the given string as a quoted DOT identifier.
+func dotQuote(s string) string {
+	r := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+	return `"` + r.Replace(s) + `"`
+}
@@ -0,0 +1,341 @@
+// (c) Copyright 2015-2017 JONNALAGADDA Srinivas
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package flow
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Engine holds the database handle of `flow`, together with the
+// configuration with which it is accessed.
+//
+// The package-level resources -- `Workflows`, `Documents`, etc. --
+// operate on the engine installed through `Open` or `RegisterDB`.
+//
+// N.B. `flow` does not support multiple engines in a process : the
+// resources read the installed engine's configuration from package
+// state.  A second engine can be opened only after the first is
+// closed.
+type Engine struct {
+	db            *sql.DB
+	dialect       Dialect
+	prefix        string
+	maxAttempts   int
+	logger        Logger
+	metrics       Metrics
+	lockDocs      bool
+	asyncMessages bool
+	nodeCacheSize int
+
+	mu       sync.Mutex     // Guards `closed`, and additions to `inflight`
+	closed   bool           // Set by `Close`
+	inflight sync.WaitGroup // Transitions in progress
+}
+
+// Option configures an engine being opened.
+type Option func(e *Engine) error
+
+// WithDialect specifies the SQL dialect of the database handle.  The
+// default is `DialectMySQL`.
+func WithDialect(d Dialect) Option {
+	return func(e *Engine) error {
+		switch d {
+		case DialectMySQL, DialectPostgres:
+			e.dialect = d
+			return nil
+
+		default:
+			return fmt.Errorf("unknown SQL dialect : %d", d)
+		}
+	}
+}
+
+// WithTablePrefix specifies a prefix for the names of all the tables
+// of `flow`, so that `wf_workflows` becomes `<prefix>wf_workflows`.
+// This allows `flow` to share a schema with other applications.  It
+// may contain only ASCII letters, digits and underscores.  The default
+// is no prefix.
+//
+// N.B. The tables themselves must be created with the prefixed names.
+func WithTablePrefix(prefix string) Option {
+	return func(e *Engine) error {
+		for _, r := range prefix {
+			switch {
+			case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '_':
+				// Intentionally left blank
+
+			default:
+				return fmt.Errorf("invalid character in table prefix : %q", r)
+			}
+		}
+		e.prefix = prefix
+		return nil
+	}
+}
+
+// WithMaxTxAttempts specifies the number of times that a transaction
+// begun by `flow` is attempted, when it fails owing to a deadlock or a
+// serialisation failure.  The default is `DefMaxTxAttempts`.
+func WithMaxTxAttempts(n int) Option {
+	return func(e *Engine) error {
+		if n < 1 {
+			return errors.New("transactions should be attempted at least once")
+		}
+		e.maxAttempts = n
+		return nil
+	}
+}
+
+// WithDocumentLocking specifies whether the document row is locked,
+// through `SELECT ... FOR UPDATE`, as each event is applied.  This
+// serialises concurrent transitions of a document, instead of failing
+// all but one of them with `ErrConcurrentModification`.  The default
+// is not to lock.
+//
+// N.B. Each event already locks its own row first; the document's row
+// is locked next.  Transactions that apply events to several documents
+// -- `ApplyEvents`, `BulkApply`, or those of the caller -- should visit
+// documents in a consistent order, lest they deadlock.  Transactions
+// begun by `flow` itself are retried upon deadlocks; see
+// `WithMaxTxAttempts`.
+func WithDocumentLocking(lock bool) Option {
+	return func(e *Engine) error {
+		e.lockDocs = lock
+		return nil
+	}
+}
+
+// WithAsyncMessages specifies whether the messages posted by
+// transitions are delivered into mailboxes asynchronously.  When set,
+// each transition writes its messages, and a row per recipient into an
+// outbox table, in its own transaction; the mailboxes are written only
+// later, by `Mailboxes.DeliverPending` or `Mailboxes.RunDelivery`.
+// This keeps mailbox writes out of the transition's transaction.  The
+// default is to deliver synchronously.
+//
+// N.B. Delivery is eventually consistent : a message is absent from
+// its recipients' mailboxes until the outbox is next drained, and a
+// message may be retried after a failure, though it appears in each
+// mailbox only once.  Registered notifiers are still invoked as soon
+// as the transition commits.
+func WithAsyncMessages(async bool) Option {
+	return func(e *Engine) error {
+		e.asyncMessages = async
+		return nil
+	}
+}
+
+// WithNodeCache enables an in-memory cache of the given number of
+// workflow nodes, which are otherwise read from the database each time
+// an event is applied.  The least recently used nodes are evicted when
+// the cache is full.  The default is no cache.
+//
+// The nodes of a workflow are kept out of the cache while nodes are
+// added to or removed from it, or re-routed, through `Workflows`, and
+// are read afresh once that transaction ends.  A transaction that the
+// caller began other than through `WithTx` cannot be seen to end;
+// the nodes it alters are not cached again.  Lookups within a caller's
+// transaction bypass the cache.  Nodes altered directly in the
+// database, or by another process, are not noticed; the cache should
+// be enabled only when workflow definitions are altered through this
+// engine alone.
+func WithNodeCache(size int) Option {
+	return func(e *Engine) error {
+		if size < 0 {
+			return errors.New("node cache size should be non-negative")
+		}
+		e.nodeCacheSize = size
+		return nil
+	}
+}
+
+// engine is the currently installed engine.
+var engine = &Engine{dialect: DialectMySQL, maxAttempts: DefMaxTxAttempts, logger: nopLogger{}, metrics: nopMetrics{}}
+
+// installMu serialises the installation of engines, and changes to the
+// configuration of the installed one.
+var installMu sync.Mutex
+
+// Open constructs an engine over the given, already initialised,
+// database handle, configured by the given options.  The engine is
+// installed as the one that `flow` operates on.  `ErrEngineOpen` is
+// answered if another engine is installed, and has not been closed.
+//
+// N.B. This, or `RegisterDB`, **MUST** be called before anything else
+// in `flow`, and not while operations are in progress.
+func Open(sdb *sql.DB, opts ...Option) (*Engine, error) {
+	if sdb == nil {
+		return nil, errors.New("given database handle is `nil`")
+	}
+
+	e := &Engine{db: sdb, dialect: DialectMySQL, maxAttempts: DefMaxTxAttempts, logger: nopLogger{}, metrics: nopMetrics{}}
+	for _, opt := range opts {
+		if err := opt(e); err != nil {
+			return nil, err
+		}
+	}
+
+	installMu.Lock()
+	defer installMu.Unlock()
+
+	if engine.isOpen() {
+		return nil, ErrEngineOpen
+	}
+	install(e)
+	return e, nil
+}
+
+// install makes the given engine the one that `flow` operates on.
+// The caller should hold `installMu`.
+func install(e *Engine) {
+	engine = e
+	db = e.db
+	dialect = e.dialect
+	tablePrefix = e.prefix
+	maxTxAttempts = e.maxAttempts
+	logger = e.logger
+	metrics = e.metrics
+	lockDocs = e.lockDocs
+	asyncMessages = e.asyncMessages
+	nodeCache = newNodeLRU(e.nodeCacheSize)
+}
+
+// isOpen answers `true` if this engine has a database handle, and has
+// not been closed.
+func (e *Engine) isOpen() bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	return e.db != nil && !e.closed
+}
+
+// DefaultEngine answers the currently installed engine.
+func DefaultEngine() *Engine {
+	return engine
+}
+
+// DB answers the database handle of this engine.
+func (e *Engine) DB() *sql.DB {
+	return e.db
+}
+
+// Dialect answers the SQL dialect of this engine.
+func (e *Engine) Dialect() Dialect {
+	return e.dialect
+}
+
+// TablePrefix answers the prefix of the names of this engine's tables.
+func (e *Engine) TablePrefix() string {
+	return e.prefix
+}
+
+// MaxTxAttempts answers the number of times that this engine attempts
+// a transaction that fails owing to a deadlock.
+func (e *Engine) MaxTxAttempts() int {
+	return e.maxAttempts
+}
+
+// Logger answers the logger of this engine.
+func (e *Engine) Logger() Logger {
+	return e.logger
+}
+
+// DocumentLocking answers `true` if this engine locks documents as
+// events are applied to them.
+func (e *Engine) DocumentLocking() bool {
+	return e.lockDocs
+}
+
+// AsyncMessages answers `true` if this engine delivers messages into
+// mailboxes asynchronously, through an outbox.
+func (e *Engine) AsyncMessages() bool {
+	return e.asyncMessages
+}
+
+// NodeCacheSize answers the number of nodes that this engine caches,
+// or `0` if it caches none.
+func (e *Engine) NodeCacheSize() int {
+	return e.nodeCacheSize
+}
+
+// Metrics answers the metrics sink of this engine.
+func (e *Engine) Metrics() Metrics {
+	return e.metrics
+}
+
+// DefCloseTimeout is the time for which `Close` waits for transitions
+// in progress to complete.
+const DefCloseTimeout = 30 * time.Second
+
+// Close shuts this engine down gracefully.  Transitions begun
+// thereafter are refused with `ErrEngineClosed`, while those in
+// progress -- including the delivery of their notifications, which
+// follows their commit on goroutines of their own -- are waited for,
+// up to `DefCloseTimeout`.  `context.DeadlineExceeded` is answered if
+// some are still in progress then.
+//
+// The engine runs no other goroutines of its own; timeouts are processed
+// only when `ProcessTimeouts` is invoked, and asynchronous messages
+// delivered only when `Mailboxes.DeliverPending` is.  Callers that
+// invoke them periodically -- or run `Mailboxes.RunDelivery` -- should
+// stop doing so before closing the engine.
+//
+// N.B. The database handle belongs to the caller, and is not closed.
+func (e *Engine) Close() error {
+	ctx, cancel := context.WithTimeout(context.Background(), DefCloseTimeout)
+	defer cancel()
+	return e.CloseContext(ctx)
+}
+
+// CloseContext is the same as `Close`, but waits for transitions in
+// progress only until the given context is done.
+func (e *Engine) CloseContext(ctx context.Context) error {
+	e.mu.Lock()
+	e.closed = true
+	e.mu.Unlock()
+
+	drained := make(chan struct{})
+	go func() {
+		e.inflight.Wait()
+		close(drained)
+	}()
+
+	select {
+	case <-drained:
+		return nil
+
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// track registers a transition in progress on this engine, and
+// answers the function that marks its completion.  Transitions are
+// refused once the engine is closed.
+func (e *Engine) track() (func(), error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.closed {
+		return nil, ErrEngineClosed
+	}
+	e.inflight.Add(1)
+	return e.inflight.Done, nil
+}
@@ -14,6 +14,11 @@
 
 package flow
 
+import (
+	"fmt"
+	"strings"
+)
+
 // Error defines `flow`-specific errors, and satisfies the `error`
 // interface.
 type Error string
@@ -28,6 +33,8 @@ func (e Error) Error() string {
 const (
 	// ErrUnknown : unknown internal error
 	ErrUnknown = Error("ErrUnknown : unknown internal error")
+	// ErrNotFound : requested entity does not exist
+	ErrNotFound = Error("ErrNotFound : requested entity does not exist")
 
 	// ErrDocEventRedundant : another equivalent event has already effected this action
 	ErrDocEventRedundant = Error("ErrDocEventRedundant : another equivalent event has already applied this action")
@@ -37,17 +44,177 @@ const (
 	ErrDocEventStateMismatch = Error("ErrDocEventStateMismatch : document's state does not match event's state")
 	// ErrDocEventAlreadyApplied : event already applied; nothing to do
 	ErrDocEventAlreadyApplied = Error("ErrDocEventAlreadyApplied : event already applied; nothing to do")
+	// ErrDocEventCancelled : event was cancelled, and cannot be applied
+	ErrDocEventCancelled = Error("ErrDocEventCancelled : event was cancelled, and cannot be applied")
+	// ErrDocEventKeyConflict : another event on this document has this idempotency key
+	ErrDocEventKeyConflict = Error("ErrDocEventKeyConflict : another event on this document has this idempotency key")
+
+	// ErrConcurrentModification : document was transitioned concurrently; reload, and retry
+	ErrConcurrentModification = Error("ErrConcurrentModification : document was transitioned concurrently; reload, and retry")
 
 	// ErrDocumentNoParent : document is a root document
 	ErrDocumentNoParent = Error("ErrDocumentNoParent : document is a root document")
 	// ErrDocumentIsChild : cannot have its own state, title or tags
 	ErrDocumentIsChild = Error("ErrDocumentIsChild : cannot have its own state, title or tags")
+	// ErrDocumentNoPriorState : document has no earlier state to revert to
+	ErrDocumentNoPriorState = Error("ErrDocumentNoPriorState : document has no earlier state to revert to")
+	// ErrDocumentTerminated : document has been terminated, and accepts no more events
+	ErrDocumentTerminated = Error("ErrDocumentTerminated : document has been terminated, and accepts no more events")
+
+	// ErrDocTypeNoCancelState : document type defines no cancel state
+	ErrDocTypeNoCancelState = Error("ErrDocTypeNoCancelState : document type defines no cancel state")
 
-	// ErrWorkflowInactive : this workflow is currently inactive
-	ErrWorkflowInactive = Error("ErrWorkflowInactive : this workflow is currently inactive")
+	// ErrDocStateCycle : parent would make the state its own ancestor
+	ErrDocStateCycle = Error("ErrDocStateCycle : parent would make the state its own ancestor")
+
+	// ErrEngineClosed : engine has been closed, and accepts no more transitions
+	ErrEngineClosed = Error("ErrEngineClosed : engine has been closed, and accepts no more transitions")
+	// ErrEngineOpen : another engine is open; only one may be, at a time
+	ErrEngineOpen = Error("ErrEngineOpen : another engine is open; only one may be, at a time")
+
+	// ErrWorkflowInactive : this workflow is currently inactive or archived
+	ErrWorkflowInactive = Error("ErrWorkflowInactive : this workflow is currently inactive or archived")
+	// ErrWorkflowNameExists : another workflow already has this name
+	ErrWorkflowNameExists = Error("ErrWorkflowNameExists : another workflow already has this name")
+	// ErrWorkflowHasDocuments : documents have already been created under this workflow
+	ErrWorkflowHasDocuments = Error("ErrWorkflowHasDocuments : documents have already been created under this workflow")
 	// ErrWorkflowInvalidAction : given action cannot be performed on this document's current state
+	//
+	// Deprecated: events with no applicable transition now result in
+	// `*ErrNoTransition`, which identifies the state and the action.
 	ErrWorkflowInvalidAction = Error("ErrWorkflowInvalidAction : given action cannot be performed on this document's current state")
 
+	// ErrAuditTrailTampered : audit entries do not form an unbroken hash chain
+	ErrAuditTrailTampered = Error("ErrAuditTrailTampered : audit entries do not form an unbroken hash chain")
+
 	// ErrMessageNoRecipients : list of recipients is empty
 	ErrMessageNoRecipients = Error("ErrMessageNoRecipients : list of recipients is empty")
 )
+
+// ErrNoTransition is answered when no transition is defined out of a
+// document state upon a given action.  It identifies both.
+type ErrNoTransition struct {
+	State  DocStateID  // Current state of the document
+	Action DocActionID // Action that was attempted
+}
+
+// Error implements the `error` interface.
+func (e *ErrNoTransition) Error() string {
+	return fmt.Sprintf("ErrNoTransition : no transition defined from state %d upon action %d", e.State, e.Action)
+}
+
+// ErrEventLogInconsistent is answered when the event log of a
+// document cannot be replayed by the definition of its workflow.  It
+// identifies the first event that could not be replayed.
+type ErrEventLogInconsistent struct {
+	Event  DocEventID // Event that could not be replayed
+	State  DocStateID // State reached by the replay before the event
+	Reason string     // Why the event could not be replayed
+}
+
+// Error implements the `error` interface.
+func (e *ErrEventLogInconsistent) Error() string {
+	return fmt.Sprintf("ErrEventLogInconsistent : event %d cannot be replayed from state %d : %s", e.Event, e.State, e.Reason)
+}
+
+// ErrDuplicateTransition is answered when a transition being added is
+// already defined for the document type, or when the action already
+// leads out of the state into another target state.
+type ErrDuplicateTransition struct {
+	State    DocStateID  // Source state of the transition
+	Action   DocActionID // Action of the transition
+	To       DocStateID  // Target state of the transition
+	Existing DocStateID  // Target state already defined, if different
+}
+
+// Error implements the `error` interface.
+func (e *ErrDuplicateTransition) Error() string {
+	if e.Existing != 0 {
+		return fmt.Sprintf("ErrDuplicateTransition : transition from state %d upon action %d into state %d conflicts with the one into state %d", e.State, e.Action, e.To, e.Existing)
+	}
+	return fmt.Sprintf("ErrDuplicateTransition : transition from state %d upon action %d into state %d is already defined", e.State, e.Action, e.To)
+}
+
+// ErrPermissionDenied is answered when the group that raised an event
+// is not permitted to perform its action on the document.
+type ErrPermissionDenied struct {
+	Group  GroupID     // Group that raised the event
+	Action DocActionID // Action that was attempted
+}
+
+// Error implements the `error` interface.
+func (e *ErrPermissionDenied) Error() string {
+	return fmt.Sprintf("ErrPermissionDenied : group %d may not perform action %d on this document", e.Group, e.Action)
+}
+
+// ErrNodeInUse is answered when a node cannot be removed, since
+// documents are currently in its state, or transitions lead into it.
+type ErrNodeInUse struct {
+	Node      NodeID       // Node that was to be removed
+	Documents int64        // Number of documents currently in the node's state
+	Branches  int64        // Number of active parallel branches in the node's state
+	Sources   []DocStateID // States with transitions into the node's state
+}
+
+// Error implements the `error` interface.
+func (e *ErrNodeInUse) Error() string {
+	return fmt.Sprintf("ErrNodeInUse : node %d has %d document(s) and %d parallel branch(es) in its state, and inbound transitions from states %v", e.Node, e.Documents, e.Branches, e.Sources)
+}
+
+// EscalationFailure identifies a document whose escalation failed,
+// while processing timeouts.
+type EscalationFailure struct {
+	DocType DocTypeID  // Type of the document
+	Doc     DocumentID // Document that could not be escalated
+	Err     error      // Cause of the failure
+}
+
+// ErrEscalationFailed is answered when some documents could not be
+// escalated, while processing timeouts.  Other documents are
+// escalated regardless.
+type ErrEscalationFailed struct {
+	Failures []EscalationFailure // Failures, in the order of processing
+}
+
+// Error implements the `error` interface.
+func (e *ErrEscalationFailed) Error() string {
+	f := e.Failures[0]
+	return fmt.Sprintf("ErrEscalationFailed : escalation of %d document(s) failed; first, of %d/%d : %v", len(e.Failures), f.DocType, f.Doc, f.Err)
+}
+
+// Unwrap answers the causes of the failures.
+func (e *ErrEscalationFailed) Unwrap() []error {
+	errs := make([]error, 0, len(e.Failures))
+	for _, f := range e.Failures {
+		errs = append(errs, f.Err)
+	}
+	return errs
+}
+
+// ErrBulkApply is answered when a bulk transition fails on one of its
+// documents.  It identifies the document, and holds the cause.
+type ErrBulkApply struct {
+	Doc DocumentID // Document whose transition failed
+	Err error      // Cause of the failure
+}
+
+// Error implements the `error` interface.
+func (e *ErrBulkApply) Error() string {
+	return fmt.Sprintf("ErrBulkApply : transition of document %d failed : %v", e.Doc, e.Err)
+}
+
+// Unwrap answers the cause of the failure.
+func (e *ErrBulkApply) Unwrap() error {
+	return e.Err
+}
+
+// ErrSchema is answered when the tables of `flow` are not present in
+// the registered database as expected.  It lists what is missing.
+type ErrSchema struct {
+	Missing []string // Missing tables, and columns as `table.column`
+}
+
+// Error implements the `error` interface.
+func (e *ErrSchema) Error() string {
+	return fmt.Sprintf("ErrSchema : missing from the database schema : %s", strings.Join(e.Missing, ", "))
+}
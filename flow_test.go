@@ -15,11 +15,22 @@
 package flow
 
 import (
+	"context"
 	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
-	_ "github.com/go-sql-driver/mysql"
+	"github.com/go-sql-driver/mysql"
 )
 
 // error0 expects only an error value as its argument.
@@ -74,408 +85,3322 @@ func assertNotEqual(expected, observed interface{}, msgs ...string) {
 	gt.Errorf("expected : '%v', observed : '%v'\n\t%s", expected, observed, strings.Join(msgs, "\n\t"))
 }
 
+// Placeholder and generated key handling in each dialect.
+func TestFlowDialect(t *testing.T) {
+	gt = t
+	defer SetDialect(DialectMySQL)
+
+	q := `SELECT id FROM wf_groups_master WHERE group_type = 'S?' AND id IN (?,?,?) LIMIT ? OFFSET ?`
+	ins := `INSERT INTO wf_roles_master(name) VALUES(?)`
+
+	fatal0(SetDialect(DialectMySQL))
+	assertEqual(q, rebind(q))
+	assertEqual(ins, returningID(ins))
+
+	fatal0(SetDialect(DialectPostgres))
+	assertEqual(`SELECT id FROM wf_groups_master WHERE group_type = 'S?' AND id IN ($1,$2,$3) LIMIT $4 OFFSET $5`, rebind(q))
+	assertEqual("INSERT INTO wf_roles_master(name) VALUES(?)\n\tRETURNING id", returningID(ins))
+	assertEqual("INSERT INTO wf_roles_master(name) VALUES($1)\n\tRETURNING id", rebind(returningID(ins)))
+
+	assertNotEqual(nil, SetDialect(Dialect(99)), "unknown dialects should be rejected")
+
+	fatal0(SetDialect(DialectMySQL))
+	tablePrefix = "app1_"
+	defer func() { tablePrefix = "" }()
+	q = "SELECT d.wf_version FROM wf_documents_001 d JOIN wf_workflows wf ON wf.id = ? WHERE d.title = 'wf_x'"
+	assertEqual("SELECT d.wf_version FROM app1_wf_documents_001 d JOIN app1_wf_workflows wf ON wf.id = ? WHERE d.title = 'wf_x'", rebind(q))
+	fatal0(SetDialect(DialectPostgres))
+	assertEqual("SELECT d.wf_version FROM app1_wf_documents_001 d JOIN app1_wf_workflows wf ON wf.id = $1 WHERE d.title = 'wf_x'", rebind(q))
+
+	assertEqual("accounting/%", likePrefix("accounting/"))
+	assertEqual("100!% a!_b!!%", likePrefix("100% a_b!"))
+}
+
+// Graph checks that do not need a database.
+func TestFlowValidateGraph(t *testing.T) {
+	gt = t
+
+	// A diamond : 1 -> {2, 3} -> 4.
+	nodes := []*Node{
+		{State: 1, NodeType: NodeTypeBegin},
+		{State: 2, NodeType: NodeTypeLinear},
+		{State: 3, NodeType: NodeTypeLinear},
+		{State: 4, NodeType: NodeTypeEnd},
+	}
+	edges := map[DocStateID][]DocStateID{
+		1: {2, 3},
+		2: {4},
+		3: {4},
+	}
+
+	t.Run("Diamond", func(t *testing.T) {
+		rep := validateGraph(1, nodes, edges)
+		assertEqual(true, rep.OK(), fmt.Sprintf("%+v", rep))
+	})
+
+	t.Run("Disconnected", func(t *testing.T) {
+		rep := validateGraph(1, append(nodes, &Node{State: 5, NodeType: NodeTypeLinear}), edges)
+		assertEqual(false, rep.OK())
+		assertEqual("[5]", fmt.Sprint(rep.Unreachable))
+		assertEqual("[5]", fmt.Sprint(rep.NoInbound))
+		assertEqual("[5]", fmt.Sprint(rep.DeadEnds))
+	})
+
+	t.Run("Cycles", func(t *testing.T) {
+		assertEqual(0, len(findCycles(edges)), "a diamond has no cycles")
+
+		// A self-loop on 2, and a rework loop 2 -> 4 -> 3 -> 2.
+		cyc := map[DocStateID][]DocStateID{
+			1: {2},
+			2: {2, 4, 4},
+			3: {2},
+			4: {3, 5},
+		}
+		assertEqual("[[2] [2 4 3]]", fmt.Sprint(findCycles(cyc)))
+	})
+
+	t.Run("Runnable", func(t *testing.T) {
+		begin := DocState{ID: 1, Name: "Draft"}
+		assertEqual(0, len(runnableReasons(begin, nodes, edges)))
+
+		reasons := runnableReasons(begin, nodes[1:], map[DocStateID][]DocStateID{2: {4}})
+		assertEqual(2, len(reasons), strings.Join(reasons, "; "))
+		assertEqual(`begin state "Draft" (1) has no node`, reasons[0])
+	})
+}
+
+// recordingHook notes the transitions it observes, tagged with its
+// own name.
+type recordingHook struct {
+	name string
+	seen *[]string
+}
+
+func (h recordingHook) OnTransition(ctx context.Context, dtype DocTypeID, doc DocumentID, from, to DocStateID, action DocActionID) error {
+	*h.seen = append(*h.seen, fmt.Sprintf("%s:%d:%d>%d", h.name, doc, from, to))
+	return nil
+}
+
+// Transition hooks, without a database.
+func TestFlowHooks(t *testing.T) {
+	gt = t
+	defer func() { hooks.list = nil }()
+
+	var seen []string
+	Workflows.RegisterHook(recordingHook{"a", &seen})
+	Workflows.RegisterHook(recordingHook{"b", &seen})
+
+	fireHooks(context.Background(), []transition{{1, 7, 2, 3, 4}, {1, 7, 3, 5, 4}})
+	assertEqual("[a:7:2>3 b:7:2>3 a:7:3>5 b:7:3>5]", fmt.Sprint(seen))
+
+	rl := &recordingLogger{}
+	ologger := logger
+	logger = rl
+	defer func() { logger = ologger }()
+
+	Workflows.RegisterHook(failingHook{})
+	fireHooks(context.Background(), []transition{{1, 7, 2, 3, 4}})
+	assertEqual(1, len(rl.errors), "hook failures should be logged through the engine's logger")
+}
+
+// failingHook fails every transition it observes.
+type failingHook struct{}
+
+func (failingHook) OnTransition(ctx context.Context, dtype DocTypeID, doc DocumentID, from, to DocStateID, action DocActionID) error {
+	return ErrUnknown
+}
+
+// countingHook counts the transitions and messages it observes.
+type countingHook struct {
+	n *int64
+}
+
+func (h countingHook) OnTransition(ctx context.Context, dtype DocTypeID, doc DocumentID, from, to DocStateID, action DocActionID) error {
+	atomic.AddInt64(h.n, 1)
+	return nil
+}
+
+func (h countingHook) Notify(ctx context.Context, msg *Message, recipients []GroupID) error {
+	atomic.AddInt64(h.n, 1)
+	return nil
+}
+
+// Registries may be added to while transitions are dispatched.  Run
+// with `-race`.
+func TestFlowRegistriesConcurrent(t *testing.T) {
+	gt = t
+	defer func() {
+		hooks.list = nil
+		notifiers.list = nil
+		guards.m = nil
+		postActions.m = nil
+		resolvers.m = nil
+	}()
+
+	var n int64
+	h := countingHook{&n}
+	Workflows.RegisterHook(h)
+	Workflows.RegisterNotifier(h)
+	ev := &DocEvent{DocType: 1, DocID: 7, State: 2, Action: 3}
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(2)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				Workflows.RegisterHook(h)
+				Workflows.RegisterNotifier(h)
+				DocTypes.RegisterGuard(1, 2, 3, DocStateID(i+10), func(DocumentID) (bool, error) { return false, nil })
+				DocTypes.RegisterPostAction(1, 2, 3, DocStateID(i+10), func(*sql.Tx, DocumentID) error { return nil })
+				DocTypes.RegisterRecipientResolver(DocTypeID(i+1), func(*sql.Tx, *DocEvent) ([]GroupID, error) { return nil, nil })
+			}
+		}(i)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				fireHooks(context.Background(), []transition{{1, 7, 2, 3, 4}})
+				ob := &outbox{list: []delivery{{&Message{ID: 1}, []GroupID{1}}}}
+				ob.send(context.Background())
+				_, _, err := chooseTarget(1, 2, 3, 7, []DocStateID{10, 11})
+				assertEqual(nil, err)
+				assertEqual(nil, runPostAction(nil, ev, 2, 4))
+				assertEqual(nil, resolveRecipients(nil, ev, map[GroupID]struct{}{}))
+			}
+		}()
+	}
+	wg.Wait()
+
+	assertEqual(402, len(hooks.list)+len(notifiers.list))
+	assertEqual(true, atomic.LoadInt64(&n) > 0, "registered hooks should have been invoked")
+}
+
+// Guarded transitions route an action by the document's data.
+func TestFlowGuards(t *testing.T) {
+	gt = t
+	defer func() { guards.m = nil }()
+
+	big := func(doc DocumentID) (bool, error) { return doc > 100, nil }
+	DocTypes.RegisterGuard(1, 2, 3, 5, big)
+	targets := []DocStateID{4, 5}
+
+	to, ok, err := chooseTarget(1, 2, 3, DocumentID(500), targets)
+	assertEqual(nil, err)
+	assertEqual(true, ok)
+	assertEqual(DocStateID(5), to, "the matching guard should decide the target")
+
+	to, ok, err = chooseTarget(1, 2, 3, DocumentID(50), targets)
+	assertEqual(nil, err)
+	assertEqual(true, ok)
+	assertEqual(DocStateID(4), to, "the unguarded transition should be the fallback")
+
+	_, ok, _ = chooseTarget(1, 2, 3, DocumentID(50), []DocStateID{5})
+	assertEqual(false, ok, "no target without a match or a fallback")
+
+	DocTypes.RegisterGuard(1, 2, 3, 5, func(DocumentID) (bool, error) { return false, errors.New("boom") })
+	_, _, err = chooseTarget(1, 2, 3, DocumentID(500), targets)
+	assertNotEqual(nil, err, "guard errors should be answered")
+
+	DocTypes.RegisterGuard(1, 2, 3, 5, nil)
+	to, _, _ = chooseTarget(1, 2, 3, DocumentID(500), targets)
+	assertEqual(DocStateID(4), to, "a removed guard should no longer apply")
+}
+
+// Events are applied or cancelled only while pending.
+func TestFlowEventStatus(t *testing.T) {
+	gt = t
+
+	assertEqual(nil, EventStatusPending.CanChangeTo(EventStatusApplied))
+	assertEqual(nil, EventStatusPending.CanChangeTo(EventStatusCancelled))
+	assertNotEqual(nil, EventStatusPending.CanChangeTo(EventStatusPending))
+	assertEqual(ErrDocEventAlreadyApplied, EventStatusApplied.CanChangeTo(EventStatusCancelled))
+	assertEqual(ErrDocEventCancelled, EventStatusCancelled.CanChangeTo(EventStatusApplied))
+}
+
+// deadlockDriver is a fake database driver, whose statements fail
+// with a deadlock in the first transaction, and succeed thereafter.
+type deadlockDriver struct {
+	begun     int
+	execs     int
+	commits   int
+	rollbacks int
+}
+
+var fakeDriver = &deadlockDriver{}
+
+func init() {
+	sql.Register("flow-deadlock", fakeDriver)
+}
+
+// openFakeDB installs a handle to a freshly reset fake database, and
+// answers a function that restores the original handle.
+func openFakeDB(t *testing.T) func() {
+	*fakeDriver = deadlockDriver{}
+	fdb, err := sql.Open("flow-deadlock", "")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	odb := db
+	db = fdb
+	return func() {
+		fdb.Close()
+		db = odb
+	}
+}
+
+func (d *deadlockDriver) Open(string) (driver.Conn, error) { return &deadlockConn{d}, nil }
+
+type deadlockConn struct{ d *deadlockDriver }
+
+func (c *deadlockConn) Prepare(q string) (driver.Stmt, error) { return &deadlockStmt{c.d}, nil }
+func (c *deadlockConn) Close() error                          { return nil }
+func (c *deadlockConn) Begin() (driver.Tx, error) {
+	c.d.begun++
+	return c, nil
+}
+func (c *deadlockConn) Commit() error {
+	c.d.commits++
+	return nil
+}
+func (c *deadlockConn) Rollback() error {
+	c.d.rollbacks++
+	return nil
+}
+
+type deadlockStmt struct{ d *deadlockDriver }
+
+func (s *deadlockStmt) Close() error  { return nil }
+func (s *deadlockStmt) NumInput() int { return -1 }
+func (s *deadlockStmt) Exec([]driver.Value) (driver.Result, error) {
+	s.d.execs++
+	if s.d.begun == 1 {
+		return nil, &mysql.MySQLError{Number: 1213, Message: "Deadlock found when trying to get lock; try restarting transaction"}
+	}
+	return deadlockResult(7), nil
+}
+func (s *deadlockStmt) Query([]driver.Value) (driver.Rows, error) {
+	return nil, errors.New("not supported")
+}
+
+type deadlockResult int64
+
+func (r deadlockResult) LastInsertId() (int64, error) { return int64(r), nil }
+func (r deadlockResult) RowsAffected() (int64, error) { return 1, nil }
+
+// recordingLogger retains the errors logged.
+type recordingLogger struct {
+	nopLogger
+	errors []string
+}
+
+func (l *recordingLogger) Errorf(format string, args ...interface{}) {
+	l.errors = append(l.errors, fmt.Sprintf(format, args...))
+}
+
+// Transactions that fail owing to deadlocks are re-run.
+func TestFlowTxRetry(t *testing.T) {
+	gt = t
+
+	defer openFakeDB(t)()
+	fd := fakeDriver
+
+	rl := &recordingLogger{}
+	obackoff, ologger := txBackoff, logger
+	txBackoff, logger = time.Millisecond, rl
+	defer func() { txBackoff, logger = obackoff, ologger }()
+
+	wid, err := Workflows.New(nil, "Deadlocked", 2, 2)
+	assertEqual(nil, err, "a deadlocked transaction should be retried")
+	assertEqual(WorkflowID(7), wid)
+	assertEqual(2, fd.begun, "the transaction should be attempted twice")
+	assertEqual(2, fd.execs)
+	assertEqual(1, len(rl.errors), "the rolled back attempt should be logged")
+
+	assertEqual(true, isRetryable(&mysql.MySQLError{Number: 1205, Message: "Lock wait timeout exceeded"}))
+	assertEqual(true, isRetryable(sqlStateError("40P01")))
+	assertEqual(true, isRetryable(sqlStateError("40001")))
+	assertEqual(false, isRetryable(&mysql.MySQLError{Number: 1062, Message: "Duplicate entry"}))
+	assertEqual(false, isRetryable(sqlStateError("23505")))
+	assertEqual(false, isRetryable(errors.New("Error 1213: Deadlock found when trying to get lock")),
+		"errors are classified by their type, not their text")
+}
+
+// Deadlocks are retried even when wrapped with context.
+func TestFlowTxRetryWrapped(t *testing.T) {
+	gt = t
+
+	defer openFakeDB(t)()
+	otxBackoff := txBackoff
+	txBackoff = time.Millisecond
+	defer func() { txBackoff = otxBackoff }()
+
+	err := inTx(context.Background(), nil, func(tx *sql.Tx) error {
+		_, err := tx.Exec("INSERT INTO wf_docstate_transitions VALUES (?)", 1)
+		if err != nil {
+			return fmt.Errorf("transition from state %d upon action %d into state %d : %w", 2, 3, 4, err)
+		}
+		return nil
+	})
+	assertEqual(nil, err, "a wrapped deadlock should be retried")
+	assertEqual(2, fakeDriver.begun, "the transaction should be attempted twice")
+	assertEqual(1, fakeDriver.commits)
+}
+
+// sqlStateError mimics the errors of drivers that report SQLSTATE.
+type sqlStateError string
+
+func (e sqlStateError) Error() string    { return "ERROR: " + string(e) }
+func (e sqlStateError) SQLState() string { return string(e) }
+
+// WithTx commits only when its function succeeds.
+func TestFlowWithTx(t *testing.T) {
+	gt = t
+
+	defer openFakeDB(t)()
+	ctx := context.Background()
+
+	err := WithTx(ctx, func(tx *sql.Tx) error { return ErrUnknown })
+	assertEqual(ErrUnknown, err)
+	assertEqual(0, fakeDriver.commits)
+	assertEqual(1, fakeDriver.rollbacks, "a failed function should roll back")
+
+	func() {
+		defer func() {
+			assertNotEqual(nil, recover(), "the panic should continue")
+		}()
+		WithTx(ctx, func(tx *sql.Tx) error { panic("boom") })
+	}()
+	assertEqual(2, fakeDriver.rollbacks, "a panicking function should roll back")
+
+	err = WithTx(ctx, func(tx *sql.Tx) error { return nil })
+	assertEqual(nil, err)
+	assertEqual(1, fakeDriver.commits)
+
+	// Work deferred within the function happens only after a commit.
+	var seen []string
+	defer func() { hooks.list = nil }()
+	Workflows.RegisterHook(recordingHook{"a", &seen})
+	commits := -1
+	err = WithTx(ctx, func(tx *sql.Tx) error {
+		afterCommit(tx, func() {
+			commits = fakeDriver.commits
+			fireHooks(ctx, []transition{{1, 7, 2, 3, 4}})
+		})
+		afterCommit(tx, func() { fireHooks(ctx, []transition{{1, 7, 3, 5, 4}}) })
+		assertEqual(0, len(seen), "hooks should not fire before the commit")
+		return nil
+	})
+	assertEqual(nil, err)
+	assertEqual(2, commits, "hooks should fire after the commit")
+	assertEqual("[a:7:2>3 a:7:3>5]", fmt.Sprint(seen), "hooks should fire in order")
+
+	seen = nil
+	WithTx(ctx, func(tx *sql.Tx) error {
+		afterCommit(tx, func() { fireHooks(ctx, []transition{{1, 7, 2, 3, 4}}) })
+		return ErrUnknown
+	})
+	func() {
+		defer func() { recover() }()
+		WithTx(ctx, func(tx *sql.Tx) error {
+			afterCommit(tx, func() { fireHooks(ctx, []transition{{1, 7, 2, 3, 4}}) })
+			panic("boom")
+		})
+	}()
+	assertEqual(0, len(seen), "hooks of a rolled back transaction should be discarded")
+}
+
+// recordingMetrics retains the observations reported.
+type recordingMetrics struct {
+	transitions int
+	latencies   []WorkflowID
+}
+
+func (m *recordingMetrics) IncTransition(WorkflowID, DocActionID) { m.transitions++ }
+func (m *recordingMetrics) ObserveLatency(wid WorkflowID, d time.Duration) {
+	m.latencies = append(m.latencies, wid)
+}
+
+// Latencies are observed even for events that fail to apply.
+func TestFlowMetrics(t *testing.T) {
+	gt = t
+
+	defer openFakeDB(t)()
+	rm := &recordingMetrics{}
+	om := metrics
+	metrics = rm
+	defer func() { metrics = om }()
+
+	w := &Workflow{ID: 9, Active: false}
+	_, err := w.ApplyEvent(nil, &DocEvent{}, nil)
+	assertEqual(ErrWorkflowInactive, err)
+	assertEqual(0, rm.transitions, "failed events should not be counted")
+	assertEqual(1, len(rm.latencies), "latency should be observed once")
+	assertEqual(WorkflowID(9), rm.latencies[0])
+}
+
+// Webhooks are retried on server errors, and notifier errors are
+// logged.
+func TestFlowWebhookNotifier(t *testing.T) {
+	gt = t
+
+	calls := 0
+	var got webhookPayload
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if calls == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		json.NewDecoder(r.Body).Decode(&got)
+	}))
+	defer srv.Close()
+
+	wn := NewWebhookNotifier(srv.URL)
+	wn.Backoff = time.Millisecond
+	msg := &Message{ID: 11, DocID: 12, Title: "Cluster"}
+	err := wn.Notify(context.Background(), msg, []GroupID{3})
+	assertEqual(nil, err, "the webhook should succeed on retry")
+	assertEqual(2, calls)
+	if got.Message != nil {
+		assertEqual(MessageID(11), got.Message.ID)
+	}
+	assertEqual("[3]", fmt.Sprint(got.Recipients))
+
+	rl := &recordingLogger{}
+	ologger := logger
+	logger = rl
+	defer func() { logger = ologger }()
+	notifiers.Lock()
+	olist := notifiers.list
+	notifiers.list = []Notifier{NewWebhookNotifier("")}
+	notifiers.Unlock()
+	defer func() {
+		notifiers.Lock()
+		notifiers.list = olist
+		notifiers.Unlock()
+	}()
+
+	ctx, ob := withOutbox(context.Background())
+	enqueue(ctx, msg, map[GroupID]struct{}{3: {}})
+	ob.send(ctx)
+	assertEqual(1, len(rl.errors), "failed deliveries should be logged")
+}
+
+// blockingNotifier signals each message it receives, and then waits
+// to be released.
+type blockingNotifier struct {
+	seen    chan MessageID
+	release chan struct{}
+}
+
+func (n blockingNotifier) Notify(ctx context.Context, msg *Message, recipients []GroupID) error {
+	n.seen <- msg.ID
+	<-n.release
+	return nil
+}
+
+// Notifiers run off the caller's goroutine, once the transaction that
+// posted the messages commits.
+func TestFlowNotifierAsync(t *testing.T) {
+	gt = t
+
+	bn := blockingNotifier{make(chan MessageID, 2), make(chan struct{})}
+	notifiers.Lock()
+	olist := notifiers.list
+	notifiers.list = []Notifier{bn}
+	notifiers.Unlock()
+	defer func() {
+		notifiers.Lock()
+		notifiers.list = olist
+		notifiers.Unlock()
+	}()
+
+	ob := &outbox{list: []delivery{{&Message{ID: 1}, []GroupID{1}}, {&Message{ID: 2}, []GroupID{1}}}}
+	ob.deliver(context.Background())
+	assertEqual(MessageID(1), <-bn.seen, "the first message should be delivered first")
+	select {
+	case id := <-bn.seen:
+		t.Errorf("message %d should await the first delivery", id)
+	default:
+	}
+	close(bn.release)
+	assertEqual(MessageID(2), <-bn.seen)
+
+	tx := &sql.Tx{}
+	ts := trackTx(tx, false)
+	ran := 0
+	afterCommit(tx, func() { ran++ })
+	assertEqual(0, ran, "nothing should run before the commit")
+	ts.end(tx, true)
+	assertEqual(1, ran, "the commit should run what was deferred")
+
+	tx = &sql.Tx{}
+	ts = trackTx(tx, false)
+	afterCommit(tx, func() { ran++ })
+	ts.end(tx, false)
+	assertEqual(1, ran, "a rollback should discard what was deferred")
+
+	afterCommit(&sql.Tx{}, func() { ran++ })
+	assertEqual(2, ran, "an untracked transaction should run it at once")
+}
+
+// Workflows round-trip through JSON with their exported fields.
+func TestFlowWorkflowJSON(t *testing.T) {
+	gt = t
+
+	w := &Workflow{ID: 3, Name: "Storage", DocType: DocType{ID: 4, Name: "Stor Request"},
+		BeginState: DocState{ID: 5, Name: "Initial"}, Active: true, Version: 2}
+	data, err := json.Marshal(w)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	var w2 Workflow
+	if err = json.Unmarshal(data, &w2); err != nil {
+		t.Fatalf("%v", err)
+	}
+	assertEqual(*w, w2, "all fields should survive a round trip")
+
+	var m map[string]json.RawMessage
+	if err = json.Unmarshal(data, &m); err != nil {
+		t.Fatalf("%v", err)
+	}
+	for _, k := range []string{"id", "name", "docType", "beginState", "active", "version"} {
+		_, ok := m[k]
+		assertEqual(true, ok, fmt.Sprintf("member %q should be present", k))
+	}
+
+	old := `{"ID":3,"Name":"Storage","DocType":{"ID":4,"Name":"Stor Request"},` +
+		`"BeginState":{"ID":5,"Name":"Initial"},"Active":true,"Version":2}`
+	var w3 Workflow
+	if err = json.Unmarshal([]byte(old), &w3); err != nil {
+		t.Fatalf("%v", err)
+	}
+	assertEqual(*w, w3, "the earlier members should still be decoded")
+}
+
+// Unknown node types are rejected before anything is inserted.
+func TestFlowNodeType(t *testing.T) {
+	gt = t
+
+	assertEqual(true, NodeTypeFork.IsValid())
+	assertEqual("joinall", NodeTypeJoin.String())
+	assertEqual(false, NodeType("loop").IsValid())
+	assertEqual(false, IsValidNodeType(""))
+
+	_, err := Workflows.AddNode(nil, 1, 2, 0, 1, "Looping", NodeType("loop"))
+	assertNotEqual(nil, err, "an unknown node type should be rejected")
+}
+
+// DOT output escapes names, and highlights the begin state.
+func TestFlowDOT(t *testing.T) {
+	gt = t
+
+	wf := &Workflow{Name: `Stor "Main"`, BeginState: DocState{ID: 2, Name: "Initial"}}
+	ts := []Transition{
+		{From: DocState{ID: 2, Name: "Initial"}, Upon: DocAction{ID: 7, Name: "Submit"}, To: DocState{ID: 3, Name: `Review\Legal`}},
+	}
+	exp := `digraph "Stor \"Main\"" {
+	rankdir=LR;
+	s2 [label="Initial", style="bold,filled", fillcolor=lightgrey];
+	s3 [label="Review\\Legal"];
+	s2 -> s3 [label="Submit"];
+}
+`
+	assertEqual(exp, dotSource(wf, ts))
+}
+
+// Reverts undo visits in order, and stop at the first state.
+func TestFlowRevertTarget(t *testing.T) {
+	gt = t
+
+	hist := []historyEntry{{state: 2}, {state: 3}, {state: 4}}
+	curr, prev, ok := revertTarget(hist)
+	assertEqual(true, ok)
+	assertEqual(DocStateID(4), curr)
+	assertEqual(DocStateID(3), prev)
+
+	hist = append(hist, historyEntry{state: 3, byRevert: true})
+	curr, prev, _ = revertTarget(hist)
+	assertEqual(DocStateID(3), curr, "the first revert should have undone the last visit")
+	assertEqual(DocStateID(2), prev, "reverting again should reach the visit before")
+
+	hist = append(hist, historyEntry{state: 2, byRevert: true})
+	_, _, ok = revertTarget(hist)
+	assertEqual(false, ok, "the first state should not be reverted")
+
+	hist = append(hist, historyEntry{state: 5})
+	curr, prev, _ = revertTarget(hist)
+	assertEqual(DocStateID(5), curr)
+	assertEqual(DocStateID(2), prev, "a new transition should revert to the state it left")
+}
+
+// Pages know where the next one begins.
+func TestFlowPageInfo(t *testing.T) {
+	gt = t
+
+	assertEqual(PageInfo{Total: 25, NextOffset: 20, HasMore: true}, newPageInfo(10, 10, 25))
+	assertEqual(PageInfo{Total: 25, NextOffset: 25, HasMore: false}, newPageInfo(20, 5, 25))
+	assertEqual(PageInfo{Total: 0, NextOffset: 0, HasMore: false}, newPageInfo(0, 0, 0))
+}
+
+// Closing an engine waits for transitions in progress, and refuses new
+// ones.
+func TestFlowEngineClose(t *testing.T) {
+	gt = t
+
+	e := &Engine{}
+	done := fatal1(e.track()).(func())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	assertEqual(context.DeadlineExceeded, e.CloseContext(ctx), "a transition is still in progress")
+	_, err := e.track()
+	assertEqual(ErrEngineClosed, err)
+
+	closed := make(chan error, 1)
+	go func() { closed <- e.Close() }()
+	done()
+	assertEqual(nil, <-closed, "the engine should close once drained")
+}
+
+// Only one engine may be open at a time; another may be opened once
+// it is closed.
+func TestFlowEngineOpen(t *testing.T) {
+	gt = t
+
+	installMu.Lock()
+	oe := engine
+	install(&Engine{dialect: DialectMySQL, maxAttempts: DefMaxTxAttempts, logger: nopLogger{}, metrics: nopMetrics{}})
+	installMu.Unlock()
+	defer func() {
+		installMu.Lock()
+		install(oe)
+		installMu.Unlock()
+	}()
+
+	fdb := fatal1(sql.Open("flow-nodes", "")).(*sql.DB)
+	defer fdb.Close()
+
+	e1 := fatal1(Open(fdb)).(*Engine)
+	_, err := Open(fdb, WithMaxTxAttempts(5))
+	assertEqual(ErrEngineOpen, err, "a second open engine should be refused")
+	assertEqual(ErrEngineOpen, RegisterDB(fdb))
+	assertEqual(e1, DefaultEngine(), "the open engine should remain installed")
+	assertEqual(DefMaxTxAttempts, maxTxAttempts, "the refused engine should not be configured")
+
+	fatal0(e1.Close())
+	e2 := fatal1(Open(fdb, WithMaxTxAttempts(5))).(*Engine)
+	assertEqual(e2, DefaultEngine(), "an engine should be opened after the first is closed")
+	assertEqual(5, maxTxAttempts)
+	fatal0(e2.Close())
+}
+
+// Cached nodes are evicted in least recently used order, and by
+// workflow; callers receive copies.
+func TestFlowNodeCache(t *testing.T) {
+	gt = t
+
+	assertEqual(true, newNodeLRU(0) == nil, "a zero size should disable the cache")
+	var off *nodeLRU
+	off.put(&Node{Wflow: 1, State: 1}, 0)
+	_, _, ok := off.get(1, 1)
+	assertEqual(false, ok)
+
+	c := newNodeLRU(2)
+	_, gen, _ := c.get(1, 1)
+	c.put(&Node{ID: 1, Wflow: 1, State: 1}, gen)
+	c.put(&Node{ID: 2, Wflow: 1, State: 2}, gen)
+	n, _, ok := c.get(1, 1)
+	assertEqual(true, ok)
+	n.Name = "Altered"
+	c.put(&Node{ID: 3, Wflow: 2, State: 1}, gen)
+	_, _, ok = c.get(1, 2)
+	assertEqual(false, ok, "the least recently used node should be evicted")
+	n, _, _ = c.get(1, 1)
+	assertEqual("", n.Name, "cached nodes should not be altered through copies")
+
+	c.invalidate(1)
+	_, _, ok = c.get(1, 1)
+	assertEqual(false, ok)
+	_, _, ok = c.get(2, 1)
+	assertEqual(true, ok, "other workflows should be retained")
+
+	// A node read before a discard is not cached after it.
+	_, gen, _ = c.get(1, 1)
+	c.invalidate(2)
+	c.put(&Node{ID: 1, Wflow: 1, State: 1}, gen)
+	_, _, ok = c.get(1, 1)
+	assertEqual(false, ok, "a node read before a discard should not be cached")
+
+	// Nodes being altered stay out of the cache until released.
+	c.hold(1)
+	c.hold(1)
+	_, gen, _ = c.get(1, 1)
+	c.put(&Node{ID: 1, Wflow: 1, State: 1}, gen)
+	_, _, ok = c.get(1, 1)
+	assertEqual(false, ok, "a held workflow should not be cached")
+	c.release(1)
+	assertEqual(true, c.isHeld(1), "each hold should be released")
+	c.release(1)
+	assertEqual(false, c.isHeld(1))
+	_, gen, _ = c.get(1, 1)
+	c.put(&Node{ID: 1, Wflow: 1, State: 1}, gen)
+	_, _, ok = c.get(1, 1)
+	assertEqual(true, ok)
+}
+
+// Nodes held on behalf of a transaction begun through `WithTx` are
+// released when it ends; those of other transactions stay held.
+func TestFlowAlterNodes(t *testing.T) {
+	gt = t
+
+	ocache := nodeCache
+	nodeCache = newNodeLRU(4)
+	defer func() { nodeCache = ocache }()
+
+	alterNodes(nil, 1)()
+	assertEqual(false, nodeCache.isHeld(1))
+
+	tx := &sql.Tx{}
+	ts := trackTx(tx, false)
+	alterNodes(tx, 1)()
+	assertEqual(true, nodeCache.isHeld(1), "nodes should be held until the transaction ends")
+	assertEqual(false, ownedTx(tx), "a transaction of `WithTx` is the caller's")
+	ts.end(tx, false)
+	assertEqual(false, nodeCache.isHeld(1), "a rolled back transaction should release its nodes")
+
+	alterNodes(&sql.Tx{}, 2)()
+	assertEqual(true, nodeCache.isHeld(2), "an untracked transaction should leave its nodes held")
+}
+
+// nodesDriver is a fake database driver, that answers a node for every
+// query, and counts the queries and the statements executed.  When
+// `err` is set, every query and statement fails with it instead.
+type nodesDriver struct {
+	queries int
+	execs   int
+	err     error
+}
+
+var fakeNodes = &nodesDriver{}
+
+func init() {
+	sql.Register("flow-nodes", fakeNodes)
+}
+
+func (d *nodesDriver) Open(string) (driver.Conn, error) { return nodesConn{d}, nil }
+
+type nodesConn struct{ d *nodesDriver }
+
+func (c nodesConn) Prepare(q string) (driver.Stmt, error) { return nodesStmt(c), nil }
+func (c nodesConn) Close() error                          { return nil }
+func (c nodesConn) Begin() (driver.Tx, error)             { return c, nil }
+func (c nodesConn) Commit() error                         { return nil }
+func (c nodesConn) Rollback() error                       { return nil }
+
+type nodesStmt struct{ d *nodesDriver }
+
+func (s nodesStmt) Close() error  { return nil }
+func (s nodesStmt) NumInput() int { return -1 }
+func (s nodesStmt) Exec([]driver.Value) (driver.Result, error) {
+	s.d.execs++
+	if s.d.err != nil {
+		return nil, s.d.err
+	}
+	return driver.RowsAffected(1), nil
+}
+func (s nodesStmt) Query(args []driver.Value) (driver.Rows, error) {
+	s.d.queries++
+	if s.d.err != nil {
+		return nil, s.d.err
+	}
+	return &nodesRows{args: args}, nil
+}
+
+// nodesRows answers a single node of the queried workflow and state.
+type nodesRows struct {
+	args []driver.Value
+	done bool
+}
+
+func (r *nodesRows) Columns() []string {
+	return []string{"id", "doctype_id", "docstate_id", "ac_id", "workflow_id", "name", "type"}
+}
+func (r *nodesRows) Close() error { return nil }
+func (r *nodesRows) Next(dest []driver.Value) error {
+	if r.done {
+		return io.EOF
+	}
+	r.done = true
+	copy(dest, []driver.Value{int64(1), int64(1), r.args[1], nil, r.args[0], "Review", "linear"})
+	return nil
+}
+
+// Repeated node lookups -- two or three per transition -- with and
+// without the node cache.
+func BenchmarkNodeLookup(b *testing.B) {
+	ctx := context.Background()
+	for _, size := range []int{0, 64} {
+		b.Run(fmt.Sprintf("cache=%d", size), func(b *testing.B) {
+			fdb, err := sql.Open("flow-nodes", "")
+			if err != nil {
+				b.Fatalf("%v", err)
+			}
+			odb, ocache := db, nodeCache
+			db, nodeCache = fdb, newNodeLRU(size)
+			defer func() {
+				fdb.Close()
+				db, nodeCache = odb, ocache
+			}()
+			fakeNodes.queries = 0
+
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if _, err := Nodes.getByWorkflowState(ctx, nil, 1, DocStateID(i%8+1)); err != nil {
+					b.Fatalf("%v", err)
+				}
+			}
+			b.ReportMetric(float64(fakeNodes.queries)/float64(b.N), "queries/op")
+		})
+	}
+}
+
+// Posting to many recipients needs a statement per batch of them.
+func TestFlowInsertPosts(t *testing.T) {
+	gt = t
+
+	fdb := fatal1(sql.Open("flow-nodes", "")).(*sql.DB)
+	defer fdb.Close()
+	tx := fatal1(fdb.Begin()).(*sql.Tx)
+	defer tx.Rollback()
+
+	gids := []GroupID{1, 2, 3, 4, 5}
+	for _, c := range []struct{ batch, execs int }{{1, 5}, {2, 3}, {5, 1}, {100, 1}} {
+		fakeNodes.execs = 0
+		fatal0(insertPosts(context.Background(), tx, false, 7, gids, c.batch))
+		assertEqual(c.execs, fakeNodes.execs)
+	}
+	fakeNodes.execs = 0
+	fatal0(insertPosts(context.Background(), tx, true, 7, nil, mailboxBatchSize))
+	assertEqual(0, fakeNodes.execs, "no recipients need no statement")
+}
+
+// Posting a message to 50 recipients, a statement per recipient and in
+// a single batch.
+func BenchmarkInsertPosts(b *testing.B) {
+	gids := make([]GroupID, 50)
+	for i := range gids {
+		gids[i] = GroupID(i + 1)
+	}
+
+	ctx := context.Background()
+	for _, batch := range []int{1, mailboxBatchSize} {
+		b.Run(fmt.Sprintf("batch=%d", batch), func(b *testing.B) {
+			fdb, err := sql.Open("flow-nodes", "")
+			if err != nil {
+				b.Fatalf("%v", err)
+			}
+			defer fdb.Close()
+			tx, err := fdb.Begin()
+			if err != nil {
+				b.Fatalf("%v", err)
+			}
+			defer tx.Rollback()
+			fakeNodes.execs = 0
+
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if err := insertPosts(ctx, tx, false, 7, gids, batch); err != nil {
+					b.Fatalf("%v", err)
+				}
+			}
+			b.ReportMetric(float64(fakeNodes.execs)/float64(b.N), "statements/op")
+		})
+	}
+}
+
+// Driver errors met while defining transitions are wrapped, naming the
+// offending transition.
+func TestFlowAddTransitionsWrapped(t *testing.T) {
+	gt = t
+
+	fdb := fatal1(sql.Open("flow-nodes", "")).(*sql.DB)
+	defer fdb.Close()
+	tx := fatal1(fdb.Begin()).(*sql.Tx)
+	defer tx.Rollback()
+
+	errDriver := errors.New("connection reset")
+	fakeNodes.err = errDriver
+	defer func() { fakeNodes.err = nil }()
+
+	err := addTransitions(context.Background(), tx, 1, 1, 2, map[DocActionID]DocStateID{7: 3})
+	assertEqual(true, errors.Is(err, errDriver), fmt.Sprintf("the driver error should be reachable : %v", err))
+	if err != nil {
+		assertEqual(true, strings.Contains(err.Error(), "upon action 7"), err.Error())
+	}
+}
+
+// Replaying an event log follows the transitions of its events.
+func TestFlowReplayEvents(t *testing.T) {
+	gt = t
+
+	targets := map[DocStateID]map[DocActionID][]DocStateID{
+		1: {10: {2}},
+		2: {20: {3, 4}, 30: {2}},
+	}
+	rec := func(ds DocStateID) sql.NullInt64 { return sql.NullInt64{Int64: int64(ds), Valid: true} }
+
+	ds, err := replayEvents(1, []replayedEvent{
+		{id: 1, state: 1, action: 10},
+		{id: 2, state: 2, action: 30},
+		{id: 3, state: 2, action: 20, recorded: rec(4)},
+	}, targets)
+	fatal0(err)
+	assertEqual(DocStateID(4), ds)
+
+	ds = fatal1(replayEvents(1, nil, targets)).(DocStateID)
+	assertEqual(DocStateID(1), ds, "a document without events is in its begin state")
+
+	for _, events := range [][]replayedEvent{
+		{{id: 1, state: 2, action: 10}},
+		{{id: 1, state: 1, action: 20}},
+		{{id: 1, state: 1, action: 10}, {id: 2, state: 2, action: 20}},
+		{{id: 1, state: 1, action: 10, recorded: rec(3)}},
+	} {
+		_, err = replayEvents(1, events, targets)
+		e, ok := err.(*ErrEventLogInconsistent)
+		assertEqual(true, ok, fmt.Sprintf("expected *ErrEventLogInconsistent, observed : %v", err))
+		if ok {
+			assertEqual(events[len(events)-1].id, e.Event)
+		}
+	}
+}
+
+// Timeline entries that share a time are ordered by cause and effect.
+func TestFlowSortTimeline(t *testing.T) {
+	gt = t
+
+	t0 := time.Date(2017, 3, 1, 10, 0, 0, 0, time.UTC)
+	ary := []TimelineEntry{
+		{Time: t0, Kind: TimelineKindMessage},
+		{Time: t0.Add(-time.Minute), Kind: TimelineKindState},
+		{Time: t0, Kind: TimelineKindState},
+		{Time: t0, Kind: TimelineKindEvent},
+	}
+	sortTimeline(ary)
+	kinds := make([]string, 0, len(ary))
+	for _, e := range ary {
+		kinds = append(kinds, string(e.Kind))
+	}
+	assertEqual("state,event,state,message", strings.Join(kinds, ","))
+	assertEqual(true, ary[0].Time.Before(t0))
+}
+
+func TestFlowSortedActions(t *testing.T) {
+	gt = t
+
+	hash := map[DocActionID]DocStateID{9: 1, 3: 2, 27: 3, 1: 4, 12: 5}
+	for i := 0; i < 10; i++ {
+		assertEqual("[1 3 9 12 27]", fmt.Sprint(sortedActions(hash)))
+	}
+}
+
+// Missing tables and columns are all reported, with the table prefix.
+func TestFlowSchema(t *testing.T) {
+	gt = t
+
+	have := make(map[string]map[string]bool)
+	for tbl, cols := range schemaTables {
+		have["app_"+tbl] = make(map[string]bool)
+		for _, col := range cols {
+			have["app_"+tbl][col] = true
+		}
+	}
+	assertEqual(0, len(missingSchema(have, "app_")))
+
+	delete(have, "app_wf_mailboxes")
+	delete(have["app_wf_workflows"], "version")
+	assertEqual("app_wf_mailboxes app_wf_workflows.version", strings.Join(missingSchema(have, "app_"), " "))
+	assertEqual(len(schemaTables), len(missingSchema(have, "")), "unprefixed tables should all be missing")
+}
+
+// Created schema matches that which is checked, in both dialects.
+func TestFlowCreateSchema(t *testing.T) {
+	gt = t
+
+	for _, d := range []Dialect{DialectMySQL, DialectPostgres} {
+		all := strings.Join(schemaStatements(d), "\n")
+		for tbl := range schemaTables {
+			if tbl == "wf_users_master" {
+				continue
+			}
+			assertEqual(true, strings.Contains(all, " "+tbl+" "), "schema should create "+tbl)
+		}
+		assertEqual(d == DialectMySQL, strings.Contains(all, "AUTO_INCREMENT"))
+		assertEqual(d == DialectMySQL, strings.Contains(all, "ENUM("))
+		assertEqual(d == DialectPostgres, strings.Contains(all, "CREATE INDEX IF NOT EXISTS wf_docevents_doctype_id_doc_id_idx"))
+	}
+}
+
+// relStates maps the given states to their positions among the test
+// states, so that assertions do not depend on generated identifiers.
+func relStates(ary []DocStateID) []int {
+	all := []DocStateID{dsID1, dsID2, dsID3, dsID4, dsID5}
+	res := make([]int, 0, len(ary))
+	for _, ds := range ary {
+		for i, el := range all {
+			if el == ds {
+				res = append(res, i+1)
+			}
+		}
+	}
+	return res
+}
+
 // Initialise DB connection.
 func TestFlowInit(t *testing.T) {
 	gt = t
 
-	// Connect to the database.
-	driver, connStr := "mysql", "travis@/flow"
-	tdb := fatal1(sql.Open(driver, connStr)).(*sql.DB)
-	RegisterDB(tdb)
-}
+	// Connect to the database.
+	driver, connStr := "mysql", "travis@/flow?parseTime=true"
+	tdb := fatal1(sql.Open(driver, connStr)).(*sql.DB)
+
+	_, err := Open(nil)
+	assertNotEqual(nil, err, "a nil handle should be rejected")
+	_, err = Open(tdb, WithTablePrefix("app-1"))
+	assertNotEqual(nil, err, "invalid table prefixes should be rejected")
+	_, err = Open(tdb, WithDialect(Dialect(99)))
+	assertNotEqual(nil, err, "unknown dialects should be rejected")
+
+	RegisterDB(tdb)
+	assertEqual(tdb, DefaultEngine().DB())
+	assertEqual(nil, CreateSchema(tdb), "creating the schema again should do nothing")
+	assertEqual(nil, CheckSchema(), "the test database should have the complete schema")
+	assertEqual(DialectMySQL, DefaultEngine().Dialect())
+}
+
+// Test-local state.
+var gt *testing.T
+
+var dtID1, dtID2 DocTypeID
+var dsID1, dsID2, dsID3, dsID4, dsID5 DocStateID
+var daID1, daID2, daID3, daID4, daID5, daID6, daID7, daID8, daID9 DocActionID
+var wfID1, wfID2 WorkflowID
+
+var roleID1, roleID2 RoleID
+var uID1, uID2, uID3, uID4 UserID
+var gID1, gID2, gID3, gID4, gID5, gID6 GroupID
+
+var acID1 AccessContextID
+var docID1, docID2 DocumentID
+
+// Create operations.
+func TestFlowCreate(t *testing.T) {
+	gt = t
+
+	t.Run("DocTypes", func(t *testing.T) {
+		tx := fatal1(db.Begin()).(*sql.Tx)
+		defer tx.Rollback()
+
+		dtID1 = fatal1(DocTypes.New(tx, "Stor Request")).(DocTypeID)
+		dtID2 = fatal1(DocTypes.New(tx, "Compute Request")).(DocTypeID)
+
+		fatal0(tx.Commit())
+	})
+
+	t.Run("DocStates", func(t *testing.T) {
+		tx := fatal1(db.Begin()).(*sql.Tx)
+		defer tx.Rollback()
+
+		dsID1 = fatal1(DocStates.New(tx, "Initial")).(DocStateID)
+		dsID2 = fatal1(DocStates.New(tx, "Pending Approval")).(DocStateID)
+		dsID3 = fatal1(DocStates.New(tx, "Approved")).(DocStateID)
+		dsID4 = fatal1(DocStates.New(tx, "Rejected")).(DocStateID)
+		dsID5 = fatal1(DocStates.New(tx, "Discarded")).(DocStateID)
+
+		fatal0(tx.Commit())
+	})
+
+	t.Run("DocActions", func(t *testing.T) {
+		tx := fatal1(db.Begin()).(*sql.Tx)
+		defer tx.Rollback()
+
+		daID1 = fatal1(DocActions.New(tx, "Initialise", false)).(DocActionID)
+		daID2 = fatal1(DocActions.New(tx, "New", false)).(DocActionID)
+		daID3 = fatal1(DocActions.New(tx, "Get", false)).(DocActionID)
+		daID4 = fatal1(DocActions.New(tx, "Update", true)).(DocActionID)
+		daID5 = fatal1(DocActions.New(tx, "Delete", true)).(DocActionID)
+		daID6 = fatal1(DocActions.New(tx, "Approve", false)).(DocActionID)
+		daID7 = fatal1(DocActions.New(tx, "Reject", false)).(DocActionID)
+		daID8 = fatal1(DocActions.New(tx, "Return", false)).(DocActionID)
+		daID9 = fatal1(DocActions.New(tx, "Discard", true)).(DocActionID)
+
+		fatal0(tx.Commit())
+	})
+
+	t.Run("Workflows", func(t *testing.T) {
+		tx := fatal1(db.Begin()).(*sql.Tx)
+		defer tx.Rollback()
+
+		wfID1 = fatal1(Workflows.New(tx, "Storage Management", dtID1, dsID1)).(WorkflowID)
+
+		fatal0(tx.Commit())
+	})
+
+	t.Run("WorkflowsNoTx", func(t *testing.T) {
+		// `nil` transaction : `New` should begin and commit its own.
+		wfID2 = fatal1(Workflows.New(nil, "Compute Management", dtID2, dsID1)).(WorkflowID)
+
+		wf := fatal1(Workflows.Get(wfID2)).(*Workflow)
+		assertEqual("Compute Management", wf.Name)
+		assertEqual(dtID2, wf.DocType.ID)
+	})
+
+	t.Run("WorkflowsAddNodeFull", func(t *testing.T) {
+		var res interface{}
+		if res = error1(Workflows.AddNodeFull(nil, dtID1, dsID1, 0, wfID1, " Storage Begin ", NodeTypeBegin)); res == nil {
+			return
+		}
+		n := res.(*Node)
+		assertNotEqual(NodeID(0), n.ID)
+		assertEqual("Storage Begin", n.Name)
+		assertEqual(dsID1, n.State)
+		assertEqual(NodeTypeBegin, n.NodeType)
+
+		if res = error1(Workflows.GetNode(n.ID)); res == nil {
+			return
+		}
+		n2 := res.(*Node)
+		assertEqual(n.Name, n2.Name)
+		assertEqual(NodeTypeBegin, n2.Type())
+		error1(n2.Transitions())
+	})
+
+	t.Run("WorkflowsAddNodesAtomic", func(t *testing.T) {
+		specs := []NodeSpec{
+			{DocType: dtID2, State: dsID2, Name: "Compute Begin", NodeType: NodeTypeBegin,
+				Transitions: map[DocActionID]DocStateID{daID1: dsID3}},
+			{DocType: dtID2, State: dsID3, Name: "Compute Review", NodeType: NodeTypeLinear},
+			{DocType: dtID2, State: DocStateID(999999), Name: "Compute Bogus", NodeType: NodeTypeEnd},
+		}
+		_, err := Workflows.AddNodes(nil, wfID2, specs)
+		assertNotEqual(nil, err, "the third node has an unknown state")
+
+		bad := []NodeSpec{
+			{DocType: dtID2, State: dsID2, Name: "Compute Begin", NodeType: NodeTypeBegin,
+				Transitions: map[DocActionID]DocStateID{DocActionID(999999): dsID3}},
+		}
+		_, err = Workflows.AddNodes(nil, wfID2, bad)
+		assertNotEqual(nil, err, "transitions upon unknown actions should be rejected")
+		if err != nil {
+			assertEqual(true, strings.Contains(err.Error(), "999999"), err.Error())
+		}
+
+		ns := fatal1(Workflows.Nodes(wfID2)).([]*Node)
+		assertEqual(0, len(ns), "no node should remain of a failed batch")
+		ts := fatal1(DocTypes.Transitions(dtID2, 0)).(map[DocStateID]*TransitionMap)
+		assertEqual(0, len(ts), "no transition should remain of a failed batch")
+	})
+
+	t.Run("TransitionsInvalid", func(t *testing.T) {
+		err := DocTypes.AddTransition(nil, dtID1, dsID1, DocActionID(999999), dsID2)
+		assertNotEqual(nil, err, "unknown actions should be rejected")
+		if err != nil {
+			assertEqual(true, strings.Contains(err.Error(), "999999"), err.Error())
+		}
+
+		err = DocTypes.AddTransition(nil, dtID1, dsID1, daID1, DocStateID(999998))
+		assertNotEqual(nil, err, "unknown target states should be rejected")
+		if err != nil {
+			assertEqual(true, strings.Contains(err.Error(), "999998"), err.Error())
+		}
+	})
+
+	t.Run("Users", func(t *testing.T) {
+		tx := fatal1(db.Begin()).(*sql.Tx)
+		defer tx.Rollback()
+
+		res, err := tx.Exec(`INSERT INTO users_master(first_name, last_name, email, active)
+			VALUES('FN 1', 'LN 1', 'email1@example.com', 1)`)
+		if err != nil {
+			t.Fatalf("%v\n", err)
+		}
+		uid, _ := res.LastInsertId()
+		uID1 = UserID(uid)
+		gID1 = fatal1(Groups.NewSingleton(tx, uID1)).(GroupID)
+
+		res, err = tx.Exec(`INSERT INTO users_master(first_name, last_name, email, active)
+			VALUES('FN 2', 'LN 2', 'email2@example.com', 1)`)
+		if err != nil {
+			t.Fatalf("%v\n", err)
+		}
+		uid, _ = res.LastInsertId()
+		uID2 = UserID(uid)
+		gID2 = fatal1(Groups.NewSingleton(tx, uID2)).(GroupID)
+
+		res, err = tx.Exec(`INSERT INTO users_master(first_name, last_name, email, active)
+			VALUES('FN 3', 'LN 3', 'email3@example.com', 1)`)
+		if err != nil {
+			t.Errorf("%v\n", err)
+		}
+		uid, _ = res.LastInsertId()
+		uID3 = UserID(uid)
+		gID3 = fatal1(Groups.NewSingleton(tx, uID3)).(GroupID)
+
+		res, err = tx.Exec(`INSERT INTO users_master(first_name, last_name, email, active)
+			VALUES('FN 4', 'LN 4', 'email4@example.com', 1)`)
+		if err != nil {
+			t.Fatalf("%v\n", err)
+		}
+		uid, _ = res.LastInsertId()
+		uID4 = UserID(uid)
+		gID4 = fatal1(Groups.NewSingleton(tx, uID4)).(GroupID)
+
+		fatal0(tx.Commit())
+	})
+
+	t.Run("Groups", func(t *testing.T) {
+		tx := fatal1(db.Begin()).(*sql.Tx)
+		defer tx.Rollback()
+
+		gID5 = fatal1(Groups.New(tx, "Analysts", "G")).(GroupID)
+		gID6 = fatal1(Groups.New(tx, "Managers", "G")).(GroupID)
+
+		fatal0(tx.Commit())
+	})
+
+	t.Run("GroupsAddUsers", func(t *testing.T) {
+		tx := fatal1(db.Begin()).(*sql.Tx)
+		defer tx.Rollback()
+
+		fatal0(Groups.AddUser(tx, gID5, uID1))
+		fatal0(Groups.AddUser(tx, gID5, uID2))
+		fatal0(Groups.AddUser(tx, gID5, uID3))
+
+		fatal0(Groups.AddUser(tx, gID6, uID2))
+		fatal0(Groups.AddUser(tx, gID6, uID3))
+		fatal0(Groups.AddUser(tx, gID6, uID4))
+
+		fatal0(tx.Commit())
+	})
+
+	t.Run("Roles", func(t *testing.T) {
+		tx := fatal1(db.Begin()).(*sql.Tx)
+		defer tx.Rollback()
+
+		roleID1 = fatal1(Roles.New(tx, "Research Analyst")).(RoleID)
+		roleID2 = fatal1(Roles.New(tx, "Manager")).(RoleID)
+
+		fatal0(tx.Commit())
+	})
+
+	t.Run("RolesAddPermissions", func(t *testing.T) {
+		tx := fatal1(db.Begin()).(*sql.Tx)
+		defer tx.Rollback()
+
+		fatal0(Roles.AddPermissions(tx, roleID1, dtID1, []DocActionID{daID1, daID2, daID3, daID4, daID8, daID9}))
+		fatal0(Roles.AddPermissions(tx, roleID2, dtID1, []DocActionID{daID1, daID2, daID3, daID4, daID5, daID6, daID7, daID8, daID9}))
+
+		fatal0(tx.Commit())
+	})
+
+	t.Run("AccessContexts", func(t *testing.T) {
+		tx := fatal1(db.Begin()).(*sql.Tx)
+		defer tx.Rollback()
+
+		acID1 = fatal1(AccessContexts.New(tx, "Storage Team")).(AccessContextID)
+		fatal0(AccessContexts.AddGroupRole(tx, acID1, gID5, roleID1))
+
+		fatal0(tx.Commit())
+	})
+
+	t.Run("Documents", func(t *testing.T) {
+		docID1 = fatal1(Documents.New(nil, &DocumentsNewInput{
+			DocTypeID:       dtID1,
+			AccessContextID: acID1,
+			GroupID:         gID1,
+			Title:           "Additional storage for analytics",
+			Data:            "Please provision 2 TB of storage.",
+		})).(DocumentID)
+
+		doc := fatal1(Documents.Get(nil, dtID1, docID1)).(*Document)
+		assertEqual(dsID1, doc.State.ID, "new documents should be in the workflow's begin state")
+	})
+}
+
+// Entity listing.
+func TestFlowList(t *testing.T) {
+	gt = t
+	var res interface{}
+
+	t.Run("DocTypes", func(t *testing.T) {
+		var dts []*DocType
+		if res = error1(DocTypes.List(0, 0)); res == nil {
+			return
+		}
+		dts = res.([]*DocType)
+		assertEqual(2, len(dts))
+	})
+
+	t.Run("DocStates", func(t *testing.T) {
+		var dss []*DocState
+		if res = error1(DocStates.List(0, 0)); res == nil {
+			return
+		}
+		dss = res.([]*DocState)
+		// There is a pre-defined reserved state for children.
+		assertEqual(6, len(dss))
+	})
+
+	t.Run("DocActions", func(t *testing.T) {
+		var das []*DocAction
+		if res = error1(DocActions.List(0, 0)); res == nil {
+			return
+		}
+		das = res.([]*DocAction)
+		assertEqual(9, len(das))
+	})
+
+	t.Run("Workflows", func(t *testing.T) {
+		if res = error1(Workflows.List(0, 0)); res == nil {
+			return
+		}
+		wfs := res.([]*Workflow)
+		assertEqual(2, len(wfs))
+	})
+
+	t.Run("WorkflowsOrdered", func(t *testing.T) {
+		if res = error1(Workflows.ListOrdered(WorkflowOrderByName, true, 0, 0)); res == nil {
+			return
+		}
+		wfs := res.([]*Workflow)
+		assertEqual(2, len(wfs))
+		if len(wfs) == 2 {
+			assertEqual("Storage Management", wfs[0].Name)
+			assertEqual("Compute Management", wfs[1].Name)
+		}
+
+		_, err := Workflows.ListOrdered(WorkflowOrderField(99), false, 0, 0)
+		assertNotEqual(nil, err, "unknown order fields should be rejected")
+	})
+
+	t.Run("WorkflowsByNamePrefix", func(t *testing.T) {
+		if res = error1(Workflows.ListByNamePrefix("Storage ", 0, 0)); res == nil {
+			return
+		}
+		wfs := res.([]*Workflow)
+		assertEqual(1, len(wfs))
+
+		if res = error1(Workflows.ListByNamePrefix("_tor", 0, 0)); res == nil {
+			return
+		}
+		assertEqual(0, len(res.([]*Workflow)), "`_` should not act as a wildcard")
+	})
+
+	t.Run("WorkflowsIterate", func(t *testing.T) {
+		var names []string
+		err := Workflows.Iterate(0, 0, func(wf *Workflow) error {
+			names = append(names, wf.Name)
+			return nil
+		})
+		error0(err)
+		assertEqual(2, len(names))
+
+		stop := errors.New("stop")
+		n := 0
+		err = Workflows.Iterate(0, 0, func(wf *Workflow) error {
+			n++
+			return stop
+		})
+		assertEqual(stop, err)
+		assertEqual(1, n, "iteration should stop at the first error")
+	})
+
+	t.Run("WorkflowsByDocType", func(t *testing.T) {
+		if res = error1(Workflows.ListByDocType(dtID2, 0, 0)); res == nil {
+			return
+		}
+		wfs := res.([]*Workflow)
+		assertEqual(1, len(wfs))
+		assertEqual(wfID2, wfs[0].ID)
+	})
+
+	t.Run("WorkflowsByBeginState", func(t *testing.T) {
+		if res = error1(Workflows.ListByBeginState(dsID1, 0, 0)); res == nil {
+			return
+		}
+		wfs := res.([]*Workflow)
+		assertEqual(2, len(wfs), "both workflows begin in the same state")
+		ids := fatal1(Workflows.ListByBeginState(dsID2, 0, 0)).([]*Workflow)
+		assertEqual(0, len(ids))
+	})
+
+	t.Run("WorkflowsListPage", func(t *testing.T) {
+		if res = error1(Workflows.ListPage(0, 1)); res == nil {
+			return
+		}
+		page := res.(*WorkflowPage)
+		total := fatal1(Workflows.Count()).(int64)
+		assertEqual(1, len(page.Items))
+		assertEqual(total, page.Total)
+		assertEqual(int64(1), page.NextOffset)
+		assertEqual(total > 1, page.HasMore)
+
+		page = fatal1(Workflows.ListPage(1000, 1)).(*WorkflowPage)
+		assertEqual(0, len(page.Items))
+		assertEqual(total, page.Total, "a page beyond the end should still know the total")
+		assertEqual(false, page.HasMore)
+	})
+
+	t.Run("WorkflowsCount", func(t *testing.T) {
+		if res = error1(Workflows.Count()); res == nil {
+			return
+		}
+		assertEqual(int64(2), res.(int64))
+
+		if res = error1(Workflows.CountByDocType(dtID1)); res == nil {
+			return
+		}
+		assertEqual(int64(1), res.(int64))
+	})
+
+	t.Run("WorkflowsCancelled", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		_, err := Workflows.ListContext(ctx, 0, 0)
+		assertNotEqual(nil, err, "listing under a cancelled context should fail")
+		_, err = DocTypes.ListContext(ctx, 0, 0)
+		assertNotEqual(nil, err, "listing document types under a cancelled context should fail")
+		_, err = Users.ListContext(ctx, "", 0, 0)
+		assertNotEqual(nil, err, "listing users under a cancelled context should fail")
+	})
+
+	t.Run("Users", func(t *testing.T) {
+		if res = error1(Users.List("", 0, 0)); res == nil {
+			return
+		}
+		us := res.([]*User)
+		assertEqual(4, len(us))
+
+		if res = error1(Users.List("LN 4", 0, 0)); res == nil {
+			return
+		}
+		us = res.([]*User)
+		assertEqual(1, len(us))
+	})
+
+	t.Run("Groups", func(t *testing.T) {
+		var gs []*Group
+		if res = error1(Groups.List(0, 0)); res == nil {
+			return
+		}
+		gs = res.([]*Group)
+		assertEqual(6, len(gs))
+	})
+
+	t.Run("Roles", func(t *testing.T) {
+		var rs []*Role
+		if res = error1(Roles.List(0, 0)); res == nil {
+			return
+		}
+		rs = res.([]*Role)
+		// There are two pre-defined roles for administrators.
+		assertEqual(4, len(rs))
+	})
+}
+
+// Retrieval of individual entities.
+func TestFlowGet(t *testing.T) {
+	gt = t
+	var res interface{}
+
+	t.Run("DocTypes", func(t *testing.T) {
+		var dt *DocType
+		if res = error1(DocTypes.GetByName("Compute Request")); res == nil {
+			return
+		}
+		dt = res.(*DocType)
+		assertEqual("Compute Request", dt.Name)
+
+		var dt2 *DocType
+		if res = error1(DocTypes.Get(dt.ID)); res == nil {
+			return
+		}
+		dt2 = res.(*DocType)
+		assertEqual("Compute Request", dt2.Name)
+	})
+
+	t.Run("DocStates", func(t *testing.T) {
+		var ds *DocState
+		if res = error1(DocStates.GetByName("Approved")); res == nil {
+			return
+		}
+		ds = res.(*DocState)
+		assertEqual("Approved", ds.Name)
+
+		var ds2 *DocState
+		if res = error1(DocStates.Get(ds.ID)); res == nil {
+			return
+		}
+		ds2 = res.(*DocState)
+		assertEqual("Approved", ds2.Name)
+	})
+
+	t.Run("DocActions", func(t *testing.T) {
+		var da *DocAction
+		if res = error1(DocActions.GetByName("Reject")); res == nil {
+			return
+		}
+		da = res.(*DocAction)
+		assertEqual("Reject", da.Name)
+
+		var da2 *DocAction
+		if res = error1(DocActions.Get(da.ID)); res == nil {
+			return
+		}
+		da2 = res.(*DocAction)
+		assertEqual("Reject", da2.Name)
+	})
+
+	t.Run("Workflows", func(t *testing.T) {
+		if res = error1(Workflows.GetByDocType(dtID1)); res == nil {
+			return
+		}
+		wf := res.(*Workflow)
+		assertEqual("Storage Management", wf.Name)
+		assertEqual(wfID1, wf.ID)
+	})
+
+	t.Run("WorkflowsByName", func(t *testing.T) {
+		if res = error1(Workflows.GetByName("  Compute Management\t")); res == nil {
+			return
+		}
+		wf := res.(*Workflow)
+		assertEqual(wfID2, wf.ID)
+
+		_, err := Workflows.GetByName("No Such Workflow")
+		assertEqual(ErrNotFound, err)
+	})
+
+	t.Run("WorkflowsGetMany", func(t *testing.T) {
+		if res = error1(Workflows.GetMany([]WorkflowID{wfID2, wfID1, wfID2, wfID2 + 1000})); res == nil {
+			return
+		}
+		m := res.(map[WorkflowID]*Workflow)
+		assertEqual(2, len(m), "repeated and unknown workflows should not be answered")
+		assertEqual("Compute Management", m[wfID2].Name)
+		assertEqual(dtID1, m[wfID1].DocType.ID)
+
+		assertEqual(0, len(fatal1(Workflows.GetMany(nil)).(map[WorkflowID]*Workflow)))
+		_, err := Workflows.GetMany([]WorkflowID{wfID1, 0})
+		assertNotEqual(nil, err)
+	})
+
+	t.Run("WorkflowsIsRunnable", func(t *testing.T) {
+		ok, reasons, err := Workflows.IsRunnable(wfID2)
+		fatal0(err)
+		assertEqual(true, ok, strings.Join(reasons, "; "))
+		assertEqual(0, len(reasons))
+
+		_, _, err = Workflows.IsRunnable(wfID2 + 1000)
+		assertEqual(ErrNotFound, err)
+	})
+
+	t.Run("WorkflowsListRunnable", func(t *testing.T) {
+		wfs := fatal1(Workflows.ListRunnable(0, 0)).([]*Workflow)
+		found := false
+		for _, wf := range wfs {
+			ok, reasons, err := Workflows.IsRunnable(wf.ID)
+			fatal0(err)
+			assertEqual(true, ok, strings.Join(reasons, "; "))
+			if wf.ID == wfID2 {
+				found = true
+			}
+		}
+		assertEqual(true, found, "a runnable workflow should be listed")
+
+		_, err := Workflows.ListRunnable(-1, 0)
+		assertNotEqual(nil, err)
+	})
+
+	t.Run("WorkflowsStateHistogram", func(t *testing.T) {
+		if res = error1(Workflows.StateHistogram(wfID1)); res == nil {
+			return
+		}
+		hist := res.(map[DocStateID]int64)
+		for _, n := range fatal1(Nodes.List(wfID1)).([]*Node) {
+			_, ok := hist[n.State]
+			assertEqual(true, ok, "every node's state should be present")
+		}
+		doc := fatal1(Documents.Get(nil, dtID1, docID1)).(*Document)
+		assertEqual(true, hist[doc.State.ID] >= 1, "the document's state should be counted")
+
+		var total int64
+		for _, n := range hist {
+			total += n
+		}
+		var count int64
+		q := `SELECT COUNT(*) FROM ` + DocTypes.docStorName(dtID1) + ` WHERE wf_version = 1 AND path = ''`
+		fatal0(db.QueryRow(rebind(q)).Scan(&count))
+		assertEqual(count, total)
+	})
+
+	t.Run("WorkflowsValidate", func(t *testing.T) {
+		if res = error1(Workflows.Validate(wfID1)); res == nil {
+			return
+		}
+		rep := res.(*ValidationReport)
+		assertEqual(wfID1, rep.Workflow)
+
+		error1(Workflows.DetectCycles(wfID1))
+	})
+
+	t.Run("WorkflowsValidateAll", func(t *testing.T) {
+		if res = error1(Workflows.ValidateAll()); res == nil {
+			return
+		}
+		reps := res.(map[WorkflowID]*ValidationReport)
+		for wid, rep := range reps {
+			assertEqual(wid, rep.Workflow)
+			assertEqual(false, rep.OK(), "clean workflows should be omitted")
+		}
+
+		for _, wid := range []WorkflowID{wfID1, wfID2} {
+			one := fatal1(Workflows.Validate(wid)).(*ValidationReport)
+			rep, ok := reps[wid]
+			assertEqual(!one.OK(), ok)
+			if ok {
+				assertEqual(fmt.Sprint(one), fmt.Sprint(rep))
+			}
+		}
+	})
+
+	t.Run("WorkflowsNodeForState", func(t *testing.T) {
+		if res = error1(Workflows.NodeForState(dtID1, dsID1)); res == nil {
+			return
+		}
+		n := res.(*Node)
+		assertEqual(dtID1, n.DocType)
+		assertEqual(dsID1, n.State)
+		assertEqual(fatal1(Nodes.GetByState(dtID1, dsID1)).(*Node).ID, n.ID)
+	})
+
+	t.Run("WorkflowsExport", func(t *testing.T) {
+		if res = error1(Workflows.Export(wfID1)); res == nil {
+			return
+		}
+		data := res.([]byte)
+		again := fatal1(Workflows.Export(wfID1)).([]byte)
+		assertEqual(string(data), string(again), "export should be deterministic")
+
+		var def WorkflowDefinition
+		fatal0(json.Unmarshal(data, &def))
+		assertEqual(DefinitionVersion, def.Version)
+		assertEqual("Storage Management", def.Name)
+		assertEqual(int64(dtID1), def.DocType.ID)
+	})
+
+	t.Run("WorkflowsImport", func(t *testing.T) {
+		def := WorkflowDefinition{}
+		fatal0(json.Unmarshal(fatal1(Workflows.Export(wfID1)).([]byte), &def))
+
+		// The document type already has a workflow; nothing should
+		// remain of the failed import.
+		def.Name = "Storage Management Copy"
+		data := fatal1(json.Marshal(&def)).([]byte)
+		_, err := Workflows.ImportByName(nil, data)
+		assertNotEqual(nil, err, "a second workflow for a document type should be rejected")
+
+		def.BeginState.ID = -1
+		data = fatal1(json.Marshal(&def)).([]byte)
+		_, err = Workflows.Import(nil, data)
+		assertNotEqual(nil, err, "unknown state IDs should be rejected")
 
-// Test-local state.
-var gt *testing.T
+		def.Version = DefinitionVersion + 1
+		data = fatal1(json.Marshal(&def)).([]byte)
+		_, err = Workflows.Import(nil, data)
+		assertNotEqual(nil, err, "unknown definition versions should be rejected")
 
-var dtID1, dtID2 DocTypeID
-var dsID1, dsID2, dsID3, dsID4, dsID5 DocStateID
-var daID1, daID2, daID3, daID4, daID5, daID6, daID7, daID8, daID9 DocActionID
-var wfID1, wfID2 WorkflowID
+		if res = error1(Workflows.Count()); res == nil {
+			return
+		}
+		assertEqual(int64(2), res.(int64))
+	})
 
-var roleID1, roleID2 RoleID
-var uID1, uID2, uID3, uID4 UserID
-var gID1, gID2, gID3, gID4, gID5, gID6 GroupID
+	t.Run("WorkflowsPeekEvent", func(t *testing.T) {
+		if res = error1(Workflows.Get(wfID1)); res == nil {
+			return
+		}
+		wf := res.(*Workflow)
 
-// Create operations.
-func TestFlowCreate(t *testing.T) {
+		_, _, err := wf.PeekEvent(&DocEvent{DocType: dtID2})
+		assertEqual(ErrDocEventDocTypeMismatch, err)
+
+		_, _, err = wf.PeekEvent(&DocEvent{DocType: dtID1, DocID: DocumentID(999999)})
+		assertNotEqual(nil, err, "peeking at an event on an unknown document should fail")
+	})
+
+	t.Run("WorkflowsApplyEvents", func(t *testing.T) {
+		if res = error1(Workflows.Get(wfID1)); res == nil {
+			return
+		}
+		wf := res.(*Workflow)
+
+		if res = error1(wf.ApplyEvents(nil, nil, nil)); res == nil {
+			return
+		}
+		assertEqual(0, len(res.([]DocStateID)))
+
+		evs := []*DocEvent{{DocType: dtID1, DocID: DocumentID(999999)}, {DocType: dtID2}}
+		_, err := wf.ApplyEvents(nil, evs, nil)
+		assertNotEqual(nil, err, "a failing event should fail the batch")
+	})
+
+	t.Run("WorkflowsNoTransition", func(t *testing.T) {
+		if res = error1(Workflows.Get(wfID1)); res == nil {
+			return
+		}
+		wf := res.(*Workflow)
+
+		_, _, err := wf.PeekEvent(&DocEvent{DocType: dtID1, DocID: docID1, State: dsID1, Action: daID2})
+		nt, ok := err.(*ErrNoTransition)
+		if !ok {
+			t.Errorf("expected *ErrNoTransition, observed : %v", err)
+			return
+		}
+		assertEqual(dsID1, nt.State)
+		assertEqual(daID2, nt.Action)
+	})
+
+	t.Run("WorkflowsCanApply", func(t *testing.T) {
+		ev := &DocEvent{DocType: dtID1, DocID: docID1, State: dsID1, Action: daID2}
+
+		if res = error1(Workflows.CanApply(ev, gID1)); res == nil {
+			return
+		}
+		assertEqual(true, res.(bool), "an analyst should be permitted")
+
+		if res = error1(Workflows.CanApply(ev, gID4)); res == nil {
+			return
+		}
+		assertEqual(false, res.(bool), "a user outside the access context should be denied")
+
+		SetPermissionChecks(true)
+		defer SetPermissionChecks(false)
+
+		wf := fatal1(Workflows.Get(wfID1)).(*Workflow)
+		ev.Group = gID4
+		_, err := wf.ApplyEvent(nil, ev, nil)
+		_, ok := err.(*ErrPermissionDenied)
+		assertEqual(true, ok, fmt.Sprintf("expected *ErrPermissionDenied, observed : %v", err))
+	})
+
+	t.Run("WorkflowsNodes", func(t *testing.T) {
+		if res = error1(Workflows.Nodes(wfID1)); res == nil {
+			return
+		}
+		ns := res.([]*Node)
+		for i := 1; i < len(ns); i++ {
+			assertEqual(true, ns[i-1].State < ns[i].State, "nodes should be ordered by state")
+		}
+	})
+
+	t.Run("Groups", func(t *testing.T) {
+		var g *Group
+		if res = error1(Groups.Get(gID1)); res == nil {
+			return
+		}
+		g = res.(*Group)
+
+		var u *User
+		if res = error1(Groups.SingletonUser(gID1)); res == nil {
+			return
+		}
+		u = res.(*User)
+
+		assertEqual(u.Email, g.Name, "singleton group name should match corresponding user's e-mail")
+
+		if res = error1(Groups.HasUser(gID6, uID4)); res == nil {
+			return
+		}
+		ok := res.(bool)
+		assertEqual(true, ok)
+	})
+
+	t.Run("Roles", func(t *testing.T) {
+		var dt *DocType
+		if res = error1(DocTypes.Get(dtID1)); res == nil {
+			return
+		}
+		dt = res.(*DocType)
+
+		if res = error1(Roles.Permissions(roleID1)); res == nil {
+			return
+		}
+		perms := res.(map[string]struct {
+			DocTypeID DocTypeID
+			Actions   []*DocAction
+		})
+		assertEqual(1, len(perms))
+		assertEqual(6, len(perms[dt.Name].Actions))
+
+		if res = error1(Roles.HasPermission(roleID2, dtID1, daID6)); res == nil {
+			return
+		}
+		assertEqual(true, res.(bool))
+	})
+
+	t.Run("GettersNotFound", func(t *testing.T) {
+		const bogus = 999999
+		check := func(name string, err error) {
+			if !errors.Is(err, ErrNotFound) {
+				t.Errorf("%s : expected ErrNotFound, observed : %v", name, err)
+			}
+		}
+
+		_, err := DocTypes.Get(bogus)
+		check("DocTypes.Get", err)
+		_, err = DocTypes.GetByName("No Such Type")
+		check("DocTypes.GetByName", err)
+		_, err = DocStates.Get(bogus)
+		check("DocStates.Get", err)
+		_, err = DocStates.GetByName("No Such State")
+		check("DocStates.GetByName", err)
+		_, err = DocActions.Get(bogus)
+		check("DocActions.Get", err)
+		_, err = DocActions.GetByName("No Such Action")
+		check("DocActions.GetByName", err)
+		_, err = Roles.Get(bogus)
+		check("Roles.Get", err)
+		_, err = Roles.GetByName("No Such Role")
+		check("Roles.GetByName", err)
+		_, err = Users.Get(bogus)
+		check("Users.Get", err)
+		_, err = Users.GetByEmail("nobody@example.com")
+		check("Users.GetByEmail", err)
+		_, err = Groups.Get(bogus)
+		check("Groups.Get", err)
+		_, err = AccessContexts.Get(bogus)
+		check("AccessContexts.Get", err)
+		_, err = Mailboxes.GetMessage(bogus)
+		check("Mailboxes.GetMessage", err)
+		_, err = Nodes.Get(bogus)
+		check("Nodes.Get", err)
+		_, err = Nodes.GetByState(dtID1, bogus)
+		check("Nodes.GetByState", err)
+		_, err = Workflows.NodeForState(dtID1, bogus)
+		check("Workflows.NodeForState", err)
+		_, err = Workflows.Get(bogus)
+		check("Workflows.Get", err)
+		_, err = Workflows.GetByDocType(bogus)
+		check("Workflows.GetByDocType", err)
+		_, err = Workflows.GetByName("No Such Workflow")
+		check("Workflows.GetByName", err)
+		_, err = Workflows.GetNode(bogus)
+		check("Workflows.GetNode", err)
+		_, err = DocEvents.Get(bogus)
+		check("DocEvents.Get", err)
+		_, err = Documents.Get(nil, dtID1, bogus)
+		check("Documents.Get", err)
+	})
+}
+
+// Entity update operations.
+func TestFlowUpdate(t *testing.T) {
 	gt = t
+	var res interface{}
 
-	t.Run("DocTypes", func(t *testing.T) {
+	t.Run("DocTypeRename", func(t *testing.T) {
 		tx := fatal1(db.Begin()).(*sql.Tx)
 		defer tx.Rollback()
 
-		dtID1 = fatal1(DocTypes.New(tx, "Stor Request")).(DocTypeID)
-		dtID2 = fatal1(DocTypes.New(tx, "Compute Request")).(DocTypeID)
+		if err := error0(DocTypes.Rename(tx, dtID1, "Storage Request")); err != nil {
+			return
+		}
 
 		fatal0(tx.Commit())
+
+		if res = error1(DocTypes.Get(dtID1)); res == nil {
+			return
+		}
+		obj := res.(*DocType)
+		assertEqual("Storage Request", obj.Name)
 	})
 
-	t.Run("DocStates", func(t *testing.T) {
+	t.Run("DocStateRename", func(t *testing.T) {
 		tx := fatal1(db.Begin()).(*sql.Tx)
 		defer tx.Rollback()
 
-		dsID1 = fatal1(DocStates.New(tx, "Initial")).(DocStateID)
-		dsID2 = fatal1(DocStates.New(tx, "Pending Approval")).(DocStateID)
-		dsID3 = fatal1(DocStates.New(tx, "Approved")).(DocStateID)
-		dsID4 = fatal1(DocStates.New(tx, "Rejected")).(DocStateID)
-		dsID5 = fatal1(DocStates.New(tx, "Discarded")).(DocStateID)
+		if err := error0(DocStates.Rename(tx, dsID1, "Draft")); err != nil {
+			return
+		}
 
 		fatal0(tx.Commit())
+
+		if res = error1(DocStates.Get(dsID1)); res == nil {
+			return
+		}
+		obj := res.(*DocState)
+		assertEqual("Draft", obj.Name)
 	})
 
-	t.Run("DocActions", func(t *testing.T) {
+	t.Run("DocStatesHierarchy", func(t *testing.T) {
 		tx := fatal1(db.Begin()).(*sql.Tx)
 		defer tx.Rollback()
 
-		daID1 = fatal1(DocActions.New(tx, "Initialise", false)).(DocActionID)
-		daID2 = fatal1(DocActions.New(tx, "New", false)).(DocActionID)
-		daID3 = fatal1(DocActions.New(tx, "Get", false)).(DocActionID)
-		daID4 = fatal1(DocActions.New(tx, "Update", true)).(DocActionID)
-		daID5 = fatal1(DocActions.New(tx, "Delete", true)).(DocActionID)
-		daID6 = fatal1(DocActions.New(tx, "Approve", false)).(DocActionID)
-		daID7 = fatal1(DocActions.New(tx, "Reject", false)).(DocActionID)
-		daID8 = fatal1(DocActions.New(tx, "Return", false)).(DocActionID)
-		daID9 = fatal1(DocActions.New(tx, "Discard", true)).(DocActionID)
-
+		review := fatal1(DocStates.New(tx, "In Review")).(DocStateID)
+		legal := fatal1(DocStates.NewWithParent(tx, "Legal Review", review)).(DocStateID)
+		clause := fatal1(DocStates.NewWithParent(tx, "Clause Review", legal)).(DocStateID)
 		fatal0(tx.Commit())
+
+		kids := fatal1(DocStates.Children(review)).([]*DocState)
+		assertEqual(1, len(kids))
+		assertEqual(legal, kids[0].ID)
+		assertEqual(legal, fatal1(DocStates.Parent(clause)).(DocStateID))
+		assertEqual(DocStateID(0), fatal1(DocStates.Parent(review)).(DocStateID))
+
+		assertEqual(true, fatal1(DocStates.IsDescendant(clause, review)).(bool))
+		assertEqual(false, fatal1(DocStates.IsDescendant(review, clause)).(bool))
+		assertEqual(false, fatal1(DocStates.IsDescendant(review, review)).(bool))
+
+		assertEqual(ErrDocStateCycle, DocStates.SetParent(nil, review, clause))
+		assertEqual(ErrDocStateCycle, DocStates.SetParent(nil, review, review))
+
+		fatal0(DocStates.SetParent(nil, clause, 0))
+		assertEqual(false, fatal1(DocStates.IsDescendant(clause, review)).(bool))
 	})
 
-	t.Run("Workflows", func(t *testing.T) {
+	t.Run("DocActionRename", func(t *testing.T) {
 		tx := fatal1(db.Begin()).(*sql.Tx)
 		defer tx.Rollback()
 
-		wfID1 = fatal1(Workflows.New(tx, "Storage Management", dtID1, dsID1)).(WorkflowID)
-		wfID2 = error1(Workflows.New(tx, "Compute Management", dtID2, dsID1)).(WorkflowID)
+		if res = error0(DocActions.Rename(tx, daID1, "List")); res != nil {
+			return
+		}
 
 		fatal0(tx.Commit())
+
+		if res = error1(DocActions.Get(daID1)); res == 0 {
+			return
+		}
+		obj := res.(*DocAction)
+		assertEqual("List", obj.Name)
 	})
 
-	t.Run("Users", func(t *testing.T) {
+	t.Run("WorkflowsRename", func(t *testing.T) {
 		tx := fatal1(db.Begin()).(*sql.Tx)
 		defer tx.Rollback()
 
-		res, err := tx.Exec(`INSERT INTO users_master(first_name, last_name, email, active)
-			VALUES('FN 1', 'LN 1', 'email1@example.com', 1)`)
-		if err != nil {
-			t.Fatalf("%v\n", err)
+		err := Workflows.Rename(tx, wfID2, " Storage Management ")
+		assertEqual(ErrWorkflowNameExists, err)
+		fatal0(tx.Rollback())
+
+		if err = error0(Workflows.Rename(nil, wfID2, "  Compute Provisioning ")); err != nil {
+			return
 		}
-		uid, _ := res.LastInsertId()
-		uID1 = UserID(uid)
-		gID1 = fatal1(Groups.NewSingleton(tx, uID1)).(GroupID)
+		if res = error1(Workflows.Get(wfID2)); res == nil {
+			return
+		}
+		assertEqual("Compute Provisioning", res.(*Workflow).Name)
+	})
 
-		res, err = tx.Exec(`INSERT INTO users_master(first_name, last_name, email, active)
-			VALUES('FN 2', 'LN 2', 'email2@example.com', 1)`)
-		if err != nil {
-			t.Fatalf("%v\n", err)
+	t.Run("WorkflowsArchive", func(t *testing.T) {
+		if err := error0(Workflows.Archive(nil, wfID2)); err != nil {
+			return
 		}
-		uid, _ = res.LastInsertId()
-		uID2 = UserID(uid)
-		gID2 = fatal1(Groups.NewSingleton(tx, uID2)).(GroupID)
 
-		res, err = tx.Exec(`INSERT INTO users_master(first_name, last_name, email, active)
-			VALUES('FN 3', 'LN 3', 'email3@example.com', 1)`)
-		if err != nil {
-			t.Errorf("%v\n", err)
+		if res = error1(Workflows.ListActive(0, 0)); res == nil {
+			return
 		}
-		uid, _ = res.LastInsertId()
-		uID3 = UserID(uid)
-		gID3 = fatal1(Groups.NewSingleton(tx, uID3)).(GroupID)
+		wfs := res.([]*Workflow)
+		assertEqual(1, len(wfs), "archived workflows should not be listed")
 
-		res, err = tx.Exec(`INSERT INTO users_master(first_name, last_name, email, active)
-			VALUES('FN 4', 'LN 4', 'email4@example.com', 1)`)
-		if err != nil {
-			t.Fatalf("%v\n", err)
+		if res = error1(Workflows.Get(wfID2)); res == nil {
+			return
 		}
-		uid, _ = res.LastInsertId()
-		uID4 = UserID(uid)
-		gID4 = fatal1(Groups.NewSingleton(tx, uID4)).(GroupID)
+		wf := res.(*Workflow)
+		assertEqual(false, wf.Active)
 
-		fatal0(tx.Commit())
+		_, err := wf.ApplyEvent(nil, &DocEvent{DocType: dtID2}, nil)
+		assertEqual(ErrWorkflowInactive, err)
+
+		if err = error0(Workflows.Unarchive(nil, wfID2)); err != nil {
+			return
+		}
+		if res = error1(Workflows.ListActive(0, 0)); res == nil {
+			return
+		}
+		assertEqual(2, len(res.([]*Workflow)))
 	})
 
-	t.Run("Groups", func(t *testing.T) {
+	t.Run("WorkflowsApplyEventNoNotify", func(t *testing.T) {
 		tx := fatal1(db.Begin()).(*sql.Tx)
 		defer tx.Rollback()
 
-		gID5 = fatal1(Groups.New(tx, "Analysts", "G")).(GroupID)
-		gID6 = fatal1(Groups.New(tx, "Managers", "G")).(GroupID)
-
+		fatal0(DocTypes.AddTransition(tx, dtID1, dsID1, daID2, dsID2))
+		fatal1(Workflows.AddNode(tx, dtID1, dsID2, 0, wfID1, "Storage Review", NodeTypeLinear))
 		fatal0(tx.Commit())
+
+		eid := fatal1(DocEvents.New(nil, &DocEventsNewInput{
+			DocTypeID:   dtID1,
+			DocumentID:  docID1,
+			DocStateID:  dsID1,
+			DocActionID: daID2,
+			GroupID:     gID1,
+			Text:        "Submitting for review.",
+		})).(DocEventID)
+		ev := fatal1(DocEvents.Get(eid)).(*DocEvent)
+		wf := fatal1(Workflows.Get(wfID1)).(*Workflow)
+
+		if res = error1(wf.ApplyEventNoNotify(nil, ev)); res == nil {
+			return
+		}
+		assertEqual(dsID2, res.(DocStateID))
+
+		doc := fatal1(Documents.Get(nil, dtID1, docID1)).(*Document)
+		assertEqual(dsID2, doc.State.ID, "the document should have transitioned")
+
+		d1 := fatal1(Workflows.TimeInState(dtID1, docID1, dsID1)).(time.Duration)
+		assertEqual(true, d1 >= 0, "the begin state should have been left")
+		d2 := fatal1(Workflows.TimeInState(dtID1, docID1, dsID2)).(time.Duration)
+		time.Sleep(10 * time.Millisecond)
+		d3 := fatal1(Workflows.TimeInState(dtID1, docID1, dsID2)).(time.Duration)
+		assertEqual(true, d3 > d2, "the current state should keep accruing time")
+		d4 := fatal1(Workflows.TimeInState(dtID1, docID1, dsID1)).(time.Duration)
+		assertEqual(d1, d4, "a state that was left should no longer accrue time")
+
+		var n int64
+		fatal0(db.QueryRow(`SELECT COUNT(*) FROM wf_messages WHERE doc_id = ?`, docID1).Scan(&n))
+		assertEqual(int64(0), n, "no message should have been posted")
+
+		if res = error1(Workflows.AuditTrail(dtID1, docID1)); res == nil {
+			return
+		}
+		trail := res.([]*AuditEntry)
+		assertEqual(1, len(trail))
+		if len(trail) == 1 {
+			assertEqual(eid, trail[0].Event)
+			assertEqual(dsID1, trail[0].From)
+			assertEqual(dsID2, trail[0].To)
+			assertEqual(gID1, trail[0].Actor)
+		}
 	})
 
-	t.Run("GroupsAddUsers", func(t *testing.T) {
-		tx := fatal1(db.Begin()).(*sql.Tx)
-		defer tx.Rollback()
+	t.Run("WorkflowsReplay", func(t *testing.T) {
+		ds := fatal1(Workflows.Replay(dtID1, docID1)).(DocStateID)
+		assertEqual(fatal1(Documents.CurrentState(nil, dtID1, docID1)).(DocStateID), ds, "the event log should agree with the stored state")
+	})
 
-		fatal0(Groups.AddUser(tx, gID5, uID1))
-		fatal0(Groups.AddUser(tx, gID5, uID2))
-		fatal0(Groups.AddUser(tx, gID5, uID3))
+	t.Run("DocTypesDuplicateTransition", func(t *testing.T) {
+		err := DocTypes.AddTransition(nil, dtID1, dsID1, daID2, dsID2)
+		e, ok := err.(*ErrDuplicateTransition)
+		assertEqual(true, ok, fmt.Sprintf("expected *ErrDuplicateTransition, observed : %v", err))
+		if ok {
+			assertEqual(dsID1, e.State)
+			assertEqual(daID2, e.Action)
+		}
 
-		fatal0(Groups.AddUser(tx, gID6, uID2))
-		fatal0(Groups.AddUser(tx, gID6, uID3))
-		fatal0(Groups.AddUser(tx, gID6, uID4))
+		err = DocTypes.AddTransition(nil, dtID1, dsID1, daID2, dsID3)
+		e, ok = err.(*ErrDuplicateTransition)
+		assertEqual(true, ok, fmt.Sprintf("expected *ErrDuplicateTransition, observed : %v", err))
+		if ok {
+			assertEqual(dsID3, e.To)
+			assertEqual(dsID2, e.Existing, "the conflicting target should be named")
+		}
 
-		fatal0(tx.Commit())
+		tx := fatal1(db.Begin()).(*sql.Tx)
+		defer tx.Rollback()
+		DocTypes.RegisterGuard(dtID1, dsID1, daID2, dsID3, func(DocumentID) (bool, error) { return false, nil })
+		defer DocTypes.RegisterGuard(dtID1, dsID1, daID2, dsID3, nil)
+		fatal0(DocTypes.AddTransition(tx, dtID1, dsID1, daID2, dsID3))
 	})
 
-	t.Run("Roles", func(t *testing.T) {
+	t.Run("DocumentsConcurrentModification", func(t *testing.T) {
+		ctx := context.Background()
 		tx := fatal1(db.Begin()).(*sql.Tx)
 		defer tx.Rollback()
 
-		roleID1 = fatal1(Roles.New(tx, "Research Analyst")).(RoleID)
-		roleID2 = fatal1(Roles.New(tx, "Manager")).(RoleID)
+		doc := fatal1(Documents.Get(tx, dtID1, docID1)).(*Document)
+		err := Documents.setState(ctx, tx, dtID1, docID1, doc.State.ID, 0, doc.Version-1)
+		assertEqual(ErrConcurrentModification, err, "a stale version should be rejected")
 
-		fatal0(tx.Commit())
+		if err = error0(Documents.setState(ctx, tx, dtID1, docID1, doc.State.ID, 0, doc.Version)); err != nil {
+			return
+		}
+		doc2 := fatal1(Documents.Get(tx, dtID1, docID1)).(*Document)
+		assertEqual(doc.Version+1, doc2.Version, "a transition should increment the version")
+	})
+
+	t.Run("DocumentsLock", func(t *testing.T) {
+		ctx := context.Background()
+		tx1 := fatal1(db.Begin()).(*sql.Tx)
+		defer tx1.Rollback()
+		fatal0(Documents.lock(ctx, tx1, dtID1, docID1))
+
+		locked := make(chan time.Time)
+		go func() {
+			tx2, err := db.Begin()
+			if err != nil {
+				locked <- time.Time{}
+				return
+			}
+			defer tx2.Rollback()
+			if err = Documents.lock(ctx, tx2, dtID1, docID1); err != nil {
+				locked <- time.Time{}
+				return
+			}
+			locked <- time.Now()
+		}()
+
+		time.Sleep(50 * time.Millisecond)
+		released := time.Now()
+		tx1.Rollback()
+		at := <-locked
+		assertEqual(true, at.After(released), "the second transaction should wait for the first")
+	})
+
+	t.Run("WorkflowsTransitions", func(t *testing.T) {
+		var n int
+		fatal0(db.QueryRow(`SELECT COUNT(*) FROM wf_docstate_transitions WHERE doctype_id = ?`, dtID1).Scan(&n))
+
+		if res = error1(Workflows.Transitions(dtID1)); res == nil {
+			return
+		}
+		ts := res.([]Transition)
+		assertEqual(n, len(ts), "all transitions of the document type should be answered")
+		for i := 1; i < len(ts); i++ {
+			prev, curr := ts[i-1], ts[i]
+			ordered := prev.From.ID < curr.From.ID || (prev.From.ID == curr.From.ID && prev.Upon.ID <= curr.Upon.ID)
+			assertEqual(true, ordered, "transitions should be ordered by state, and then action")
+		}
 	})
 
-	t.Run("RolesAddPermissions", func(t *testing.T) {
-		tx := fatal1(db.Begin()).(*sql.Tx)
-		defer tx.Rollback()
+	t.Run("WorkflowsUpdateNodeTransitions", func(t *testing.T) {
+		n := fatal1(Nodes.GetByState(dtID1, dsID2)).(*Node)
 
-		fatal0(Roles.AddPermissions(tx, roleID1, dtID1, []DocActionID{daID1, daID2, daID3, daID4, daID8, daID9}))
-		fatal0(Roles.AddPermissions(tx, roleID2, dtID1, []DocActionID{daID1, daID2, daID3, daID4, daID5, daID6, daID7, daID8, daID9}))
+		fatal0(Workflows.UpdateNodeTransitions(nil, n.ID, map[DocActionID]DocStateID{
+			daID3: dsID3, daID4: dsID4, daID5: dsID5,
+		}))
+		fatal0(Workflows.UpdateNodeTransitions(nil, n.ID, map[DocActionID]DocStateID{
+			daID3: dsID4, daID6: dsID5, daID7: dsID3,
+		}))
 
-		fatal0(tx.Commit())
+		if res = error1(n.Transitions()); res == nil {
+			return
+		}
+		ts := res.(map[DocActionID]DocStateID)
+		assertEqual(3, len(ts))
+		assertEqual(dsID4, ts[daID3])
+		assertEqual(dsID5, ts[daID6])
+		assertEqual(dsID3, ts[daID7])
+
+		err := Workflows.UpdateNodeTransitions(nil, n.ID, map[DocActionID]DocStateID{daID3: DocStateID(999999)})
+		assertNotEqual(nil, err, "unknown target states should be rejected")
+		ts = fatal1(n.Transitions()).(map[DocActionID]DocStateID)
+		assertEqual(3, len(ts), "a failed update should leave the transitions unaltered")
 	})
-}
 
-// Entity listing.
-func TestFlowList(t *testing.T) {
-	gt = t
-	var res interface{}
+	t.Run("WorkflowsRemoveNode", func(t *testing.T) {
+		n := fatal1(Nodes.GetByState(dtID1, dsID2)).(*Node)
+		err := Workflows.RemoveNode(nil, wfID1, n.ID)
+		e, ok := err.(*ErrNodeInUse)
+		assertEqual(true, ok, "a node in use should not be removed")
+		if ok {
+			assertEqual(int64(1), e.Documents)
+			assertEqual(1, len(e.Sources))
+		}
 
-	t.Run("DocTypes", func(t *testing.T) {
-		var dts []*DocType
-		if res = error1(DocTypes.List(0, 0)); res == nil {
+		ns := fatal1(Workflows.AddNodes(nil, wfID2, []NodeSpec{
+			{DocType: dtID2, State: dsID4, Name: "Compute Scratch", NodeType: NodeTypeLinear,
+				Transitions: map[DocActionID]DocStateID{daID1: dsID5}},
+		})).([]NodeID)
+		if err = error0(Workflows.RemoveNode(nil, wfID2, ns[0])); err != nil {
 			return
 		}
-		dts = res.([]*DocType)
-		assertEqual(2, len(dts))
+		_, err = Workflows.GetNode(ns[0])
+		assertNotEqual(nil, err, "the node should have been removed")
+		ts := fatal1(DocTypes.Transitions(dtID2, dsID4)).(map[DocStateID]*TransitionMap)
+		assertEqual(0, len(ts), "the node's transitions should have been removed")
 	})
 
-	t.Run("DocStates", func(t *testing.T) {
-		var dss []*DocState
-		if res = error1(DocStates.List(0, 0)); res == nil {
+	t.Run("WorkflowsFork", func(t *testing.T) {
+		fatal1(Workflows.AddNodes(nil, wfID2, []NodeSpec{
+			{DocType: dtID2, State: dsID1, Name: "Compute Intake", NodeType: NodeTypeFork,
+				Transitions: map[DocActionID]DocStateID{daID1: dsID2}},
+			{DocType: dtID2, State: dsID2, Name: "Compute Legal", NodeType: NodeTypeLinear,
+				Transitions: map[DocActionID]DocStateID{daID2: dsID4}},
+			{DocType: dtID2, State: dsID3, Name: "Compute Finance", NodeType: NodeTypeLinear},
+			{DocType: dtID2, State: dsID4, Name: "Compute Legal Done", NodeType: NodeTypeLinear},
+		}))
+		fatal0(DocTypes.AddTransition(nil, dtID2, dsID1, daID1, dsID3))
+
+		docID2 = fatal1(Documents.New(nil, &DocumentsNewInput{
+			DocTypeID:       dtID2,
+			AccessContextID: acID1,
+			GroupID:         gID1,
+			Title:           "Cluster for nightly builds",
+			Data:            "Please provision 16 cores.",
+		})).(DocumentID)
+		did := docID2
+		wf := fatal1(Workflows.Get(wfID2)).(*Workflow)
+
+		apply := func(state DocStateID, action DocActionID) (DocStateID, error) {
+			eid := fatal1(DocEvents.New(nil, &DocEventsNewInput{
+				DocTypeID:   dtID2,
+				DocumentID:  did,
+				DocStateID:  state,
+				DocActionID: action,
+				GroupID:     gID1,
+				Text:        "Moving along.",
+			})).(DocEventID)
+			ev := fatal1(DocEvents.Get(eid)).(*DocEvent)
+			return wf.ApplyEventNoNotify(nil, ev)
+		}
+
+		if res = error1(apply(dsID1, daID1)); res == nil {
 			return
 		}
-		dss = res.([]*DocState)
-		// There is a pre-defined reserved state for children.
-		assertEqual(6, len(dss))
-	})
+		assertEqual(dsID1, res.(DocStateID), "the document should remain in the fork's state")
+		as := fatal1(Documents.ActiveStates(dtID2, did)).([]DocStateID)
+		assertEqual("[2 3]", fmt.Sprint(relStates(as)), "both branches should be active")
 
-	t.Run("DocActions", func(t *testing.T) {
-		var das []*DocAction
-		if res = error1(DocActions.List(0, 0)); res == nil {
+		if res = error1(apply(dsID2, daID2)); res == nil {
 			return
 		}
-		das = res.([]*DocAction)
-		assertEqual(9, len(das))
+		assertEqual(dsID4, res.(DocStateID))
+		as = fatal1(Documents.ActiveStates(dtID2, did)).([]DocStateID)
+		assertEqual("[3 4]", fmt.Sprint(relStates(as)), "only the legal branch should have moved")
+
+		finance := fatal1(Nodes.GetByState(dtID2, dsID3)).(*Node)
+		err := Workflows.RemoveNode(nil, wfID2, finance.ID)
+		e, ok := err.(*ErrNodeInUse)
+		assertEqual(true, ok, "a node with an active branch should not be removed")
+		if ok {
+			assertEqual(int64(1), e.Branches)
+		}
+
+		_, err = apply(dsID1, daID1)
+		assertEqual(ErrDocEventStateMismatch, err, "a forked document should not fork again")
 	})
 
-	t.Run("Workflows", func(t *testing.T) {
-		if res = error1(Workflows.List(0, 0)); res == nil {
+	t.Run("WorkflowsJoin", func(t *testing.T) {
+		fatal1(Workflows.AddNodes(nil, wfID2, []NodeSpec{
+			{DocType: dtID2, State: dsID5, Name: "Compute Done", NodeType: NodeTypeJoin},
+		}))
+		fatal0(DocTypes.AddTransition(nil, dtID2, dsID3, daID3, dsID5))
+		fatal0(DocTypes.AddTransition(nil, dtID2, dsID4, daID3, dsID5))
+
+		did := docID2
+		wf := fatal1(Workflows.Get(wfID2)).(*Workflow)
+
+		apply := func(state DocStateID) (DocStateID, error) {
+			eid := fatal1(DocEvents.New(nil, &DocEventsNewInput{
+				DocTypeID:   dtID2,
+				DocumentID:  did,
+				DocStateID:  state,
+				DocActionID: daID3,
+				GroupID:     gID1,
+				Text:        "Branch complete.",
+			})).(DocEventID)
+			ev := fatal1(DocEvents.Get(eid)).(*DocEvent)
+			return wf.ApplyEventNoNotify(nil, ev)
+		}
+
+		if res = error1(apply(dsID4)); res == nil {
 			return
 		}
-		wfs := res.([]*Workflow)
-		assertEqual(2, len(wfs))
+		assertEqual(DocStateJoinWaiting, res.(DocStateID), "the join should wait for the finance branch")
+		as := fatal1(Documents.ActiveStates(dtID2, did)).([]DocStateID)
+		assertEqual("[3]", fmt.Sprint(relStates(as)), "only the finance branch should remain")
+
+		if res = error1(apply(dsID3)); res == nil {
+			return
+		}
+		assertEqual(dsID5, res.(DocStateID), "the last branch should advance the join")
+		as = fatal1(Documents.ActiveStates(dtID2, did)).([]DocStateID)
+		assertEqual(0, len(as))
+		doc := fatal1(Documents.Get(nil, dtID2, did)).(*Document)
+		assertEqual(dsID5, doc.State.ID, "the document should be in the join's state")
 	})
 
-	t.Run("Users", func(t *testing.T) {
-		if res = error1(Users.List("", 0, 0)); res == nil {
+	t.Run("WorkflowsProcessTimeouts", func(t *testing.T) {
+		n := fatal1(Nodes.GetByState(dtID2, dsID5)).(*Node)
+		fatal0(DocTypes.AddTransition(nil, dtID2, dsID5, daID4, dsID4))
+
+		err := Workflows.AddTimeout(nil, n.ID, time.Hour, daID5)
+		assertNotEqual(nil, err, "an action without a transition should be rejected")
+		if err = error0(Workflows.AddTimeout(nil, n.ID, time.Hour, daID4)); err != nil {
 			return
 		}
-		us := res.([]*User)
-		assertEqual(4, len(us))
 
-		if res = error1(Users.List("LN 4", 0, 0)); res == nil {
+		if res = error1(Workflows.ProcessTimeouts(time.Now())); res == nil {
 			return
 		}
-		us = res.([]*User)
-		assertEqual(1, len(us))
-	})
+		assertEqual(0, res.(int), "the timeout should not have elapsed yet")
+
+		_, _, err = Workflows.ProcessTimeoutsBatch(time.Now(), 0)
+		assertNotEqual(nil, err, "a batch size of zero should be rejected")
+		cctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		count, _, err := Workflows.ProcessTimeoutsBatchContext(cctx, time.Now().Add(2*time.Hour), 10)
+		assertEqual(context.Canceled, err)
+		assertEqual(0, count, "nothing should be escalated after cancellation")
+
+		// A document whose escalation fails should not hold up others.
+		other := fatal1(Documents.New(nil, &DocumentsNewInput{
+			DocTypeID:       dtID2,
+			AccessContextID: acID1,
+			GroupID:         gID1,
+			Title:           "Cluster for load tests",
+			Data:            "Please provision 8 cores.",
+		})).(DocumentID)
+		tbl := DocTypes.docStorName(dtID2)
+		fatal1(db.Exec(rebind(`UPDATE `+tbl+` SET docstate_id = ? WHERE id = ?`), dsID5, other))
+		DocTypes.RegisterGuard(dtID2, dsID5, daID4, dsID4, func(doc DocumentID) (bool, error) {
+			if doc == docID2 {
+				return false, ErrUnknown
+			}
+			return true, nil
+		})
+		count, more, err := Workflows.ProcessTimeoutsBatch(time.Now().Add(2*time.Hour), 1)
+		DocTypes.RegisterGuard(dtID2, dsID5, daID4, dsID4, nil)
+		e, ok := err.(*ErrEscalationFailed)
+		assertEqual(true, ok, fmt.Sprintf("expected *ErrEscalationFailed, observed : %v", err))
+		if ok {
+			assertEqual(1, len(e.Failures))
+			assertEqual(docID2, e.Failures[0].Doc)
+			assertEqual(true, errors.Is(err, ErrUnknown), "the cause should be reachable")
+		}
+		assertEqual(1, count, "the other document should have been escalated")
+		assertEqual(false, more, "the failed document should not be reported as remaining")
+		assertEqual(dsID4, fatal1(Documents.Get(nil, dtID2, other)).(*Document).State.ID)
 
-	t.Run("Groups", func(t *testing.T) {
-		var gs []*Group
-		if res = error1(Groups.List(0, 0)); res == nil {
+		if res = error1(Workflows.ProcessTimeouts(time.Now().Add(2 * time.Hour))); res == nil {
 			return
 		}
-		gs = res.([]*Group)
-		assertEqual(6, len(gs))
+		assertEqual(1, res.(int))
+		doc := fatal1(Documents.Get(nil, dtID2, docID2)).(*Document)
+		assertEqual(dsID4, doc.State.ID, "the document should have been escalated")
 	})
 
-	t.Run("Roles", func(t *testing.T) {
-		var rs []*Role
-		if res = error1(Roles.List(0, 0)); res == nil {
+	t.Run("WorkflowsJoinConcurrent", func(t *testing.T) {
+		did := fatal1(Documents.New(nil, &DocumentsNewInput{
+			DocTypeID:       dtID2,
+			AccessContextID: acID1,
+			GroupID:         gID1,
+			Title:           "Cluster for parallel review",
+			Data:            "Please provision 4 cores.",
+		})).(DocumentID)
+		wf := fatal1(Workflows.Get(wfID2)).(*Workflow)
+
+		event := func(state DocStateID, action DocActionID) *DocEvent {
+			eid := fatal1(DocEvents.New(nil, &DocEventsNewInput{
+				DocTypeID:   dtID2,
+				DocumentID:  did,
+				DocStateID:  state,
+				DocActionID: action,
+				GroupID:     gID1,
+				Text:        "Moving along.",
+			})).(DocEventID)
+			return fatal1(DocEvents.Get(eid)).(*DocEvent)
+		}
+		fatal1(wf.ApplyEventNoNotify(nil, event(dsID1, daID1)))
+		fatal1(wf.ApplyEventNoNotify(nil, event(dsID2, daID2)))
+		legal, finance := event(dsID4, daID3), event(dsID3, daID3)
+
+		// Both transactions observe the two branches as pending before
+		// either of them joins.
+		txA := fatal1(db.Begin()).(*sql.Tx)
+		defer txA.Rollback()
+		txB := fatal1(db.Begin()).(*sql.Tx)
+		defer txB.Rollback()
+		fatal1(Documents.activeStates(context.Background(), txB, dtID2, did))
+
+		if res = error1(wf.ApplyEventNoNotify(txA, legal)); res == nil {
 			return
 		}
-		rs = res.([]*Role)
-		// There are two pre-defined roles for administrators.
-		assertEqual(4, len(rs))
-	})
-}
+		assertEqual(DocStateJoinWaiting, res.(DocStateID), "the first branch should wait")
 
-// Retrieval of individual entities.
-func TestFlowGet(t *testing.T) {
-	gt = t
-	var res interface{}
+		type result struct {
+			state DocStateID
+			err   error
+		}
+		done := make(chan result, 1)
+		go func() {
+			state, err := wf.ApplyEventNoNotify(txB, finance)
+			if err == nil {
+				err = txB.Commit()
+			}
+			done <- result{state, err}
+		}()
+		time.Sleep(100 * time.Millisecond)
+		fatal0(txA.Commit())
+
+		r := <-done
+		if err := error0(r.err); err != nil {
+			return
+		}
+		assertEqual(dsID5, r.state, "the later branch should observe the earlier one as joined")
+		as := fatal1(Documents.ActiveStates(dtID2, did)).([]DocStateID)
+		assertEqual(0, len(as))
+		doc := fatal1(Documents.Get(nil, dtID2, did)).(*Document)
+		assertEqual(dsID5, doc.State.ID, "the document should be in the join's state")
+	})
 
-	t.Run("DocTypes", func(t *testing.T) {
-		var dt *DocType
-		if res = error1(DocTypes.GetByName("Compute Request")); res == nil {
+	t.Run("DocEventsCancel", func(t *testing.T) {
+		eid := fatal1(DocEvents.New(nil, &DocEventsNewInput{
+			DocTypeID:   dtID1,
+			DocumentID:  docID1,
+			DocStateID:  dsID2,
+			DocActionID: daID3,
+			GroupID:     gID1,
+			Text:        "Raised by mistake.",
+		})).(DocEventID)
+		ev := fatal1(DocEvents.Get(eid)).(*DocEvent)
+
+		if err := error0(DocEvents.Cancel(nil, eid)); err != nil {
 			return
 		}
-		dt = res.(*DocType)
-		assertEqual("Compute Request", dt.Name)
+		assertEqual(EventStatusCancelled, fatal1(DocEvents.Get(eid)).(*DocEvent).Status)
+
+		wf := fatal1(Workflows.Get(wfID1)).(*Workflow)
+		_, err := wf.ApplyEvent(nil, ev, nil)
+		assertEqual(ErrDocEventCancelled, err, "a cancelled event should not be applied")
+		assertEqual(ErrDocEventCancelled, DocEvents.Cancel(nil, eid))
+
+		doc := fatal1(Documents.Get(nil, dtID1, docID1)).(*Document)
+		assertEqual(dsID2, doc.State.ID, "the document should not have transitioned")
+
+		var n int64
+		q := `
+		SELECT COUNT(*)
+		FROM wf_messages msgs
+		JOIN wf_mailboxes mbs ON mbs.message_id = msgs.id
+		WHERE msgs.docevent_id = ?
+		`
+		fatal0(db.QueryRow(rebind(q), eid).Scan(&n))
+		assertEqual(int64(0), n, "no mailbox should hold a message about a cancelled event")
+	})
 
-		var dt2 *DocType
-		if res = error1(DocTypes.Get(dt.ID)); res == nil {
+	t.Run("DocumentsListByState", func(t *testing.T) {
+		if res = error1(Documents.ListByState(dtID1, dsID2, 0, 0)); res == nil {
 			return
 		}
-		dt2 = res.(*DocType)
-		assertEqual("Compute Request", dt2.Name)
+		ids := res.([]DocumentID)
+		assertEqual(1, len(ids))
+		if len(ids) == 1 {
+			assertEqual(docID1, ids[0])
+		}
+
+		ids = fatal1(Documents.ListByState(dtID1, dsID1, 0, 0)).([]DocumentID)
+		assertEqual(0, len(ids), "no document should remain in the begin state")
+
+		_, err := Documents.ListByState(dtID1, dsID2, -1, 0)
+		assertNotEqual(nil, err, "negative offsets should be rejected")
 	})
 
-	t.Run("DocStates", func(t *testing.T) {
-		var ds *DocState
-		if res = error1(DocStates.GetByName("Approved")); res == nil {
+	t.Run("WorkflowsBulkApply", func(t *testing.T) {
+		_, err := Workflows.BulkApply(nil, dtID1, dsID2, daID3, nil)
+		e, ok := err.(*ErrBulkApply)
+		assertEqual(true, ok, "a transition into a state without a node should fail")
+		if ok {
+			assertEqual(docID1, e.Doc)
+		}
+		doc := fatal1(Documents.Get(nil, dtID1, docID1)).(*Document)
+		assertEqual(dsID2, doc.State.ID, "a failed bulk transition should be rolled back")
+
+		if res = error1(Workflows.BulkApply(nil, dtID1, dsID5, daID3, nil)); res == nil {
 			return
 		}
-		ds = res.(*DocState)
-		assertEqual("Approved", ds.Name)
+		assertEqual(0, res.(int), "no document is in the state")
 
-		var ds2 *DocState
-		if res = error1(DocStates.Get(ds.ID)); res == nil {
+		if res = error1(Workflows.BulkApply(nil, dtID2, dsID4, daID3, nil)); res == nil {
 			return
 		}
-		ds2 = res.(*DocState)
-		assertEqual("Approved", ds2.Name)
+		assertEqual(2, res.(int), "both escalated documents should be migrated")
+		doc = fatal1(Documents.Get(nil, dtID2, docID2)).(*Document)
+		assertEqual(dsID5, doc.State.ID, "the document should have been migrated")
 	})
 
-	t.Run("DocActions", func(t *testing.T) {
-		var da *DocAction
-		if res = error1(DocActions.GetByName("Reject")); res == nil {
+	t.Run("MailboxesListForGroup", func(t *testing.T) {
+		eid := fatal1(DocEvents.New(nil, &DocEventsNewInput{
+			DocTypeID:   dtID2,
+			DocumentID:  docID2,
+			DocStateID:  dsID5,
+			DocActionID: daID4,
+			GroupID:     gID1,
+			Text:        "Reopening for legal.",
+		})).(DocEventID)
+		ev := fatal1(DocEvents.Get(eid)).(*DocEvent)
+		wf := fatal1(Workflows.Get(wfID2)).(*Workflow)
+		_, ids, err := wf.ApplyEventWithMessages(nil, ev, []GroupID{gID1})
+		if err = error0(err); err != nil {
 			return
 		}
-		da = res.(*DocAction)
-		assertEqual("Reject", da.Name)
+		assertEqual(1, len(ids), "one message should have been posted")
 
-		var da2 *DocAction
-		if res = error1(DocActions.Get(da.ID)); res == nil {
+		if res = error1(Mailboxes.ListForGroup(gID1, 0, 1)); res == nil {
 			return
 		}
-		da2 = res.(*DocAction)
-		assertEqual("Reject", da2.Name)
-	})
+		msgs := res.([]*Message)
+		assertEqual(1, len(msgs))
+		if len(msgs) == 1 {
+			assertEqual(eid, msgs[0].Event, "the newest message should be first")
+			assertEqual(docID2, msgs[0].DocID)
+			assertEqual(wfID2, msgs[0].Workflow)
+			assertEqual(daID4, msgs[0].Action)
+			assertEqual(false, msgs[0].Ctime.IsZero())
+			if len(ids) == 1 {
+				assertEqual(ids[0], msgs[0].ID, "the committed message should be answered")
+			}
+		}
 
-	t.Run("Workflows", func(t *testing.T) {
-		if res = error1(Workflows.GetByDocType(dtID1)); res == nil {
+		_, err = Mailboxes.ListForGroup(gID1, -1, 0)
+		assertNotEqual(nil, err, "negative offsets should be rejected")
+
+		f := MessageFilter{WorkflowID: wfID2, DocActionID: daID4, Unread: true}
+		if res = error1(Mailboxes.ListForGroupFiltered(gID1, f, 0, 0)); res == nil {
 			return
 		}
-		wf := res.(*Workflow)
-		assertEqual("Storage Management", wf.Name)
-		assertEqual(wfID1, wf.ID)
+		msgs = res.([]*Message)
+		assertEqual(true, len(msgs) > 0, "the new message should match")
+		for _, msg := range msgs {
+			assertEqual(wfID2, msg.Workflow)
+			assertEqual(daID4, msg.Action)
+		}
+		if len(msgs) > 0 && len(ids) == 1 {
+			assertEqual(ids[0], msgs[0].ID)
+		}
+		f = MessageFilter{WorkflowID: wfID2, DocActionID: daID4 + 1000}
+		assertEqual(0, len(fatal1(Mailboxes.ListForGroupFiltered(gID1, f, 0, 0)).([]*Message)))
+		_, err = Mailboxes.ListForGroupFiltered(gID1, MessageFilter{DocumentID: docID2}, 0, 0)
+		assertNotEqual(nil, err, "a document filter without a type should be rejected")
+
+		page := fatal1(Mailboxes.ListPageForGroup(gID1, 0, 1)).(*MessagePage)
+		assertEqual(1, len(page.Items))
+		assertEqual(fatal1(Mailboxes.CountByGroup(gID1, false)).(int64), page.Total)
 	})
 
-	t.Run("Groups", func(t *testing.T) {
-		var g *Group
-		if res = error1(Groups.Get(gID1)); res == nil {
+	t.Run("MailboxesMarkRead", func(t *testing.T) {
+		if res = error1(Mailboxes.UnreadCount(gID1)); res == nil {
 			return
 		}
-		g = res.(*Group)
+		n := res.(int64)
+		assertEqual(true, n > 0, "the posted message should be unread")
 
-		var u *User
-		if res = error1(Groups.SingletonUser(gID1)); res == nil {
+		msgs := fatal1(Mailboxes.ListForGroup(gID1, 0, 1)).([]*Message)
+		if err := error0(Mailboxes.MarkRead(nil, msgs[0].ID)); err != nil {
 			return
 		}
-		u = res.(*User)
+		assertEqual(n-1, fatal1(Mailboxes.UnreadCount(gID1)).(int64))
 
-		assertEqual(u.Email, g.Name, "singleton group name should match corresponding user's e-mail")
-
-		if res = error1(Groups.HasUser(gID6, uID4)); res == nil {
+		if err := error0(Mailboxes.MarkAllRead(nil, gID1)); err != nil {
 			return
 		}
-		ok := res.(bool)
-		assertEqual(true, ok)
+		assertEqual(int64(0), fatal1(Mailboxes.UnreadCount(gID1)).(int64), "the badge should be cleared")
 	})
 
-	t.Run("Roles", func(t *testing.T) {
-		var dt *DocType
-		if res = error1(DocTypes.Get(dtID1)); res == nil {
+	t.Run("WorkflowsApplyEventToUsers", func(t *testing.T) {
+		members := fatal1(Groups.Users(gID5)).([]*User)
+		users := make([]UserID, 0, len(members))
+		for _, u := range members {
+			users = append(users, u.ID)
+		}
+		assertEqual(3, len(users))
+
+		eid := fatal1(DocEvents.New(nil, &DocEventsNewInput{
+			DocTypeID:   dtID2,
+			DocumentID:  docID2,
+			DocStateID:  dsID4,
+			DocActionID: daID3,
+			GroupID:     gID1,
+			Text:        "Legal review complete.",
+		})).(DocEventID)
+		ev := fatal1(DocEvents.Get(eid)).(*DocEvent)
+		wf := fatal1(Workflows.Get(wfID2)).(*Workflow)
+		if res = error1(wf.ApplyEventToUsers(nil, ev, users)); res == nil {
 			return
 		}
-		dt = res.(*DocType)
+		assertEqual(dsID5, res.(DocStateID))
+
+		var n int64
+		fatal0(db.QueryRow(`
+		SELECT COUNT(*)
+		FROM wf_mailboxes mbs
+		JOIN wf_messages msgs ON msgs.id = mbs.message_id
+		WHERE msgs.docevent_id = ?
+		AND mbs.group_id IN (?, ?, ?)
+		`, eid, gID1, gID2, gID3).Scan(&n))
+		assertEqual(int64(3), n, "each member should have a mailbox entry")
+	})
 
-		if res = error1(Roles.Permissions(roleID1)); res == nil {
+	t.Run("MailboxesPurgeOlderThan", func(t *testing.T) {
+		unread := fatal1(Mailboxes.UnreadCount(gID2)).(int64)
+		assertEqual(true, unread > 0, "the member should have an unread message")
+
+		if res = error1(Mailboxes.PurgeOlderThan(nil, time.Now().Add(24*time.Hour), true)); res == nil {
 			return
 		}
-		perms := res.(map[string]struct {
-			DocTypeID DocTypeID
-			Actions   []*DocAction
+		assertEqual(true, res.(int64) > 0, "read entries should have been purged")
+		assertEqual(fatal1(Mailboxes.UnreadCount(gID1)).(int64), fatal1(Mailboxes.CountByGroup(gID1, false)).(int64),
+			"only unread entries should remain")
+		assertEqual(unread, fatal1(Mailboxes.UnreadCount(gID2)).(int64), "unread entries should survive")
+
+		_, err := Mailboxes.PurgeOlderThan(nil, time.Time{}, true)
+		assertNotEqual(nil, err, "a zero time should be rejected")
+	})
+
+	t.Run("DocTypesPostAction", func(t *testing.T) {
+		var called DocumentID
+		DocTypes.RegisterPostAction(dtID2, dsID5, daID4, dsID4, func(tx *sql.Tx, doc DocumentID) error {
+			called = doc
+			return errors.New("approver could not be recorded")
 		})
-		assertEqual(1, len(perms))
-		assertEqual(6, len(perms[dt.Name].Actions))
+		defer DocTypes.RegisterPostAction(dtID2, dsID5, daID4, dsID4, nil)
+
+		eid := fatal1(DocEvents.New(nil, &DocEventsNewInput{
+			DocTypeID:   dtID2,
+			DocumentID:  docID2,
+			DocStateID:  dsID5,
+			DocActionID: daID4,
+			GroupID:     gID1,
+			Text:        "Reopening, with an approver.",
+		})).(DocEventID)
+		ev := fatal1(DocEvents.Get(eid)).(*DocEvent)
+		wf := fatal1(Workflows.Get(wfID2)).(*Workflow)
+		_, err := wf.ApplyEvent(nil, ev, nil)
+		assertNotEqual(nil, err, "the failing post action should fail the transition")
+		assertEqual(docID2, called)
+
+		doc := fatal1(Documents.Get(nil, dtID2, docID2)).(*Document)
+		assertEqual(dsID5, doc.State.ID, "the transition should have been rolled back")
+		assertEqual(EventStatusPending, fatal1(DocEvents.Get(eid)).(*DocEvent).Status)
+		fatal0(DocEvents.Cancel(nil, eid))
+	})
 
-		if res = error1(Roles.HasPermission(roleID2, dtID1, daID6)); res == nil {
+	t.Run("DocumentsCurrentState", func(t *testing.T) {
+		if res = error1(Documents.CurrentState(nil, dtID2, docID2)); res == nil {
 			return
 		}
-		assertEqual(true, res.(bool))
+		assertEqual(dsID5, res.(DocStateID))
+		if res = error1(Documents.Workflow(nil, dtID2, docID2)); res == nil {
+			return
+		}
+		assertEqual(wfID2, res.(*Workflow).ID)
+
+		_, err := Documents.CurrentState(nil, dtID2, docID2+1000)
+		assertEqual(ErrNotFound, err)
+		_, err = Documents.Workflow(nil, dtID2, docID2+1000)
+		assertEqual(ErrNotFound, err)
 	})
-}
 
-// Entity update operations.
-func TestFlowUpdate(t *testing.T) {
-	gt = t
-	var res interface{}
+	t.Run("WorkflowsAvailableActions", func(t *testing.T) {
+		fatal0(DocTypes.AddTransition(nil, dtID2, dsID5, daID5, dsID3))
 
-	t.Run("DocTypeRename", func(t *testing.T) {
+		if res = error1(Workflows.AvailableActions(dtID2, docID2, 0)); res == nil {
+			return
+		}
+		assertEqual(fmt.Sprint([]DocActionID{daID4, daID5}), fmt.Sprint(res.([]DocActionID)),
+			"both outbound actions should be available")
+
+		_, err := Workflows.AvailableActions(dtID2, docID2+1000, 0)
+		assertEqual(ErrNotFound, err)
+	})
+
+	t.Run("WorkflowsClone", func(t *testing.T) {
+		// The clone is the latest version of the workflow of its
+		// document type; it is rolled back for the tests that follow.
 		tx := fatal1(db.Begin()).(*sql.Tx)
 		defer tx.Rollback()
 
-		if err := error0(DocTypes.Rename(tx, dtID1, "Storage Request")); err != nil {
+		if res = error1(Workflows.Clone(tx, wfID2, "Compute Variant")); res == nil {
 			return
 		}
+		cid := res.(WorkflowID)
 
-		fatal0(tx.Commit())
+		count := func(q string, wid WorkflowID) int {
+			var n int
+			fatal0(tx.QueryRow(rebind(q), wid).Scan(&n))
+			return n
+		}
+		nodes := `SELECT COUNT(*) FROM wf_workflow_nodes WHERE workflow_id = ?`
+		edges := `
+		SELECT COUNT(*)
+		FROM wf_docstate_transitions dst
+		JOIN wf_workflows wf ON wf.doctype_id = dst.doctype_id AND wf.version = dst.version
+		WHERE wf.id = ?
+		`
+		assertEqual(count(nodes, wfID2), count(nodes, cid), "all nodes should be copied")
+		assertEqual(count(edges, wfID2), count(edges, cid), "all transitions should be copied")
+
+		fatal0(DocTypes.AddTransition(tx, dtID2, dsID3, daID4, dsID4))
+		assertEqual(count(edges, wfID2)+1, count(edges, cid), "the source should be independent of its clone")
+		n := fatal1(Nodes.GetByState(dtID2, dsID5)).(*Node)
+		before := count(edges, cid)
+		fatal0(Workflows.UpdateNodeTransitions(tx, n.ID, map[DocActionID]DocStateID{daID4: dsID4}))
+		assertEqual(before, count(edges, cid), "the clone should be independent of its source")
+
+		_, err := Workflows.Clone(tx, wfID1, "Compute Variant")
+		assertEqual(ErrWorkflowNameExists, err, "names should be unique within a version")
+	})
 
-		if res = error1(DocTypes.Get(dtID1)); res == nil {
+	t.Run("WorkflowsSetBeginState", func(t *testing.T) {
+		dt := fatal1(DocTypes.New(nil, "Compute Request Draft")).(DocTypeID)
+		cid := fatal1(Workflows.New(nil, "Compute Draft", dt, dsID1)).(WorkflowID)
+		fatal1(Workflows.AddNode(nil, dt, dsID2, 0, cid, "Draft Review", NodeTypeLinear))
+		if err := error0(Workflows.SetBeginState(nil, cid, dsID2)); err != nil {
 			return
 		}
-		obj := res.(*DocType)
-		assertEqual("Storage Request", obj.Name)
-	})
+		assertEqual(dsID2, fatal1(Workflows.Get(cid)).(*Workflow).BeginState.ID,
+			"a workflow without documents should accept a new begin state")
 
-	t.Run("DocStateRename", func(t *testing.T) {
-		tx := fatal1(db.Begin()).(*sql.Tx)
-		defer tx.Rollback()
+		assertEqual(ErrWorkflowHasDocuments, Workflows.SetBeginState(nil, wfID2, dsID2),
+			"a workflow with documents should be left unaltered")
+		assertEqual(dsID1, fatal1(Workflows.Get(wfID2)).(*Workflow).BeginState.ID)
 
-		if err := error0(DocStates.Rename(tx, dsID1, "Draft")); err != nil {
+		ds := fatal1(DocStates.New(nil, "Withdrawn")).(DocStateID)
+		err := Workflows.SetBeginState(nil, cid, ds)
+		assertNotEqual(nil, err, "a state without a node should be rejected")
+		assertNotEqual(ErrWorkflowHasDocuments, err)
+	})
+
+	t.Run("WorkflowsRevert", func(t *testing.T) {
+		if res = error1(Workflows.Revert(nil, dtID2, docID2, nil)); res == nil {
 			return
 		}
+		assertEqual(dsID4, res.(DocStateID), "the latest transition should be undone")
+		if res = error1(Workflows.Revert(nil, dtID2, docID2, nil)); res == nil {
+			return
+		}
+		assertEqual(dsID5, res.(DocStateID), "the transition before that should be undone")
+		doc := fatal1(Documents.Get(nil, dtID2, docID2)).(*Document)
+		assertEqual(dsID5, doc.State.ID)
+	})
 
-		fatal0(tx.Commit())
+	t.Run("DocEventsRaise", func(t *testing.T) {
+		input := func(state DocStateID, action DocActionID, group GroupID) *DocEventsNewInput {
+			return &DocEventsNewInput{
+				DocTypeID:   dtID2,
+				DocumentID:  docID2,
+				DocStateID:  state,
+				DocActionID: action,
+				GroupID:     group,
+				Text:        "Reopening for review.",
+			}
+		}
 
-		if res = error1(DocStates.Get(dsID1)); res == nil {
+		_, err := DocEvents.Raise(nil, input(dsID3, daID4, gID1))
+		assertEqual(ErrDocEventStateMismatch, err, "a stale state should be refused")
+		da := fatal1(DocActions.New(nil, "Escalate Externally", false)).(DocActionID)
+		_, err = DocEvents.Raise(nil, input(dsID5, da, gID1))
+		_, ok := err.(*ErrNoTransition)
+		assertEqual(true, ok, "an action without a transition should be refused")
+		_, err = DocEvents.Raise(nil, input(dsID5, daID4, gID1+1000))
+		assertNotEqual(nil, err, "an unknown group should be refused")
+
+		if res = error1(DocEvents.Raise(nil, input(dsID5, daID4, gID1))); res == nil {
 			return
 		}
-		obj := res.(*DocState)
-		assertEqual("Draft", obj.Name)
+		ev := res.(*DocEvent)
+		assertEqual(EventStatusPending, ev.Status)
+		assertEqual(docID2, ev.DocID)
+		assertEqual(daID4, ev.Action)
+		assertEqual(false, ev.Ctime.IsZero())
+		fatal0(DocEvents.Cancel(nil, ev.ID))
 	})
 
-	t.Run("DocActionRename", func(t *testing.T) {
+	t.Run("DocEventsListForDocument", func(t *testing.T) {
+		list := func(statuses ...EventStatus) []*DocEvent {
+			return fatal1(DocEvents.ListForDocument(dtID2, docID2, statuses, 0, 0)).([]*DocEvent)
+		}
+		all := list()
+		assertEqual(true, len(all) > 0)
+		for i := 1; i < len(all); i++ {
+			assertEqual(false, all[i].Ctime.Before(all[i-1].Ctime), "events should be chronological")
+		}
+
+		cancelled := list(EventStatusCancelled)
+		assertEqual(true, len(cancelled) >= 2, "the refused and the raised events were cancelled")
+		for _, ev := range cancelled {
+			assertEqual(EventStatusCancelled, ev.Status)
+		}
+		assertEqual(len(all), len(list(EventStatusApplied, EventStatusPending))+len(cancelled))
+		assertEqual(len(all), len(list(EventStatusPending, EventStatusAll)))
+
+		_, err := DocEvents.ListForDocument(dtID2, docID2, []EventStatus{EventStatus(99)}, 0, 0)
+		assertNotEqual(nil, err, "unknown statuses should be rejected")
+	})
+
+	t.Run("DocEventsIdempotencyKey", func(t *testing.T) {
+		input := &DocEventsNewInput{
+			DocTypeID:      dtID2,
+			DocumentID:     docID2,
+			DocStateID:     dsID5,
+			DocActionID:    daID4,
+			GroupID:        gID1,
+			Text:           "Submitting for approval.",
+			IdempotencyKey: "req-7f3a9c",
+		}
+		eid := fatal1(DocEvents.New(nil, input)).(DocEventID)
+		assertEqual(eid, fatal1(DocEvents.New(nil, input)).(DocEventID), "a repeated key should answer the original event")
+
+		wf := fatal1(Workflows.Get(wfID2)).(*Workflow)
+		apply := func() (DocStateID, []MessageID) {
+			ev := fatal1(DocEvents.Get(eid)).(*DocEvent)
+			ds, mids, err := wf.ApplyEventWithMessages(nil, ev, nil)
+			fatal0(err)
+			return ds, mids
+		}
+		first, _ := apply()
+		assertEqual(dsID4, first)
+		again, mids := apply()
+		assertEqual(first, again, "a repeated application should answer the original result")
+		assertEqual(0, len(mids), "a repeated application should post no messages")
+		assertEqual(dsID4, fatal1(Documents.Get(nil, dtID2, docID2)).(*Document).State.ID)
+
+		ev := fatal1(DocEvents.Raise(nil, input)).(*DocEvent)
+		assertEqual(eid, ev.ID, "a raise with a repeated key should answer the original event")
+
+		conflict := *input
+		conflict.DocActionID = daID5
+		_, err := DocEvents.New(nil, &conflict)
+		assertEqual(ErrDocEventKeyConflict, err)
+
+		assertEqual(dsID5, fatal1(Workflows.Revert(nil, dtID2, docID2, nil)).(DocStateID))
+	})
+
+	t.Run("DocTypesRecipientResolver", func(t *testing.T) {
+		var seen *sql.Tx
+		DocTypes.RegisterRecipientResolver(dtID2, func(tx *sql.Tx, event *DocEvent) ([]GroupID, error) {
+			seen = tx
+			if event.State == dsID5 {
+				return []GroupID{gID3}, nil
+			}
+			return nil, nil
+		})
+		defer DocTypes.RegisterRecipientResolver(dtID2, nil)
+
+		eid := fatal1(DocEvents.New(nil, &DocEventsNewInput{
+			DocTypeID:   dtID2,
+			DocumentID:  docID2,
+			DocStateID:  dsID5,
+			DocActionID: daID4,
+			GroupID:     gID1,
+			Text:        "Routing to the department owner.",
+		})).(DocEventID)
+		ev := fatal1(DocEvents.Get(eid)).(*DocEvent)
+		wf := fatal1(Workflows.Get(wfID2)).(*Workflow)
+		_, mids, err := wf.ApplyEventWithMessages(nil, ev, nil)
+		fatal0(err)
+		assertNotEqual((*sql.Tx)(nil), seen, "the resolver should run in the transition's transaction")
+		assertEqual(true, len(mids) > 0)
+
+		found := false
+		for _, msg := range fatal1(Mailboxes.ListForGroup(gID3, 0, 0)).([]*Message) {
+			found = found || msg.ID == mids[len(mids)-1]
+		}
+		assertEqual(true, found, "the resolved group should be notified")
+
+		assertEqual(dsID5, fatal1(Workflows.Revert(nil, dtID2, docID2, nil)).(DocStateID))
+	})
+
+	t.Run("WorkflowsApplyEventToRoles", func(t *testing.T) {
+		doc := fatal1(Documents.Get(nil, dtID2, docID2)).(*Document)
+		rid := fatal1(Roles.New(nil, "Reviewer")).(RoleID)
+		fatal0(AccessContexts.AddGroupRole(nil, doc.AccCtx.ID, gID2, rid))
+		fatal0(AccessContexts.AddGroupRole(nil, doc.AccCtx.ID, gID4, rid))
+		defer func() {
+			fatal0(AccessContexts.RemoveGroupRole(nil, doc.AccCtx.ID, gID2, rid))
+			fatal0(AccessContexts.RemoveGroupRole(nil, doc.AccCtx.ID, gID4, rid))
+			fatal0(Roles.Delete(nil, rid))
+		}()
+
+		n2 := len(fatal1(Mailboxes.ListForGroup(gID2, 0, 0)).([]*Message))
+		n4 := len(fatal1(Mailboxes.ListForGroup(gID4, 0, 0)).([]*Message))
+
+		eid := fatal1(DocEvents.New(nil, &DocEventsNewInput{
+			DocTypeID:   dtID2,
+			DocumentID:  docID2,
+			DocStateID:  dsID5,
+			DocActionID: daID4,
+			GroupID:     gID1,
+			Text:        "For the reviewers.",
+		})).(DocEventID)
+		ev := fatal1(DocEvents.Get(eid)).(*DocEvent)
+		wf := fatal1(Workflows.Get(wfID2)).(*Workflow)
+		assertEqual(dsID4, fatal1(wf.ApplyEventToRoles(nil, ev, []GroupID{gID2}, []RoleID{rid})).(DocStateID))
+
+		assertEqual(n2+1, len(fatal1(Mailboxes.ListForGroup(gID2, 0, 0)).([]*Message)), "a group reached twice should be notified once")
+		assertEqual(n4+1, len(fatal1(Mailboxes.ListForGroup(gID4, 0, 0)).([]*Message)), "every group holding the role should be notified")
+
+		assertEqual(dsID5, fatal1(Workflows.Revert(nil, dtID2, docID2, nil)).(DocStateID))
+	})
+
+	t.Run("MailboxesDeliverPending", func(t *testing.T) {
+		eid := fatal1(DocEvents.New(nil, &DocEventsNewInput{
+			DocTypeID:   dtID2,
+			DocumentID:  docID2,
+			DocStateID:  dsID5,
+			DocActionID: daID4,
+			GroupID:     gID1,
+			Text:        "Delivered later.",
+		})).(DocEventID)
+		ev := fatal1(DocEvents.Get(eid)).(*DocEvent)
+		wf := fatal1(Workflows.Get(wfID2)).(*Workflow)
+
+		asyncMessages = true
+		_, mids, err := wf.ApplyEventWithMessages(nil, ev, []GroupID{gID3})
+		asyncMessages = false
+		fatal0(err)
+		assertEqual(true, len(mids) > 0)
+		mid := mids[len(mids)-1]
+
+		inMailbox := func() bool {
+			for _, msg := range fatal1(Mailboxes.ListForGroup(gID3, 0, 0)).([]*Message) {
+				if msg.ID == mid {
+					return true
+				}
+			}
+			return false
+		}
+		pending := func() int {
+			var n int
+			fatal0(db.QueryRow(`SELECT COUNT(*) FROM wf_message_outbox WHERE message_id = ?`, mid).Scan(&n))
+			return n
+		}
+
+		doc := fatal1(Documents.Get(nil, dtID2, docID2)).(*Document)
+		assertEqual(dsID4, doc.State.ID, "the transition should have committed")
+		assertEqual(true, pending() > 0, "the outbox should have committed with the transition")
+		assertEqual(false, inMailbox(), "delivery should await the worker")
+
+		cctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		_, _, err = Mailboxes.DeliverPendingContext(cctx, 10)
+		assertEqual(context.Canceled, err)
+		assertEqual(true, pending() > 0, "failed deliveries should remain in the outbox")
+		assertEqual(dsID4, fatal1(Documents.Get(nil, dtID2, docID2)).(*Document).State.ID)
+
+		for more := true; more; {
+			_, more, err = Mailboxes.DeliverPending(10)
+			fatal0(err)
+		}
+		assertEqual(0, pending())
+		assertEqual(true, inMailbox(), "the message should have been delivered")
+
+		assertEqual(dsID5, fatal1(Workflows.Revert(nil, dtID2, docID2, nil)).(DocStateID))
+	})
+
+	t.Run("DocTypesTransitionMetadata", func(t *testing.T) {
+		data := json.RawMessage(`{"color":"green","category":"review"}`)
+		fatal0(DocTypes.SetTransitionMetadata(nil, dtID2, dsID5, daID4, dsID4, data))
+		defer func() {
+			fatal0(DocTypes.SetTransitionMetadata(nil, dtID2, dsID5, daID4, dsID4, nil))
+			assertEqual(0, len(fatal1(DocTypes.GetTransitionMetadata(dtID2, dsID5, daID4, dsID4)).(json.RawMessage)))
+		}()
+
+		md := fatal1(DocTypes.GetTransitionMetadata(dtID2, dsID5, daID4, dsID4)).(json.RawMessage)
+		var attrs map[string]string
+		fatal0(json.Unmarshal(md, &attrs))
+		assertEqual("green", attrs["color"])
+		assertEqual("review", attrs["category"])
+
+		found := false
+		for _, tr := range fatal1(Workflows.Transitions(dtID2)).([]Transition) {
+			if tr.From.ID == dsID5 && tr.Upon.ID == daID4 && tr.To.ID == dsID4 {
+				found = true
+				assertEqual(string(md), string(tr.Metadata))
+			}
+		}
+		assertEqual(true, found, "the transition should be listed with its metadata")
+
 		tx := fatal1(db.Begin()).(*sql.Tx)
-		defer tx.Rollback()
+		cid := fatal1(Workflows.Clone(tx, wfID2, "Compute Annotated")).(WorkflowID)
+		var cmd string
+		q := `
+		SELECT dst.metadata
+		FROM wf_docstate_transitions dst
+		JOIN wf_workflows wf ON wf.doctype_id = dst.doctype_id AND wf.version = dst.version
+		WHERE wf.id = ?
+		AND dst.from_state_id = ?
+		AND dst.docaction_id = ?
+		AND dst.to_state_id = ?
+		`
+		fatal0(tx.QueryRow(rebind(q), cid, dsID5, daID4, dsID4).Scan(&cmd))
+		fatal0(tx.Rollback())
+		assertEqual(string(md), cmd, "cloning should copy the metadata of transitions")
+
+		err := DocTypes.SetTransitionMetadata(nil, dtID2, dsID5, daID4, dsID4, json.RawMessage(`{"color":`))
+		assertNotEqual(nil, err, "invalid JSON should be refused")
+	})
 
-		if res = error0(DocActions.Rename(tx, daID1, "List")); res != nil {
+	t.Run("DocumentsTimeline", func(t *testing.T) {
+		var tl DocumentTimeline
+		fatal0(json.Unmarshal(fatal1(Documents.Timeline(dtID2, docID2)).([]byte), &tl))
+		assertEqual(docID2, tl.DocID)
+		assertEqual(dsID5, tl.State)
+
+		kinds := map[TimelineKind]int{}
+		for i, e := range tl.Entries {
+			kinds[e.Kind]++
+			if i > 0 {
+				assertEqual(false, e.Time.Before(tl.Entries[i-1].Time), "entries should be chronological")
+			}
+			switch e.Kind {
+			case TimelineKindEvent:
+				assertEqual(EventStatusApplied, e.Event.Status)
+			case TimelineKindMessage:
+				assertEqual(true, len(e.Message.Recipients) > 0)
+			}
+		}
+		assertEqual(true, kinds[TimelineKindEvent] > 0, "applied events should be included")
+		assertEqual(true, kinds[TimelineKindState] > kinds[TimelineKindEvent], "the first state has no event")
+		assertEqual(true, kinds[TimelineKindMessage] > 0, "messages should be included")
+
+		var curr *StateVisit
+		for _, e := range tl.Entries {
+			if e.Kind == TimelineKindState {
+				curr = e.State
+			}
+		}
+		assertEqual(dsID5, curr.State)
+		assertEqual(true, curr.LeftAt == nil, "the current state has not been left")
+
+		_, err := Documents.Timeline(dtID2, docID2+1000)
+		assertNotEqual(nil, err)
+	})
+
+	t.Run("WorkflowsNewVersion", func(t *testing.T) {
+		n := fatal1(Nodes.GetByState(dtID1, dsID2)).(*Node)
+		fatal0(Workflows.AddTimeout(nil, n.ID, time.Hour, daID3))
+
+		if res = error1(Workflows.NewVersion(nil, wfID1)); res == nil {
 			return
 		}
+		wid := res.(WorkflowID)
+		assertNotEqual(wfID1, wid)
+
+		var secs int64
+		q := `
+		SELECT wnt.after_secs
+		FROM wf_workflow_node_timeouts wnt
+		JOIN wf_workflow_nodes wn ON wn.id = wnt.node_id
+		WHERE wn.workflow_id = ?
+		AND wn.docstate_id = ?
+		`
+		fatal0(db.QueryRow(rebind(q), wid, dsID2).Scan(&secs))
+		assertEqual(int64(3600), secs, "the new version should carry the node's timeout")
+		q = `DELETE FROM wf_workflow_node_timeouts WHERE node_id IN (SELECT id FROM wf_workflow_nodes WHERE docstate_id = ? AND workflow_id IN (?, ?))`
+		fatal1(db.Exec(rebind(q), dsID2, wfID1, wid))
 
-		fatal0(tx.Commit())
+		if res = error1(Workflows.GetByDocType(dtID1)); res == nil {
+			return
+		}
+		wf := res.(*Workflow)
+		assertEqual(wid, wf.ID, "the latest version should be answered")
+		assertEqual(2, wf.Version)
+		assertEqual("Storage Management", wf.Name)
 
-		if res = error1(DocActions.Get(daID1)); res == 0 {
+		if res = error1(Workflows.Get(wfID1)); res == nil {
 			return
 		}
-		obj := res.(*DocAction)
-		assertEqual("List", obj.Name)
+		assertEqual(1, res.(*Workflow).Version, "the earlier version should remain")
+
+		// Transitions added to the new version leave the earlier
+		// version alone.
+		old := fatal1(Nodes.List(wfID1)).([]*Node)[0]
+		acts := fmt.Sprint(fatal1(old.Transitions()))
+		da := fatal1(DocActions.New(nil, "Archive Early", false)).(DocActionID)
+		fatal0(DocTypes.AddTransition(nil, dtID1, old.State, da, dsID1))
+		defer func() { fatal0(DocTypes.RemoveTransition(nil, dtID1, old.State, da)) }()
+		tmap := fatal1(DocTypes.Transitions(dtID1, old.State)).(map[DocStateID]*TransitionMap)
+		_, found := tmap[old.State].Transitions[da]
+		assertEqual(true, found, "the transition should be added to the new version")
+		assertEqual(acts, fmt.Sprint(fatal1(old.Transitions())),
+			"the earlier version should retain its own transitions")
+	})
+
+	t.Run("WorkflowsMigrateDocuments", func(t *testing.T) {
+		wid2 := fatal1(Workflows.GetByDocType(dtID1)).(*Workflow).ID
+		newDoc := func() DocumentID {
+			return fatal1(Documents.New(nil, &DocumentsNewInput{
+				DocTypeID:       dtID1,
+				AccessContextID: acID1,
+				GroupID:         gID1,
+				Title:           "Storage for archival",
+				Data:            "Please provision 5 TB of cold storage.",
+			})).(DocumentID)
+		}
+		stays, moves := newDoc(), newDoc()
+
+		// Park one document in a state that the next version drops.
+		var dropped DocStateID
+		for _, n := range fatal1(Nodes.List(wid2)).([]*Node) {
+			if n.State != dsID1 {
+				dropped = n.State
+				break
+			}
+		}
+		assertNotEqual(DocStateID(0), dropped)
+		tbl := DocTypes.docStorName(dtID1)
+		fatal1(db.Exec(rebind(`UPDATE `+tbl+` SET docstate_id = ? WHERE id = ?`), dropped, stays))
+
+		wid3 := fatal1(Workflows.NewVersion(nil, wid2)).(WorkflowID)
+		q := `DELETE FROM wf_workflow_node_timeouts WHERE node_id IN (SELECT id FROM wf_workflow_nodes WHERE workflow_id = ? AND docstate_id = ?)`
+		fatal1(db.Exec(rebind(q), wid3, dropped))
+		q = `DELETE FROM wf_workflow_nodes WHERE workflow_id = ? AND docstate_id = ?`
+		fatal1(db.Exec(rebind(q), wid3, dropped))
+
+		migrated, skipped, err := Workflows.MigrateDocuments(nil, wid2, wid3)
+		fatal0(err)
+		assertEqual(1, migrated)
+		assertEqual(1, skipped)
+
+		assertEqual(wid3, fatal1(Documents.Workflow(nil, dtID1, moves)).(*Workflow).ID)
+		assertEqual(wid2, fatal1(Documents.Workflow(nil, dtID1, stays)).(*Workflow).ID)
+
+		_, _, err = Workflows.MigrateDocuments(nil, wid3, wfID2)
+		assertNotEqual(nil, err, "versions of different workflows should be refused")
+	})
+
+	t.Run("WorkflowsBeginSelector", func(t *testing.T) {
+		wf := fatal1(Workflows.GetByDocType(dtID1)).(*Workflow)
+		var alt DocStateID
+		for _, n := range fatal1(Nodes.List(wf.ID)).([]*Node) {
+			if n.State != wf.BeginState.ID {
+				alt = n.State
+				break
+			}
+		}
+		assertNotEqual(DocStateID(0), alt)
+		unrouted := fatal1(DocStates.New(nil, "Imported Unrouted")).(DocStateID)
+
+		tbl := DocTypes.docStorName(dtID1)
+		Workflows.RegisterBeginSelector(wf.ID, func(tx *sql.Tx, doc DocumentID) (DocStateID, error) {
+			var title string
+			err := tx.QueryRow(rebind(`SELECT title FROM `+tbl+` WHERE id = ?`), doc).Scan(&title)
+			switch {
+			case err != nil:
+				return 0, err
+			case title == "Imported":
+				return alt, nil
+			case title == "Unrouted":
+				return unrouted, nil
+			}
+			return 0, nil
+		})
+		defer Workflows.RegisterBeginSelector(wf.ID, nil)
+
+		newDoc := func(title string) (DocumentID, error) {
+			return Documents.New(nil, &DocumentsNewInput{
+				DocTypeID:       dtID1,
+				AccessContextID: acID1,
+				GroupID:         gID1,
+				Title:           title,
+				Data:            "Please provision 2 TB of storage.",
+			})
+		}
+		stateOf := func(doc DocumentID) DocStateID {
+			return fatal1(Documents.Get(nil, dtID1, doc)).(*Document).State.ID
+		}
+
+		web := fatal1(newDoc("Web")).(DocumentID)
+		assertEqual(wf.BeginState.ID, stateOf(web), "the selector may keep the begin state")
+		imp := fatal1(newDoc("Imported")).(DocumentID)
+		assertEqual(alt, stateOf(imp), "the selector may choose another state")
+		var n int
+		q := `SELECT COUNT(*) FROM wf_document_state_history WHERE doctype_id = ? AND doc_id = ? AND docstate_id = ? AND left_at IS NULL`
+		fatal0(db.QueryRow(rebind(q), dtID1, imp, alt).Scan(&n))
+		assertEqual(1, n, "the chosen state should be entered in the history")
+
+		_, err := newDoc("Unrouted")
+		assertNotEqual(nil, err, "a state without a node should be refused")
+
+		Workflows.RegisterBeginSelector(wf.ID, nil)
+		plain := fatal1(newDoc("Imported")).(DocumentID)
+		assertEqual(wf.BeginState.ID, stateOf(plain), "without a selector, the begin state applies")
+	})
+
+	t.Run("WorkflowsTerminate", func(t *testing.T) {
+		doc := fatal1(Documents.New(nil, &DocumentsNewInput{
+			DocTypeID:       dtID1,
+			AccessContextID: acID1,
+			GroupID:         gID1,
+			Title:           "Storage for a cancelled project",
+			Data:            "Please provision 1 TB of storage.",
+		})).(DocumentID)
+		assertEqual(ErrDocTypeNoCancelState, Workflows.Terminate(nil, dtID1, doc, "Duplicate request.", nil))
+
+		cancelled := fatal1(DocStates.New(nil, "Cancelled")).(DocStateID)
+		fatal0(DocTypes.SetCancelState(nil, dtID1, cancelled))
+		defer func() { fatal0(DocTypes.SetCancelState(nil, dtID1, 0)) }()
+		assertEqual(cancelled, fatal1(DocTypes.CancelState(dtID1)).(DocStateID))
+
+		eid := fatal1(DocEvents.New(nil, &DocEventsNewInput{
+			DocTypeID:   dtID1,
+			DocumentID:  doc,
+			DocStateID:  dsID1,
+			DocActionID: daID2,
+			GroupID:     gID1,
+			Text:        "Raised before the termination.",
+		})).(DocEventID)
+
+		assertNotEqual(nil, Workflows.Terminate(nil, dtID1, doc, " ", nil), "a reason should be required")
+		fatal0(Workflows.Terminate(nil, dtID1, doc, "Duplicate request.", nil))
+		assertEqual(cancelled, fatal1(Documents.Get(nil, dtID1, doc)).(*Document).State.ID)
+
+		ev := fatal1(DocEvents.Get(eid)).(*DocEvent)
+		wf := fatal1(Documents.Workflow(nil, dtID1, doc)).(*Workflow)
+		_, err := wf.ApplyEvent(nil, ev, nil)
+		assertEqual(ErrDocumentTerminated, err, "a terminated document should refuse events")
+		assertEqual(ErrDocumentTerminated, Workflows.Terminate(nil, dtID1, doc, "Again.", nil))
+
+		var tl DocumentTimeline
+		fatal0(json.Unmarshal(fatal1(Documents.Timeline(dtID1, doc)).([]byte), &tl))
+		var last *StateVisit
+		for _, e := range tl.Entries {
+			if e.Kind == TimelineKindState {
+				last = e.State
+			}
+		}
+		assertEqual(cancelled, last.State)
+		assertEqual("Duplicate request.", last.Reason, "the reason should be recorded in the history")
+	})
+
+	t.Run("WorkflowsReopen", func(t *testing.T) {
+		doc := fatal1(Documents.New(nil, &DocumentsNewInput{
+			DocTypeID:       dtID1,
+			AccessContextID: acID1,
+			GroupID:         gID1,
+			Title:           "Storage for a revived project",
+			Data:            "Please provision 2 TB of storage.",
+		})).(DocumentID)
+
+		withdrawn := fatal1(DocStates.New(nil, "Abandoned")).(DocStateID)
+		fatal0(DocTypes.SetCancelState(nil, dtID1, withdrawn))
+		defer func() { fatal0(DocTypes.SetCancelState(nil, dtID1, 0)) }()
+
+		assertNotEqual(nil, Workflows.Reopen(nil, dtID1, doc, dsID1, nil), "a running document should not be reopened")
+		fatal0(Workflows.Terminate(nil, dtID1, doc, "Budget withdrawn.", nil))
+
+		assertNotEqual(nil, Workflows.Reopen(nil, dtID1, doc, withdrawn, nil), "the cancel state should be refused")
+		nowhere := fatal1(DocStates.New(nil, "Nowhere")).(DocStateID)
+		assertNotEqual(nil, Workflows.Reopen(nil, dtID1, doc, nowhere, nil), "a state without a node should be refused")
+
+		fatal0(Workflows.Reopen(nil, dtID1, doc, dsID1, nil))
+		assertEqual(dsID1, fatal1(Documents.Get(nil, dtID1, doc)).(*Document).State.ID)
+
+		var tl DocumentTimeline
+		fatal0(json.Unmarshal(fatal1(Documents.Timeline(dtID1, doc)).([]byte), &tl))
+		var last *StateVisit
+		for _, e := range tl.Entries {
+			if e.Kind == TimelineKindState {
+				last = e.State
+			}
+		}
+		assertEqual(dsID1, last.State)
+		assertEqual(true, last.ByReopen, "the reopening should be recorded in the history")
 	})
 
 	t.Run("WorkflowsSetActive", func(t *testing.T) {
@@ -615,6 +3540,17 @@ func TestFlowTearDown(t *testing.T) {
 	tx := fatal1(db.Begin()).(*sql.Tx)
 	defer tx.Rollback()
 
+	error1(tx.Exec(`DELETE FROM wf_mailboxes`))
+	error1(tx.Exec(`DELETE FROM wf_message_outbox`))
+	error1(tx.Exec(`DELETE FROM wf_messages`))
+	error1(tx.Exec(`DELETE FROM wf_audit_log`))
+	error1(tx.Exec(`DELETE FROM wf_document_active_states`))
+	error1(tx.Exec(`DELETE FROM wf_document_state_history`))
+	error1(tx.Exec(`DELETE FROM wf_docevent_application`))
+	error1(tx.Exec(`DELETE FROM wf_docevents`))
+	error1(tx.Exec(`DELETE FROM ` + DocTypes.docStorName(dtID1)))
+	error1(tx.Exec(`DELETE FROM ` + DocTypes.docStorName(dtID2)))
+
 	error1(tx.Exec(`DELETE FROM wf_ac_group_roles`))
 	error1(tx.Exec(`DELETE FROM wf_ac_group_hierarchy`))
 	error1(tx.Exec(`DELETE FROM wf_access_contexts`))
@@ -625,11 +3561,13 @@ func TestFlowTearDown(t *testing.T) {
 	error1(tx.Exec(`DELETE FROM wf_role_docactions`))
 	error1(tx.Exec(`DELETE FROM wf_roles_master WHERE id > 2`))
 
+	error1(tx.Exec(`DELETE FROM wf_workflow_node_timeouts`))
 	error1(tx.Exec(`DELETE FROM wf_workflow_nodes`))
+	error1(tx.Exec(`DELETE FROM wf_docstate_transitions`))
 	error1(tx.Exec(`DELETE FROM wf_workflows`))
 	error1(tx.Exec(`DELETE FROM wf_docactions_master`))
-	error1(tx.Exec(`DELETE FROM wf_docstates_master WHERE id > 1`))
 	error1(tx.Exec(`DELETE FROM wf_doctypes_master`))
+	error1(tx.Exec(`DELETE FROM wf_docstates_master WHERE id > 1`))
 
 	fatal0(tx.Commit())
 }
@@ -0,0 +1,219 @@
+// (c) Copyright 2015-2017 JONNALAGADDA Srinivas
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package flow
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// A document that reaches a fork node is entered into several states
+// at once -- one per parallel branch.  These are recorded in
+// `wf_document_active_states`, while the document's own state remains
+// that of the fork.  Each branch then progresses independently.
+//
+// A branch that reaches a join node is marked as joined, and takes no
+// further part.  When the last branch joins, the branches are cleared,
+// and the document itself enters the join's state.
+
+// DocStateJoinWaiting is answered by `ApplyEvent` and its variants,
+// in place of a document state, when the event brought one parallel
+// branch into a join node, but other branches are yet to arrive.  It
+// does not indicate an error.
+const DocStateJoinWaiting DocStateID = -1
+
+// ActiveStates answers the states of the parallel branches in which
+// the given document currently is, in ascending order.  Branches that
+// have arrived at a join are not included.  It answers an
+// empty list when the document has not forked.
+func (_Documents) ActiveStates(dtype DocTypeID, id DocumentID) ([]DocStateID, error) {
+	return Documents.ActiveStatesContext(context.Background(), dtype, id)
+}
+
+// ActiveStatesContext is the same as `ActiveStates`, but runs its
+// queries under the given context.
+func (_Documents) ActiveStatesContext(ctx context.Context, dtype DocTypeID, id DocumentID) ([]DocStateID, error) {
+	return Documents.activeStates(ctx, nil, dtype, id)
+}
+
+// activeStates answers the states of the given document's parallel
+// branches, in the given transaction, if any.
+func (_Documents) activeStates(ctx context.Context, otx *sql.Tx, dtype DocTypeID, id DocumentID) ([]DocStateID, error) {
+	q := `
+	SELECT docstate_id
+	FROM wf_document_active_states
+	WHERE doctype_id = ?
+	AND doc_id = ?
+	AND joined = FALSE
+	ORDER BY docstate_id
+	`
+	var rows *sql.Rows
+	var err error
+	if otx == nil {
+		rows, err = db.QueryContext(ctx, rebind(q), dtype, id)
+	} else {
+		rows, err = otx.QueryContext(ctx, rebind(q), dtype, id)
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ary := make([]DocStateID, 0, 2)
+	for rows.Next() {
+		var ds DocStateID
+		if err = rows.Scan(&ds); err != nil {
+			return nil, err
+		}
+		ary = append(ary, ds)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return ary, nil
+}
+
+// enterBranches records the document as being in all the given states,
+// having forked from the given state.
+func (_Documents) enterBranches(ctx context.Context, otx *sql.Tx, dtype DocTypeID, id DocumentID, fork DocStateID, states []DocStateID) error {
+	q := `
+	INSERT INTO wf_document_active_states(doctype_id, doc_id, fork_state_id, docstate_id, ctime)
+	VALUES(?, ?, ?, ?, ?)
+	`
+	now := time.Now().UTC()
+	for _, ds := range states {
+		_, err := otx.ExecContext(ctx, rebind(q), dtype, id, fork, ds, now)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// moveBranch transitions one parallel branch of the document from the
+// given state into another.
+func (_Documents) moveBranch(ctx context.Context, otx *sql.Tx, dtype DocTypeID, id DocumentID, from, to DocStateID) error {
+	q := `
+	UPDATE wf_document_active_states SET docstate_id = ?, ctime = ?
+	WHERE doctype_id = ?
+	AND doc_id = ?
+	AND docstate_id = ?
+	`
+	res, err := otx.ExecContext(ctx, rebind(q), to, time.Now().UTC(), dtype, id, from)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrConcurrentModification
+	}
+
+	logger.Infof("branch of document %d/%d changed state from %d to %d", dtype, id, from, to)
+	return nil
+}
+
+// joinBranch marks the given branch of the document as having
+// arrived at the given join state.  It answers `true` if other
+// branches are yet to arrive.  Otherwise, the branches are cleared,
+// and the document enters the join state.
+//
+// The document's branches are locked first, so that branches arriving
+// concurrently are serialised : the later one observes the earlier
+// as joined, and advances the document.  A locking read observes the
+// latest committed rows, even under snapshot isolation.
+func (_Documents) joinBranch(ctx context.Context, otx *sql.Tx, dtype DocTypeID, id DocumentID, from, join DocStateID, ac AccessContextID,
+	version int64) (bool, error) {
+	q := `
+	SELECT docstate_id, joined
+	FROM wf_document_active_states
+	WHERE doctype_id = ?
+	AND doc_id = ?
+	FOR UPDATE
+	`
+	rows, err := otx.QueryContext(ctx, rebind(q), dtype, id)
+	if err != nil {
+		return false, err
+	}
+	defer rows.Close()
+
+	arriving := false
+	waiting := false
+	for rows.Next() {
+		var ds DocStateID
+		var joined bool
+		if err = rows.Scan(&ds, &joined); err != nil {
+			return false, err
+		}
+		switch {
+		case joined:
+			// Intentionally left blank
+
+		case ds == from:
+			arriving = true
+
+		default:
+			waiting = true
+		}
+	}
+	if err = rows.Err(); err != nil {
+		return false, err
+	}
+	rows.Close()
+	if !arriving {
+		return false, ErrConcurrentModification
+	}
+
+	q = `
+	UPDATE wf_document_active_states SET joined = TRUE, ctime = ?
+	WHERE doctype_id = ?
+	AND doc_id = ?
+	AND docstate_id = ?
+	`
+	_, err = otx.ExecContext(ctx, rebind(q), time.Now().UTC(), dtype, id, from)
+	if err != nil {
+		return false, err
+	}
+	if waiting {
+		return true, nil
+	}
+
+	q = `
+	DELETE FROM wf_document_active_states
+	WHERE doctype_id = ?
+	AND doc_id = ?
+	`
+	_, err = otx.ExecContext(ctx, rebind(q), dtype, id)
+	if err != nil {
+		return false, err
+	}
+
+	return false, Documents.setState(ctx, otx, dtype, id, join, ac, version)
+}
+
+// containsState answers `true` if the given state is in the list.
+func containsState(ary []DocStateID, ds DocStateID) bool {
+	for _, el := range ary {
+		if el == ds {
+			return true
+		}
+	}
+	return false
+}
@@ -15,6 +15,7 @@
 package flow
 
 import (
+	"context"
 	"database/sql"
 	"errors"
 	"fmt"
@@ -43,9 +44,16 @@ var Groups _Groups
 // user.  The e-mail address of the user is used as the name of the
 // group.  This serves as the linking identifier.
 func (_Groups) NewSingleton(otx *sql.Tx, uid UserID) (GroupID, error) {
+	return Groups.NewSingletonContext(context.Background(), otx, uid)
+}
+
+// NewSingletonContext is the same as `NewSingleton`, but runs its
+// queries under the given context.
+func (_Groups) NewSingletonContext(ctx context.Context, otx *sql.Tx, uid UserID) (GroupID, error) {
 	var tx *sql.Tx
+	var err error
 	if otx == nil {
-		tx, err := db.Begin()
+		tx, err = db.BeginTx(ctx, nil)
 		if err != nil {
 			return 0, err
 		}
@@ -60,21 +68,13 @@ func (_Groups) NewSingleton(otx *sql.Tx, uid UserID) (GroupID, error) {
 	FROM wf_users_master u
 	WHERE u.id = ?
 	`
-	res, err := tx.Exec(q, uid)
-	if err != nil {
-		return 0, err
-	}
 	var gid int64
-	gid, err = res.LastInsertId()
+	gid, err = execInsert(ctx, tx, q, uid)
 	if err != nil {
 		return 0, err
 	}
 
-	res, err = tx.Exec("INSERT INTO wf_group_users(group_id, user_id) VALUES(?, ?)", gid, uid)
-	if err != nil {
-		return 0, err
-	}
-	_, err = res.LastInsertId()
+	_, err = tx.ExecContext(ctx, rebind("INSERT INTO wf_group_users(group_id, user_id) VALUES(?, ?)"), gid, uid)
 	if err != nil {
 		return 0, err
 	}
@@ -91,6 +91,12 @@ func (_Groups) NewSingleton(otx *sql.Tx, uid UserID) (GroupID, error) {
 
 // New creates a new group that can be populated with users later.
 func (_Groups) New(otx *sql.Tx, name string, gtype string) (GroupID, error) {
+	return Groups.NewContext(context.Background(), otx, name, gtype)
+}
+
+// NewContext is the same as `New`, but runs its queries under the
+// given context.
+func (_Groups) NewContext(ctx context.Context, otx *sql.Tx, name string, gtype string) (GroupID, error) {
 	name = strings.TrimSpace(name)
 	gtype = strings.TrimSpace(gtype)
 	if name == "" || gtype == "" {
@@ -105,8 +111,9 @@ func (_Groups) New(otx *sql.Tx, name string, gtype string) (GroupID, error) {
 	}
 
 	var tx *sql.Tx
+	var err error
 	if otx == nil {
-		tx, err := db.Begin()
+		tx, err = db.BeginTx(ctx, nil)
 		if err != nil {
 			return 0, err
 		}
@@ -115,12 +122,8 @@ func (_Groups) New(otx *sql.Tx, name string, gtype string) (GroupID, error) {
 		tx = otx
 	}
 
-	res, err := tx.Exec("INSERT INTO wf_groups_master(name, group_type) VALUES(?, ?)", name, gtype)
-	if err != nil {
-		return 0, err
-	}
 	var id int64
-	id, err = res.LastInsertId()
+	id, err = execInsert(ctx, tx, "INSERT INTO wf_groups_master(name, group_type) VALUES(?, ?)", name, gtype)
 	if err != nil {
 		return 0, err
 	}
@@ -142,6 +145,12 @@ func (_Groups) New(otx *sql.Tx, name string, gtype string) (GroupID, error) {
 // `limit` elements.  A value of `0` for `offset` fetches from the
 // beginning, while a value of `0` for `limit` fetches until the end.
 func (_Groups) List(offset, limit int64) ([]*Group, error) {
+	return Groups.ListContext(context.Background(), offset, limit)
+}
+
+// ListContext is the same as `List`, but runs its queries under the
+// given context.
+func (_Groups) ListContext(ctx context.Context, offset, limit int64) ([]*Group, error) {
 	if offset < 0 || limit < 0 {
 		return nil, errors.New("offset and limit must be non-negative integers")
 	}
@@ -155,7 +164,7 @@ func (_Groups) List(offset, limit int64) ([]*Group, error) {
 	ORDER BY id
 	LIMIT ? OFFSET ?
 	`
-	rows, err := db.Query(q, limit, offset)
+	rows, err := db.QueryContext(ctx, rebind(q), limit, offset)
 	if err != nil {
 		return nil, err
 	}
@@ -179,14 +188,23 @@ func (_Groups) List(offset, limit int64) ([]*Group, error) {
 
 // Get initialises the group by reading from database.
 func (_Groups) Get(id GroupID) (*Group, error) {
+	return Groups.GetContext(context.Background(), id)
+}
+
+// GetContext is the same as `Get`, but runs its queries under the
+// given context.
+func (_Groups) GetContext(ctx context.Context, id GroupID) (*Group, error) {
 	if id <= 0 {
 		return nil, errors.New("group ID should be a positive integer")
 	}
 
 	var elem Group
-	row := db.QueryRow("SELECT id, name, group_type FROM wf_groups_master WHERE id = ?", id)
+	row := db.QueryRowContext(ctx, rebind("SELECT id, name, group_type FROM wf_groups_master WHERE id = ?"), id)
 	err := row.Scan(&elem.ID, &elem.Name, &elem.GroupType)
 	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrNotFound
+		}
 		return nil, err
 	}
 
@@ -195,13 +213,19 @@ func (_Groups) Get(id GroupID) (*Group, error) {
 
 // Rename renames the given group.
 func (_Groups) Rename(otx *sql.Tx, id GroupID, name string) error {
+	return Groups.RenameContext(context.Background(), otx, id, name)
+}
+
+// RenameContext is the same as `Rename`, but runs its queries under
+// the given context.
+func (_Groups) RenameContext(ctx context.Context, otx *sql.Tx, id GroupID, name string) error {
 	name = strings.TrimSpace(name)
 	if name == "" {
 		return errors.New("name cannot be empty")
 	}
 
 	var elem Group
-	row := db.QueryRow("SELECT id, name, group_type FROM wf_groups_master WHERE id = ?", id)
+	row := db.QueryRowContext(ctx, rebind("SELECT id, name, group_type FROM wf_groups_master WHERE id = ?"), id)
 	err := row.Scan(&elem.ID, &elem.Name, &elem.GroupType)
 	if err != nil {
 		return err
@@ -212,7 +236,7 @@ func (_Groups) Rename(otx *sql.Tx, id GroupID, name string) error {
 
 	var tx *sql.Tx
 	if otx == nil {
-		tx, err := db.Begin()
+		tx, err = db.BeginTx(ctx, nil)
 		if err != nil {
 			return err
 		}
@@ -221,7 +245,7 @@ func (_Groups) Rename(otx *sql.Tx, id GroupID, name string) error {
 		tx = otx
 	}
 
-	_, err = tx.Exec("UPDATE wf_groups_master SET name = ? WHERE id = ?", name, id)
+	_, err = tx.ExecContext(ctx, rebind("UPDATE wf_groups_master SET name = ? WHERE id = ?"), name, id)
 	if err != nil {
 		return err
 	}
@@ -239,11 +263,17 @@ func (_Groups) Rename(otx *sql.Tx, id GroupID, name string) error {
 // Delete deletes the given group from the system, if no access
 // context is actively using it.
 func (_Groups) Delete(otx *sql.Tx, id GroupID) error {
+	return Groups.DeleteContext(context.Background(), otx, id)
+}
+
+// DeleteContext is the same as `Delete`, but runs its queries under
+// the given context.
+func (_Groups) DeleteContext(ctx context.Context, otx *sql.Tx, id GroupID) error {
 	if id <= 0 {
 		return errors.New("group ID must be a positive integer")
 	}
 
-	row := db.QueryRow("SELECT group_type FROM wf_groups_master WHERE id = ?", id)
+	row := db.QueryRowContext(ctx, rebind("SELECT group_type FROM wf_groups_master WHERE id = ?"), id)
 	var gtype string
 	err := row.Scan(&gtype)
 	if err != nil {
@@ -253,7 +283,7 @@ func (_Groups) Delete(otx *sql.Tx, id GroupID) error {
 		return errors.New("singleton groups cannot be deleted")
 	}
 
-	row = db.QueryRow("SELECT COUNT(*) FROM wf_ac_group_roles WHERE group_id = ?", id)
+	row = db.QueryRowContext(ctx, rebind("SELECT COUNT(*) FROM wf_ac_group_roles WHERE group_id = ?"), id)
 	var n int64
 	err = row.Scan(&n)
 	if n > 0 {
@@ -262,7 +292,7 @@ func (_Groups) Delete(otx *sql.Tx, id GroupID) error {
 
 	var tx *sql.Tx
 	if otx == nil {
-		tx, err := db.Begin()
+		tx, err = db.BeginTx(ctx, nil)
 		if err != nil {
 			return err
 		}
@@ -271,11 +301,11 @@ func (_Groups) Delete(otx *sql.Tx, id GroupID) error {
 		tx = otx
 	}
 
-	_, err = tx.Exec("DELETE FROM wf_group_users WHERE group_id = ?", id)
+	_, err = tx.ExecContext(ctx, rebind("DELETE FROM wf_group_users WHERE group_id = ?"), id)
 	if err != nil {
 		return err
 	}
-	res, err := tx.Exec("DELETE FROM wf_groups_master WHERE id = ?", id)
+	res, err := tx.ExecContext(ctx, rebind("DELETE FROM wf_groups_master WHERE id = ?"), id)
 	if err != nil {
 		return err
 	}
@@ -296,13 +326,19 @@ func (_Groups) Delete(otx *sql.Tx, id GroupID) error {
 
 // Users answers a list of the given group's users.
 func (_Groups) Users(gid GroupID) ([]*User, error) {
+	return Groups.UsersContext(context.Background(), gid)
+}
+
+// UsersContext is the same as `Users`, but runs its queries under the
+// given context.
+func (_Groups) UsersContext(ctx context.Context, gid GroupID) ([]*User, error) {
 	q := `
 	SELECT um.id, um.first_name, um.last_name, um.email, um.active
 	FROM wf_users_master um
 	JOIN wf_group_users gu ON gu.user_id = um.id
 	WHERE gu.group_id = ?
 	`
-	rows, err := db.Query(q, gid)
+	rows, err := db.QueryContext(ctx, rebind(q), gid)
 	if err != nil {
 		return nil, err
 	}
@@ -327,6 +363,12 @@ func (_Groups) Users(gid GroupID) ([]*User, error) {
 // HasUser answers `true` if this group includes the given user;
 // `false` otherwise.
 func (_Groups) HasUser(gid GroupID, uid UserID) (bool, error) {
+	return Groups.HasUserContext(context.Background(), gid, uid)
+}
+
+// HasUserContext is the same as `HasUser`, but runs its queries under
+// the given context.
+func (_Groups) HasUserContext(ctx context.Context, gid GroupID, uid UserID) (bool, error) {
 	q := `
 	SELECT id FROM wf_group_users
 	WHERE group_id = ?
@@ -335,7 +377,7 @@ func (_Groups) HasUser(gid GroupID, uid UserID) (bool, error) {
 	LIMIT 1
 	`
 	var id int64
-	row := db.QueryRow(q, gid, uid)
+	row := db.QueryRowContext(ctx, rebind(q), gid, uid)
 	err := row.Scan(&id)
 	switch {
 	case err == sql.ErrNoRows:
@@ -352,6 +394,12 @@ func (_Groups) HasUser(gid GroupID, uid UserID) (bool, error) {
 // SingletonUser answer the user ID of the corresponding user, if this
 // group is a singleton group.
 func (_Groups) SingletonUser(gid GroupID) (*User, error) {
+	return Groups.SingletonUserContext(context.Background(), gid)
+}
+
+// SingletonUserContext is the same as `SingletonUser`, but runs its
+// queries under the given context.
+func (_Groups) SingletonUserContext(ctx context.Context, gid GroupID) (*User, error) {
 	q := `
 	SELECT um.id, um.first_name, um.last_name, um.email, um.active
 	FROM wf_users_master um
@@ -364,7 +412,7 @@ func (_Groups) SingletonUser(gid GroupID) (*User, error) {
 	`
 
 	var elem User
-	row := db.QueryRow(q, gid)
+	row := db.QueryRowContext(ctx, rebind(q), gid)
 	err := row.Scan(&elem.ID, &elem.FirstName, &elem.LastName, &elem.Email, &elem.Active)
 	switch {
 	case err != nil:
@@ -377,13 +425,20 @@ func (_Groups) SingletonUser(gid GroupID) (*User, error) {
 
 // AddUser adds the given user as a member of this group.
 func (_Groups) AddUser(otx *sql.Tx, gid GroupID, uid UserID) error {
+	return Groups.AddUserContext(context.Background(), otx, gid, uid)
+}
+
+// AddUserContext is the same as `AddUser`, but runs its queries under
+// the given context.
+func (_Groups) AddUserContext(ctx context.Context, otx *sql.Tx, gid GroupID, uid UserID) error {
 	if gid <= 0 || uid <= 0 {
 		return errors.New("group ID and user ID must be positive integers")
 	}
 
 	var tx *sql.Tx
+	var err error
 	if otx == nil {
-		tx, err := db.Begin()
+		tx, err = db.BeginTx(ctx, nil)
 		if err != nil {
 			return err
 		}
@@ -393,8 +448,8 @@ func (_Groups) AddUser(otx *sql.Tx, gid GroupID, uid UserID) error {
 	}
 
 	var gtype string
-	row := tx.QueryRow("SELECT group_type FROM wf_groups_master WHERE id = ?", gid)
-	err := row.Scan(&gtype)
+	row := tx.QueryRowContext(ctx, rebind("SELECT group_type FROM wf_groups_master WHERE id = ?"), gid)
+	err = row.Scan(&gtype)
 	if err != nil {
 		return err
 	}
@@ -402,7 +457,7 @@ func (_Groups) AddUser(otx *sql.Tx, gid GroupID, uid UserID) error {
 		return errors.New("cannot add users to singleton groups")
 	}
 
-	_, err = tx.Exec("INSERT INTO wf_group_users(group_id, user_id) VALUES(?, ?)", gid, uid)
+	_, err = tx.ExecContext(ctx, rebind("INSERT INTO wf_group_users(group_id, user_id) VALUES(?, ?)"), gid, uid)
 	if err != nil {
 		return err
 	}
@@ -419,13 +474,20 @@ func (_Groups) AddUser(otx *sql.Tx, gid GroupID, uid UserID) error {
 // RemoveUser removes the given user from this group, if the user is a
 // member of the group.  This operation is idempotent.
 func (_Groups) RemoveUser(otx *sql.Tx, gid GroupID, uid UserID) error {
+	return Groups.RemoveUserContext(context.Background(), otx, gid, uid)
+}
+
+// RemoveUserContext is the same as `RemoveUser`, but runs its queries
+// under the given context.
+func (_Groups) RemoveUserContext(ctx context.Context, otx *sql.Tx, gid GroupID, uid UserID) error {
 	if gid <= 0 || uid <= 0 {
 		return errors.New("group ID and user ID must be positive integers")
 	}
 
 	var tx *sql.Tx
+	var err error
 	if otx == nil {
-		tx, err := db.Begin()
+		tx, err = db.BeginTx(ctx, nil)
 		if err != nil {
 			return err
 		}
@@ -435,8 +497,8 @@ func (_Groups) RemoveUser(otx *sql.Tx, gid GroupID, uid UserID) error {
 	}
 
 	var gtype string
-	row := tx.QueryRow("SELECT group_type FROM wf_groups_master WHERE id = ?", gid)
-	err := row.Scan(&gtype)
+	row := tx.QueryRowContext(ctx, rebind("SELECT group_type FROM wf_groups_master WHERE id = ?"), gid)
+	err = row.Scan(&gtype)
 	if err != nil {
 		return err
 	}
@@ -444,7 +506,7 @@ func (_Groups) RemoveUser(otx *sql.Tx, gid GroupID, uid UserID) error {
 		return errors.New("cannot remove users from singleton groups")
 	}
 
-	res, err := tx.Exec("DELETE FROM wf_group_users WHERE group_id = ? AND user_id = ?", gid, uid)
+	res, err := tx.ExecContext(ctx, rebind("DELETE FROM wf_group_users WHERE group_id = ? AND user_id = ?"), gid, uid)
 	if err != nil {
 		return err
 	}
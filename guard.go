@@ -0,0 +1,144 @@
+// (c) Copyright 2015-2017 JONNALAGADDA Srinivas
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package flow
+
+import (
+	"context"
+	"database/sql"
+	"sync"
+)
+
+// GuardFunc decides whether a guarded transition applies to the given
+// document.  It is consulted when an event is applied, so that a
+// single action can lead to different target states, based on the
+// document's data.
+type GuardFunc func(doc DocumentID) (bool, error)
+
+// guardKey identifies a single transition of a document type.
+type guardKey struct {
+	dtype  DocTypeID
+	from   DocStateID
+	action DocActionID
+	to     DocStateID
+}
+
+// guards holds the registered transition guards.
+var guards struct {
+	sync.RWMutex
+	m map[guardKey]GuardFunc
+}
+
+// RegisterGuard associates the given guard with the transition from
+// the given state, upon the given action, into the given target
+// state.  A `nil` guard removes any that is registered.
+//
+// An action can have several transitions out of a state, each into a
+// different target state.  When such an action is applied, guarded
+// transitions are tried in the order of their definition, and the
+// first whose guard answers `true` is taken.  Should none match, the
+// unguarded transition, if any, is taken.  Since an unguarded action
+// has only one transition out of a state, except from a fork, the
+// guard should be registered before its transition is added.
+//
+// N.B. `Node.Transitions` answers only one target state per action.
+func (_DocTypes) RegisterGuard(dtype DocTypeID, from DocStateID, action DocActionID, to DocStateID, g GuardFunc) {
+	k := guardKey{dtype, from, action, to}
+
+	guards.Lock()
+	defer guards.Unlock()
+
+	if g == nil {
+		delete(guards.m, k)
+		return
+	}
+	if guards.m == nil {
+		guards.m = make(map[guardKey]GuardFunc)
+	}
+	guards.m[k] = g
+}
+
+// actionTargets answers the target states of the transitions from the
+// given state upon the given action, in the given workflow, in the
+// order of their definition.
+func actionTargets(ctx context.Context, otx *sql.Tx, wid WorkflowID, from DocStateID, action DocActionID) ([]DocStateID, error) {
+	q := `
+	SELECT dst.to_state_id
+	FROM wf_docstate_transitions dst
+	JOIN wf_workflows wf ON wf.doctype_id = dst.doctype_id AND wf.version = dst.version
+	WHERE wf.id = ?
+	AND dst.from_state_id = ?
+	AND dst.docaction_id = ?
+	ORDER BY dst.id
+	`
+	var rows *sql.Rows
+	var err error
+	if otx == nil {
+		rows, err = db.QueryContext(ctx, rebind(q), wid, from, action)
+	} else {
+		rows, err = otx.QueryContext(ctx, rebind(q), wid, from, action)
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ary := make([]DocStateID, 0, 2)
+	for rows.Next() {
+		var ds DocStateID
+		if err = rows.Scan(&ds); err != nil {
+			return nil, err
+		}
+		ary = append(ary, ds)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return ary, nil
+}
+
+// chooseTarget selects the target state for the given document from
+// among the given candidates, by evaluating their guards.  It answers
+// `false` if no guard matches, and there is no unguarded candidate.
+func chooseTarget(dtype DocTypeID, from DocStateID, action DocActionID, doc DocumentID, targets []DocStateID) (DocStateID, bool, error) {
+	guards.RLock()
+	m := guards.m
+	gs := make([]GuardFunc, len(targets))
+	for i, to := range targets {
+		gs[i] = m[guardKey{dtype, from, action, to}]
+	}
+	guards.RUnlock()
+
+	var fallback DocStateID
+	found := false
+	for i, to := range targets {
+		if gs[i] == nil {
+			if !found {
+				fallback, found = to, true
+			}
+			continue
+		}
+
+		ok, err := gs[i](doc)
+		if err != nil {
+			return 0, false, err
+		}
+		if ok {
+			return to, true, nil
+		}
+	}
+
+	return fallback, found, nil
+}
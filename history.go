@@ -0,0 +1,281 @@
+// (c) Copyright 2015-2017 JONNALAGADDA Srinivas
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package flow
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// enterState records, in the given transaction, that the given
+// document has left its current state, and entered the given one.
+func enterState(ctx context.Context, otx *sql.Tx, dtype DocTypeID, id DocumentID, state DocStateID) error {
+	now := time.Now().UTC()
+
+	q := `
+	UPDATE wf_document_state_history SET left_at = ?
+	WHERE doctype_id = ?
+	AND doc_id = ?
+	AND left_at IS NULL
+	`
+	_, err := otx.ExecContext(ctx, rebind(q), now, dtype, id)
+	if err != nil {
+		return err
+	}
+
+	q = `
+	INSERT INTO wf_document_state_history(doctype_id, doc_id, docstate_id, entered_at)
+	VALUES(?, ?, ?, ?)
+	`
+	_, err = otx.ExecContext(ctx, rebind(q), dtype, id, state, now)
+	return err
+}
+
+// TimeInState answers the total time that the given document has
+// spent in the given state, across all its visits to that state.  A
+// visit that is in progress is counted up to the present.
+//
+// N.B. Only the document's own state is tracked; time spent in the
+// parallel branches of a fork is not.
+func (_Workflows) TimeInState(dtype DocTypeID, doc DocumentID, state DocStateID) (time.Duration, error) {
+	return Workflows.TimeInStateContext(context.Background(), dtype, doc, state)
+}
+
+// TimeInStateContext is the same as `TimeInState`, but runs its
+// queries under the given context.
+func (_Workflows) TimeInStateContext(ctx context.Context, dtype DocTypeID, doc DocumentID, state DocStateID) (time.Duration, error) {
+	q := `
+	SELECT entered_at, left_at
+	FROM wf_document_state_history
+	WHERE doctype_id = ?
+	AND doc_id = ?
+	AND docstate_id = ?
+	`
+	rows, err := db.QueryContext(ctx, rebind(q), dtype, doc, state)
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	now := time.Now()
+	var total time.Duration
+	for rows.Next() {
+		var entered time.Time
+		var left sql.NullTime
+		err = rows.Scan(&entered, &left)
+		if err != nil {
+			return 0, err
+		}
+		if left.Valid {
+			total += left.Time.Sub(entered)
+		} else {
+			total += now.Sub(entered)
+		}
+	}
+	if err = rows.Err(); err != nil {
+		return 0, err
+	}
+
+	return total, nil
+}
+
+// Revert moves the given document back into the state it was in
+// before its latest transition, as recorded in its state history.
+// The new state is answered.  The revert is itself recorded in the
+// history, so that reverting again moves the document one further
+// step back.  A document in its first state cannot be reverted, and
+// `ErrDocumentNoPriorState` is answered.
+//
+// A revert is not an event; no event is recorded, and no message is
+// posted to mailboxes.  The given recipients are informed through the
+// registered notifiers, and transition hooks are invoked with an
+// action of `0`.
+//
+// N.B. Documents within parallel branches cannot be reverted.
+func (_Workflows) Revert(otx *sql.Tx, dtype DocTypeID, doc DocumentID, recipients []GroupID) (DocStateID, error) {
+	return Workflows.RevertContext(context.Background(), otx, dtype, doc, recipients)
+}
+
+// RevertContext is the same as `Revert`, but runs its queries under
+// the given context.
+func (_Workflows) RevertContext(ctx context.Context, otx *sql.Tx, dtype DocTypeID, doc DocumentID, recipients []GroupID) (DocStateID, error) {
+	done, err := engine.track()
+	if err != nil {
+		return 0, err
+	}
+	defer done()
+
+	var from, to DocStateID
+	var ob *outbox
+	err = inTx(ctx, otx, func(tx *sql.Tx) error {
+		var octx context.Context
+		octx, ob = withOutbox(ctx)
+		var err error
+		from, to, err = revertTx(octx, tx, dtype, doc, recipients)
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	afterCommit(otx, func() {
+		fireHooks(ctx, []transition{{dtype, doc, from, to, 0}})
+		ob.deliver(ctx)
+	})
+	return to, nil
+}
+
+// revertTx reverts the given document within the given transaction,
+// and answers the states that it moved from and to.
+func revertTx(ctx context.Context, tx *sql.Tx, dtype DocTypeID, id DocumentID, recipients []GroupID) (DocStateID, DocStateID, error) {
+	if lockDocs {
+		err := Documents.lock(ctx, tx, dtype, id)
+		if err != nil {
+			return 0, 0, err
+		}
+	}
+	doc, err := Documents.GetContext(ctx, tx, dtype, id)
+	if err != nil {
+		return 0, 0, err
+	}
+	if doc.Path != "" {
+		return 0, 0, ErrDocumentIsChild
+	}
+	branches, err := Documents.activeStates(ctx, tx, dtype, id)
+	if err != nil {
+		return 0, 0, err
+	}
+	if len(branches) > 0 {
+		return 0, 0, errors.New("documents within parallel branches cannot be reverted")
+	}
+	wf, err := Documents.WorkflowContext(ctx, tx, dtype, id)
+	if err != nil {
+		return 0, 0, err
+	}
+	if !wf.Active {
+		return 0, 0, ErrWorkflowInactive
+	}
+
+	q := `
+	SELECT docstate_id, by_revert
+	FROM wf_document_state_history
+	WHERE doctype_id = ?
+	AND doc_id = ?
+	ORDER BY id
+	`
+	rows, err := tx.QueryContext(ctx, rebind(q), dtype, id)
+	if err != nil {
+		return 0, 0, err
+	}
+	var hist []historyEntry
+	for rows.Next() {
+		var elem historyEntry
+		if err = rows.Scan(&elem.state, &elem.byRevert); err != nil {
+			rows.Close()
+			return 0, 0, err
+		}
+		hist = append(hist, elem)
+	}
+	err = rows.Err()
+	rows.Close()
+	if err != nil {
+		return 0, 0, err
+	}
+
+	curr, prev, ok := revertTarget(hist)
+	if !ok || curr != doc.State.ID {
+		return 0, 0, ErrDocumentNoPriorState
+	}
+
+	n, err := Nodes.getByWorkflowState(ctx, tx, wf.ID, prev)
+	if err != nil {
+		return 0, 0, err
+	}
+	ac := n.AccCtx
+	if ac == 0 {
+		ac = doc.AccCtx.ID
+	}
+	err = Documents.setState(ctx, tx, dtype, id, prev, ac, doc.Version)
+	if err != nil {
+		return 0, 0, err
+	}
+	q = `
+	UPDATE wf_document_state_history SET by_revert = TRUE
+	WHERE doctype_id = ?
+	AND doc_id = ?
+	AND left_at IS NULL
+	`
+	_, err = tx.ExecContext(ctx, rebind(q), dtype, id)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	enqueueNotice(ctx, doc, wf.ID, recipients, fmt.Sprintf("Reverted from state %d to state %d.", curr, prev))
+	return curr, prev, nil
+}
+
+// enqueueNotice prepares a message with the given text about the given
+// document, for the registered notifiers to deliver to the given
+// recipients.  Such notices concern changes of state that are not
+// events, and are hence posted to no mailbox.
+func enqueueNotice(ctx context.Context, doc *Document, wid WorkflowID, recipients []GroupID, text string) {
+	if len(recipients) == 0 {
+		return
+	}
+
+	recv := make(map[GroupID]struct{}, len(recipients))
+	for _, gid := range recipients {
+		recv[gid] = struct{}{}
+	}
+	msg := &Message{
+		DocType:  doc.DocType,
+		DocID:    doc.ID,
+		Workflow: wid,
+		Title:    doc.Title,
+		Data:     text,
+		Ctime:    time.Now().UTC(),
+	}
+	enqueue(ctx, msg, recv)
+}
+
+// historyEntry is a single visit of a document to a state, as read
+// from its state history.
+type historyEntry struct {
+	state    DocStateID
+	byRevert bool // This visit was entered by reverting the preceding one
+}
+
+// revertTarget replays the given history, in chronological order, and
+// answers the current state and the one before it.  Each visit
+// entered by a revert undoes the visit that it followed.  It answers
+// `false` if there is no earlier state.
+func revertTarget(hist []historyEntry) (DocStateID, DocStateID, bool) {
+	stack := make([]DocStateID, 0, len(hist))
+	for _, e := range hist {
+		if e.byRevert && len(stack) > 0 {
+			stack = stack[:len(stack)-1]
+			continue
+		}
+		stack = append(stack, e.state)
+	}
+
+	if len(stack) < 2 {
+		return 0, 0, false
+	}
+	return stack[len(stack)-1], stack[len(stack)-2], true
+}
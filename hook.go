@@ -0,0 +1,89 @@
+// (c) Copyright 2015-2017 JONNALAGADDA Srinivas
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package flow
+
+import (
+	"context"
+	"sync"
+)
+
+// TransitionHook is implemented by consumers that need to trigger
+// external side effects -- updating a search index, emitting an event
+// to a message bus, etc. -- whenever a document transitions from one
+// state into another.
+//
+// Errors answered by hooks are logged, but do not undo the
+// transition, which has already been committed.
+type TransitionHook interface {
+	OnTransition(ctx context.Context, dtype DocTypeID, doc DocumentID, from, to DocStateID, action DocActionID) error
+}
+
+// transition records a single applied state transition, for the
+// benefit of hooks.
+type transition struct {
+	dtype  DocTypeID
+	doc    DocumentID
+	from   DocStateID
+	to     DocStateID
+	action DocActionID
+}
+
+// hooks holds the registered transition hooks, in their order of
+// registration.
+var hooks struct {
+	sync.RWMutex
+	list []TransitionHook
+}
+
+// RegisterHook adds the given hook to those that are invoked after
+// each successful document state transition.  Hooks are invoked in
+// the order of their registration.
+//
+// When `ApplyEvent` and its variants manage their own transaction,
+// hooks are invoked after it commits.  When a transaction begun
+// through `WithTx` is given, hooks are invoked once it commits, and
+// not at all should it roll back.  Any other transaction given by the
+// caller cannot be seen to commit; hooks are then invoked as the
+// method returns, and hence before the caller commits.
+//
+// Registration is safe while events are being applied on other
+// goroutines; such events may or may not see the new hook.  It is,
+// however, expected to happen during initialisation.
+func (_Workflows) RegisterHook(h TransitionHook) {
+	if h == nil {
+		return
+	}
+
+	hooks.Lock()
+	hooks.list = append(hooks.list, h)
+	hooks.Unlock()
+}
+
+// fireHooks invokes the registered hooks for each of the given
+// transitions, in order.
+func fireHooks(ctx context.Context, ts []transition) {
+	hooks.RLock()
+	list := hooks.list
+	hooks.RUnlock()
+
+	for _, t := range ts {
+		for _, h := range list {
+			err := h.OnTransition(ctx, t.dtype, t.doc, t.from, t.to, t.action)
+			if err != nil {
+				logger.Errorf("transition hook failed for document %d/%d (%d -> %d) : %v", t.dtype, t.doc, t.from, t.to, err)
+			}
+		}
+	}
+}
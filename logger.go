@@ -0,0 +1,48 @@
+// (c) Copyright 2015-2017 JONNALAGADDA Srinivas
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package flow
+
+// Logger is implemented by consumers that wish to receive diagnostic
+// output from `flow`.  Its methods are modelled after `fmt.Printf`, so
+// that most logging libraries can be adapted to it trivially.
+//
+// N.B. Implementations must be safe for concurrent use.
+type Logger interface {
+	Debugf(format string, args ...interface{})
+	Infof(format string, args ...interface{})
+	Errorf(format string, args ...interface{})
+}
+
+// nopLogger discards all output.  It is the default logger.
+type nopLogger struct{}
+
+func (nopLogger) Debugf(string, ...interface{}) {}
+func (nopLogger) Infof(string, ...interface{})  {}
+func (nopLogger) Errorf(string, ...interface{}) {}
+
+// logger is the logger of the installed engine.
+var logger Logger = nopLogger{}
+
+// WithLogger specifies the logger to which `flow` writes its
+// diagnostic output.  By default, nothing is logged.
+func WithLogger(l Logger) Option {
+	return func(e *Engine) error {
+		if l == nil {
+			l = nopLogger{}
+		}
+		e.logger = l
+		return nil
+	}
+}
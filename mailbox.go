@@ -15,9 +15,11 @@
 package flow
 
 import (
+	"context"
 	"database/sql"
 	"errors"
 	"math"
+	"time"
 )
 
 // Mailbox is the message delivery destination for both action and
@@ -63,7 +65,7 @@ func (_Mailboxes) CountByUser(uid UserID, unread bool) (int64, error) {
 		q += `AND unread = 1`
 	}
 
-	row := db.QueryRow(q, uid)
+	row := db.QueryRow(rebind(q), uid)
 	var n int64
 	err := row.Scan(&n)
 	if err != nil {
@@ -90,7 +92,7 @@ func (_Mailboxes) CountByGroup(gid GroupID, unread bool) (int64, error) {
 		q += `AND unread = 1`
 	}
 
-	row := db.QueryRow(q, gid)
+	row := db.QueryRow(rebind(q), gid)
 	var n int64
 	err := row.Scan(&n)
 	if err != nil {
@@ -100,6 +102,13 @@ func (_Mailboxes) CountByGroup(gid GroupID, unread bool) (int64, error) {
 	return n, nil
 }
 
+// UnreadCount answers the number of unread messages in the given
+// group's virtual mailbox.  It is the same as `CountByGroup` with
+// `unread` set to `true`.
+func (_Mailboxes) UnreadCount(gid GroupID) (int64, error) {
+	return Mailboxes.CountByGroup(gid, true)
+}
+
 // ListByUser answers a list of the messages in the given user's
 // virtual mailbox, as per the given specification.
 //
@@ -118,10 +127,11 @@ func (_Mailboxes) ListByUser(uid UserID, offset, limit int64, unread bool) ([]*N
 	}
 
 	q := `
-	SELECT mbs.group_id, msgs.id, msgs.doctype_id, dtm.name, msgs.doc_id, msgs.docevent_id, msgs.title, msgs.data, mbs.unread, mbs.ctime
+	SELECT mbs.group_id, msgs.id, msgs.doctype_id, dtm.name, msgs.doc_id, msgs.docevent_id, msgs.workflow_id, de.docaction_id, msgs.title, msgs.data, mbs.unread, mbs.ctime
 	FROM wf_messages msgs
 	JOIN wf_mailboxes mbs ON mbs.message_id = msgs.id
 	JOIN wf_doctypes_master dtm ON dtm.id = msgs.doctype_id
+	JOIN wf_docevents de ON de.id = msgs.docevent_id
 	WHERE mbs.group_id = (
 		SELECT gm.id
 		FROM wf_groups_master gm
@@ -138,7 +148,7 @@ func (_Mailboxes) ListByUser(uid UserID, offset, limit int64, unread bool) ([]*N
 	LIMIT ? OFFSET ?
 	`
 
-	rows, err := db.Query(q, uid, limit, offset)
+	rows, err := db.Query(rebind(q), uid, limit, offset)
 	if err != nil {
 		return nil, err
 	}
@@ -149,10 +159,12 @@ func (_Mailboxes) ListByUser(uid UserID, offset, limit int64, unread bool) ([]*N
 		var elem Notification
 		err = rows.Scan(&elem.GroupID, &elem.Message.ID, &elem.Message.DocType.ID,
 			&elem.Message.DocType.Name, &elem.Message.DocID, &elem.Message.Event,
-			&elem.Message.Title, &elem.Message.Data, &elem.Unread, &elem.Ctime)
+			&elem.Message.Workflow, &elem.Message.Action, &elem.Message.Title,
+			&elem.Message.Data, &elem.Unread, &elem.Ctime)
 		if err != nil {
 			return nil, err
 		}
+		elem.Message.Ctime = elem.Ctime
 		ary = append(ary, &elem)
 	}
 	if err = rows.Err(); err != nil {
@@ -180,10 +192,11 @@ func (_Mailboxes) ListByGroup(gid GroupID, offset, limit int64, unread bool) ([]
 	}
 
 	q := `
-	SELECT mbs.group_id, msgs.id, msgs.doctype_id, dtm.name, msgs.doc_id, msgs.docevent_id, msgs.title, msgs.data, mbs.unread, mbs.ctime
+	SELECT mbs.group_id, msgs.id, msgs.doctype_id, dtm.name, msgs.doc_id, msgs.docevent_id, msgs.workflow_id, de.docaction_id, msgs.title, msgs.data, mbs.unread, mbs.ctime
 	FROM wf_messages msgs
 	JOIN wf_mailboxes mbs ON mbs.message_id = msgs.id
 	JOIN wf_doctypes_master dtm ON dtm.id = msgs.doctype_id
+	JOIN wf_docevents de ON de.id = msgs.docevent_id
 	WHERE mbs.group_id = ?
 	`
 	if unread {
@@ -194,7 +207,7 @@ func (_Mailboxes) ListByGroup(gid GroupID, offset, limit int64, unread bool) ([]
 	LIMIT ? OFFSET ?
 	`
 
-	rows, err := db.Query(q, gid, limit, offset)
+	rows, err := db.Query(rebind(q), gid, limit, offset)
 	if err != nil {
 		return nil, err
 	}
@@ -205,7 +218,119 @@ func (_Mailboxes) ListByGroup(gid GroupID, offset, limit int64, unread bool) ([]
 		var elem Notification
 		err = rows.Scan(&elem.GroupID, &elem.Message.ID, &elem.Message.DocType.ID,
 			&elem.Message.DocType.Name, &elem.Message.DocID, &elem.Message.Event,
-			&elem.Message.Title, &elem.Message.Data, &elem.Unread, &elem.Ctime)
+			&elem.Message.Workflow, &elem.Message.Action, &elem.Message.Title,
+			&elem.Message.Data, &elem.Unread, &elem.Ctime)
+		if err != nil {
+			return nil, err
+		}
+		elem.Message.Ctime = elem.Ctime
+		ary = append(ary, &elem)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return ary, nil
+}
+
+// ListForGroup answers the messages in the given group's virtual
+// mailbox, newest first, irrespective of their `unread` status.
+//
+// The first `offset` messages are skipped, and not more than `limit`
+// are answered.  A value of `0` for `limit` fetches until the end.
+func (_Mailboxes) ListForGroup(gid GroupID, offset, limit int64) ([]*Message, error) {
+	return Mailboxes.ListForGroupContext(context.Background(), gid, offset, limit)
+}
+
+// ListForGroupContext is the same as `ListForGroup`, but runs its
+// queries under the given context.
+func (_Mailboxes) ListForGroupContext(ctx context.Context, gid GroupID, offset, limit int64) ([]*Message, error) {
+	return Mailboxes.ListForGroupFilteredContext(ctx, gid, MessageFilter{}, offset, limit)
+}
+
+// MessageFilter specifies a set of conditions to narrow down the
+// messages listed from a mailbox.  Conditions with zero values are
+// not applied.
+type MessageFilter struct {
+	DocTypeID        // Messages on documents of this type are listed
+	DocumentID       // List messages on this document; needs `DocTypeID`
+	WorkflowID       // List messages posted in this workflow
+	DocActionID      // List messages of events with this action
+	Unread      bool // List only unread messages
+}
+
+// ListForGroupFiltered is the same as `ListForGroup`, except that only
+// the messages matching the given filter are answered.
+func (_Mailboxes) ListForGroupFiltered(gid GroupID, f MessageFilter, offset, limit int64) ([]*Message, error) {
+	return Mailboxes.ListForGroupFilteredContext(context.Background(), gid, f, offset, limit)
+}
+
+// ListForGroupFilteredContext is the same as `ListForGroupFiltered`,
+// but runs its queries under the given context.
+func (_Mailboxes) ListForGroupFilteredContext(ctx context.Context, gid GroupID, f MessageFilter, offset, limit int64) ([]*Message, error) {
+	if gid <= 0 {
+		return nil, errors.New("group ID should be a positive integer")
+	}
+	if offset < 0 || limit < 0 {
+		return nil, errors.New("offset and limit must be non-negative integers")
+	}
+	if limit == 0 {
+		limit = math.MaxInt64
+	}
+	if f.DocumentID > 0 && f.DocTypeID <= 0 {
+		return nil, errors.New("document filter requires a document type")
+	}
+
+	q := `
+	SELECT msgs.id, msgs.doctype_id, dtm.name, msgs.doc_id, msgs.docevent_id, msgs.workflow_id, de.docaction_id, msgs.title, msgs.data, mbs.ctime
+	FROM wf_messages msgs
+	JOIN wf_mailboxes mbs ON mbs.message_id = msgs.id
+	JOIN wf_doctypes_master dtm ON dtm.id = msgs.doctype_id
+	JOIN wf_docevents de ON de.id = msgs.docevent_id
+	WHERE mbs.group_id = ?
+	`
+	args := []interface{}{gid}
+
+	if f.DocTypeID > 0 {
+		q += `AND msgs.doctype_id = ?
+		`
+		args = append(args, f.DocTypeID)
+	}
+	if f.DocumentID > 0 {
+		q += `AND msgs.doc_id = ?
+		`
+		args = append(args, f.DocumentID)
+	}
+	if f.WorkflowID > 0 {
+		q += `AND msgs.workflow_id = ?
+		`
+		args = append(args, f.WorkflowID)
+	}
+	if f.DocActionID > 0 {
+		q += `AND de.docaction_id = ?
+		`
+		args = append(args, f.DocActionID)
+	}
+	if f.Unread {
+		q += `AND mbs.unread = 1
+		`
+	}
+
+	q += `ORDER BY mbs.ctime DESC, msgs.id DESC
+	LIMIT ? OFFSET ?
+	`
+	args = append(args, limit, offset)
+	rows, err := db.QueryContext(ctx, rebind(q), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ary := make([]*Message, 0, 10)
+	for rows.Next() {
+		var elem Message
+		err = rows.Scan(&elem.ID, &elem.DocType.ID, &elem.DocType.Name, &elem.DocID, &elem.Event,
+			&elem.Workflow, &elem.Action, &elem.Title, &elem.Data, &elem.Ctime)
 		if err != nil {
 			return nil, err
 		}
@@ -226,20 +351,26 @@ func (_Mailboxes) GetMessage(msgID MessageID) (*Notification, error) {
 	}
 
 	q := `
-	SELECT mbs.group_id, msgs.id, msgs.doctype_id, dtm.name, msgs.doc_id, msgs.docevent_id, msgs.title, msgs.data, mbs.unread, mbs.ctime
+	SELECT mbs.group_id, msgs.id, msgs.doctype_id, dtm.name, msgs.doc_id, msgs.docevent_id, msgs.workflow_id, de.docaction_id, msgs.title, msgs.data, mbs.unread, mbs.ctime
 	FROM wf_messages msgs
 	JOIN wf_mailboxes mbs ON mbs.message_id = msgs.id
 	JOIN wf_doctypes_master dtm ON dtm.id = msgs.doctype_id
+	JOIN wf_docevents de ON de.id = msgs.docevent_id
 	WHERE mbs.id = ?
 	`
-	row := db.QueryRow(q, msgID)
+	row := db.QueryRow(rebind(q), msgID)
 	var elem Notification
 	err := row.Scan(&elem.GroupID, &elem.Message.ID, &elem.Message.DocType.ID,
 		&elem.Message.DocType.Name, &elem.Message.DocID, &elem.Message.Event,
-		&elem.Message.Title, &elem.Message.Data, &elem.Unread, &elem.Ctime)
+		&elem.Message.Workflow, &elem.Message.Action, &elem.Message.Title,
+		&elem.Message.Data, &elem.Unread, &elem.Ctime)
 	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrNotFound
+		}
 		return nil, err
 	}
+	elem.Message.Ctime = elem.Ctime
 
 	return &elem, nil
 }
@@ -256,8 +387,9 @@ func (_Mailboxes) ReassignMessage(otx *sql.Tx, fgid, tgid GroupID, msgID Message
 	}
 
 	var tx *sql.Tx
+	var err error
 	if otx == nil {
-		tx, err := db.Begin()
+		tx, err = db.Begin()
 		if err != nil {
 			return err
 		}
@@ -271,7 +403,7 @@ func (_Mailboxes) ReassignMessage(otx *sql.Tx, fgid, tgid GroupID, msgID Message
 	WHERE group_id = ?
 	AND message_id = ?
 	`
-	_, err := tx.Exec(q, tgid, fgid, msgID)
+	_, err = tx.Exec(rebind(q), tgid, fgid, msgID)
 	if err != nil {
 		return err
 	}
@@ -294,8 +426,9 @@ func (_Mailboxes) SetStatusByUser(otx *sql.Tx, uid UserID, msgID MessageID, stat
 	}
 
 	var tx *sql.Tx
+	var err error
 	if otx == nil {
-		tx, err := db.Begin()
+		tx, err = db.Begin()
 		if err != nil {
 			return err
 		}
@@ -315,7 +448,7 @@ func (_Mailboxes) SetStatusByUser(otx *sql.Tx, uid UserID, msgID MessageID, stat
 	)
 	AND message_id = ?
 	`
-	_, err := tx.Exec(q, status, uid, msgID)
+	_, err = tx.Exec(rebind(q), status, uid, msgID)
 	if err != nil {
 		return err
 	}
@@ -338,8 +471,9 @@ func (_Mailboxes) SetStatusByGroup(otx *sql.Tx, gid GroupID, msgID MessageID, st
 	}
 
 	var tx *sql.Tx
+	var err error
 	if otx == nil {
-		tx, err := db.Begin()
+		tx, err = db.Begin()
 		if err != nil {
 			return err
 		}
@@ -353,7 +487,144 @@ func (_Mailboxes) SetStatusByGroup(otx *sql.Tx, gid GroupID, msgID MessageID, st
 	WHERE group_id = ?
 	AND message_id = ?
 	`
-	_, err := tx.Exec(q, status, gid, msgID)
+	_, err = tx.Exec(rebind(q), status, gid, msgID)
+	if err != nil {
+		return err
+	}
+
+	if otx == nil {
+		err = tx.Commit()
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// MarkRead marks the given message as read, in all the mailboxes into
+// which it was delivered.
+func (_Mailboxes) MarkRead(otx *sql.Tx, msgID MessageID) error {
+	return Mailboxes.MarkReadContext(context.Background(), otx, msgID)
+}
+
+// MarkReadContext is the same as `MarkRead`, but runs its queries
+// under the given context.
+func (_Mailboxes) MarkReadContext(ctx context.Context, otx *sql.Tx, msgID MessageID) error {
+	if msgID <= 0 {
+		return errors.New("message ID should be a positive integer")
+	}
+
+	q := `
+	UPDATE wf_mailboxes SET unread = 0
+	WHERE message_id = ?
+	`
+	return Mailboxes.markRead(ctx, otx, q, int64(msgID))
+}
+
+// MarkAllRead marks all the messages in the given group's virtual
+// mailbox as read.
+func (_Mailboxes) MarkAllRead(otx *sql.Tx, gid GroupID) error {
+	return Mailboxes.MarkAllReadContext(context.Background(), otx, gid)
+}
+
+// MarkAllReadContext is the same as `MarkAllRead`, but runs its
+// queries under the given context.
+func (_Mailboxes) MarkAllReadContext(ctx context.Context, otx *sql.Tx, gid GroupID) error {
+	if gid <= 0 {
+		return errors.New("group ID should be a positive integer")
+	}
+
+	q := `
+	UPDATE wf_mailboxes SET unread = 0
+	WHERE group_id = ?
+	AND unread = 1
+	`
+	return Mailboxes.markRead(ctx, otx, q, int64(gid))
+}
+
+// PurgeOlderThan removes the mailbox entries posted before the given
+// time, and answers the number removed.  Unread entries are kept,
+// irrespective of their age, if `keepUnread` is `true`.  Messages left
+// in no mailbox, and awaiting no asynchronous delivery, are deleted as
+// well.
+func (_Mailboxes) PurgeOlderThan(otx *sql.Tx, t time.Time, keepUnread bool) (int64, error) {
+	return Mailboxes.PurgeOlderThanContext(context.Background(), otx, t, keepUnread)
+}
+
+// PurgeOlderThanContext is the same as `PurgeOlderThan`, but runs its
+// queries under the given context.
+func (_Mailboxes) PurgeOlderThanContext(ctx context.Context, otx *sql.Tx, t time.Time, keepUnread bool) (int64, error) {
+	if t.IsZero() {
+		return 0, errors.New("purge time should be specified")
+	}
+
+	var tx *sql.Tx
+	var err error
+	if otx == nil {
+		tx, err = db.BeginTx(ctx, nil)
+		if err != nil {
+			return 0, err
+		}
+		defer tx.Rollback()
+	} else {
+		tx = otx
+	}
+
+	q := `DELETE FROM wf_mailboxes WHERE ctime < ?`
+	if keepUnread {
+		q += ` AND unread = 0`
+	}
+	res, err := tx.ExecContext(ctx, rebind(q), t)
+	if err != nil {
+		return 0, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+
+	q = `
+	DELETE FROM wf_messages
+	WHERE NOT EXISTS (
+		SELECT 1 FROM wf_mailboxes mbs
+		WHERE mbs.message_id = wf_messages.id
+	)
+	AND NOT EXISTS (
+		SELECT 1 FROM wf_message_outbox mo
+		WHERE mo.message_id = wf_messages.id
+	)
+	`
+	_, err = tx.ExecContext(ctx, rebind(q))
+	if err != nil {
+		return 0, err
+	}
+
+	if otx == nil {
+		err = tx.Commit()
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	return n, nil
+}
+
+// markRead runs the given update, which marks messages as read.
+func (_Mailboxes) markRead(ctx context.Context, otx *sql.Tx, q string, arg int64) error {
+	var tx *sql.Tx
+	var err error
+	if otx == nil {
+		tx, err = db.BeginTx(ctx, nil)
+		if err != nil {
+			return err
+		}
+		defer tx.Rollback()
+	} else {
+		tx = otx
+	}
+
+	_, err = tx.ExecContext(ctx, rebind(q), arg)
 	if err != nil {
 		return err
 	}
@@ -28,12 +28,15 @@ type MessageID int64
 // contains a reference to the document that began the current
 // workflow, as well as the event that triggered this message.
 type Message struct {
-	ID      MessageID        `json:"ID"` // Globally-unique identifier of this message
-	DocType `json:"DocType"` // Document type of the associated document
-	DocID   DocumentID       `json:"DocID"`    // Document in the workflow
-	Event   DocEventID       `json:"DocEvent"` // Event that triggered this message
-	Title   string           `json:"Title"`    // Subject of this message
-	Data    string           `json:"Data"`     // Body of this message
+	ID       MessageID        `json:"ID"` // Globally-unique identifier of this message
+	DocType  `json:"DocType"` // Document type of the associated document
+	DocID    DocumentID       `json:"DocID"`     // Document in the workflow
+	Event    DocEventID       `json:"DocEvent"`  // Event that triggered this message
+	Workflow WorkflowID       `json:"Workflow"`  // Workflow in which the event was applied
+	Action   DocActionID      `json:"DocAction"` // Action of the triggering event
+	Title    string           `json:"Title"`     // Subject of this message
+	Data     string           `json:"Data"`      // Body of this message
+	Ctime    time.Time        `json:"Ctime"`     // Time when this message was posted
 }
 
 // Notification tracks the 'unread' status of a message in a mailbox.
@@ -0,0 +1,55 @@
+// (c) Copyright 2015-2017 JONNALAGADDA Srinivas
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package flow
+
+import "time"
+
+// Metrics is implemented by consumers that wish to instrument the
+// application of events -- typically, by adapting it to Prometheus or
+// a similar system.
+//
+// N.B. Implementations must be safe for concurrent use, and should be
+// quick; they are invoked synchronously.
+type Metrics interface {
+	// IncTransition counts a successful application of the given
+	// action in the given workflow.
+	IncTransition(wid WorkflowID, action DocActionID)
+
+	// ObserveLatency records the wall time taken by a call to
+	// `ApplyEvent`, or a variant, in the given workflow, whether it
+	// succeeded or not.
+	ObserveLatency(wid WorkflowID, d time.Duration)
+}
+
+// nopMetrics discards all observations.  It is the default.
+type nopMetrics struct{}
+
+func (nopMetrics) IncTransition(WorkflowID, DocActionID)    {}
+func (nopMetrics) ObserveLatency(WorkflowID, time.Duration) {}
+
+// metrics is the metrics sink of the installed engine.
+var metrics Metrics = nopMetrics{}
+
+// WithMetrics specifies the sink to which `flow` reports transition
+// counts and latencies.  By default, nothing is reported.
+func WithMetrics(m Metrics) Option {
+	return func(e *Engine) error {
+		if m == nil {
+			m = nopMetrics{}
+		}
+		e.metrics = m
+		return nil
+	}
+}
@@ -15,9 +15,13 @@
 package flow
 
 import (
+	"context"
 	"database/sql"
 	"errors"
-	"log"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
 )
 
 // NodeID is the type of unique identifiers of nodes.
@@ -68,10 +72,16 @@ type Node struct {
 	nfunc    NodeFunc        // Processing function of this node
 }
 
+// Type answers the topology type of this node.
+func (n *Node) Type() NodeType {
+	return n.NodeType
+}
+
 // Transitions answers the possible document states into which a
-// document currently in the given state can transition.
+// document currently in the given state can transition, in this
+// node's version of its workflow.
 func (n *Node) Transitions() (map[DocActionID]DocStateID, error) {
-	return DocTypes._Transitions(n.DocType, n.State)
+	return DocTypes._Transitions(context.Background(), n.Wflow, n.State)
 }
 
 // SetFunc registers the given node function with this node.
@@ -95,49 +105,142 @@ func (n *Node) Func() NodeFunc {
 	return n.nfunc
 }
 
-// applyEvent checks to see if the given event can be applied
-// successfully.  Accordingly, it prepares a message by utilising the
-// registered node function, and posts it to applicable mailboxes.
-func (n *Node) applyEvent(otx *sql.Tx, event *DocEvent, recipients []GroupID) (DocStateID, error) {
-	ts, err := n.Transitions()
+// eventPlan describes the effect that applying an event would have
+// on its document.
+type eventPlan struct {
+	doc       *Document       // Document to which the event applies
+	tstate    DocStateID      // Target state of the document
+	tnode     *Node           // Node handling the target state
+	tacid     AccessContextID // Access context applicable in the target state
+	redundant bool            // Document is already in the target state
+	branch    bool            // Event applies to one of the document's parallel branches
+	forks     []*Node         // Nodes of the branches entered, when forking
+}
+
+// recipients adds the groups that should be notified of the planned
+// transition to the given set.  When forking, the groups of all the
+// branches entered are included.
+func (p *eventPlan) recipients(ctx context.Context, otx *sql.Tx, recv map[GroupID]struct{}, event *DocEvent) (map[GroupID]struct{}, error) {
+	if p.forks == nil {
+		return p.tnode.determineRecipients(ctx, otx, recv, p.doc, event, p.tacid)
+	}
+
+	var err error
+	for _, tn := range p.forks {
+		recv, err = tn.determineRecipients(ctx, otx, recv, p.doc, event, p.tacid)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return recv, nil
+}
+
+// planEvent checks to see if the given event can be applied
+// successfully, and determines its effect.  It does not alter
+// anything.
+func (n *Node) planEvent(ctx context.Context, otx *sql.Tx, event *DocEvent) (*eventPlan, error) {
+	targets, err := actionTargets(ctx, otx, n.Wflow, n.State, event.Action)
 	if err != nil {
-		return 0, err
+		return nil, err
+	}
+	if len(targets) == 0 {
+		return nil, &ErrNoTransition{State: n.State, Action: event.Action}
+	}
+
+	// Check document's current state.  A document that has forked
+	// is in each of its parallel branches, instead.
+	doc, err := Documents.GetContext(ctx, otx, event.DocType, event.DocID)
+	if err != nil {
+		return nil, err
+	}
+	branches, err := Documents.activeStates(ctx, otx, event.DocType, event.DocID)
+	if err != nil {
+		return nil, err
+	}
+	if len(branches) > 0 {
+		if !containsState(branches, event.State) {
+			return nil, ErrDocEventStateMismatch
+		}
+	} else if doc.State.ID != event.State {
+		return nil, ErrDocEventStateMismatch
+	}
+
+	// A fork enters all the targets of the action together.
+	if n.NodeType == NodeTypeFork {
+		if len(branches) > 0 {
+			return nil, errors.New("forks within parallel branches are not supported")
+		}
+		p := &eventPlan{doc: doc, tstate: n.State, tacid: doc.AccCtx.ID, forks: make([]*Node, 0, len(targets))}
+		for _, ts := range targets {
+			tn, err := Nodes.getByWorkflowState(ctx, otx, n.Wflow, ts)
+			if err != nil {
+				return nil, err
+			}
+			p.forks = append(p.forks, tn)
+		}
+		return p, nil
+	}
+
+	// Guards, if any, decide the target state.
+	tstate, ok, err := chooseTarget(n.DocType, n.State, event.Action, event.DocID, targets)
+	if err != nil {
+		return nil, err
 	}
-	tstate, ok := ts[event.Action]
 	if !ok {
-		return 0, ErrWorkflowInvalidAction
+		return nil, &ErrNoTransition{State: n.State, Action: event.Action}
 	}
 
-	// Check document's current state.
-	doc, err := Documents.Get(otx, event.DocType, event.DocID)
+	logger.Debugf("transition resolved for document %d/%d : %d --(%d)--> %d", event.DocType, event.DocID, n.State, event.Action, tstate)
+
+	p := &eventPlan{doc: doc, tstate: tstate, branch: len(branches) > 0}
+	if event.State == tstate {
+		p.redundant = true
+		return p, nil
+	}
+
+	p.tnode, err = Nodes.getByWorkflowState(ctx, otx, n.Wflow, tstate)
 	if err != nil {
-		return 0, err
+		return nil, err
 	}
-	if doc.State.ID != event.State {
-		return 0, ErrDocEventStateMismatch
+	p.tacid = p.tnode.AccCtx
+	if p.tacid == 0 {
+		p.tacid = doc.AccCtx.ID
 	}
 
+	return p, nil
+}
+
+// applyEvent checks to see if the given event can be applied
+// successfully.  Accordingly, it prepares a message by utilising the
+// registered node function, and posts it to applicable mailboxes.
+// Messages are neither prepared nor posted if `notify` is `false`.
+func (n *Node) applyEvent(ctx context.Context, otx *sql.Tx, event *DocEvent, recipients []GroupID, notify bool) (DocStateID, error) {
+	p, err := n.planEvent(ctx, otx, event)
+	if err != nil {
+		return 0, err
+	}
+	tstate, tnode := p.tstate, p.tnode
+
 	// Document has already transitioned.  So, we note that the event
 	// is applied, and return.
 	//
 	// N.B. This has implications for `NodeTypeJoinAny` below.  Should
 	// you alter this logic or its position, verify that the
 	// corresponding logic in the switch below is in coherence.
-	if doc.State.ID == tstate {
-		err = n.recordEvent(otx, event, tstate, true)
+	if p.redundant {
+		err = n.recordEvent(ctx, otx, event, tstate, true)
 		if err != nil {
 			return 0, err
 		}
 		return tstate, ErrDocEventRedundant
 	}
 
-	// Transition document state according to the target node type.
-
-	tnode, err := Nodes.GetByState(n.DocType, tstate)
-	if err != nil {
-		return 0, err
+	if p.forks != nil {
+		return n.applyFork(ctx, otx, event, p, recipients, notify)
 	}
 
+	// Transition document state according to the target node type.
+
 	switch tnode.NodeType {
 	case NodeTypeJoinAny:
 		// Multiple 'in's, but any one suffices.
@@ -147,71 +250,171 @@ func (n *Node) applyEvent(otx *sql.Tx, event *DocEvent, recipients []GroupID) (D
 		// far, the event can be applied.
 		fallthrough
 
-	case NodeTypeBegin, NodeTypeEnd, NodeTypeLinear, NodeTypeBranch:
+	case NodeTypeBegin, NodeTypeEnd, NodeTypeLinear, NodeTypeBranch, NodeTypeFork:
 		// Any node type having a single 'in'.
 
-		// Update the document to transition the state.
-		tacid := tnode.AccCtx
-		if tacid == 0 {
-			tacid = doc.AccCtx.ID
+		// Update the document to transition the state.  Within a
+		// parallel branch, only that branch moves.
+		tacid := p.tacid
+		if p.branch {
+			err = Documents.moveBranch(ctx, otx, event.DocType, event.DocID, event.State, tstate)
+		} else {
+			err = Documents.setState(ctx, otx, event.DocType, event.DocID, tstate, tacid, p.doc.Version)
 		}
-		err = Documents.setState(otx, event.DocType, event.DocID, tstate, tacid)
 		if err != nil {
 			return 0, err
 		}
 
 		// Record event application.
-		err = n.recordEvent(otx, event, tstate, false)
+		err = n.recordEvent(ctx, otx, event, tstate, false)
 		if err != nil {
 			return 0, err
 		}
-
-		// Post messages.
-		recv := make(map[GroupID]struct{})
-		for _, gid := range recipients {
-			recv[gid] = struct{}{}
+		err = writeAudit(ctx, otx, event, tstate)
+		if err != nil {
+			return 0, err
 		}
-		msg := n.nfunc(doc, event)
-		recv, err = tnode.determineRecipients(otx, recv, doc, event, tacid)
+		err = runPostAction(otx, event, n.State, tstate)
 		if err != nil {
 			return 0, err
 		}
-		// It is legal to not have any recipients, too.
-		if len(recv) > 0 {
-			err = n.postMessage(otx, msg, recv)
-			if err != nil {
-				return 0, err
-			}
+
+		if !notify {
+			break
+		}
+
+		err = n.notify(ctx, otx, p, event, recipients)
+		if err != nil {
+			return 0, err
 		}
 
 	case NodeTypeJoinAll:
 		// Multiple 'in's, and all are required.
 
-		// TODO(js)
+		// A document that has not forked arrives by its only 'in'.
+		waiting := false
+		if p.branch {
+			waiting, err = Documents.joinBranch(ctx, otx, event.DocType, event.DocID, event.State, tstate, p.tacid, p.doc.Version)
+		} else {
+			err = Documents.setState(ctx, otx, event.DocType, event.DocID, tstate, p.tacid, p.doc.Version)
+		}
+		if err != nil {
+			return 0, err
+		}
+
+		err = n.recordEvent(ctx, otx, event, tstate, false)
+		if err != nil {
+			return 0, err
+		}
+		err = writeAudit(ctx, otx, event, tstate)
+		if err != nil {
+			return 0, err
+		}
+		err = runPostAction(otx, event, n.State, tstate)
+		if err != nil {
+			return 0, err
+		}
+
+		// Notifications wait for the last branch.
+		if waiting {
+			return DocStateJoinWaiting, nil
+		}
+		if !notify {
+			break
+		}
+
+		err = n.notify(ctx, otx, p, event, recipients)
+		if err != nil {
+			return 0, err
+		}
 
 	default:
-		log.Panicf("unknown node type encountered : %s\n", tnode.NodeType)
+		panic(fmt.Sprintf("unknown node type encountered : %s", tnode.NodeType))
 	}
 
 	return tstate, nil
 }
 
+// applyFork enters the document into all the branches of the given
+// plan.  The document itself remains in the fork's state, which is
+// answered.
+func (n *Node) applyFork(ctx context.Context, otx *sql.Tx, event *DocEvent, p *eventPlan, recipients []GroupID, notify bool) (DocStateID, error) {
+	states := make([]DocStateID, 0, len(p.forks))
+	for _, tn := range p.forks {
+		states = append(states, tn.State)
+	}
+
+	err := Documents.enterBranches(ctx, otx, event.DocType, event.DocID, n.State, states)
+	if err != nil {
+		return 0, err
+	}
+	err = n.recordEvent(ctx, otx, event, n.State, false)
+	if err != nil {
+		return 0, err
+	}
+	for _, ds := range states {
+		err = writeAudit(ctx, otx, event, ds)
+		if err != nil {
+			return 0, err
+		}
+		err = runPostAction(otx, event, n.State, ds)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	if notify {
+		err = n.notify(ctx, otx, p, event, recipients)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	return n.State, nil
+}
+
+// notify prepares a message for the planned transition, and posts it
+// to the given recipients together with those that the registered
+// recipient resolver and the target nodes determine.
+func (n *Node) notify(ctx context.Context, otx *sql.Tx, p *eventPlan, event *DocEvent, recipients []GroupID) error {
+	recv := make(map[GroupID]struct{})
+	for _, gid := range recipients {
+		recv[gid] = struct{}{}
+	}
+	err := resolveRecipients(otx, event, recv)
+	if err != nil {
+		return err
+	}
+	msg := n.nfunc(p.doc, event)
+	msg.Action = event.Action
+	recv, err = p.recipients(ctx, otx, recv, event)
+	if err != nil {
+		return err
+	}
+	// It is legal to not have any recipients, too.
+	if len(recv) == 0 {
+		return nil
+	}
+
+	return n.postMessage(ctx, otx, msg, recv)
+}
+
 // recordEvent writes a record stating that the given event has
 // successfully been applied to effect a document state transition.
-func (n *Node) recordEvent(otx *sql.Tx, event *DocEvent, tstate DocStateID, statusOnly bool) error {
+func (n *Node) recordEvent(ctx context.Context, otx *sql.Tx, event *DocEvent, tstate DocStateID, statusOnly bool) error {
 	if !statusOnly {
 		q := `
 		INSERT INTO wf_docevent_application(doctype_id, doc_id, from_state_id, docevent_id, to_state_id)
 		VALUES(?, ?, ?, ?, ?)
 		`
-		_, err := otx.Exec(q, event.DocType, event.DocID, event.State, event.ID, tstate)
+		_, err := otx.ExecContext(ctx, rebind(q), event.DocType, event.DocID, event.State, event.ID, tstate)
 		if err != nil {
 			return err
 		}
 	}
 
 	q := `UPDATE wf_docevents SET status = 'A' WHERE id = ?`
-	_, err := otx.Exec(q, event.ID)
+	_, err := otx.ExecContext(ctx, rebind(q), event.ID)
 	if err != nil {
 		return err
 	}
@@ -222,7 +425,7 @@ func (n *Node) recordEvent(otx *sql.Tx, event *DocEvent, tstate DocStateID, stat
 // determineRecipients takes the document type and access context into
 // account, and determines the list of groups to which the
 // notification should be posted.
-func (n *Node) determineRecipients(otx *sql.Tx, recv map[GroupID]struct{}, doc *Document,
+func (n *Node) determineRecipients(ctx context.Context, otx *sql.Tx, recv map[GroupID]struct{}, doc *Document,
 	event *DocEvent, acid AccessContextID) (map[GroupID]struct{}, error) {
 	// We have to notify reporting authorities.
 	q := `
@@ -233,7 +436,7 @@ func (n *Node) determineRecipients(otx *sql.Tx, recv map[GroupID]struct{}, doc *
 	ORDER BY group_id
 	LIMIT 1
 	`
-	rows, err := otx.Query(q, acid, event.Group)
+	rows, err := otx.QueryContext(ctx, rebind(q), acid, event.Group)
 	if err != nil {
 		return nil, err
 	}
@@ -258,7 +461,7 @@ func (n *Node) determineRecipients(otx *sql.Tx, recv map[GroupID]struct{}, doc *
 	WHERE doctype_id = ?
 	AND doc_id = ?
 	`
-	rows2, err := otx.Query(q2, doc.DocType.ID, doc.ID)
+	rows2, err := otx.QueryContext(ctx, rebind(q2), doc.DocType.ID, doc.ID)
 	if err != nil {
 		return nil, err
 	}
@@ -281,33 +484,70 @@ func (n *Node) determineRecipients(otx *sql.Tx, recv map[GroupID]struct{}, doc *
 
 // postMessage posts the given message into the mailboxes of the
 // specified recipients.
-func (n *Node) postMessage(otx *sql.Tx, msg *Message, recv map[GroupID]struct{}) error {
+func (n *Node) postMessage(ctx context.Context, otx *sql.Tx, msg *Message, recv map[GroupID]struct{}) error {
 	// Record the message.
 
 	q := `
-	INSERT INTO wf_messages(doctype_id, doc_id, docevent_id, title, data)
-	VALUES(?, ?, ?, ?, ?)
+	INSERT INTO wf_messages(doctype_id, doc_id, docevent_id, workflow_id, title, data)
+	VALUES(?, ?, ?, ?, ?, ?)
 	`
-	res, err := otx.Exec(q, msg.DocType.ID, msg.DocID, msg.Event, msg.Title, msg.Data)
+	msgid, err := execInsert(ctx, otx, q, msg.DocType.ID, msg.DocID, msg.Event, n.Wflow, msg.Title, msg.Data)
 	if err != nil {
 		return err
 	}
-	var msgid int64
-	if msgid, err = res.LastInsertId(); err != nil {
+
+	// Post it into applicable mailboxes, or into the outbox when
+	// delivery is asynchronous.
+
+	gids := make([]GroupID, 0, len(recv))
+	for gid := range recv {
+		gids = append(gids, gid)
+	}
+	sort.Slice(gids, func(i, j int) bool { return gids[i] < gids[j] })
+	err = insertPosts(ctx, otx, asyncMessages, msgid, gids, mailboxBatchSize)
+	if err != nil {
 		return err
 	}
 
-	// Post it into applicable mailboxes.
+	logger.Debugf("message %d for document %d/%d posted to %d mailboxes", msgid, msg.DocType.ID, msg.DocID, len(recv))
+	msg.ID = MessageID(msgid)
+	msg.Workflow = n.Wflow
+	msg.Ctime = time.Now().UTC()
+	enqueue(ctx, msg, recv)
+	return nil
+}
 
-	q = `
-	INSERT INTO wf_mailboxes(group_id, message_id, unread, ctime)
-	VALUES(?, ?, 1, NOW())
-	`
-	for gid := range recv {
-		res, err = otx.Exec(q, gid, msgid)
+// mailboxBatchSize is the largest number of recipients to which a
+// message is posted by a single statement.
+const mailboxBatchSize = 100
+
+// insertPosts posts the given message to the given groups -- into
+// their mailboxes, or into the outbox if `outbox` is `true` -- through
+// multi-row inserts of at most `batch` rows each.
+func insertPosts(ctx context.Context, otx *sql.Tx, outbox bool, msgid int64, gids []GroupID, batch int) error {
+	head := `INSERT INTO wf_mailboxes(group_id, message_id, unread, ctime) VALUES`
+	row := `(?, ?, 1, NOW())`
+	if outbox {
+		head = `INSERT INTO wf_message_outbox(group_id, message_id, ctime) VALUES`
+		row = `(?, ?, NOW())`
+	}
+
+	for len(gids) > 0 {
+		n := len(gids)
+		if n > batch {
+			n = batch
+		}
+
+		q := head + ` ` + row + strings.Repeat(`, `+row, n-1)
+		args := make([]interface{}, 0, 2*n)
+		for _, gid := range gids[:n] {
+			args = append(args, gid, msgid)
+		}
+		_, err := otx.ExecContext(ctx, rebind(q), args...)
 		if err != nil {
 			return err
 		}
+		gids = gids[n:]
 	}
 
 	return nil
@@ -320,14 +560,22 @@ type _Nodes struct{}
 // this system.
 var Nodes _Nodes
 
-// List answers a list of the nodes comprising the given workflow.
+// List answers a list of the nodes comprising the given workflow,
+// ordered by their document states.
 func (_Nodes) List(id WorkflowID) ([]*Node, error) {
+	return Nodes.ListContext(context.Background(), id)
+}
+
+// ListContext is the same as `List`, but runs its queries under the
+// given context.
+func (_Nodes) ListContext(ctx context.Context, id WorkflowID) ([]*Node, error) {
 	q := `
-	SELECT id, doctype_id, docstate_id, workflow_id, name, type
+	SELECT id, doctype_id, docstate_id, ac_id, workflow_id, name, type
 	FROM wf_workflow_nodes
 	WHERE workflow_id = ?
+	ORDER BY docstate_id
 	`
-	rows, err := db.Query(q, id)
+	rows, err := db.QueryContext(ctx, rebind(q), id)
 	if err != nil {
 		return nil, err
 	}
@@ -336,10 +584,14 @@ func (_Nodes) List(id WorkflowID) ([]*Node, error) {
 	ary := make([]*Node, 0, 5)
 	for rows.Next() {
 		var elem Node
-		err = rows.Scan(&elem.ID, &elem.DocType, &elem.State, &elem.Wflow, &elem.Name, &elem.NodeType)
+		var acID sql.NullInt64
+		err = rows.Scan(&elem.ID, &elem.DocType, &elem.State, &acID, &elem.Wflow, &elem.Name, &elem.NodeType)
 		if err != nil {
 			return nil, err
 		}
+		if acID.Valid {
+			elem.AccCtx = AccessContextID(acID.Int64)
+		}
 		elem.nfunc = defNodeFunc
 		ary = append(ary, &elem)
 	}
@@ -352,6 +604,12 @@ func (_Nodes) List(id WorkflowID) ([]*Node, error) {
 
 // Get retrieves the requested node from the database.
 func (_Nodes) Get(id NodeID) (*Node, error) {
+	return Nodes.GetContext(context.Background(), id)
+}
+
+// GetContext is the same as `Get`, but runs its queries under the
+// given context.
+func (_Nodes) GetContext(ctx context.Context, id NodeID) (*Node, error) {
 	if id <= 0 {
 		return nil, errors.New("node ID must be a positive integer")
 	}
@@ -363,9 +621,12 @@ func (_Nodes) Get(id NodeID) (*Node, error) {
 	FROM wf_workflow_nodes
 	WHERE id = ?
 	`
-	row := db.QueryRow(q, id)
+	row := db.QueryRowContext(ctx, rebind(q), id)
 	err := row.Scan(&elem.ID, &elem.DocType, &elem.State, &acID, &elem.Wflow, &elem.Name, &elem.NodeType)
 	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrNotFound
+		}
 		return nil, err
 	}
 	if acID.Valid {
@@ -377,19 +638,80 @@ func (_Nodes) Get(id NodeID) (*Node, error) {
 }
 
 // GetByState retrieves the requested node from the database, as per
-// the document state specification.
+// the document state specification.  The node is looked up in the
+// latest version of the document type's workflow.
 func (_Nodes) GetByState(dtype DocTypeID, state DocStateID) (*Node, error) {
+	return Nodes.GetByStateContext(context.Background(), dtype, state)
+}
+
+// GetByStateContext is the same as `GetByState`, but runs its queries
+// under the given context.
+func (_Nodes) GetByStateContext(ctx context.Context, dtype DocTypeID, state DocStateID) (*Node, error) {
+	var elem Node
+	var acID sql.NullInt64
+	q := `
+	SELECT wn.id, wn.doctype_id, wn.docstate_id, wn.ac_id, wn.workflow_id, wn.name, wn.type
+	FROM wf_workflow_nodes wn
+	JOIN wf_workflows wf ON wf.id = wn.workflow_id
+	WHERE wn.doctype_id = ?
+	AND wn.docstate_id = ?
+	ORDER BY wf.version DESC
+	LIMIT 1
+	`
+	row := db.QueryRowContext(ctx, rebind(q), dtype, state)
+	err := row.Scan(&elem.ID, &elem.DocType, &elem.State, &acID, &elem.Wflow, &elem.Name, &elem.NodeType)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	if acID.Valid {
+		elem.AccCtx = AccessContextID(acID.Int64)
+	}
+
+	elem.nfunc = defNodeFunc
+	return &elem, nil
+}
+
+// getByWorkflowState retrieves the node of the given workflow version
+// that handles the given document state, within the given transaction,
+// if any.
+//
+// The node cache, if enabled, is consulted only outside of the
+// caller's transactions, and for workflow versions that no transaction
+// is altering.  It is filled through the database handle rather than
+// the transaction, whose snapshot may predate changes committed since.
+func (_Nodes) getByWorkflowState(ctx context.Context, otx *sql.Tx, wid WorkflowID, state DocStateID) (*Node, error) {
+	cached := nodeCache != nil && (otx == nil || ownedTx(otx)) && !nodeCache.isHeld(wid)
+	var gen uint64
+	if cached {
+		var n *Node
+		var ok bool
+		if n, gen, ok = nodeCache.get(wid, state); ok {
+			return n, nil
+		}
+	}
+
 	var elem Node
 	var acID sql.NullInt64
 	q := `
 	SELECT id, doctype_id, docstate_id, ac_id, workflow_id, name, type
 	FROM wf_workflow_nodes
-	WHERE doctype_id = ?
+	WHERE workflow_id = ?
 	AND docstate_id = ?
 	`
-	row := db.QueryRow(q, dtype, state)
+	var row *sql.Row
+	if cached || otx == nil {
+		row = db.QueryRowContext(ctx, rebind(q), wid, state)
+	} else {
+		row = otx.QueryRowContext(ctx, rebind(q), wid, state)
+	}
 	err := row.Scan(&elem.ID, &elem.DocType, &elem.State, &acID, &elem.Wflow, &elem.Name, &elem.NodeType)
 	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrNotFound
+		}
 		return nil, err
 	}
 	if acID.Valid {
@@ -397,5 +719,8 @@ func (_Nodes) GetByState(dtype DocTypeID, state DocStateID) (*Node, error) {
 	}
 
 	elem.nfunc = defNodeFunc
+	if cached {
+		nodeCache.put(&elem, gen)
+	}
 	return &elem, nil
 }
@@ -24,26 +24,44 @@ const (
 	// NodeTypeBegin : none incoming, one outgoing
 	NodeTypeBegin NodeType = "begin"
 	// NodeTypeEnd : one incoming, none outgoing
-	NodeTypeEnd = "end"
+	NodeTypeEnd NodeType = "end"
 	// NodeTypeLinear : one incoming, one outgoing
-	NodeTypeLinear = "linear"
+	NodeTypeLinear NodeType = "linear"
 	// NodeTypeBranch : one incoming, two or more outgoing
-	NodeTypeBranch = "branch"
+	NodeTypeBranch NodeType = "branch"
 	// NodeTypeJoinAny : two or more incoming, one outgoing
-	NodeTypeJoinAny = "joinany"
+	NodeTypeJoinAny NodeType = "joinany"
 	// NodeTypeJoinAll : two or more incoming, one outgoing
-	NodeTypeJoinAll = "joinall"
+	NodeTypeJoinAll NodeType = "joinall"
+	// NodeTypeFork : one incoming, two or more outgoing, all taken in parallel
+	NodeTypeFork NodeType = "fork"
 )
 
-// IsValidNodeType answers `true` if the given node type is a
-// recognised node type in the system.
-func IsValidNodeType(ntype string) bool {
-	nt := NodeType(ntype)
+// NodeTypeJoin is the counterpart of `NodeTypeFork` : its outbound
+// transitions become available only after all the parallel branches
+// of the document have arrived.  It is stored as `NodeTypeJoinAll`.
+const NodeTypeJoin = NodeTypeJoinAll
+
+// String answers the representation of this node type, as stored in
+// the database.
+func (nt NodeType) String() string {
+	return string(nt)
+}
+
+// IsValid answers `true` if this node type is a recognised node type
+// in the system.
+func (nt NodeType) IsValid() bool {
 	switch nt {
-	case NodeTypeBegin, NodeTypeEnd, NodeTypeLinear, NodeTypeBranch, NodeTypeJoinAny, NodeTypeJoinAll:
+	case NodeTypeBegin, NodeTypeEnd, NodeTypeLinear, NodeTypeBranch, NodeTypeJoinAny, NodeTypeJoinAll, NodeTypeFork:
 		return true
 
 	default:
 		return false
 	}
 }
+
+// IsValidNodeType answers `true` if the given node type is a
+// recognised node type in the system.
+func IsValidNodeType(ntype string) bool {
+	return NodeType(ntype).IsValid()
+}
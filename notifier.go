@@ -0,0 +1,261 @@
+// (c) Copyright 2015-2017 JONNALAGADDA Srinivas
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package flow
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Notifier is implemented by consumers that deliver the messages
+// posted by `flow` elsewhere -- to a webhook, a chat channel, e-mail,
+// etc. -- in addition to the mailboxes of the recipients.
+//
+// Notifiers are invoked only after the transition that posted the
+// message is committed, so that no one is notified of a transition
+// that was rolled back.  They are invoked on a goroutine of their own,
+// so that the caller is not held up by them; the messages of each
+// transition are delivered in order.  Errors answered by notifiers are
+// logged, but do not undo the transition.
+type Notifier interface {
+	Notify(ctx context.Context, msg *Message, recipients []GroupID) error
+}
+
+// notifiers holds the registered notifiers, in their order of
+// registration.
+var notifiers struct {
+	sync.RWMutex
+	list []Notifier
+}
+
+// RegisterNotifier adds the given notifier to those that are invoked
+// for each message posted.  Notifiers are invoked in the order of
+// their registration.
+//
+// When a transaction begun through `WithTx` is given, notifiers are
+// invoked once it commits, and not at all should it roll back.  Any
+// other transaction given by the caller cannot be seen to commit;
+// notifiers are then invoked as the method returns, and hence before
+// the caller commits.
+//
+// As with hooks, registration is safe while events are being applied,
+// but is expected to happen during initialisation.
+func (_Workflows) RegisterNotifier(n Notifier) {
+	if n == nil {
+		return
+	}
+
+	notifiers.Lock()
+	notifiers.list = append(notifiers.list, n)
+	notifiers.Unlock()
+}
+
+// delivery is a single posted message, awaiting delivery to the
+// registered notifiers.
+type delivery struct {
+	msg        *Message
+	recipients []GroupID
+}
+
+// outbox collects the messages posted during a transaction, so that
+// they can be delivered once it commits.
+type outbox struct {
+	list []delivery
+}
+
+// outboxKey is the context key under which the outbox of the current
+// transaction is carried.
+type outboxKey struct{}
+
+// withOutbox answers a context that carries a new, empty outbox.
+func withOutbox(ctx context.Context) (context.Context, *outbox) {
+	ob := &outbox{}
+	return context.WithValue(ctx, outboxKey{}, ob), ob
+}
+
+// enqueue adds the given message to the outbox carried by the given
+// context, if any.
+func enqueue(ctx context.Context, msg *Message, recv map[GroupID]struct{}) {
+	ob, ok := ctx.Value(outboxKey{}).(*outbox)
+	if !ok {
+		return
+	}
+
+	gids := make([]GroupID, 0, len(recv))
+	for gid := range recv {
+		gids = append(gids, gid)
+	}
+	sort.Slice(gids, func(i, j int) bool { return gids[i] < gids[j] })
+	ob.list = append(ob.list, delivery{msg, gids})
+}
+
+// messageIDs answers the identifiers of the messages in this outbox,
+// in order.
+func (ob *outbox) messageIDs() []MessageID {
+	ids := make([]MessageID, 0, len(ob.list))
+	for _, d := range ob.list {
+		ids = append(ids, d.msg.ID)
+	}
+	return ids
+}
+
+// deliver hands the messages in this outbox to the registered
+// notifiers, on a goroutine of its own, so that slow notifiers do not
+// hold up the caller.  The engine's `Close` waits for such deliveries.
+// Once the engine is closing, the messages are delivered before
+// answering, instead.
+func (ob *outbox) deliver(ctx context.Context) {
+	if ob == nil || len(ob.list) == 0 {
+		return
+	}
+
+	done, err := engine.track()
+	if err != nil {
+		ob.send(ctx)
+		return
+	}
+	go func() {
+		defer done()
+		ob.send(context.WithoutCancel(ctx))
+	}()
+}
+
+// send invokes the registered notifiers for each message in this
+// outbox, in order.
+func (ob *outbox) send(ctx context.Context) {
+	notifiers.RLock()
+	list := notifiers.list
+	notifiers.RUnlock()
+
+	for _, d := range ob.list {
+		for _, n := range list {
+			err := n.Notify(ctx, d.msg, d.recipients)
+			if err != nil {
+				logger.Errorf("notifier failed for message %d of document %d/%d : %v", d.msg.ID, d.msg.DocType.ID, d.msg.DocID, err)
+			}
+		}
+	}
+}
+
+// WebhookNotifier delivers messages by POSTing them, as JSON, to a
+// configured URL.  The payload is an object with the members
+// `Message` and `Recipients`.
+//
+// Failed deliveries -- transport errors and `5xx` responses -- are
+// retried, with exponential backoff.  Other responses outside `2xx`
+// are not retried.
+type WebhookNotifier struct {
+	URL         string        // Destination of the POST requests
+	Client      *http.Client  // Client used; `http.DefaultClient` if `nil`
+	Timeout     time.Duration // Time allowed for each attempt
+	MaxAttempts int           // Number of attempts per message
+	Backoff     time.Duration // Delay before the first retry
+}
+
+// NewWebhookNotifier answers a webhook notifier for the given URL,
+// with a timeout of 5 seconds per attempt, and up to 3 attempts.
+func NewWebhookNotifier(url string) *WebhookNotifier {
+	return &WebhookNotifier{
+		URL:         url,
+		Timeout:     5 * time.Second,
+		MaxAttempts: 3,
+		Backoff:     500 * time.Millisecond,
+	}
+}
+
+// webhookPayload is the body POSTed by `WebhookNotifier`.
+type webhookPayload struct {
+	Message    *Message  `json:"Message"`
+	Recipients []GroupID `json:"Recipients"`
+}
+
+// Notify POSTs the given message to the configured URL.
+func (wn *WebhookNotifier) Notify(ctx context.Context, msg *Message, recipients []GroupID) error {
+	if wn.URL == "" {
+		return errors.New("webhook URL should not be empty")
+	}
+	body, err := json.Marshal(&webhookPayload{Message: msg, Recipients: recipients})
+	if err != nil {
+		return err
+	}
+
+	attempts := wn.MaxAttempts
+	if attempts < 1 {
+		attempts = 1
+	}
+	delay := wn.Backoff
+	for attempt := 1; ; attempt++ {
+		var retry bool
+		retry, err = wn.post(ctx, body)
+		if err == nil || !retry || attempt >= attempts {
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+
+		case <-time.After(delay):
+			delay *= 2
+		}
+	}
+}
+
+// post makes a single attempt at delivering the given body.  It
+// answers `true` if a failed attempt may be retried.
+func (wn *WebhookNotifier) post(ctx context.Context, body []byte) (bool, error) {
+	if wn.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, wn.Timeout)
+		defer cancel()
+	}
+
+	req, err := http.NewRequest(http.MethodPost, wn.URL, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/json")
+
+	client := wn.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+
+	switch {
+	case resp.StatusCode >= 200 && resp.StatusCode < 300:
+		return false, nil
+
+	case resp.StatusCode >= 500:
+		return true, fmt.Errorf("webhook answered : %s", resp.Status)
+
+	default:
+		return false, fmt.Errorf("webhook answered : %s", resp.Status)
+	}
+}
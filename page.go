@@ -0,0 +1,168 @@
+// (c) Copyright 2015-2017 JONNALAGADDA Srinivas
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package flow
+
+import (
+	"context"
+	"errors"
+	"math"
+)
+
+// PageInfo describes a page of a listing, within the whole of it.
+type PageInfo struct {
+	Total      int64 `json:"Total"`      // Number of items in the whole listing
+	NextOffset int64 `json:"NextOffset"` // Offset at which the next page begins
+	HasMore    bool  `json:"HasMore"`    // Are there items beyond this page?
+}
+
+// newPageInfo answers the description of a page of `n` items, that
+// begins at the given offset in a listing of `total` items.
+func newPageInfo(offset int64, n int, total int64) PageInfo {
+	next := offset + int64(n)
+	return PageInfo{Total: total, NextOffset: next, HasMore: next < total}
+}
+
+// WorkflowPage is a page of a listing of workflows.
+type WorkflowPage struct {
+	Items []*Workflow `json:"Items"`
+	PageInfo
+}
+
+// MessagePage is a page of a listing of messages.
+type MessagePage struct {
+	Items []*Message `json:"Items"`
+	PageInfo
+}
+
+// ListPage is the same as `List`, except that the page of workflows is
+// answered together with the total number of workflows, and where the
+// next page begins.  Both are read in the same query as the page.
+func (_Workflows) ListPage(offset, limit int64) (*WorkflowPage, error) {
+	return Workflows.ListPageContext(context.Background(), offset, limit)
+}
+
+// ListPageContext is the same as `ListPage`, but runs its queries
+// under the given context.
+func (_Workflows) ListPageContext(ctx context.Context, offset, limit int64) (*WorkflowPage, error) {
+	if offset < 0 || limit < 0 {
+		return nil, errors.New("offset and limit must be non-negative integers")
+	}
+	if limit == 0 {
+		limit = math.MaxInt64
+	}
+
+	q := `
+	SELECT wf.id, wf.name, dtm.id, dtm.name, dsm.id, dsm.name, wf.active, wf.version,
+		(SELECT COUNT(*) FROM wf_workflows)
+	FROM wf_workflows wf
+	JOIN wf_doctypes_master dtm ON wf.doctype_id = dtm.id
+	JOIN wf_docstates_master dsm ON wf.docstate_id = dsm.id
+	ORDER BY wf.id
+	LIMIT ? OFFSET ?
+	`
+	rows, err := db.QueryContext(ctx, rebind(q), limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var total int64
+	ary := make([]*Workflow, 0, 10)
+	for rows.Next() {
+		var elem Workflow
+		err = rows.Scan(&elem.ID, &elem.Name, &elem.DocType.ID, &elem.DocType.Name,
+			&elem.BeginState.ID, &elem.BeginState.Name, &elem.Active, &elem.Version, &total)
+		if err != nil {
+			return nil, err
+		}
+		ary = append(ary, &elem)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	// A page beyond the end has no row to carry the total.
+	if len(ary) == 0 && offset > 0 {
+		total, err = Workflows.CountContext(ctx)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return &WorkflowPage{Items: ary, PageInfo: newPageInfo(offset, len(ary), total)}, nil
+}
+
+// ListPageForGroup is the same as `ListForGroup`, except that the page
+// of messages is answered together with the total number of messages
+// in the mailbox, and where the next page begins.  Both are read in
+// the same query as the page.
+func (_Mailboxes) ListPageForGroup(gid GroupID, offset, limit int64) (*MessagePage, error) {
+	return Mailboxes.ListPageForGroupContext(context.Background(), gid, offset, limit)
+}
+
+// ListPageForGroupContext is the same as `ListPageForGroup`, but runs
+// its queries under the given context.
+func (_Mailboxes) ListPageForGroupContext(ctx context.Context, gid GroupID, offset, limit int64) (*MessagePage, error) {
+	if gid <= 0 {
+		return nil, errors.New("group ID should be a positive integer")
+	}
+	if offset < 0 || limit < 0 {
+		return nil, errors.New("offset and limit must be non-negative integers")
+	}
+	if limit == 0 {
+		limit = math.MaxInt64
+	}
+
+	q := `
+	SELECT msgs.id, msgs.doctype_id, dtm.name, msgs.doc_id, msgs.docevent_id, msgs.workflow_id, de.docaction_id, msgs.title, msgs.data, mbs.ctime,
+		(SELECT COUNT(*) FROM wf_mailboxes WHERE group_id = ?)
+	FROM wf_messages msgs
+	JOIN wf_mailboxes mbs ON mbs.message_id = msgs.id
+	JOIN wf_doctypes_master dtm ON dtm.id = msgs.doctype_id
+	JOIN wf_docevents de ON de.id = msgs.docevent_id
+	WHERE mbs.group_id = ?
+	ORDER BY mbs.ctime DESC, msgs.id DESC
+	LIMIT ? OFFSET ?
+	`
+	rows, err := db.QueryContext(ctx, rebind(q), gid, gid, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var total int64
+	ary := make([]*Message, 0, 10)
+	for rows.Next() {
+		var elem Message
+		err = rows.Scan(&elem.ID, &elem.DocType.ID, &elem.DocType.Name, &elem.DocID, &elem.Event,
+			&elem.Workflow, &elem.Action, &elem.Title, &elem.Data, &elem.Ctime, &total)
+		if err != nil {
+			return nil, err
+		}
+		ary = append(ary, &elem)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if len(ary) == 0 && offset > 0 {
+		total, err = Mailboxes.CountByGroup(gid, false)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return &MessagePage{Items: ary, PageInfo: newPageInfo(offset, len(ary), total)}, nil
+}
@@ -0,0 +1,94 @@
+// (c) Copyright 2015-2017 JONNALAGADDA Srinivas
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package flow
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"sync/atomic"
+)
+
+// enforcePerms is non-zero if events should be checked for
+// permission before application.
+var enforcePerms int32
+
+// SetPermissionChecks specifies whether `ApplyEvent` and its variants
+// should verify, using `CanApply`, that the group which raised an
+// event is permitted to perform its action.  Checks are disabled by
+// default.
+func SetPermissionChecks(enabled bool) {
+	var v int32
+	if enabled {
+		v = 1
+	}
+	atomic.StoreInt32(&enforcePerms, v)
+}
+
+// permChecksEnabled answers `true` if events should be checked for
+// permission.
+func permChecksEnabled() bool {
+	return atomic.LoadInt32(&enforcePerms) != 0
+}
+
+// CanApply answers `true` if the given group may perform the event's
+// action on its document, in the document's current access context.
+//
+// A group may do so if a role that it has in the access context
+// permits the action on the document type.  A singleton group may
+// also do so if its user belongs to any such group.
+func (_Workflows) CanApply(event *DocEvent, gid GroupID) (bool, error) {
+	return Workflows.CanApplyContext(context.Background(), nil, event, gid)
+}
+
+// CanApplyContext is the same as `CanApply`, but runs its queries
+// under the given context, in the given transaction, if any.
+func (_Workflows) CanApplyContext(ctx context.Context, otx *sql.Tx, event *DocEvent, gid GroupID) (bool, error) {
+	if gid <= 0 || event.DocType <= 0 || event.DocID <= 0 || event.Action <= 0 {
+		return false, errors.New("invalid group ID or document or document action")
+	}
+
+	doc, err := Documents.GetContext(ctx, otx, event.DocType, event.DocID)
+	if err != nil {
+		return false, err
+	}
+
+	q := `
+	SELECT COUNT(*) FROM wf_ac_perms_v
+	WHERE ac_id = ?
+	AND doctype_id = ?
+	AND docaction_id = ?
+	AND (group_id = ? OR user_id IN (
+		SELECT gu.user_id
+		FROM wf_group_users gu
+		JOIN wf_groups_master gm ON gm.id = gu.group_id
+		WHERE gu.group_id = ?
+		AND gm.group_type = 'S'
+	))
+	`
+	var row *sql.Row
+	if otx == nil {
+		row = db.QueryRowContext(ctx, rebind(q), doc.AccCtx.ID, event.DocType, event.Action, gid, gid)
+	} else {
+		row = otx.QueryRowContext(ctx, rebind(q), doc.AccCtx.ID, event.DocType, event.Action, gid, gid)
+	}
+	var n int64
+	err = row.Scan(&n)
+	if err != nil {
+		return false, err
+	}
+
+	return n > 0, nil
+}
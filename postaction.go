@@ -0,0 +1,68 @@
+// (c) Copyright 2015-2017 JONNALAGADDA Srinivas
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package flow
+
+import (
+	"database/sql"
+	"sync"
+)
+
+// PostActionFunc makes changes to the given document -- setting the
+// approver, a decision date, etc. -- as part of a transition.  It is
+// invoked with the transition's own transaction, after the document's
+// state has changed.  Answering an error rolls the whole transition
+// back.
+type PostActionFunc func(tx *sql.Tx, doc DocumentID) error
+
+// postActions holds the registered transition post actions.
+var postActions struct {
+	sync.RWMutex
+	m map[guardKey]PostActionFunc
+}
+
+// RegisterPostAction associates the given post action with the
+// transition from the given state, upon the given action, into the
+// given target state.  A `nil` post action removes any that is
+// registered.
+//
+// Upon a fork, the post action of each branch's transition is invoked.
+func (_DocTypes) RegisterPostAction(dtype DocTypeID, from DocStateID, action DocActionID, to DocStateID, fn PostActionFunc) {
+	k := guardKey{dtype, from, action, to}
+
+	postActions.Lock()
+	defer postActions.Unlock()
+
+	if fn == nil {
+		delete(postActions.m, k)
+		return
+	}
+	if postActions.m == nil {
+		postActions.m = make(map[guardKey]PostActionFunc)
+	}
+	postActions.m[k] = fn
+}
+
+// runPostAction invokes the post action registered for the
+// transition of the given event into the given state, if any.
+func runPostAction(otx *sql.Tx, event *DocEvent, from, to DocStateID) error {
+	postActions.RLock()
+	fn := postActions.m[guardKey{event.DocType, from, event.Action, to}]
+	postActions.RUnlock()
+
+	if fn == nil {
+		return nil
+	}
+	return fn(otx, event.DocID)
+}
@@ -0,0 +1,78 @@
+// (c) Copyright 2015-2017 JONNALAGADDA Srinivas
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package flow
+
+import (
+	"database/sql"
+	"sync"
+)
+
+// RecipientResolver answers the groups that should be notified of the
+// given event, based on the document's data -- the owner of the
+// document's department, for instance.  It is invoked with the
+// transition's own transaction, so that it sees the data that the
+// transition sees.  Answering an error rolls the whole transition
+// back.
+type RecipientResolver func(tx *sql.Tx, event *DocEvent) ([]GroupID, error)
+
+// resolvers holds the registered recipient resolvers, by document
+// type.
+var resolvers struct {
+	sync.RWMutex
+	m map[DocTypeID]RecipientResolver
+}
+
+// RegisterRecipientResolver associates the given resolver with the
+// given document type.  A `nil` resolver removes any that is
+// registered.
+//
+// When an event on a document of this type is applied, and a message
+// is posted, the groups answered by the resolver are notified in
+// addition to those given to `ApplyEvent` and those that the target
+// nodes determine.  Callers may hence pass `nil` recipients.
+func (_DocTypes) RegisterRecipientResolver(dtype DocTypeID, fn RecipientResolver) {
+	resolvers.Lock()
+	defer resolvers.Unlock()
+
+	if fn == nil {
+		delete(resolvers.m, dtype)
+		return
+	}
+	if resolvers.m == nil {
+		resolvers.m = make(map[DocTypeID]RecipientResolver)
+	}
+	resolvers.m[dtype] = fn
+}
+
+// resolveRecipients adds the groups answered by the resolver
+// registered for the given event's document type, if any, to the
+// given recipients.
+func resolveRecipients(otx *sql.Tx, event *DocEvent, recv map[GroupID]struct{}) error {
+	resolvers.RLock()
+	fn := resolvers.m[event.DocType]
+	resolvers.RUnlock()
+
+	if fn == nil {
+		return nil
+	}
+	gids, err := fn(otx, event)
+	if err != nil {
+		return err
+	}
+	for _, gid := range gids {
+		recv[gid] = struct{}{}
+	}
+	return nil
+}
@@ -0,0 +1,174 @@
+// (c) Copyright 2015-2017 JONNALAGADDA Srinivas
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package flow
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+)
+
+// replayedEvent is a single applied event of a document, as read from
+// its event log for replay.
+type replayedEvent struct {
+	id       DocEventID
+	state    DocStateID    // State in which the event was raised
+	action   DocActionID   // Action of the event
+	recorded sql.NullInt64 // Target state recorded when the event was applied, if any
+}
+
+// Replay reconstructs the current state of the given document from
+// its event log alone, without consulting its stored state.  Starting
+// from the begin state of the document's workflow version, the
+// transition of each applied event is followed, in the order in which
+// the events were raised.  The computed state is answered.
+//
+// Should the log be inconsistent with the definition of the workflow,
+// `*ErrEventLogInconsistent` is answered, identifying the first event
+// that could not be replayed.
+//
+// N.B. Where guards choose among several targets of an action, the
+// target recorded when the event was applied is followed; guards are
+// not evaluated again.  Documents that have forked into parallel
+// branches cannot be replayed.  `Revert`, `Terminate`, `Reopen`,
+// begin selectors and migrations move documents outside the event
+// log; the documents so moved are hence reported as inconsistent.
+// Comparing the answer with `Documents.CurrentState` detects drift
+// between the stored state and the event log.
+func (_Workflows) Replay(dtype DocTypeID, doc DocumentID) (DocStateID, error) {
+	return Workflows.ReplayContext(context.Background(), dtype, doc)
+}
+
+// ReplayContext is the same as `Replay`, but runs its queries under
+// the given context.
+func (_Workflows) ReplayContext(ctx context.Context, dtype DocTypeID, doc DocumentID) (DocStateID, error) {
+	wf, err := Documents.WorkflowContext(ctx, nil, dtype, doc)
+	if err != nil {
+		return 0, err
+	}
+
+	q := `
+	SELECT de.id, de.docstate_id, de.docaction_id, dea.to_state_id
+	FROM wf_docevents de
+	LEFT JOIN wf_docevent_application dea ON dea.docevent_id = de.id
+	WHERE de.doctype_id = ?
+	AND de.doc_id = ?
+	AND de.status = 'A'
+	ORDER BY de.id
+	`
+	rows, err := db.QueryContext(ctx, rebind(q), dtype, doc)
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	events := make([]replayedEvent, 0, 10)
+	for rows.Next() {
+		var elem replayedEvent
+		err = rows.Scan(&elem.id, &elem.state, &elem.action, &elem.recorded)
+		if err != nil {
+			return 0, err
+		}
+		events = append(events, elem)
+	}
+	if err = rows.Err(); err != nil {
+		return 0, err
+	}
+	rows.Close()
+
+	targets, err := actionTable(ctx, wf.ID)
+	if err != nil {
+		return 0, err
+	}
+
+	return replayEvents(wf.BeginState.ID, events, targets)
+}
+
+// actionTable answers the transitions defined in the given workflow,
+// as a map from each source state and action to the target states.
+func actionTable(ctx context.Context, wid WorkflowID) (map[DocStateID]map[DocActionID][]DocStateID, error) {
+	q := `
+	SELECT dst.from_state_id, dst.docaction_id, dst.to_state_id
+	FROM wf_docstate_transitions dst
+	JOIN wf_workflows wf ON wf.doctype_id = dst.doctype_id AND wf.version = dst.version
+	WHERE wf.id = ?
+	`
+	rows, err := db.QueryContext(ctx, rebind(q), wid)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	table := make(map[DocStateID]map[DocActionID][]DocStateID)
+	for rows.Next() {
+		var from, to DocStateID
+		var da DocActionID
+		err = rows.Scan(&from, &da, &to)
+		if err != nil {
+			return nil, err
+		}
+		if table[from] == nil {
+			table[from] = make(map[DocActionID][]DocStateID)
+		}
+		table[from][da] = append(table[from][da], to)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return table, nil
+}
+
+// replayEvents follows the transitions of the given events, in order,
+// from the given begin state, and answers the state reached.
+func replayEvents(begin DocStateID, events []replayedEvent, targets map[DocStateID]map[DocActionID][]DocStateID) (DocStateID, error) {
+	curr := begin
+	for _, e := range events {
+		fail := func(format string, args ...interface{}) (DocStateID, error) {
+			return 0, &ErrEventLogInconsistent{Event: e.id, State: curr, Reason: fmt.Sprintf(format, args...)}
+		}
+
+		if e.state != curr {
+			return fail("event was raised in state %d", e.state)
+		}
+		ts := targets[curr][e.action]
+		if len(ts) == 0 {
+			return fail("no transition is defined upon action %d", e.action)
+		}
+
+		switch {
+		case e.recorded.Valid:
+			to := DocStateID(e.recorded.Int64)
+			if !containsState(ts, to) {
+				if to == curr {
+					return fail("document forked upon action %d", e.action)
+				}
+				return fail("recorded target state %d is not defined upon action %d", to, e.action)
+			}
+			curr = to
+
+		case containsState(ts, curr):
+			// A redundant event leaves the document where it is.
+
+		case len(ts) == 1:
+			curr = ts[0]
+
+		default:
+			return fail("target state upon action %d cannot be determined", e.action)
+		}
+	}
+
+	return curr, nil
+}
@@ -0,0 +1,122 @@
+// (c) Copyright 2015-2017 JONNALAGADDA Srinivas
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package flow
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+// DefMaxTxAttempts is the default number of times that a transaction
+// is attempted, when it fails owing to a deadlock or a serialisation
+// failure.
+const DefMaxTxAttempts = 3
+
+// maxTxAttempts is the number of attempts configured for the engine.
+var maxTxAttempts = DefMaxTxAttempts
+
+// txBackoff is the delay before the first retry of a transaction.  It
+// doubles with each subsequent retry.
+var txBackoff = 20 * time.Millisecond
+
+// inTx runs the given function in a transaction.  When a transaction
+// is given by the caller, the function simply runs in it; the caller
+// is then responsible for any retries.
+//
+// Otherwise, a new transaction is begun, and committed if the function
+// succeeds.  Should the function or the commit fail owing to a
+// deadlock or a serialisation failure, the whole transaction is
+// re-run, with exponential backoff, up to the configured number of
+// attempts.
+func inTx(ctx context.Context, otx *sql.Tx, fn func(tx *sql.Tx) error) error {
+	if otx != nil {
+		return fn(otx)
+	}
+
+	delay := txBackoff
+	var err error
+	for attempt := 1; ; attempt++ {
+		err = runTx(ctx, fn)
+		if err == nil || !isRetryable(err) || attempt >= maxTxAttempts {
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+
+		case <-time.After(delay):
+			delay *= 2
+		}
+	}
+}
+
+// runTx makes a single attempt at running the given function in a new
+// transaction.
+func runTx(ctx context.Context, fn func(tx *sql.Tx) error) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	ts := trackTx(tx, true)
+	committed := false
+	defer func() {
+		if !committed {
+			tx.Rollback()
+		}
+		ts.end(tx, committed)
+	}()
+
+	err = fn(tx)
+	if err != nil {
+		logger.Errorf("transaction rolled back : %v", err)
+		return err
+	}
+	err = tx.Commit()
+	committed = err == nil
+	return err
+}
+
+// sqlStater is implemented by the errors of drivers that report the
+// SQLSTATE of failures, such as those of `lib/pq` and `pgx`.
+type sqlStater interface {
+	SQLState() string
+}
+
+// isRetryable answers `true` if the given error indicates a deadlock
+// or a serialisation failure, upon which the transaction can safely be
+// re-run.  The driver's error is looked for in the chain of the given
+// error, so that errors wrapped with context are recognised as well.
+func isRetryable(err error) bool {
+	var me *mysql.MySQLError
+	if errors.As(err, &me) {
+		// Deadlock found; lock wait timeout exceeded.
+		return me.Number == 1213 || me.Number == 1205
+	}
+
+	var se sqlStater
+	if errors.As(err, &se) {
+		switch se.SQLState() {
+		case "40001", "40P01":
+			return true
+		}
+	}
+
+	return false
+}
@@ -15,6 +15,7 @@
 package flow
 
 import (
+	"context"
 	"database/sql"
 	"errors"
 	"fmt"
@@ -41,14 +42,21 @@ var Roles _Roles
 
 // New creates a role with the given name.
 func (_Roles) New(otx *sql.Tx, name string) (RoleID, error) {
+	return Roles.NewContext(context.Background(), otx, name)
+}
+
+// NewContext is the same as `New`, but runs its queries under the
+// given context.
+func (_Roles) NewContext(ctx context.Context, otx *sql.Tx, name string) (RoleID, error) {
 	name = strings.TrimSpace(name)
 	if name == "" {
 		return 0, errors.New("name cannot not be empty")
 	}
 
 	var tx *sql.Tx
+	var err error
 	if otx == nil {
-		tx, err := db.Begin()
+		tx, err = db.BeginTx(ctx, nil)
 		if err != nil {
 			return 0, err
 		}
@@ -57,11 +65,7 @@ func (_Roles) New(otx *sql.Tx, name string) (RoleID, error) {
 		tx = otx
 	}
 
-	res, err := tx.Exec("INSERT INTO wf_roles_master(name) VALUES(?)", name)
-	if err != nil {
-		return 0, err
-	}
-	id, err := res.LastInsertId()
+	id, err := execInsert(ctx, tx, "INSERT INTO wf_roles_master(name) VALUES(?)", name)
 	if err != nil {
 		return 0, err
 	}
@@ -83,6 +87,12 @@ func (_Roles) New(otx *sql.Tx, name string) (RoleID, error) {
 // `limit` elements.  A value of `0` for `offset` fetches from the
 // beginning, while a value of `0` for `limit` fetches until the end.
 func (_Roles) List(offset, limit int64) ([]*Role, error) {
+	return Roles.ListContext(context.Background(), offset, limit)
+}
+
+// ListContext is the same as `List`, but runs its queries under the
+// given context.
+func (_Roles) ListContext(ctx context.Context, offset, limit int64) ([]*Role, error) {
 	if offset < 0 || limit < 0 {
 		return nil, errors.New("offset and limit must be non-negative integers")
 	}
@@ -96,7 +106,7 @@ func (_Roles) List(offset, limit int64) ([]*Role, error) {
 	ORDER BY id
 	LIMIT ? OFFSET ?
 	`
-	rows, err := db.Query(q, limit, offset)
+	rows, err := db.QueryContext(ctx, rebind(q), limit, offset)
 	if err != nil {
 		return nil, err
 	}
@@ -121,14 +131,23 @@ func (_Roles) List(offset, limit int64) ([]*Role, error) {
 // Get loads the role object corresponding to the given role ID from
 // the database, and answers that.
 func (_Roles) Get(id RoleID) (*Role, error) {
+	return Roles.GetContext(context.Background(), id)
+}
+
+// GetContext is the same as `Get`, but runs its queries under the
+// given context.
+func (_Roles) GetContext(ctx context.Context, id RoleID) (*Role, error) {
 	if id <= 0 {
 		return nil, errors.New("ID must be a positive integer")
 	}
 
 	var elem Role
-	row := db.QueryRow("SELECT id, name FROM wf_roles_master WHERE id = ?", id)
+	row := db.QueryRowContext(ctx, rebind("SELECT id, name FROM wf_roles_master WHERE id = ?"), id)
 	err := row.Scan(&elem.ID, &elem.Name)
 	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrNotFound
+		}
 		return nil, err
 	}
 
@@ -138,15 +157,24 @@ func (_Roles) Get(id RoleID) (*Role, error) {
 // GetByName answers the role, if one with the given name is
 // registered; `nil` and the error, otherwise.
 func (_Roles) GetByName(name string) (*Role, error) {
+	return Roles.GetByNameContext(context.Background(), name)
+}
+
+// GetByNameContext is the same as `GetByName`, but runs its queries
+// under the given context.
+func (_Roles) GetByNameContext(ctx context.Context, name string) (*Role, error) {
 	name = strings.TrimSpace(name)
 	if name == "" {
 		return nil, errors.New("role cannot be empty")
 	}
 
 	var elem Role
-	row := db.QueryRow("SELECT id, name FROM wf_roles_master WHERE name = ?", name)
+	row := db.QueryRowContext(ctx, rebind("SELECT id, name FROM wf_roles_master WHERE name = ?"), name)
 	err := row.Scan(&elem.ID, &elem.Name)
 	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrNotFound
+		}
 		return nil, err
 	}
 
@@ -155,14 +183,21 @@ func (_Roles) GetByName(name string) (*Role, error) {
 
 // Rename renames the given role.
 func (_Roles) Rename(otx *sql.Tx, id RoleID, name string) error {
+	return Roles.RenameContext(context.Background(), otx, id, name)
+}
+
+// RenameContext is the same as `Rename`, but runs its queries under
+// the given context.
+func (_Roles) RenameContext(ctx context.Context, otx *sql.Tx, id RoleID, name string) error {
 	name = strings.TrimSpace(name)
 	if name == "" {
 		return errors.New("name cannot be empty")
 	}
 
 	var tx *sql.Tx
+	var err error
 	if otx == nil {
-		tx, err := db.Begin()
+		tx, err = db.BeginTx(ctx, nil)
 		if err != nil {
 			return err
 		}
@@ -171,7 +206,7 @@ func (_Roles) Rename(otx *sql.Tx, id RoleID, name string) error {
 		tx = otx
 	}
 
-	_, err := tx.Exec("UPDATE wf_roles_master SET name = ? WHERE id = ?", name, id)
+	_, err = tx.ExecContext(ctx, rebind("UPDATE wf_roles_master SET name = ? WHERE id = ?"), name, id)
 	if err != nil {
 		return err
 	}
@@ -189,11 +224,17 @@ func (_Roles) Rename(otx *sql.Tx, id RoleID, name string) error {
 // Delete deletes the given role from the system, if no access context
 // is actively using it.
 func (_Roles) Delete(otx *sql.Tx, id RoleID) error {
+	return Roles.DeleteContext(context.Background(), otx, id)
+}
+
+// DeleteContext is the same as `Delete`, but runs its queries under
+// the given context.
+func (_Roles) DeleteContext(ctx context.Context, otx *sql.Tx, id RoleID) error {
 	if id <= 0 {
 		return errors.New("role ID must be a positive integer")
 	}
 
-	row := db.QueryRow("SELECT COUNT(*) FROM wf_ac_group_roles WHERE role_id = ?", id)
+	row := db.QueryRowContext(ctx, rebind("SELECT COUNT(*) FROM wf_ac_group_roles WHERE role_id = ?"), id)
 	var n int64
 	err := row.Scan(&n)
 	if n > 0 {
@@ -202,7 +243,7 @@ func (_Roles) Delete(otx *sql.Tx, id RoleID) error {
 
 	var tx *sql.Tx
 	if otx == nil {
-		tx, err := db.Begin()
+		tx, err = db.BeginTx(ctx, nil)
 		if err != nil {
 			return err
 		}
@@ -211,11 +252,11 @@ func (_Roles) Delete(otx *sql.Tx, id RoleID) error {
 		tx = otx
 	}
 
-	_, err = tx.Exec("DELETE FROM wf_role_docactions WHERE role_id = ?", id)
+	_, err = tx.ExecContext(ctx, rebind("DELETE FROM wf_role_docactions WHERE role_id = ?"), id)
 	if err != nil {
 		return err
 	}
-	res, err := tx.Exec("DELETE FROM wf_roles_master WHERE id = ?", id)
+	res, err := tx.ExecContext(ctx, rebind("DELETE FROM wf_roles_master WHERE id = ?"), id)
 	if err != nil {
 		return err
 	}
@@ -237,9 +278,16 @@ func (_Roles) Delete(otx *sql.Tx, id RoleID) error {
 // AddPermissions adds the given actions to this role, for the given
 // document type.
 func (_Roles) AddPermissions(otx *sql.Tx, rid RoleID, dtype DocTypeID, actions []DocActionID) error {
+	return Roles.AddPermissionsContext(context.Background(), otx, rid, dtype, actions)
+}
+
+// AddPermissionsContext is the same as `AddPermissions`, but runs its
+// queries under the given context.
+func (_Roles) AddPermissionsContext(ctx context.Context, otx *sql.Tx, rid RoleID, dtype DocTypeID, actions []DocActionID) error {
 	var tx *sql.Tx
+	var err error
 	if otx == nil {
-		tx, err := db.Begin()
+		tx, err = db.BeginTx(ctx, nil)
 		if err != nil {
 			return err
 		}
@@ -253,7 +301,7 @@ func (_Roles) AddPermissions(otx *sql.Tx, rid RoleID, dtype DocTypeID, actions [
 	VALUES(?, ?, ?)
 	`
 	for _, action := range actions {
-		_, err := tx.Exec(q, rid, dtype, action)
+		_, err := tx.ExecContext(ctx, rebind(q), rid, dtype, action)
 		if err != nil {
 			return err
 		}
@@ -271,9 +319,16 @@ func (_Roles) AddPermissions(otx *sql.Tx, rid RoleID, dtype DocTypeID, actions [
 // RemovePermissions removes the given actions from this role, for the
 // given document type.
 func (_Roles) RemovePermissions(otx *sql.Tx, rid RoleID, dtype DocTypeID, actions []DocActionID) error {
+	return Roles.RemovePermissionsContext(context.Background(), otx, rid, dtype, actions)
+}
+
+// RemovePermissionsContext is the same as `RemovePermissions`, but
+// runs its queries under the given context.
+func (_Roles) RemovePermissionsContext(ctx context.Context, otx *sql.Tx, rid RoleID, dtype DocTypeID, actions []DocActionID) error {
 	var tx *sql.Tx
+	var err error
 	if otx == nil {
-		tx, err := db.Begin()
+		tx, err = db.BeginTx(ctx, nil)
 		if err != nil {
 			return err
 		}
@@ -289,7 +344,7 @@ func (_Roles) RemovePermissions(otx *sql.Tx, rid RoleID, dtype DocTypeID, action
 	AND docaction_id = ?
 	`
 	for _, action := range actions {
-		_, err := tx.Exec(q, rid, dtype, action)
+		_, err := tx.ExecContext(ctx, rebind(q), rid, dtype, action)
 		if err != nil {
 			return err
 		}
@@ -310,6 +365,15 @@ func (_Roles) RemovePermissions(otx *sql.Tx, rid RoleID, dtype DocTypeID, action
 func (_Roles) Permissions(rid RoleID) (map[string]struct {
 	DocTypeID DocTypeID
 	Actions   []*DocAction
+}, error) {
+	return Roles.PermissionsContext(context.Background(), rid)
+}
+
+// PermissionsContext is the same as `Permissions`, but runs its
+// queries under the given context.
+func (_Roles) PermissionsContext(ctx context.Context, rid RoleID) (map[string]struct {
+	DocTypeID DocTypeID
+	Actions   []*DocAction
 }, error) {
 	q := `
 	SELECT dtm.id, dtm.name, dam.id, dam.name, dam.reconfirm
@@ -318,7 +382,7 @@ func (_Roles) Permissions(rid RoleID) (map[string]struct {
 	JOIN wf_docactions_master dam ON dam.id = rdas.docaction_id
 	WHERE rdas.role_id = ?
 	`
-	rows, err := db.Query(q, rid)
+	rows, err := db.QueryContext(ctx, rebind(q), rid)
 	if err != nil {
 		return nil, err
 	}
@@ -353,6 +417,12 @@ func (_Roles) Permissions(rid RoleID) (map[string]struct {
 // HasPermission answers `true` if this role has the queried
 // permission for the given document type.
 func (_Roles) HasPermission(rid RoleID, dtype DocTypeID, action DocActionID) (bool, error) {
+	return Roles.HasPermissionContext(context.Background(), rid, dtype, action)
+}
+
+// HasPermissionContext is the same as `HasPermission`, but runs its
+// queries under the given context.
+func (_Roles) HasPermissionContext(ctx context.Context, rid RoleID, dtype DocTypeID, action DocActionID) (bool, error) {
 	q := `
 	SELECT rdas.id FROM wf_role_docactions rdas
 	JOIN wf_doctypes_master dtm ON rdas.doctype_id = dtm.id
@@ -363,7 +433,7 @@ func (_Roles) HasPermission(rid RoleID, dtype DocTypeID, action DocActionID) (bo
 	ORDER BY rdas.id
 	LIMIT 1
 	`
-	row := db.QueryRow(q, rid, dtype, action)
+	row := db.QueryRowContext(ctx, rebind(q), rid, dtype, action)
 	var n int64
 	err := row.Scan(&n)
 	if err != nil {
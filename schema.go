@@ -0,0 +1,563 @@
+// (c) Copyright 2015-2017 JONNALAGADDA Srinivas
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package flow
+
+import (
+	"context"
+	"database/sql"
+	"sort"
+	"strings"
+)
+
+// schemaTables lists the tables -- and views -- of `flow`, together
+// with the columns that each must have at the least.  Per document
+// type tables are created by `DocTypes.New`, and are not listed.
+var schemaTables = map[string][]string{
+	"wf_access_contexts":        {"id", "name", "active"},
+	"wf_ac_group_hierarchy":     {"id", "ac_id", "group_id", "reports_to"},
+	"wf_ac_group_roles":         {"id", "ac_id", "group_id", "role_id"},
+	"wf_ac_perms_v":             {"ac_id", "group_id", "user_id", "role_id", "doctype_id", "docaction_id"},
+	"wf_audit_log":              {"id", "docevent_id", "doctype_id", "doc_id", "from_state_id", "to_state_id", "docaction_id", "group_id", "ctime", "prev_hash", "hash"},
+	"wf_docactions_master":      {"id", "name", "reconfirm"},
+	"wf_docevent_application":   {"id", "doctype_id", "doc_id", "from_state_id", "docevent_id", "to_state_id"},
+	"wf_docevents":              {"id", "doctype_id", "doc_id", "docstate_id", "docaction_id", "group_id", "data", "ctime", "status", "idem_key"},
+	"wf_docstate_transitions":   {"id", "doctype_id", "version", "from_state_id", "docaction_id", "to_state_id", "seq", "metadata"},
+	"wf_docstates_master":       {"id", "name", "parent_id"},
+	"wf_doctypes_master":        {"id", "name", "cancel_state_id"},
+	"wf_document_active_states": {"id", "doctype_id", "doc_id", "fork_state_id", "docstate_id", "joined", "ctime"},
+	"wf_document_blobs":         {"id", "doctype_id", "doc_id", "sha1sum", "name", "path"},
+	"wf_document_children":      {"id", "parent_doctype_id", "parent_id", "child_doctype_id", "child_id"},
+	"wf_document_state_history": {"id", "doctype_id", "doc_id", "docstate_id", "entered_at", "left_at", "by_revert", "by_reopen", "reason"},
+	"wf_document_tags":          {"id", "doctype_id", "doc_id", "tag"},
+	"wf_group_users":            {"id", "group_id", "user_id"},
+	"wf_groups_master":          {"id", "name", "group_type"},
+	"wf_mailboxes":              {"id", "group_id", "message_id", "unread", "ctime"},
+	"wf_message_outbox":         {"id", "group_id", "message_id", "ctime"},
+	"wf_messages":               {"id", "doctype_id", "doc_id", "docevent_id", "workflow_id", "title", "data"},
+	"wf_role_docactions":        {"id", "role_id", "doctype_id", "docaction_id"},
+	"wf_roles_master":           {"id", "name"},
+	"wf_users_master":           {"id", "first_name", "last_name", "email", "active"},
+	"wf_workflow_node_timeouts": {"id", "node_id", "after_secs", "docaction_id"},
+	"wf_workflow_nodes":         {"id", "doctype_id", "docstate_id", "ac_id", "workflow_id", "name", "type"},
+	"wf_workflows":              {"id", "name", "doctype_id", "docstate_id", "active", "version"},
+}
+
+// CheckSchema verifies that each of the tables of `flow` exists in
+// the current schema of the registered database, with the columns
+// that `flow` uses.  Everything found missing is listed in the
+// answered `*ErrSchema`.
+//
+// It is intended to be called once, at start-up, so that a
+// misconfigured deployment fails before its first transition.
+func CheckSchema() error {
+	return CheckSchemaContext(context.Background())
+}
+
+// CheckSchemaContext is the same as `CheckSchema`, but runs its
+// queries under the given context.
+func CheckSchemaContext(ctx context.Context) error {
+	var q string
+	switch dialect {
+	case DialectPostgres:
+		q = `
+		SELECT table_name, column_name
+		FROM information_schema.columns
+		WHERE table_schema = current_schema()
+		`
+	default:
+		q = `
+		SELECT table_name, column_name
+		FROM information_schema.columns
+		WHERE table_schema = DATABASE()
+		`
+	}
+	rows, err := db.QueryContext(ctx, q)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	have := make(map[string]map[string]bool)
+	for rows.Next() {
+		var tbl, col string
+		err = rows.Scan(&tbl, &col)
+		if err != nil {
+			return err
+		}
+		tbl, col = strings.ToLower(tbl), strings.ToLower(col)
+		if have[tbl] == nil {
+			have[tbl] = make(map[string]bool)
+		}
+		have[tbl][col] = true
+	}
+	if err = rows.Err(); err != nil {
+		return err
+	}
+
+	if missing := missingSchema(have, tablePrefix); len(missing) > 0 {
+		return &ErrSchema{Missing: missing}
+	}
+	return nil
+}
+
+// missingSchema answers the tables and columns of `flow` that are not
+// present in the given schema, in sorted order.  The tables are looked
+// up with the given prefix.  A missing table is reported by its name,
+// and a missing column as `table.column`.
+func missingSchema(have map[string]map[string]bool, prefix string) []string {
+	missing := []string{}
+	for tbl, cols := range schemaTables {
+		name := strings.ToLower(prefix) + tbl
+		hcols, ok := have[name]
+		if !ok {
+			missing = append(missing, name)
+			continue
+		}
+		for _, col := range cols {
+			if !hcols[col] {
+				missing = append(missing, name+"."+col)
+			}
+		}
+	}
+
+	sort.Strings(missing)
+	return missing
+}
+
+// schemaTable describes a table of `flow`, for `CreateSchema`.  The
+// definition of its columns and constraints is built for a dialect.
+type schemaTable struct {
+	name    string
+	body    func(c schemaCols) string
+	indexes [][]string // Non-unique indexes : their columns
+}
+
+// schemaCols answers the dialect-specific column definitions used in
+// the tables of `flow`.
+type schemaCols struct {
+	d Dialect
+}
+
+// id answers the definition of an auto-generated `id` column.
+func (c schemaCols) id() string {
+	if c.d == DialectPostgres {
+		return "id SERIAL NOT NULL"
+	}
+	return "id INT NOT NULL AUTO_INCREMENT"
+}
+
+// flag answers the type of a column holding `0` or `1`.
+func (c schemaCols) flag() string {
+	if c.d == DialectPostgres {
+		return "SMALLINT"
+	}
+	return "TINYINT(1)"
+}
+
+// enum answers the definition of the given column, restricted to the
+// given values.
+func (c schemaCols) enum(col string, vals ...string) string {
+	q := "'" + strings.Join(vals, "', '") + "'"
+	if c.d == DialectPostgres {
+		return col + " VARCHAR(10) CHECK (" + col + " IN (" + q + "))"
+	}
+	return col + " ENUM(" + q + ")"
+}
+
+// schemaDefs lists the tables of `flow`, in an order that satisfies
+// their foreign keys.  These mirror the scripts in `sql/`.
+var schemaDefs = []schemaTable{
+	{name: "wf_docstates_master", body: func(c schemaCols) string {
+		return c.id() + `,
+		name VARCHAR(100) NOT NULL,
+		parent_id INT NULL,
+		PRIMARY KEY (id),
+		FOREIGN KEY (parent_id) REFERENCES wf_docstates_master(id),
+		UNIQUE (name)`
+	}, indexes: [][]string{{"parent_id"}}},
+	{name: "wf_doctypes_master", body: func(c schemaCols) string {
+		return c.id() + `,
+		name VARCHAR(100) NOT NULL,
+		cancel_state_id INT NULL,
+		PRIMARY KEY (id),
+		FOREIGN KEY (cancel_state_id) REFERENCES wf_docstates_master(id),
+		UNIQUE (name)`
+	}},
+	{name: "wf_docactions_master", body: func(c schemaCols) string {
+		return c.id() + `,
+		name VARCHAR(100) NOT NULL,
+		reconfirm ` + c.flag() + ` NOT NULL,
+		PRIMARY KEY (id),
+		UNIQUE (name)`
+	}},
+	{name: "wf_groups_master", body: func(c schemaCols) string {
+		return c.id() + `,
+		name VARCHAR(100) NOT NULL,
+		` + c.enum("group_type", "G", "S") + `,
+		PRIMARY KEY (id),
+		UNIQUE (name)`
+	}},
+	{name: "wf_roles_master", body: func(c schemaCols) string {
+		return c.id() + `,
+		name VARCHAR(50) NOT NULL,
+		PRIMARY KEY (id),
+		UNIQUE (name)`
+	}},
+	{name: "wf_group_users", body: func(c schemaCols) string {
+		return c.id() + `,
+		group_id INT NOT NULL,
+		user_id INT NOT NULL,
+		PRIMARY KEY (id),
+		FOREIGN KEY (group_id) REFERENCES wf_groups_master(id),
+		UNIQUE (group_id, user_id)`
+	}, indexes: [][]string{{"user_id"}}},
+	{name: "wf_role_docactions", body: func(c schemaCols) string {
+		return c.id() + `,
+		role_id INT NOT NULL,
+		doctype_id INT NOT NULL,
+		docaction_id INT NOT NULL,
+		PRIMARY KEY (id),
+		FOREIGN KEY (role_id) REFERENCES wf_roles_master(id),
+		FOREIGN KEY (doctype_id) REFERENCES wf_doctypes_master(id),
+		FOREIGN KEY (docaction_id) REFERENCES wf_docactions_master(id),
+		UNIQUE (role_id, doctype_id, docaction_id)`
+	}},
+	{name: "wf_access_contexts", body: func(c schemaCols) string {
+		return c.id() + `,
+		name VARCHAR(100) NOT NULL,
+		active ` + c.flag() + ` NOT NULL,
+		PRIMARY KEY (id),
+		UNIQUE (name)`
+	}},
+	{name: "wf_ac_group_roles", body: func(c schemaCols) string {
+		return c.id() + `,
+		ac_id INT NOT NULL,
+		group_id INT NOT NULL,
+		role_id INT NOT NULL,
+		PRIMARY KEY (id),
+		FOREIGN KEY (ac_id) REFERENCES wf_access_contexts(id),
+		FOREIGN KEY (group_id) REFERENCES wf_groups_master(id),
+		FOREIGN KEY (role_id) REFERENCES wf_roles_master(id)`
+	}, indexes: [][]string{{"ac_id", "group_id"}, {"role_id"}}},
+	{name: "wf_ac_group_hierarchy", body: func(c schemaCols) string {
+		return c.id() + `,
+		ac_id INT NOT NULL,
+		group_id INT NOT NULL,
+		reports_to INT NOT NULL,
+		PRIMARY KEY (id),
+		FOREIGN KEY (ac_id) REFERENCES wf_access_contexts(id),
+		FOREIGN KEY (group_id) REFERENCES wf_groups_master(id),
+		FOREIGN KEY (reports_to) REFERENCES wf_groups_master(id),
+		UNIQUE (ac_id, group_id)`
+	}},
+	{name: "wf_document_children", body: func(c schemaCols) string {
+		return c.id() + `,
+		parent_doctype_id INT NOT NULL,
+		parent_id INT NOT NULL,
+		child_doctype_id INT NOT NULL,
+		child_id INT NOT NULL,
+		PRIMARY KEY (id),
+		FOREIGN KEY (parent_doctype_id) REFERENCES wf_doctypes_master(id),
+		FOREIGN KEY (child_doctype_id) REFERENCES wf_doctypes_master(id),
+		UNIQUE (parent_doctype_id, parent_id, child_doctype_id, child_id)`
+	}, indexes: [][]string{{"child_doctype_id", "child_id"}}},
+	{name: "wf_document_blobs", body: func(c schemaCols) string {
+		return c.id() + `,
+		doctype_id INT NOT NULL,
+		doc_id INT NOT NULL,
+		sha1sum CHAR(40) NOT NULL,
+		name TEXT NOT NULL,
+		path TEXT NOT NULL,
+		PRIMARY KEY (id),
+		FOREIGN KEY (doctype_id) REFERENCES wf_doctypes_master(id),
+		UNIQUE (doctype_id, doc_id, sha1sum)`
+	}},
+	{name: "wf_document_tags", body: func(c schemaCols) string {
+		return c.id() + `,
+		doctype_id INT NOT NULL,
+		doc_id INT NOT NULL,
+		tag VARCHAR(50) NOT NULL,
+		PRIMARY KEY (id),
+		FOREIGN KEY (doctype_id) REFERENCES wf_doctypes_master(id),
+		UNIQUE (doctype_id, doc_id, tag)`
+	}, indexes: [][]string{{"doctype_id", "tag"}}},
+	{name: "wf_docstate_transitions", body: func(c schemaCols) string {
+		return c.id() + `,
+		doctype_id INT NOT NULL,
+		version INT NOT NULL DEFAULT 1,
+		from_state_id INT NOT NULL,
+		docaction_id INT NOT NULL,
+		to_state_id INT NOT NULL,
+		seq INT NOT NULL DEFAULT 0,
+		metadata TEXT NULL,
+		PRIMARY KEY (id),
+		FOREIGN KEY (doctype_id) REFERENCES wf_doctypes_master(id),
+		FOREIGN KEY (from_state_id) REFERENCES wf_docstates_master(id),
+		FOREIGN KEY (docaction_id) REFERENCES wf_docactions_master(id),
+		FOREIGN KEY (to_state_id) REFERENCES wf_docstates_master(id),
+		UNIQUE (doctype_id, version, from_state_id, docaction_id, to_state_id),
+		UNIQUE (doctype_id, version, from_state_id, docaction_id, seq)`
+	}, indexes: [][]string{{"from_state_id"}, {"to_state_id"}}},
+	{name: "wf_docevents", body: func(c schemaCols) string {
+		return c.id() + `,
+		doctype_id INT NOT NULL,
+		doc_id INT NOT NULL,
+		docstate_id INT NOT NULL,
+		docaction_id INT NOT NULL,
+		group_id INT NOT NULL,
+		data TEXT,
+		ctime TIMESTAMP NOT NULL,
+		` + c.enum("status", "A", "P", "C") + ` NOT NULL,
+		idem_key VARCHAR(100) NULL,
+		PRIMARY KEY (id),
+		FOREIGN KEY (doctype_id) REFERENCES wf_doctypes_master(id),
+		FOREIGN KEY (docstate_id) REFERENCES wf_docstates_master(id),
+		FOREIGN KEY (docaction_id) REFERENCES wf_docactions_master(id),
+		FOREIGN KEY (group_id) REFERENCES wf_groups_master(id),
+		UNIQUE (doctype_id, doc_id, idem_key)`
+	}, indexes: [][]string{{"doctype_id", "doc_id"}, {"status"}}},
+	{name: "wf_docevent_application", body: func(c schemaCols) string {
+		return c.id() + `,
+		doctype_id INT NOT NULL,
+		doc_id INT NOT NULL,
+		from_state_id INT NOT NULL,
+		docevent_id INT NOT NULL,
+		to_state_id INT NOT NULL,
+		PRIMARY KEY (id),
+		FOREIGN KEY (doctype_id) REFERENCES wf_doctypes_master(id),
+		FOREIGN KEY (from_state_id) REFERENCES wf_docstates_master(id),
+		FOREIGN KEY (docevent_id) REFERENCES wf_docevents(id),
+		FOREIGN KEY (to_state_id) REFERENCES wf_docstates_master(id)`
+	}, indexes: [][]string{{"doctype_id", "doc_id"}, {"docevent_id"}}},
+	{name: "wf_audit_log", body: func(c schemaCols) string {
+		return c.id() + `,
+		docevent_id INT NOT NULL,
+		doctype_id INT NOT NULL,
+		doc_id INT NOT NULL,
+		from_state_id INT NOT NULL,
+		to_state_id INT NOT NULL,
+		docaction_id INT NOT NULL,
+		group_id INT NOT NULL,
+		ctime TIMESTAMP NOT NULL,
+		prev_hash CHAR(64) NOT NULL,
+		hash CHAR(64) NOT NULL,
+		PRIMARY KEY (id),
+		FOREIGN KEY (docevent_id) REFERENCES wf_docevents(id),
+		FOREIGN KEY (doctype_id) REFERENCES wf_doctypes_master(id),
+		FOREIGN KEY (from_state_id) REFERENCES wf_docstates_master(id),
+		FOREIGN KEY (to_state_id) REFERENCES wf_docstates_master(id),
+		FOREIGN KEY (docaction_id) REFERENCES wf_docactions_master(id),
+		FOREIGN KEY (group_id) REFERENCES wf_groups_master(id),
+		UNIQUE (hash)`
+	}, indexes: [][]string{{"doctype_id", "doc_id"}}},
+	{name: "wf_document_active_states", body: func(c schemaCols) string {
+		return c.id() + `,
+		doctype_id INT NOT NULL,
+		doc_id INT NOT NULL,
+		fork_state_id INT NOT NULL,
+		docstate_id INT NOT NULL,
+		joined BOOLEAN NOT NULL DEFAULT FALSE,
+		ctime TIMESTAMP NOT NULL,
+		PRIMARY KEY (id),
+		FOREIGN KEY (doctype_id) REFERENCES wf_doctypes_master(id),
+		FOREIGN KEY (fork_state_id) REFERENCES wf_docstates_master(id),
+		FOREIGN KEY (docstate_id) REFERENCES wf_docstates_master(id),
+		UNIQUE (doctype_id, doc_id, docstate_id)`
+	}},
+	{name: "wf_document_state_history", body: func(c schemaCols) string {
+		return c.id() + `,
+		doctype_id INT NOT NULL,
+		doc_id INT NOT NULL,
+		docstate_id INT NOT NULL,
+		entered_at TIMESTAMP NOT NULL,
+		left_at TIMESTAMP NULL,
+		by_revert BOOLEAN NOT NULL DEFAULT FALSE,
+		by_reopen BOOLEAN NOT NULL DEFAULT FALSE,
+		reason TEXT NULL,
+		PRIMARY KEY (id),
+		FOREIGN KEY (doctype_id) REFERENCES wf_doctypes_master(id),
+		FOREIGN KEY (docstate_id) REFERENCES wf_docstates_master(id)`
+	}, indexes: [][]string{{"doctype_id", "doc_id"}}},
+	{name: "wf_workflows", body: func(c schemaCols) string {
+		return c.id() + `,
+		name VARCHAR(100) NOT NULL,
+		doctype_id INT NOT NULL,
+		docstate_id INT NOT NULL,
+		active ` + c.flag() + ` NOT NULL,
+		version INT NOT NULL DEFAULT 1,
+		PRIMARY KEY (id),
+		FOREIGN KEY (doctype_id) REFERENCES wf_doctypes_master(id),
+		FOREIGN KEY (docstate_id) REFERENCES wf_docstates_master(id),
+		UNIQUE (name, version),
+		UNIQUE (doctype_id, version)`
+	}, indexes: [][]string{{"docstate_id"}}},
+	{name: "wf_workflow_nodes", body: func(c schemaCols) string {
+		return c.id() + `,
+		doctype_id INT NOT NULL,
+		docstate_id INT NOT NULL,
+		ac_id INT,
+		workflow_id INT NOT NULL,
+		name VARCHAR(100) NOT NULL,
+		` + c.enum("type", "begin", "end", "linear", "branch", "joinany", "joinall", "fork") + ` NOT NULL,
+		PRIMARY KEY (id),
+		FOREIGN KEY (doctype_id) REFERENCES wf_doctypes_master(id),
+		FOREIGN KEY (docstate_id) REFERENCES wf_docstates_master(id),
+		FOREIGN KEY (ac_id) REFERENCES wf_access_contexts(id),
+		FOREIGN KEY (workflow_id) REFERENCES wf_workflows(id),
+		UNIQUE (workflow_id, docstate_id),
+		UNIQUE (workflow_id, name)`
+	}, indexes: [][]string{{"doctype_id", "docstate_id"}}},
+	{name: "wf_workflow_node_timeouts", body: func(c schemaCols) string {
+		return c.id() + `,
+		node_id INT NOT NULL,
+		after_secs INT NOT NULL,
+		docaction_id INT NOT NULL,
+		PRIMARY KEY (id),
+		FOREIGN KEY (node_id) REFERENCES wf_workflow_nodes(id),
+		FOREIGN KEY (docaction_id) REFERENCES wf_docactions_master(id),
+		UNIQUE (node_id)`
+	}},
+	{name: "wf_messages", body: func(c schemaCols) string {
+		return c.id() + `,
+		doctype_id INT NOT NULL,
+		doc_id INT NOT NULL,
+		docevent_id INT NOT NULL,
+		workflow_id INT NOT NULL,
+		title VARCHAR(250) NOT NULL,
+		data TEXT NOT NULL,
+		PRIMARY KEY (id),
+		FOREIGN KEY (doctype_id) REFERENCES wf_doctypes_master(id),
+		FOREIGN KEY (docevent_id) REFERENCES wf_docevents(id),
+		FOREIGN KEY (workflow_id) REFERENCES wf_workflows(id),
+		UNIQUE (doctype_id, doc_id, docevent_id)`
+	}, indexes: [][]string{{"workflow_id"}}},
+	{name: "wf_mailboxes", body: func(c schemaCols) string {
+		return c.id() + `,
+		group_id INT NOT NULL,
+		message_id INT NOT NULL,
+		unread ` + c.flag() + ` NOT NULL,
+		ctime TIMESTAMP NOT NULL,
+		PRIMARY KEY (id),
+		FOREIGN KEY (group_id) REFERENCES wf_groups_master(id),
+		FOREIGN KEY (message_id) REFERENCES wf_messages(id),
+		UNIQUE (group_id, message_id)`
+	}, indexes: [][]string{{"message_id"}}},
+	{name: "wf_message_outbox", body: func(c schemaCols) string {
+		return c.id() + `,
+		group_id INT NOT NULL,
+		message_id INT NOT NULL,
+		ctime TIMESTAMP NOT NULL,
+		PRIMARY KEY (id),
+		FOREIGN KEY (group_id) REFERENCES wf_groups_master(id),
+		FOREIGN KEY (message_id) REFERENCES wf_messages(id)`
+	}, indexes: [][]string{{"message_id"}}},
+}
+
+// schemaViews holds the definitions of the views of `flow`, that
+// depend only on its own tables.
+var schemaViews = []string{`
+	CREATE OR REPLACE VIEW wf_ac_perms_v AS
+	SELECT ac_grs.ac_id, ac_grs.group_id, gu.user_id, ac_grs.role_id, rdas.doctype_id, rdas.docaction_id
+	FROM wf_ac_group_roles ac_grs
+	JOIN wf_group_users gu ON ac_grs.group_id = gu.group_id
+	JOIN wf_role_docactions rdas ON ac_grs.role_id = rdas.role_id
+	`,
+}
+
+// schemaStatements answers the statements that create the tables,
+// indexes and views of `flow` under the given dialect.  Table names
+// are unprefixed; `rebind` applies the table prefix.
+//
+// MySQL has no `CREATE INDEX IF NOT EXISTS`; its indexes are declared
+// in the tables themselves.
+func schemaStatements(d Dialect) []string {
+	c := schemaCols{d: d}
+	ary := make([]string, 0, 2*len(schemaDefs))
+	for _, t := range schemaDefs {
+		body := t.body(c)
+		idxs := make([]string, 0, len(t.indexes))
+		for _, cols := range t.indexes {
+			name := t.name + "_" + strings.Join(cols, "_") + "_idx"
+			list := strings.Join(cols, ", ")
+			if d == DialectPostgres {
+				idxs = append(idxs, "CREATE INDEX IF NOT EXISTS "+name+" ON "+t.name+" ("+list+")")
+			} else {
+				body += ",\n\t\tINDEX " + name + " (" + list + ")"
+			}
+		}
+
+		ary = append(ary, "\n\tCREATE TABLE IF NOT EXISTS "+t.name+" (\n\t\t"+body+"\n\t)\n\t")
+		ary = append(ary, idxs...)
+	}
+
+	return append(ary, schemaViews...)
+}
+
+// schemaSeeds lists the reserved rows of the master tables, in the
+// order in which they must be inserted.  The reserved child state must
+// have the ID `1`.
+var schemaSeeds = []struct {
+	table string
+	name  string
+}{
+	{"wf_docstates_master", "__RESERVED_CHILD_STATE__"},
+	{"wf_roles_master", "SUPER_ADMIN"},
+	{"wf_roles_master", "ADMIN"},
+}
+
+// CreateSchema creates the tables, indexes and views of `flow` in the
+// given database, together with their reserved rows, under the dialect
+// and the table prefix of the engine.  Tables that already exist are
+// left alone, so it is safe to call this on every start-up.
+//
+// The view `wf_users_master` is not created, since it depends on the
+// application's own users table; see `sql/wf_users_master.sql`.
+//
+// N.B. MySQL commits DDL statements implicitly.  Should this fail
+// midway, fix the cause and call it again.
+func CreateSchema(sdb *sql.DB) error {
+	return CreateSchemaContext(context.Background(), sdb)
+}
+
+// CreateSchemaContext is the same as `CreateSchema`, but runs its
+// statements under the given context.
+func CreateSchemaContext(ctx context.Context, sdb *sql.DB) error {
+	for _, q := range schemaStatements(dialect) {
+		_, err := sdb.ExecContext(ctx, rebind(q))
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, s := range schemaSeeds {
+		var n int64
+		q := `SELECT COUNT(*) FROM ` + s.table + ` WHERE name = ?`
+		err := sdb.QueryRowContext(ctx, rebind(q), s.name).Scan(&n)
+		if err != nil {
+			return err
+		}
+		if n > 0 {
+			continue
+		}
+
+		q = `INSERT INTO ` + s.table + `(name) VALUES(?)`
+		_, err = sdb.ExecContext(ctx, rebind(q), s.name)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -16,8 +16,8 @@ else
 fi
 
 # Create document-related masters.
-mysql -u $user $db < ./sql/wf_doctypes_master.sql >> err.log 2>&1
 mysql -u $user $db < ./sql/wf_docstates_master.sql >> err.log 2>&1
+mysql -u $user $db < ./sql/wf_doctypes_master.sql >> err.log 2>&1
 mysql -u $user $db < ./sql/wf_docactions_master.sql >> err.log 2>&1
 
 # Create a local users master, if in test mode.
@@ -41,7 +41,12 @@ mysql -u $user $db < ./sql/wf_documents.sql >> err.log 2>&1
 mysql -u $user $db < ./sql/wf_docstate_transitions.sql >> err.log 2>&1
 mysql -u $user $db < ./sql/wf_docevents.sql >> err.log 2>&1
 mysql -u $user $db < ./sql/wf_docevent_application.sql >> err.log 2>&1
+mysql -u $user $db < ./sql/wf_audit_log.sql >> err.log 2>&1
+mysql -u $user $db < ./sql/wf_document_active_states.sql >> err.log 2>&1
+mysql -u $user $db < ./sql/wf_document_state_history.sql >> err.log 2>&1
 mysql -u $user $db < ./sql/wf_workflows.sql >> err.log 2>&1
 mysql -u $user $db < ./sql/wf_workflow_nodes.sql >> err.log 2>&1
+mysql -u $user $db < ./sql/wf_workflow_node_timeouts.sql >> err.log 2>&1
 mysql -u $user $db < ./sql/wf_messages.sql >> err.log 2>&1
 mysql -u $user $db < ./sql/wf_mailboxes.sql >> err.log 2>&1
+mysql -u $user $db < ./sql/wf_message_outbox.sql >> err.log 2>&1
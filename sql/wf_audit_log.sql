@@ -0,0 +1,25 @@
+DROP TABLE IF EXISTS wf_audit_log;
+
+--
+
+CREATE TABLE wf_audit_log (
+    id INT NOT NULL AUTO_INCREMENT,
+    docevent_id INT NOT NULL,
+    doctype_id INT NOT NULL,
+    doc_id INT NOT NULL,
+    from_state_id INT NOT NULL,
+    to_state_id INT NOT NULL,
+    docaction_id INT NOT NULL,
+    group_id INT NOT NULL,
+    ctime TIMESTAMP NOT NULL,
+    prev_hash CHAR(64) NOT NULL,
+    hash CHAR(64) NOT NULL,
+    PRIMARY KEY (id),
+    FOREIGN KEY (docevent_id) REFERENCES wf_docevents(id),
+    FOREIGN KEY (doctype_id) REFERENCES wf_doctypes_master(id),
+    FOREIGN KEY (from_state_id) REFERENCES wf_docstates_master(id),
+    FOREIGN KEY (to_state_id) REFERENCES wf_docstates_master(id),
+    FOREIGN KEY (docaction_id) REFERENCES wf_docactions_master(id),
+    FOREIGN KEY (group_id) REFERENCES wf_groups_master(id),
+    UNIQUE (hash)
+);
@@ -11,10 +11,12 @@ CREATE TABLE wf_docevents (
     group_id INT NOT NULL,
     data TEXT,
     ctime TIMESTAMP NOT NULL,
-    status ENUM('A', 'P') NOT NULL,
+    status ENUM('A', 'P', 'C') NOT NULL,
+    idem_key VARCHAR(100) NULL,
     PRIMARY KEY (id),
     FOREIGN KEY (doctype_id) REFERENCES wf_doctypes_master(id),
     FOREIGN KEY (docstate_id) REFERENCES wf_docstates_master(id),
     FOREIGN KEY (docaction_id) REFERENCES wf_docactions_master(id),
-    FOREIGN KEY (group_id) REFERENCES wf_groups_master(id)
+    FOREIGN KEY (group_id) REFERENCES wf_groups_master(id),
+    UNIQUE (doctype_id, doc_id, idem_key)
 );
@@ -5,7 +5,9 @@ DROP TABLE IF EXISTS wf_docstates_master;
 CREATE TABLE wf_docstates_master (
     id INT NOT NULL AUTO_INCREMENT,
     name VARCHAR(100) NOT NULL,
+    parent_id INT NULL,
     PRIMARY KEY (id),
+    FOREIGN KEY (parent_id) REFERENCES wf_docstates_master(id),
     UNIQUE (name)
 );
 
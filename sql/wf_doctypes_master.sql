@@ -5,6 +5,8 @@ DROP TABLE IF EXISTS wf_doctypes_master;
 CREATE TABLE wf_doctypes_master (
     id INT NOT NULL AUTO_INCREMENT,
     name VARCHAR(100) NOT NULL,
+    cancel_state_id INT NULL,
     PRIMARY KEY (id),
+    FOREIGN KEY (cancel_state_id) REFERENCES wf_docstates_master(id),
     UNIQUE (name)
 );
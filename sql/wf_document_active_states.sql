@@ -0,0 +1,18 @@
+DROP TABLE IF EXISTS wf_document_active_states;
+
+--
+
+CREATE TABLE wf_document_active_states (
+    id INT NOT NULL AUTO_INCREMENT,
+    doctype_id INT NOT NULL,
+    doc_id INT NOT NULL,
+    fork_state_id INT NOT NULL,
+    docstate_id INT NOT NULL,
+    joined BOOLEAN NOT NULL DEFAULT FALSE,
+    ctime TIMESTAMP NOT NULL,
+    PRIMARY KEY (id),
+    FOREIGN KEY (doctype_id) REFERENCES wf_doctypes_master(id),
+    FOREIGN KEY (fork_state_id) REFERENCES wf_docstates_master(id),
+    FOREIGN KEY (docstate_id) REFERENCES wf_docstates_master(id),
+    UNIQUE (doctype_id, doc_id, docstate_id)
+);
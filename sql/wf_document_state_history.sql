@@ -0,0 +1,18 @@
+DROP TABLE IF EXISTS wf_document_state_history;
+
+--
+
+CREATE TABLE wf_document_state_history (
+    id INT NOT NULL AUTO_INCREMENT,
+    doctype_id INT NOT NULL,
+    doc_id INT NOT NULL,
+    docstate_id INT NOT NULL,
+    entered_at TIMESTAMP NOT NULL,
+    left_at TIMESTAMP NULL,
+    by_revert BOOLEAN NOT NULL DEFAULT FALSE,
+    by_reopen BOOLEAN NOT NULL DEFAULT FALSE,
+    reason TEXT NULL,
+    PRIMARY KEY (id),
+    FOREIGN KEY (doctype_id) REFERENCES wf_doctypes_master(id),
+    FOREIGN KEY (docstate_id) REFERENCES wf_docstates_master(id)
+);
@@ -3,8 +3,11 @@
 --     path VARCHAR(1000) NOT NULL,
 --     ac_id INT NOT NULL,
 --     docstate_id INT NOT NULL,
+--     wf_version INT NOT NULL DEFAULT 1,
+--     version INT NOT NULL DEFAULT 1,
 --     group_id INT NOT NULL,
 --     ctime TIMESTAMP NOT NULL,
+--     state_since TIMESTAMP NULL,
 --     title VARCHAR(250) NULL,
 --     data TEXT NOT NULL,
 --     PRIMARY KEY (id),
@@ -0,0 +1,13 @@
+DROP TABLE IF EXISTS wf_message_outbox;
+
+--
+
+CREATE TABLE wf_message_outbox (
+    id INT NOT NULL AUTO_INCREMENT,
+    group_id INT NOT NULL,
+    message_id INT NOT NULL,
+    ctime TIMESTAMP NOT NULL,
+    PRIMARY KEY (id),
+    FOREIGN KEY (group_id) REFERENCES wf_groups_master(id),
+    FOREIGN KEY (message_id) REFERENCES wf_messages(id)
+);
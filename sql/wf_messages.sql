@@ -7,10 +7,12 @@ CREATE TABLE wf_messages (
     doctype_id INT NOT NULL,
     doc_id INT NOT NULL,
     docevent_id INT NOT NULL,
+    workflow_id INT NOT NULL,
     title VARCHAR(250) NOT NULL,
     data TEXT NOT NULL,
     PRIMARY KEY (id),
     FOREIGN KEY (doctype_id) REFERENCES wf_doctypes_master(id),
     FOREIGN KEY (docevent_id) REFERENCES wf_docevents(id),
+    FOREIGN KEY (workflow_id) REFERENCES wf_workflows(id),
     UNIQUE (doctype_id, doc_id, docevent_id)
 );
@@ -0,0 +1,14 @@
+DROP TABLE IF EXISTS wf_workflow_node_timeouts;
+
+--
+
+CREATE TABLE wf_workflow_node_timeouts (
+    id INT NOT NULL AUTO_INCREMENT,
+    node_id INT NOT NULL,
+    after_secs INT NOT NULL,
+    docaction_id INT NOT NULL,
+    PRIMARY KEY (id),
+    FOREIGN KEY (node_id) REFERENCES wf_workflow_nodes(id),
+    FOREIGN KEY (docaction_id) REFERENCES wf_docactions_master(id),
+    UNIQUE (node_id)
+);
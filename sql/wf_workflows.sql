@@ -8,9 +8,10 @@ CREATE TABLE wf_workflows (
     doctype_id INT NOT NULL,
     docstate_id INT NOT NULL,
     active TINYINT(1) NOT NULL,
+    version INT NOT NULL DEFAULT 1,
     PRIMARY KEY (id),
     FOREIGN KEY (doctype_id) REFERENCES wf_doctypes_master(id),
     FOREIGN KEY (docstate_id) REFERENCES wf_docstates_master(id),
-    UNIQUE (name),
-    UNIQUE (doctype_id)
+    UNIQUE (name, version),
+    UNIQUE (doctype_id, version)
 );
@@ -0,0 +1,279 @@
+// (c) Copyright 2015-2017 JONNALAGADDA Srinivas
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package flow
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Terminate withdraws the given document from its workflow, whatever
+// its current state.  The document is moved into the cancel state of
+// its type -- see `DocTypes.SetCancelState` -- and its parallel
+// branches, if any, are ended.  The given reason is recorded in the
+// document's state history.  Events on the document are thereafter
+// refused with `ErrDocumentTerminated`.
+//
+// As with `Revert`, a termination is not an event; no event is
+// recorded, and no message is posted to mailboxes.  The given
+// recipients are informed through the registered notifiers, and
+// transition hooks are invoked with an action of `0`.
+//
+// N.B. A document terminated by mistake can be reinstated into its
+// preceding state through `Revert`.
+func (_Workflows) Terminate(otx *sql.Tx, dtype DocTypeID, doc DocumentID, reason string, recipients []GroupID) error {
+	return Workflows.TerminateContext(context.Background(), otx, dtype, doc, reason, recipients)
+}
+
+// TerminateContext is the same as `Terminate`, but runs its queries
+// under the given context.
+func (_Workflows) TerminateContext(ctx context.Context, otx *sql.Tx, dtype DocTypeID, doc DocumentID, reason string, recipients []GroupID) error {
+	reason = strings.TrimSpace(reason)
+	if reason == "" {
+		return errors.New("reason for termination should not be empty")
+	}
+
+	done, err := engine.track()
+	if err != nil {
+		return err
+	}
+	defer done()
+
+	var from, to DocStateID
+	var ob *outbox
+	err = inTx(ctx, otx, func(tx *sql.Tx) error {
+		var octx context.Context
+		octx, ob = withOutbox(ctx)
+		var err error
+		from, to, err = terminateTx(octx, tx, dtype, doc, reason, recipients)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	afterCommit(otx, func() {
+		fireHooks(ctx, []transition{{dtype, doc, from, to, 0}})
+		ob.deliver(ctx)
+	})
+	return nil
+}
+
+// terminateTx terminates the given document within the given
+// transaction, and answers the states that it moved from and to.
+func terminateTx(ctx context.Context, tx *sql.Tx, dtype DocTypeID, id DocumentID, reason string, recipients []GroupID) (DocStateID, DocStateID, error) {
+	if lockDocs {
+		err := Documents.lock(ctx, tx, dtype, id)
+		if err != nil {
+			return 0, 0, err
+		}
+	}
+	doc, err := Documents.GetContext(ctx, tx, dtype, id)
+	if err != nil {
+		return 0, 0, err
+	}
+	if doc.Path != "" {
+		return 0, 0, ErrDocumentIsChild
+	}
+	cancel, err := DocTypes.cancelState(ctx, tx, dtype)
+	if err != nil {
+		return 0, 0, err
+	}
+	if cancel == 0 {
+		return 0, 0, ErrDocTypeNoCancelState
+	}
+	if doc.State.ID == cancel {
+		return 0, 0, ErrDocumentTerminated
+	}
+	wf, err := Documents.WorkflowContext(ctx, tx, dtype, id)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	err = Documents.setState(ctx, tx, dtype, id, cancel, 0, doc.Version)
+	if err != nil {
+		return 0, 0, err
+	}
+	q := `
+	UPDATE wf_document_state_history SET reason = ?
+	WHERE doctype_id = ?
+	AND doc_id = ?
+	AND left_at IS NULL
+	`
+	_, err = tx.ExecContext(ctx, rebind(q), reason, dtype, id)
+	if err != nil {
+		return 0, 0, err
+	}
+	q = `
+	UPDATE wf_document_active_states SET joined = TRUE
+	WHERE doctype_id = ?
+	AND doc_id = ?
+	AND joined = FALSE
+	`
+	_, err = tx.ExecContext(ctx, rebind(q), dtype, id)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	enqueueNotice(ctx, doc, wf.ID, recipients, fmt.Sprintf("Terminated in state %d : %s", doc.State.ID, reason))
+	return doc.State.ID, cancel, nil
+}
+
+// Reopen revives the given document, which should have been
+// terminated, or have completed -- reached an end node of its workflow
+// -- by moving it into the given state.  The state must have a node in
+// the document's version of the workflow, and that node may not itself
+// be an end node; nor may the state be the cancel state of the
+// document's type.  The reopening is recorded in the document's state
+// history.
+//
+// As with `Revert`, a reopening is not an event; no event is
+// recorded, and no message is posted to mailboxes.  The given
+// recipients are informed through the registered notifiers, and
+// transition hooks are invoked with an action of `0`.
+func (_Workflows) Reopen(otx *sql.Tx, dtype DocTypeID, doc DocumentID, toState DocStateID, recipients []GroupID) error {
+	return Workflows.ReopenContext(context.Background(), otx, dtype, doc, toState, recipients)
+}
+
+// ReopenContext is the same as `Reopen`, but runs its queries under
+// the given context.
+func (_Workflows) ReopenContext(ctx context.Context, otx *sql.Tx, dtype DocTypeID, doc DocumentID, toState DocStateID, recipients []GroupID) error {
+	if toState <= 0 {
+		return errors.New("target state ID should be a positive integer")
+	}
+
+	done, err := engine.track()
+	if err != nil {
+		return err
+	}
+	defer done()
+
+	var from DocStateID
+	var ob *outbox
+	err = inTx(ctx, otx, func(tx *sql.Tx) error {
+		var octx context.Context
+		octx, ob = withOutbox(ctx)
+		var err error
+		from, err = reopenTx(octx, tx, dtype, doc, toState, recipients)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	afterCommit(otx, func() {
+		fireHooks(ctx, []transition{{dtype, doc, from, toState, 0}})
+		ob.deliver(ctx)
+	})
+	return nil
+}
+
+// reopenTx reopens the given document within the given transaction,
+// and answers the state that it moved from.
+func reopenTx(ctx context.Context, tx *sql.Tx, dtype DocTypeID, id DocumentID, toState DocStateID, recipients []GroupID) (DocStateID, error) {
+	if lockDocs {
+		err := Documents.lock(ctx, tx, dtype, id)
+		if err != nil {
+			return 0, err
+		}
+	}
+	doc, err := Documents.GetContext(ctx, tx, dtype, id)
+	if err != nil {
+		return 0, err
+	}
+	if doc.Path != "" {
+		return 0, ErrDocumentIsChild
+	}
+	wf, err := Documents.WorkflowContext(ctx, tx, dtype, id)
+	if err != nil {
+		return 0, err
+	}
+	if !wf.Active {
+		return 0, ErrWorkflowInactive
+	}
+	cancel, err := DocTypes.cancelState(ctx, tx, dtype)
+	if err != nil {
+		return 0, err
+	}
+
+	// The document should have ended, one way or the other.
+	ended := cancel > 0 && doc.State.ID == cancel
+	if !ended {
+		n, err := Nodes.getByWorkflowState(ctx, tx, wf.ID, doc.State.ID)
+		switch {
+		case err == nil:
+			ended = n.NodeType == NodeTypeEnd
+		case err != ErrNotFound:
+			return 0, err
+		}
+	}
+	if !ended {
+		return 0, fmt.Errorf("document %d/%d is neither terminated nor completed", dtype, id)
+	}
+
+	if toState == cancel {
+		return 0, errors.New("documents cannot be reopened into the cancel state")
+	}
+	n, err := Nodes.getByWorkflowState(ctx, tx, wf.ID, toState)
+	if err != nil {
+		if err == ErrNotFound {
+			return 0, fmt.Errorf("state %d has no node in workflow %d", toState, wf.ID)
+		}
+		return 0, err
+	}
+	if n.NodeType == NodeTypeEnd {
+		return 0, errors.New("documents cannot be reopened into an end state")
+	}
+
+	err = Documents.setState(ctx, tx, dtype, id, toState, n.AccCtx, doc.Version)
+	if err != nil {
+		return 0, err
+	}
+	q := `
+	UPDATE wf_document_state_history SET by_reopen = TRUE
+	WHERE doctype_id = ?
+	AND doc_id = ?
+	AND left_at IS NULL
+	`
+	_, err = tx.ExecContext(ctx, rebind(q), dtype, id)
+	if err != nil {
+		return 0, err
+	}
+
+	enqueueNotice(ctx, doc, wf.ID, recipients, fmt.Sprintf("Reopened from state %d into state %d.", doc.State.ID, toState))
+	return doc.State.ID, nil
+}
+
+// terminated answers `true` if the given document is in the cancel
+// state of its type.
+func terminated(ctx context.Context, tx *sql.Tx, dtype DocTypeID, id DocumentID) (bool, error) {
+	q := `
+	SELECT COUNT(*)
+	FROM ` + DocTypes.docStorName(dtype) + ` docs
+	JOIN wf_doctypes_master dtm ON dtm.cancel_state_id = docs.docstate_id
+	WHERE dtm.id = ?
+	AND docs.id = ?
+	`
+	var n int64
+	err := tx.QueryRowContext(ctx, rebind(q), dtype, id).Scan(&n)
+	if err != nil {
+		return false, err
+	}
+
+	return n > 0, nil
+}
@@ -0,0 +1,229 @@
+// (c) Copyright 2015-2017 JONNALAGADDA Srinivas
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package flow
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"sort"
+	"time"
+)
+
+// TimelineKind enumerates the kinds of entries in a document's
+// timeline.
+type TimelineKind string
+
+const (
+	// TimelineKindEvent : an event was applied to the document
+	TimelineKindEvent TimelineKind = "event"
+	// TimelineKindState : the document entered a state
+	TimelineKindState TimelineKind = "state"
+	// TimelineKindMessage : a message about the document was posted
+	TimelineKindMessage TimelineKind = "message"
+)
+
+// StateVisit records a single stay of a document in a state.
+type StateVisit struct {
+	State     DocStateID `json:"DocState"`         // State that was entered
+	EnteredAt time.Time  `json:"EnteredAt"`        // Time of entry
+	LeftAt    *time.Time `json:"LeftAt,omitempty"` // Time of leaving; absent for the current state
+	ByRevert  bool       `json:"ByRevert"`         // Was the state entered through `Revert`?
+	ByReopen  bool       `json:"ByReopen"`         // Was the state entered through `Reopen`?
+	Reason    string     `json:"Reason,omitempty"` // Reason given to `Terminate`, if the state was entered thus
+}
+
+// TimelineMessage is a message posted about a document, together with
+// the groups to whose mailboxes it was posted.
+type TimelineMessage struct {
+	Message
+	Recipients []GroupID `json:"Recipients"`
+}
+
+// TimelineEntry is a single happening in the life cycle of a
+// document.  Exactly one of `Event`, `State` and `Message` is present,
+// as indicated by `Kind`.
+type TimelineEntry struct {
+	Time    time.Time        `json:"Time"`
+	Kind    TimelineKind     `json:"Kind"`
+	Event   *DocEvent        `json:"Event,omitempty"`
+	State   *StateVisit      `json:"State,omitempty"`
+	Message *TimelineMessage `json:"Message,omitempty"`
+}
+
+// DocumentTimeline is the life cycle of a document, as answered by
+// `Timeline`.
+type DocumentTimeline struct {
+	DocType DocTypeID       `json:"DocType"`
+	DocID   DocumentID      `json:"DocID"`
+	Title   string          `json:"Title"`
+	State   DocStateID      `json:"DocState"` // Current state
+	Entries []TimelineEntry `json:"Entries"`
+}
+
+// Timeline answers the life cycle of the given document -- its state
+// history, its applied events and the messages posted about it -- in
+// chronological order, as an indented JSON document of the form of
+// `DocumentTimeline`.  An event precedes the state that it led to,
+// which in turn precedes the messages posted about it, when they
+// share a time.
+//
+// Events and messages are recorded against root documents; the
+// timeline of a child document has only its own details.
+func (_Documents) Timeline(dtype DocTypeID, doc DocumentID) ([]byte, error) {
+	return Documents.TimelineContext(context.Background(), dtype, doc)
+}
+
+// TimelineContext is the same as `Timeline`, but runs its queries
+// under the given context.
+func (_Documents) TimelineContext(ctx context.Context, dtype DocTypeID, doc DocumentID) ([]byte, error) {
+	d, err := Documents.GetContext(ctx, nil, dtype, doc)
+	if err != nil {
+		return nil, err
+	}
+	tl := &DocumentTimeline{
+		DocType: dtype,
+		DocID:   doc,
+		Title:   d.Title,
+		State:   d.State.ID,
+		Entries: []TimelineEntry{},
+	}
+
+	events, err := DocEvents.ListForDocumentContext(ctx, dtype, doc, []EventStatus{EventStatusApplied}, 0, 0)
+	if err != nil {
+		return nil, err
+	}
+	for _, ev := range events {
+		tl.Entries = append(tl.Entries, TimelineEntry{Time: ev.Ctime, Kind: TimelineKindEvent, Event: ev})
+	}
+
+	visits, err := stateVisits(ctx, dtype, doc)
+	if err != nil {
+		return nil, err
+	}
+	for _, v := range visits {
+		tl.Entries = append(tl.Entries, TimelineEntry{Time: v.EnteredAt, Kind: TimelineKindState, State: v})
+	}
+
+	msgs, err := documentMessages(ctx, dtype, doc)
+	if err != nil {
+		return nil, err
+	}
+	for _, m := range msgs {
+		tl.Entries = append(tl.Entries, TimelineEntry{Time: m.Ctime, Kind: TimelineKindMessage, Message: m})
+	}
+
+	sortTimeline(tl.Entries)
+	return json.MarshalIndent(tl, "", "  ")
+}
+
+// timelineRank orders entries of different kinds that share a time.
+var timelineRank = map[TimelineKind]int{TimelineKindEvent: 0, TimelineKindState: 1, TimelineKindMessage: 2}
+
+// sortTimeline orders the given entries chronologically.  Entries of
+// a kind retain their relative order.
+func sortTimeline(ary []TimelineEntry) {
+	sort.SliceStable(ary, func(i, j int) bool {
+		if !ary[i].Time.Equal(ary[j].Time) {
+			return ary[i].Time.Before(ary[j].Time)
+		}
+		return timelineRank[ary[i].Kind] < timelineRank[ary[j].Kind]
+	})
+}
+
+// stateVisits answers the state history of the given document, in the
+// order of entry.
+func stateVisits(ctx context.Context, dtype DocTypeID, doc DocumentID) ([]*StateVisit, error) {
+	q := `
+	SELECT docstate_id, entered_at, left_at, by_revert, by_reopen, reason
+	FROM wf_document_state_history
+	WHERE doctype_id = ?
+	AND doc_id = ?
+	ORDER BY id
+	`
+	rows, err := db.QueryContext(ctx, rebind(q), dtype, doc)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ary := make([]*StateVisit, 0, 8)
+	for rows.Next() {
+		var elem StateVisit
+		var left sql.NullTime
+		var reason sql.NullString
+		err = rows.Scan(&elem.State, &elem.EnteredAt, &left, &elem.ByRevert, &elem.ByReopen, &reason)
+		if err != nil {
+			return nil, err
+		}
+		if left.Valid {
+			elem.LeftAt = &left.Time
+		}
+		elem.Reason = reason.String
+		ary = append(ary, &elem)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return ary, nil
+}
+
+// documentMessages answers the messages posted about the given
+// document, in the order of posting.  A message is taken to have been
+// posted when it reached its first mailbox.
+func documentMessages(ctx context.Context, dtype DocTypeID, doc DocumentID) ([]*TimelineMessage, error) {
+	q := `
+	SELECT msgs.id, msgs.doctype_id, dtm.name, msgs.doc_id, msgs.docevent_id, msgs.workflow_id, de.docaction_id, msgs.title, msgs.data, mbs.group_id, mbs.ctime
+	FROM wf_messages msgs
+	JOIN wf_mailboxes mbs ON mbs.message_id = msgs.id
+	JOIN wf_doctypes_master dtm ON dtm.id = msgs.doctype_id
+	JOIN wf_docevents de ON de.id = msgs.docevent_id
+	WHERE msgs.doctype_id = ?
+	AND msgs.doc_id = ?
+	ORDER BY msgs.id, mbs.group_id
+	`
+	rows, err := db.QueryContext(ctx, rebind(q), dtype, doc)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ary := make([]*TimelineMessage, 0, 8)
+	var curr *TimelineMessage
+	for rows.Next() {
+		var elem TimelineMessage
+		var gid GroupID
+		err = rows.Scan(&elem.ID, &elem.DocType.ID, &elem.DocType.Name, &elem.DocID, &elem.Event,
+			&elem.Workflow, &elem.Action, &elem.Title, &elem.Data, &gid, &elem.Ctime)
+		if err != nil {
+			return nil, err
+		}
+		if curr == nil || curr.ID != elem.ID {
+			curr = &elem
+			curr.Recipients = []GroupID{}
+			ary = append(ary, curr)
+		} else if elem.Ctime.Before(curr.Ctime) {
+			curr.Ctime = elem.Ctime
+		}
+		curr.Recipients = append(curr.Recipients, gid)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	sort.SliceStable(ary, func(i, j int) bool { return ary[i].Ctime.Before(ary[j].Ctime) })
+	return ary, nil
+}
@@ -0,0 +1,354 @@
+// (c) Copyright 2015-2017 JONNALAGADDA Srinivas
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package flow
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"math"
+	"time"
+)
+
+// AddTimeout attaches a timeout to the given node.  A document that
+// remains in the node's state for longer than the given duration is
+// escalated by applying the given action to it, when
+// `ProcessTimeouts` next runs.  A transition upon the action must be
+// defined out of the node's state.
+//
+// A node has at most one timeout; any existing one is replaced.
+func (_Workflows) AddTimeout(otx *sql.Tx, nid NodeID, after time.Duration, action DocActionID) error {
+	return Workflows.AddTimeoutContext(context.Background(), otx, nid, after, action)
+}
+
+// AddTimeoutContext is the same as `AddTimeout`, but runs its queries
+// under the given context.
+func (_Workflows) AddTimeoutContext(ctx context.Context, otx *sql.Tx, nid NodeID, after time.Duration, action DocActionID) error {
+	if after < time.Second {
+		return errors.New("timeout should be at least one second")
+	}
+	n, err := Nodes.GetContext(ctx, nid)
+	if err != nil {
+		return err
+	}
+
+	var tx *sql.Tx
+	if otx == nil {
+		tx, err = db.BeginTx(ctx, nil)
+		if err != nil {
+			return err
+		}
+		defer tx.Rollback()
+	} else {
+		tx = otx
+	}
+
+	targets, err := actionTargets(ctx, tx, n.Wflow, n.State, action)
+	if err != nil {
+		return err
+	}
+	if len(targets) == 0 {
+		return &ErrNoTransition{State: n.State, Action: action}
+	}
+
+	q := `DELETE FROM wf_workflow_node_timeouts WHERE node_id = ?`
+	_, err = tx.ExecContext(ctx, rebind(q), nid)
+	if err != nil {
+		return err
+	}
+	q = `
+	INSERT INTO wf_workflow_node_timeouts(node_id, after_secs, docaction_id)
+	VALUES(?, ?, ?)
+	`
+	_, err = tx.ExecContext(ctx, rebind(q), nid, int64(after/time.Second), action)
+	if err != nil {
+		return err
+	}
+
+	if otx == nil {
+		err = tx.Commit()
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// stalledDoc identifies a document whose node's timeout has elapsed.
+type stalledDoc struct {
+	wid    WorkflowID
+	dtype  DocTypeID
+	state  DocStateID
+	action DocActionID
+	after  int64
+	doc    DocumentID
+	group  GroupID
+}
+
+// ProcessTimeouts escalates every document that has been in the state
+// of a node with a timeout, for longer than that timeout, as of the
+// given time.  It is meant to be called periodically by an external
+// scheduler.  The number of documents escalated is answered.
+//
+// Each escalation raises an event on behalf of the document's
+// creator, and applies it in its own transaction.  A document whose
+// escalation fails is logged and passed over, so that it does not
+// hold up the others; the failures are answered together, as
+// `*ErrEscalationFailed`, alongside the number escalated.  Only nodes
+// of active workflows are considered, and documents that have forked
+// into parallel branches are skipped.
+//
+// Times of entry into states are recorded by the application's clock,
+// in UTC; the given time is compared with them in UTC as well.
+func (_Workflows) ProcessTimeouts(now time.Time) (int, error) {
+	return Workflows.ProcessTimeoutsContext(context.Background(), now)
+}
+
+// ProcessTimeoutsContext is the same as `ProcessTimeouts`, but runs
+// its queries under the given context.
+func (_Workflows) ProcessTimeoutsContext(ctx context.Context, now time.Time) (int, error) {
+	count, _, err := processTimeouts(ctx, now, 0)
+	return count, err
+}
+
+// ProcessTimeoutsBatch is the same as `ProcessTimeouts`, except that
+// it escalates at most `batchSize` documents.  It also answers whether
+// more documents remain to be escalated, so that a scheduler can call
+// it repeatedly, until none remain.
+//
+// Documents whose escalation fails do not count towards the batch;
+// they are attempted once in each call, and are not reported as
+// remaining.
+func (_Workflows) ProcessTimeoutsBatch(now time.Time, batchSize int) (int, bool, error) {
+	return Workflows.ProcessTimeoutsBatchContext(context.Background(), now, batchSize)
+}
+
+// ProcessTimeoutsBatchContext is the same as `ProcessTimeoutsBatch`,
+// but runs its queries under the given context.
+//
+// The context is checked before each document is escalated.  Upon its
+// cancellation, documents already escalated remain so, and the count
+// of those is answered together with the context's error.
+func (_Workflows) ProcessTimeoutsBatchContext(ctx context.Context, now time.Time, batchSize int) (int, bool, error) {
+	if batchSize <= 0 {
+		return 0, false, errors.New("batch size must be a positive integer")
+	}
+
+	return processTimeouts(ctx, now, batchSize)
+}
+
+// processTimeouts escalates at most `limit` of the documents whose
+// timeouts have elapsed as of the given time; `0` means no limit.  It
+// answers the number escalated, and whether more remain.
+func processTimeouts(ctx context.Context, now time.Time, limit int) (int, bool, error) {
+	type docKey struct {
+		dtype DocTypeID
+		doc   DocumentID
+	}
+	failed := make(map[docKey]struct{})
+	var fails []EscalationFailure
+
+	count := 0
+	more := false
+	for {
+		// Documents that failed in this pass are fetched again, and
+		// passed over.  One more than needed is fetched, to learn if
+		// more remain.
+		fetch := 0
+		if limit > 0 {
+			fetch = limit - count + len(failed) + 1
+		}
+		docs, err := stalledDocs(ctx, now, fetch)
+		if err != nil {
+			return count, false, err
+		}
+
+		pending := make([]stalledDoc, 0, len(docs))
+		for _, sd := range docs {
+			if _, ok := failed[docKey{sd.dtype, sd.doc}]; !ok {
+				pending = append(pending, sd)
+			}
+		}
+		if limit > 0 && len(pending) > limit-count {
+			pending, more = pending[:limit-count], true
+		}
+		if len(pending) == 0 {
+			break
+		}
+
+		for _, sd := range pending {
+			if err = ctx.Err(); err != nil {
+				return count, true, err
+			}
+			err = escalate(ctx, sd)
+			if err != nil {
+				logger.Errorf("escalation failed for document %d/%d : %v", sd.dtype, sd.doc, err)
+				failed[docKey{sd.dtype, sd.doc}] = struct{}{}
+				fails = append(fails, EscalationFailure{DocType: sd.dtype, Doc: sd.doc, Err: err})
+				continue
+			}
+			count++
+		}
+
+		// Without a limit, every stalled document has been attempted.
+		// With one, failures leave room for others in this batch.
+		if limit == 0 || count == limit || len(fails) == 0 {
+			break
+		}
+		more = false
+	}
+
+	if len(fails) > 0 {
+		return count, more, &ErrEscalationFailed{Failures: fails}
+	}
+	return count, more, nil
+}
+
+// stalledDocs answers the documents whose timeouts have elapsed as of
+// the given time.  At most `limit` documents are answered, unless it
+// is `0`.
+func stalledDocs(ctx context.Context, now time.Time, limit int) ([]stalledDoc, error) {
+	q := `
+	SELECT wf.id, wn.doctype_id, wn.docstate_id, nt.docaction_id, nt.after_secs, wf.version
+	FROM wf_workflow_node_timeouts nt
+	JOIN wf_workflow_nodes wn ON wn.id = nt.node_id
+	JOIN wf_workflows wf ON wf.id = wn.workflow_id
+	WHERE wf.active = 1
+	ORDER BY nt.id
+	`
+	rows, err := db.QueryContext(ctx, rebind(q))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var tos []stalledDoc
+	var versions []int
+	for rows.Next() {
+		var elem stalledDoc
+		var version int
+		err = rows.Scan(&elem.wid, &elem.dtype, &elem.state, &elem.action, &elem.after, &version)
+		if err != nil {
+			return nil, err
+		}
+		tos = append(tos, elem)
+		versions = append(versions, version)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	rows.Close()
+
+	res := make([]stalledDoc, 0, 10)
+	for i, to := range tos {
+		rem := int64(math.MaxInt64)
+		if limit > 0 {
+			rem = int64(limit - len(res))
+			if rem == 0 {
+				break
+			}
+		}
+		q = `
+		SELECT docs.id, docs.group_id
+		FROM ` + DocTypes.docStorName(to.dtype) + ` docs
+		WHERE docs.docstate_id = ?
+		AND docs.wf_version = ?
+		AND docs.path = ''
+		AND docs.state_since <= ?
+		AND NOT EXISTS (
+			SELECT 1 FROM wf_document_active_states das
+			WHERE das.doctype_id = ?
+			AND das.doc_id = docs.id
+		)
+		ORDER BY docs.id
+		LIMIT ?
+		`
+		threshold := now.UTC().Add(-time.Duration(to.after) * time.Second)
+		drows, err := db.QueryContext(ctx, rebind(q), to.state, versions[i], threshold, to.dtype, rem)
+		if err != nil {
+			return nil, err
+		}
+		for drows.Next() {
+			elem := to
+			err = drows.Scan(&elem.doc, &elem.group)
+			if err != nil {
+				drows.Close()
+				return nil, err
+			}
+			res = append(res, elem)
+		}
+		err = drows.Err()
+		drows.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return res, nil
+}
+
+// escalate raises and applies the escalation event of the given
+// stalled document.
+func escalate(ctx context.Context, sd stalledDoc) error {
+	done, err := engine.track()
+	if err != nil {
+		return err
+	}
+	defer done()
+
+	w, err := Workflows.GetContext(ctx, sd.wid)
+	if err != nil {
+		return err
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	text := fmt.Sprintf("Escalated after a timeout of %s.", time.Duration(sd.after)*time.Second)
+	eid, err := DocEvents.New(tx, &DocEventsNewInput{
+		DocTypeID:   sd.dtype,
+		DocumentID:  sd.doc,
+		DocStateID:  sd.state,
+		DocActionID: sd.action,
+		GroupID:     sd.group,
+		Text:        text,
+	})
+	if err != nil {
+		return err
+	}
+	ev := &DocEvent{ID: eid, DocType: sd.dtype, DocID: sd.doc, State: sd.state, Action: sd.action,
+		Group: sd.group, Text: text, Status: EventStatusPending}
+
+	octx, ob := withOutbox(ctx)
+	nstate, err := w.applyEventTx(octx, tx, ev, nil, true)
+	if err != nil {
+		return err
+	}
+	err = tx.Commit()
+	if err != nil {
+		return err
+	}
+
+	if nstate != DocStateJoinWaiting {
+		fireHooks(ctx, []transition{{ev.DocType, ev.DocID, ev.State, nstate, ev.Action}})
+	}
+	ob.deliver(ctx)
+	return nil
+}
@@ -0,0 +1,150 @@
+// (c) Copyright 2015-2017 JONNALAGADDA Srinivas
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package flow
+
+import (
+	"context"
+	"database/sql"
+	"sync"
+)
+
+// WithTx runs the given function in a new transaction on the
+// registered database.  The transaction is committed if the function
+// answers `nil`, and rolled back otherwise.  Should the function
+// panic, the transaction is rolled back before the panic continues.
+//
+// The transaction can be passed to the methods of `flow` that accept
+// one -- `ApplyEvent`, `Documents.New`, etc. -- so that all of their
+// effects are committed, or discarded, together.  For example :
+//
+//	err := flow.WithTx(ctx, func(tx *sql.Tx) error {
+//		if _, err := wf.ApplyEventContext(ctx, tx, ev1, nil); err != nil {
+//			return err
+//		}
+//		_, err := wf.ApplyEventContext(ctx, tx, ev2, nil)
+//		return err
+//	})
+//
+// The transition hooks and notifiers of the methods run within the
+// function are collected, and invoked only once the transaction
+// commits, in order.  Should it roll back, they are discarded.  This
+// is unlike other transactions given by the caller, whose commit
+// `flow` cannot observe.
+//
+// N.B. Unlike the transactions that `flow` begins itself, this one is
+// not retried upon deadlocks; the function may have effects beyond
+// the database.
+func WithTx(ctx context.Context, fn func(tx *sql.Tx) error) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	ts := trackTx(tx, false)
+	defer func() {
+		if p := recover(); p != nil {
+			tx.Rollback()
+			ts.end(tx, false)
+			logger.Errorf("transaction rolled back : panic : %v", p)
+			panic(p)
+		}
+	}()
+
+	err = fn(tx)
+	if err != nil {
+		tx.Rollback()
+		ts.end(tx, false)
+		logger.Errorf("transaction rolled back : %v", err)
+		return err
+	}
+	err = tx.Commit()
+	ts.end(tx, err == nil)
+	return err
+}
+
+// afterCommit runs the given function once the given transaction
+// commits.  With no transaction given, the transaction was our own,
+// and has already committed.  A transaction begun through `WithTx`
+// defers the function until it commits, and discards it should it roll
+// back.  Other transactions given by the caller cannot be seen to
+// commit; for those, the function is run at once.
+func afterCommit(otx *sql.Tx, fn func()) {
+	if otx != nil && afterTx(otx, func(committed bool) {
+		if committed {
+			fn()
+		}
+	}) {
+		return
+	}
+	fn()
+}
+
+// txState is what we know of a transaction that we have begun : whether
+// it is private to a single operation, and the work to be done once it
+// ends.
+type txState struct {
+	sync.Mutex
+	owned bool                   // Begun by an operation for itself, and not given to callers
+	fns   []func(committed bool) // Run in order, once the transaction ends
+}
+
+// txStates maps the transactions that we have begun, and that are yet
+// to end, to their states.
+var txStates sync.Map
+
+// trackTx begins tracking the given transaction.
+func trackTx(tx *sql.Tx, owned bool) *txState {
+	ts := &txState{owned: owned}
+	txStates.Store(tx, ts)
+	return ts
+}
+
+// end stops tracking the given transaction, and runs the work that was
+// registered for when it ends.
+func (ts *txState) end(tx *sql.Tx, committed bool) {
+	txStates.Delete(tx)
+
+	ts.Lock()
+	fns := ts.fns
+	ts.fns = nil
+	ts.Unlock()
+
+	for _, fn := range fns {
+		fn(committed)
+	}
+}
+
+// afterTx registers the given function to be run once the given
+// transaction ends, and answers `true`.  If the transaction is not
+// tracked -- the caller began it, other than through `WithTx` -- the
+// function is not registered, and `false` is answered.
+func afterTx(tx *sql.Tx, fn func(committed bool)) bool {
+	v, ok := txStates.Load(tx)
+	if !ok {
+		return false
+	}
+
+	ts := v.(*txState)
+	ts.Lock()
+	defer ts.Unlock()
+	ts.fns = append(ts.fns, fn)
+	return true
+}
+
+// ownedTx answers `true` if the given transaction was begun by one of
+// our operations for itself, rather than given by the caller.
+func ownedTx(tx *sql.Tx) bool {
+	v, ok := txStates.Load(tx)
+	return ok && v.(*txState).owned
+}
@@ -15,6 +15,7 @@
 package flow
 
 import (
+	"context"
 	"database/sql"
 	"errors"
 	"math"
@@ -51,6 +52,12 @@ var Users _Users
 // `limit` elements.  A value of `0` for `offset` fetches from the
 // beginning, while a value of `0` for `limit` fetches until the end.
 func (_Users) List(prefix string, offset, limit int64) ([]*User, error) {
+	return Users.ListContext(context.Background(), prefix, offset, limit)
+}
+
+// ListContext is the same as `List`, but runs its queries under the
+// given context.
+func (_Users) ListContext(ctx context.Context, prefix string, offset, limit int64) ([]*User, error) {
 	if offset < 0 || limit < 0 {
 		return nil, errors.New("offset and limit must be non-negative integers")
 	}
@@ -70,7 +77,7 @@ func (_Users) List(prefix string, offset, limit int64) ([]*User, error) {
 		ORDER BY id
 		LIMIT ? OFFSET ?
 		`
-		rows, err = db.Query(q, limit, offset)
+		rows, err = db.QueryContext(ctx, rebind(q), limit, offset)
 	} else {
 		q = `
 		SELECT id, first_name, last_name, email, active
@@ -83,7 +90,7 @@ func (_Users) List(prefix string, offset, limit int64) ([]*User, error) {
 		ORDER BY id
 		LIMIT ? OFFSET ?
 		`
-		rows, err = db.Query(q, prefix+"%", prefix+"%", limit, offset)
+		rows, err = db.QueryContext(ctx, rebind(q), prefix+"%", prefix+"%", limit, offset)
 	}
 	if err != nil {
 		return nil, err
@@ -108,14 +115,23 @@ func (_Users) List(prefix string, offset, limit int64) ([]*User, error) {
 
 // Get instantiates a user instance by reading the database.
 func (_Users) Get(uid UserID) (*User, error) {
+	return Users.GetContext(context.Background(), uid)
+}
+
+// GetContext is the same as `Get`, but runs its queries under the
+// given context.
+func (_Users) GetContext(ctx context.Context, uid UserID) (*User, error) {
 	if uid <= 0 {
 		return nil, errors.New("user ID should be a positive integer")
 	}
 
 	var elem User
-	row := db.QueryRow("SELECT id, first_name, last_name, email, active FROM wf_users_master WHERE id = ?", uid)
+	row := db.QueryRowContext(ctx, rebind("SELECT id, first_name, last_name, email, active FROM wf_users_master WHERE id = ?"), uid)
 	err := row.Scan(&elem.ID, &elem.FirstName, &elem.LastName, &elem.Email, &elem.Active)
 	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrNotFound
+		}
 		return nil, err
 	}
 
@@ -125,15 +141,24 @@ func (_Users) Get(uid UserID) (*User, error) {
 // GetByEmail retrieves user information from the database, by looking
 // up the given e-mail address.
 func (_Users) GetByEmail(email string) (*User, error) {
+	return Users.GetByEmailContext(context.Background(), email)
+}
+
+// GetByEmailContext is the same as `GetByEmail`, but runs its queries
+// under the given context.
+func (_Users) GetByEmailContext(ctx context.Context, email string) (*User, error) {
 	email = strings.TrimSpace(email)
 	if email == "" {
 		return nil, errors.New("e-mail address should be non-empty")
 	}
 
 	var elem User
-	row := db.QueryRow("SELECT id, first_name, last_name, email, active FROM wf_users_master WHERE email = ?", email)
+	row := db.QueryRowContext(ctx, rebind("SELECT id, first_name, last_name, email, active FROM wf_users_master WHERE email = ?"), email)
 	err := row.Scan(&elem.ID, &elem.FirstName, &elem.LastName, &elem.Email, &elem.Active)
 	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrNotFound
+		}
 		return nil, err
 	}
 
@@ -142,7 +167,13 @@ func (_Users) GetByEmail(email string) (*User, error) {
 
 // IsActive answers `true` if the given user's account is enabled.
 func (_Users) IsActive(uid UserID) (bool, error) {
-	row := db.QueryRow("SELECT active FROM wf_users_master WHERE id = ?", uid)
+	return Users.IsActiveContext(context.Background(), uid)
+}
+
+// IsActiveContext is the same as `IsActive`, but runs its queries
+// under the given context.
+func (_Users) IsActiveContext(ctx context.Context, uid UserID) (bool, error) {
+	row := db.QueryRowContext(ctx, rebind("SELECT active FROM wf_users_master WHERE id = ?"), uid)
 	var active bool
 	err := row.Scan(&active)
 	if err != nil {
@@ -155,6 +186,12 @@ func (_Users) IsActive(uid UserID) (bool, error) {
 // GroupsOf answers a list of groups that the given user is a member
 // of.
 func (_Users) GroupsOf(uid UserID) ([]*Group, error) {
+	return Users.GroupsOfContext(context.Background(), uid)
+}
+
+// GroupsOfContext is the same as `GroupsOf`, but runs its queries
+// under the given context.
+func (_Users) GroupsOfContext(ctx context.Context, uid UserID) ([]*Group, error) {
 	q := `
 	SELECT gm.id, gm.name, gm.group_type
 	FROM wf_groups_master gm
@@ -162,7 +199,7 @@ func (_Users) GroupsOf(uid UserID) ([]*Group, error) {
 	JOIN wf_users_master um ON um.id = gus.user_id
 	WHERE um.id = ?
 	`
-	rows, err := db.Query(q, uid)
+	rows, err := db.QueryContext(ctx, rebind(q), uid)
 	if err != nil {
 		return nil, err
 	}
@@ -188,6 +225,12 @@ func (_Users) GroupsOf(uid UserID) ([]*Group, error) {
 // SingletonGroupOf answers the ID of the given user's singleton
 // group.
 func (_Users) SingletonGroupOf(uid UserID) (*Group, error) {
+	return Users.SingletonGroupOfContext(context.Background(), uid)
+}
+
+// SingletonGroupOfContext is the same as `SingletonGroupOf`, but runs
+// its queries under the given context.
+func (_Users) SingletonGroupOfContext(ctx context.Context, uid UserID) (*Group, error) {
 	q := `
 	SELECT gm.id, gm.name, gm.group_type
 	FROM wf_groups_master gm
@@ -196,7 +239,7 @@ func (_Users) SingletonGroupOf(uid UserID) (*Group, error) {
 	AND gm.group_type = 'S'
 	`
 	var elem Group
-	row := db.QueryRow(q, uid)
+	row := db.QueryRowContext(ctx, rebind(q), uid)
 	err := row.Scan(&elem.ID, &elem.Name, &elem.GroupType)
 	if err != nil {
 		return nil, err
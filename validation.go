@@ -0,0 +1,396 @@
+// (c) Copyright 2015-2017 JONNALAGADDA Srinivas
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package flow
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+)
+
+// ValidationReport holds the results of a structural check of a
+// workflow's graph.  Each list holds the document states of the
+// offending nodes, in ascending order.
+type ValidationReport struct {
+	Workflow    WorkflowID   `json:"Workflow"`    // Workflow that was checked
+	Unreachable []DocStateID `json:"Unreachable"` // Nodes that cannot be reached from the begin state
+	NoInbound   []DocStateID `json:"NoInbound"`   // Nodes, other than the beginning, with no inbound transition
+	DeadEnds    []DocStateID `json:"DeadEnds"`    // Non-end nodes with no outbound transition
+}
+
+// OK answers `true` if no problems were found in the workflow.
+func (r *ValidationReport) OK() bool {
+	return len(r.Unreachable) == 0 && len(r.NoInbound) == 0 && len(r.DeadEnds) == 0
+}
+
+// Validate checks the graph of the given workflow, as formed by its
+// nodes and transitions.  Starting from the workflow's begin state, it
+// reports nodes that cannot be reached, nodes that no transition leads
+// into, and nodes that lead nowhere, though they are not end nodes.
+//
+// N.B. Problems in the graph are answered in the report; an error is
+// answered only when the workflow's definition could not be read.
+func (_Workflows) Validate(wid WorkflowID) (*ValidationReport, error) {
+	return Workflows.ValidateContext(context.Background(), wid)
+}
+
+// ValidateContext is the same as `Validate`, but runs its queries
+// under the given context.
+func (_Workflows) ValidateContext(ctx context.Context, wid WorkflowID) (*ValidationReport, error) {
+	wf, err := Workflows.GetContext(ctx, wid)
+	if err != nil {
+		return nil, err
+	}
+	nodes, err := Nodes.ListContext(ctx, wid)
+	if err != nil {
+		return nil, err
+	}
+	edges, err := stateEdges(ctx, wid)
+	if err != nil {
+		return nil, err
+	}
+
+	rep := validateGraph(wf.BeginState.ID, nodes, edges)
+	rep.Workflow = wid
+	return rep, nil
+}
+
+// ValidateAll checks the graphs of all the workflows in the system,
+// every version included, as `Validate` does.  The reports of the
+// workflows in which problems were found are answered, keyed by the
+// workflow; those of clean workflows are omitted.
+func (_Workflows) ValidateAll() (map[WorkflowID]*ValidationReport, error) {
+	return Workflows.ValidateAllContext(context.Background())
+}
+
+// ValidateAllContext is the same as `ValidateAll`, but runs its
+// queries under the given context.
+func (_Workflows) ValidateAllContext(ctx context.Context) (map[WorkflowID]*ValidationReport, error) {
+	q := `
+	SELECT id, docstate_id
+	FROM wf_workflows
+	ORDER BY id
+	`
+	rows, err := db.QueryContext(ctx, rebind(q))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	type wfBegin struct {
+		id    WorkflowID
+		begin DocStateID
+	}
+	ary := make([]wfBegin, 0, 10)
+	for rows.Next() {
+		var elem wfBegin
+		if err = rows.Scan(&elem.id, &elem.begin); err != nil {
+			return nil, err
+		}
+		ary = append(ary, elem)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	rows.Close()
+
+	reps := make(map[WorkflowID]*ValidationReport)
+	for _, wf := range ary {
+		edges, err := stateEdges(ctx, wf.id)
+		if err != nil {
+			return nil, err
+		}
+		nodes, err := Nodes.ListContext(ctx, wf.id)
+		if err != nil {
+			return nil, err
+		}
+
+		rep := validateGraph(wf.begin, nodes, edges)
+		if rep.OK() {
+			continue
+		}
+		rep.Workflow = wf.id
+		reps[wf.id] = rep
+	}
+
+	return reps, nil
+}
+
+// stateEdges answers the transitions defined in the given workflow, as
+// a map from each source state to its target states.
+func stateEdges(ctx context.Context, wid WorkflowID) (map[DocStateID][]DocStateID, error) {
+	q := `
+	SELECT dst.from_state_id, dst.to_state_id
+	FROM wf_docstate_transitions dst
+	JOIN wf_workflows wf ON wf.doctype_id = dst.doctype_id AND wf.version = dst.version
+	WHERE wf.id = ?
+	`
+	rows, err := db.QueryContext(ctx, rebind(q), wid)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	edges := make(map[DocStateID][]DocStateID)
+	for rows.Next() {
+		var from, to DocStateID
+		err = rows.Scan(&from, &to)
+		if err != nil {
+			return nil, err
+		}
+		edges[from] = append(edges[from], to)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return edges, nil
+}
+
+// validateGraph examines the graph formed by the given nodes and
+// transitions, starting from the given begin state.
+func validateGraph(begin DocStateID, nodes []*Node, edges map[DocStateID][]DocStateID) *ValidationReport {
+	// Breadth-first traversal from the begin state.
+	seen := map[DocStateID]struct{}{begin: {}}
+	queue := []DocStateID{begin}
+	for len(queue) > 0 {
+		from := queue[0]
+		queue = queue[1:]
+		for _, to := range edges[from] {
+			if _, ok := seen[to]; ok {
+				continue
+			}
+			seen[to] = struct{}{}
+			queue = append(queue, to)
+		}
+	}
+
+	inbound := make(map[DocStateID]struct{})
+	for from, tos := range edges {
+		for _, to := range tos {
+			if to != from {
+				inbound[to] = struct{}{}
+			}
+		}
+	}
+
+	rep := &ValidationReport{
+		Unreachable: []DocStateID{},
+		NoInbound:   []DocStateID{},
+		DeadEnds:    []DocStateID{},
+	}
+	for _, n := range nodes {
+		if _, ok := seen[n.State]; !ok {
+			rep.Unreachable = append(rep.Unreachable, n.State)
+		}
+		if _, ok := inbound[n.State]; !ok && n.State != begin {
+			rep.NoInbound = append(rep.NoInbound, n.State)
+		}
+		if len(edges[n.State]) == 0 && n.NodeType != NodeTypeEnd {
+			rep.DeadEnds = append(rep.DeadEnds, n.State)
+		}
+	}
+
+	sortStates(rep.Unreachable)
+	sortStates(rep.NoInbound)
+	sortStates(rep.DeadEnds)
+	return rep
+}
+
+// sortStates sorts the given document states in ascending order.
+func sortStates(ary []DocStateID) {
+	sort.Slice(ary, func(i, j int) bool { return ary[i] < ary[j] })
+}
+
+// IsRunnable answers `true` if the given workflow can process the
+// first event on a new document : its begin state should have a node,
+// and a transition should be defined out of that state.  Otherwise,
+// the reasons are answered, in human-readable form.  Administrative
+// tools may check this before activating a workflow.
+func (_Workflows) IsRunnable(id WorkflowID) (bool, []string, error) {
+	return Workflows.IsRunnableContext(context.Background(), id)
+}
+
+// IsRunnableContext is the same as `IsRunnable`, but runs its queries
+// under the given context.
+func (_Workflows) IsRunnableContext(ctx context.Context, id WorkflowID) (bool, []string, error) {
+	wf, err := Workflows.GetContext(ctx, id)
+	if err != nil {
+		return false, nil, err
+	}
+	nodes, err := Nodes.ListContext(ctx, id)
+	if err != nil {
+		return false, nil, err
+	}
+	edges, err := stateEdges(ctx, id)
+	if err != nil {
+		return false, nil, err
+	}
+
+	reasons := runnableReasons(wf.BeginState, nodes, edges)
+	return len(reasons) == 0, reasons, nil
+}
+
+// ListRunnable answers a subset of the workflows that pass
+// `IsRunnable`, in the order of `List`.  Administrative tools may use
+// this to offer the workflows that can be activated.
+//
+// Runnability is checked in the same query that lists the workflows;
+// `offset` and `limit` apply to the runnable workflows, and behave as
+// they do in `List`.
+func (_Workflows) ListRunnable(offset, limit int64) ([]*Workflow, error) {
+	return Workflows.ListRunnableContext(context.Background(), offset, limit)
+}
+
+// ListRunnableContext is the same as `ListRunnable`, but runs its
+// queries under the given context.
+func (_Workflows) ListRunnableContext(ctx context.Context, offset, limit int64) ([]*Workflow, error) {
+	if offset < 0 || limit < 0 {
+		return nil, errors.New("offset and limit must be non-negative integers")
+	}
+	if limit == 0 {
+		limit = math.MaxInt64
+	}
+
+	q := `
+	SELECT wf.id, wf.name, dtm.id, dtm.name, dsm.id, dsm.name, wf.active, wf.version
+	FROM wf_workflows wf
+	JOIN wf_doctypes_master dtm ON wf.doctype_id = dtm.id
+	JOIN wf_docstates_master dsm ON wf.docstate_id = dsm.id
+	WHERE EXISTS (
+		SELECT 1 FROM wf_workflow_nodes wn
+		WHERE wn.workflow_id = wf.id
+		AND wn.docstate_id = wf.docstate_id
+	)
+	AND EXISTS (
+		SELECT 1 FROM wf_docstate_transitions dst
+		WHERE dst.doctype_id = wf.doctype_id
+		AND dst.version = wf.version
+		AND dst.from_state_id = wf.docstate_id
+	)
+	ORDER BY wf.id
+	LIMIT ? OFFSET ?
+	`
+	rows, err := db.QueryContext(ctx, rebind(q), limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanWorkflows(rows)
+}
+
+// runnableReasons answers why a workflow with the given begin state,
+// nodes and transitions cannot process its first event, if at all.
+func runnableReasons(begin DocState, nodes []*Node, edges map[DocStateID][]DocStateID) []string {
+	reasons := []string{}
+	found := false
+	for _, n := range nodes {
+		if n.State == begin.ID {
+			found = true
+			break
+		}
+	}
+	if !found {
+		reasons = append(reasons, fmt.Sprintf("begin state %q (%d) has no node", begin.Name, begin.ID))
+	}
+	if len(edges[begin.ID]) == 0 {
+		reasons = append(reasons, fmt.Sprintf("no transition is defined out of begin state %q (%d)", begin.Name, begin.ID))
+	}
+
+	return reasons
+}
+
+// DetectCycles answers all the simple cycles in the graph of the
+// given workflow, as formed by its transitions.  Each cycle begins
+// with its smallest state, and is closed implicitly by a transition
+// from its last state back to its first.  A state that transitions
+// into itself forms a cycle of one.
+//
+// Rework loops are often intentional; the caller should decide
+// whether each cycle found is acceptable.
+func (_Workflows) DetectCycles(wid WorkflowID) ([][]DocStateID, error) {
+	return Workflows.DetectCyclesContext(context.Background(), wid)
+}
+
+// DetectCyclesContext is the same as `DetectCycles`, but runs its
+// queries under the given context.
+func (_Workflows) DetectCyclesContext(ctx context.Context, wid WorkflowID) ([][]DocStateID, error) {
+	_, err := Workflows.GetContext(ctx, wid)
+	if err != nil {
+		return nil, err
+	}
+	edges, err := stateEdges(ctx, wid)
+	if err != nil {
+		return nil, err
+	}
+
+	return findCycles(edges), nil
+}
+
+// findCycles enumerates the simple cycles in the given graph.
+//
+// Each cycle is searched for only from its smallest state, visiting
+// only larger states thereafter, so that every cycle is reported
+// exactly once.
+func findCycles(edges map[DocStateID][]DocStateID) [][]DocStateID {
+	// Normalise adjacency : unique, sorted targets per state.
+	adj := make(map[DocStateID][]DocStateID, len(edges))
+	starts := make([]DocStateID, 0, len(edges))
+	for from, tos := range edges {
+		uniq := map[DocStateID]struct{}{}
+		ary := make([]DocStateID, 0, len(tos))
+		for _, to := range tos {
+			if _, ok := uniq[to]; !ok {
+				uniq[to] = struct{}{}
+				ary = append(ary, to)
+			}
+		}
+		sortStates(ary)
+		adj[from] = ary
+		starts = append(starts, from)
+	}
+	sortStates(starts)
+
+	res := make([][]DocStateID, 0, 2)
+	onPath := map[DocStateID]bool{}
+	path := make([]DocStateID, 0, 8)
+
+	var visit func(start, curr DocStateID)
+	visit = func(start, curr DocStateID) {
+		path = append(path, curr)
+		onPath[curr] = true
+		for _, next := range adj[curr] {
+			switch {
+			case next == start:
+				cycle := make([]DocStateID, len(path))
+				copy(cycle, path)
+				res = append(res, cycle)
+
+			case next > start && !onPath[next]:
+				visit(start, next)
+			}
+		}
+		onPath[curr] = false
+		path = path[:len(path)-1]
+	}
+
+	for _, s := range starts {
+		visit(s, s)
+	}
+	return res
+}
@@ -15,10 +15,15 @@
 package flow
 
 import (
+	"context"
 	"database/sql"
+	"encoding/json"
 	"errors"
+	"fmt"
 	"math"
+	"sort"
 	"strings"
+	"time"
 )
 
 // WorkflowID is the type of unique workflow identifiers.
@@ -38,37 +43,445 @@ type WorkflowID int64
 //
 // N.B. It is highly recommended, but not necessary, that workflow
 // names be defined in a system of hierarchical namespaces.
+//
+// Workflows are encoded in JSON with the members `id`, `name`,
+// `docType`, `beginState`, `active` and `version`.
 type Workflow struct {
-	ID         WorkflowID `json:"ID,omitempty"`     // Globally-unique identifier of this workflow
-	Name       string     `json:"Name,omitempty"`   // Globally-unique name of this workflow
-	DocType    DocType    `json:"DocType"`          // Document type of which this workflow defines the life cycle
-	BeginState DocState   `json:"BeginState"`       // Where this flow begins
-	Active     bool       `json:"Active,omitempty"` // Is this workflow enabled?
+	ID         WorkflowID // Globally-unique identifier of this workflow
+	Name       string     // Globally-unique name of this workflow
+	DocType    DocType    // Document type of which this workflow defines the life cycle
+	BeginState DocState   // Where this flow begins
+	Active     bool       // Is this workflow enabled?
+	Version    int        // Version of this definition; see `NewVersion`
+}
+
+// workflowJSON is the JSON form of a workflow.
+type workflowJSON struct {
+	ID         WorkflowID `json:"id"`
+	Name       string     `json:"name"`
+	DocType    DocType    `json:"docType"`
+	BeginState DocState   `json:"beginState"`
+	Active     bool       `json:"active"`
+	Version    int        `json:"version"`
+}
+
+// MarshalJSON encodes this workflow, with camel-cased members.
+func (w Workflow) MarshalJSON() ([]byte, error) {
+	return json.Marshal(workflowJSON(w))
+}
+
+// UnmarshalJSON decodes a workflow encoded by `MarshalJSON`.  The
+// members are matched regardless of case, so that workflows encoded
+// with the earlier members -- `ID`, `DocType`, etc. -- are decoded,
+// too.
+func (w *Workflow) UnmarshalJSON(data []byte) error {
+	var wj workflowJSON
+	err := json.Unmarshal(data, &wj)
+	if err != nil {
+		return err
+	}
+
+	*w = Workflow(wj)
+	return nil
 }
 
 // ApplyEvent takes an input user action or a system event, and
 // applies its document action to the given document.  This results in
 // a possibly new document state.  This method also prepares a message
 // that is posted to applicable mailboxes.
+//
+// The document is routed through the nodes of the version of this
+// workflow under which it was created, irrespective of the version
+// on which this method is invoked.  Events are refused with
+// `ErrWorkflowInactive` if that version has been archived or
+// otherwise deactivated.
+//
+// If permission checks are enabled through `SetPermissionChecks`,
+// events whose groups may not perform their actions are refused with
+// `*ErrPermissionDenied`.  Events on documents terminated through
+// `Terminate` are refused with `ErrDocumentTerminated`.
+//
+// An event created with an idempotency key, that has already been
+// applied, is not applied again : the state into which it originally
+// transitioned the document is answered, and no message is posted nor
+// hook fired.  Events without a key answer `ErrDocEventAlreadyApplied`
+// instead.
 func (w *Workflow) ApplyEvent(otx *sql.Tx, event *DocEvent, recipients []GroupID) (DocStateID, error) {
+	return w.ApplyEventContext(context.Background(), otx, event, recipients)
+}
+
+// ApplyEventContext is the same as `ApplyEvent`, but runs its queries
+// under the given context.
+func (w *Workflow) ApplyEventContext(ctx context.Context, otx *sql.Tx, event *DocEvent, recipients []GroupID) (DocStateID, error) {
+	nstate, _, err := w.applyEvent(ctx, otx, event, recipients, true)
+	return nstate, err
+}
+
+// ApplyEventWithMessages is the same as `ApplyEvent`, but also answers
+// the identifiers of the messages posted by the transition, in the
+// order of their posting.
+//
+// When this method manages its own transaction, the identifiers are
+// those that were committed, even if the transaction was retried.
+// When a transaction is given, they are valid only once the caller
+// commits it.
+func (w *Workflow) ApplyEventWithMessages(otx *sql.Tx, event *DocEvent, recipients []GroupID) (DocStateID, []MessageID, error) {
+	return w.ApplyEventWithMessagesContext(context.Background(), otx, event, recipients)
+}
+
+// ApplyEventWithMessagesContext is the same as
+// `ApplyEventWithMessages`, but runs its queries under the given
+// context.
+func (w *Workflow) ApplyEventWithMessagesContext(ctx context.Context, otx *sql.Tx, event *DocEvent, recipients []GroupID) (DocStateID, []MessageID, error) {
+	return w.applyEvent(ctx, otx, event, recipients, true)
+}
+
+// ApplyEventToUsers is the same as `ApplyEvent`, except that the
+// additional recipients are given as users.  Each is notified through
+// the mailbox of their singleton group.
+func (w *Workflow) ApplyEventToUsers(otx *sql.Tx, event *DocEvent, users []UserID) (DocStateID, error) {
+	return w.ApplyEventToUsersContext(context.Background(), otx, event, users)
+}
+
+// ApplyEventToUsersContext is the same as `ApplyEventToUsers`, but
+// runs its queries under the given context.
+func (w *Workflow) ApplyEventToUsersContext(ctx context.Context, otx *sql.Tx, event *DocEvent, users []UserID) (DocStateID, error) {
+	recipients := make([]GroupID, 0, len(users))
+	for _, uid := range users {
+		g, err := Users.SingletonGroupOf(uid)
+		if err != nil {
+			return 0, fmt.Errorf("singleton group of user %d : %w", uid, err)
+		}
+		recipients = append(recipients, g.ID)
+	}
+
+	nstate, _, err := w.applyEvent(ctx, otx, event, recipients, true)
+	return nstate, err
+}
+
+// ApplyEventToRoles is the same as `ApplyEvent`, except that
+// additional recipients may also be given as roles.  Each role is
+// expanded to the groups that hold it in the document's access
+// context, as assigned through `AccessContexts.AddGroupRole`.  Groups
+// reached more than once are notified only once.
+func (w *Workflow) ApplyEventToRoles(otx *sql.Tx, event *DocEvent, recipients []GroupID, roles []RoleID) (DocStateID, error) {
+	return w.ApplyEventToRolesContext(context.Background(), otx, event, recipients, roles)
+}
+
+// ApplyEventToRolesContext is the same as `ApplyEventToRoles`, but
+// runs its queries under the given context.
+func (w *Workflow) ApplyEventToRolesContext(ctx context.Context, otx *sql.Tx, event *DocEvent, recipients []GroupID, roles []RoleID) (DocStateID, error) {
+	if len(roles) > 0 {
+		doc, err := Documents.GetContext(ctx, otx, event.DocType, event.DocID)
+		if err != nil {
+			return 0, err
+		}
+		gids, err := groupsWithRoles(ctx, otx, doc.AccCtx.ID, roles)
+		if err != nil {
+			return 0, err
+		}
+
+		seen := make(map[GroupID]struct{}, len(recipients))
+		all := make([]GroupID, 0, len(recipients)+len(gids))
+		for _, gid := range recipients {
+			seen[gid] = struct{}{}
+			all = append(all, gid)
+		}
+		for _, gid := range gids {
+			if _, ok := seen[gid]; !ok {
+				all = append(all, gid)
+			}
+		}
+		recipients = all
+	}
+
+	nstate, _, err := w.applyEvent(ctx, otx, event, recipients, true)
+	return nstate, err
+}
+
+// ApplyEventNoNotify is the same as `ApplyEvent`, except that no
+// message is prepared or posted to any mailbox.  This suits system
+// transitions, such as automatic archival, that concern no one.
+func (w *Workflow) ApplyEventNoNotify(otx *sql.Tx, event *DocEvent) (DocStateID, error) {
+	return w.ApplyEventNoNotifyContext(context.Background(), otx, event)
+}
+
+// ApplyEventNoNotifyContext is the same as `ApplyEventNoNotify`, but
+// runs its queries under the given context.
+func (w *Workflow) ApplyEventNoNotifyContext(ctx context.Context, otx *sql.Tx, event *DocEvent) (DocStateID, error) {
+	nstate, _, err := w.applyEvent(ctx, otx, event, nil, false)
+	return nstate, err
+}
+
+// applyEvent applies the given event, notifying the applicable
+// mailboxes only if `notify` is `true`.  Registered hooks are fired
+// once the transition is committed.  The identifiers of the posted
+// messages are answered together with the new state.
+func (w *Workflow) applyEvent(ctx context.Context, otx *sql.Tx, event *DocEvent, recipients []GroupID, notify bool) (DocStateID, []MessageID, error) {
+	done, err := engine.track()
+	if err != nil {
+		return 0, nil, err
+	}
+	defer done()
+
+	start := time.Now()
+	defer func() { metrics.ObserveLatency(w.ID, time.Since(start)) }()
+
+	if err = w.checkEvent(event); err != nil {
+		return 0, nil, err
+	}
+
+	var nstate DocStateID
+	var ob *outbox
+	replayed := false
+	err = inTx(ctx, otx, func(tx *sql.Tx) error {
+		var octx context.Context
+		octx, ob = withOutbox(ctx)
+		var err error
+		if event.IdempotencyKey != "" {
+			nstate, replayed, err = priorResult(octx, tx, event)
+			if err != nil || replayed {
+				return err
+			}
+		}
+		nstate, err = w.applyEventTx(octx, tx, event, recipients, notify)
+		return err
+	})
+	if err != nil {
+		return 0, nil, err
+	}
+	if replayed {
+		return nstate, nil, nil
+	}
+
+	metrics.IncTransition(w.ID, event.Action)
+	afterCommit(otx, func() {
+		if nstate != DocStateJoinWaiting {
+			fireHooks(ctx, []transition{{event.DocType, event.DocID, event.State, nstate, event.Action}})
+		}
+		ob.deliver(ctx)
+	})
+	return nstate, ob.messageIDs(), nil
+}
+
+// checkEvent verifies that the given event can be applied in this
+// workflow at all, before any of its state is read.
+func (w *Workflow) checkEvent(event *DocEvent) error {
 	if !w.Active {
-		return 0, ErrWorkflowInactive
+		return ErrWorkflowInactive
+	}
+	if w.DocType.ID != event.DocType {
+		return ErrDocEventDocTypeMismatch
+	}
+
+	return nil
+}
+
+// priorResult answers the state into which the given keyed event
+// transitioned its document, if it has already been applied.  Such a
+// repeated application is thus answered its original result, with no
+// further effect.  Otherwise, `false` is answered, and the event
+// should be applied as usual.
+func priorResult(ctx context.Context, tx *sql.Tx, event *DocEvent) (DocStateID, bool, error) {
+	q := `
+	SELECT dea.to_state_id
+	FROM wf_docevents de
+	JOIN wf_docevent_application dea ON dea.docevent_id = de.id
+	WHERE de.id = ?
+	AND de.status = 'A'
+	AND de.idem_key IS NOT NULL
+	ORDER BY dea.id DESC
+	LIMIT 1
+	`
+	var ds DocStateID
+	err := tx.QueryRowContext(ctx, rebind(q), event.ID).Scan(&ds)
+	switch {
+	case err == sql.ErrNoRows:
+		return 0, false, nil
+	case err != nil:
+		return 0, false, err
+	}
+
+	return ds, true, nil
+}
+
+// applyEventTx applies the given event within the given transaction.
+func (w *Workflow) applyEventTx(ctx context.Context, tx *sql.Tx, event *DocEvent, recipients []GroupID, notify bool) (DocStateID, error) {
+	if err := w.checkEvent(event); err != nil {
+		return 0, err
 	}
 	if event.Status == EventStatusApplied {
 		return 0, ErrDocEventAlreadyApplied
 	}
-	if w.DocType.ID != event.DocType {
-		return 0, ErrDocEventDocTypeMismatch
+
+	// The event may have changed since it was read.
+	status, err := eventStatusTx(ctx, tx, event.ID)
+	if err != nil {
+		return 0, err
+	}
+	if err = status.CanChangeTo(EventStatusApplied); err != nil {
+		return 0, err
+	}
+	if lockDocs {
+		err = Documents.lock(ctx, tx, event.DocType, event.DocID)
+		if err != nil {
+			return 0, err
+		}
+	}
+	ended, err := terminated(ctx, tx, event.DocType, event.DocID)
+	if err != nil {
+		return 0, err
+	}
+	if ended {
+		return 0, ErrDocumentTerminated
+	}
+
+	if permChecksEnabled() {
+		ok, err := Workflows.CanApplyContext(ctx, tx, event, event.Group)
+		if err != nil {
+			return 0, err
+		}
+		if !ok {
+			return 0, &ErrPermissionDenied{Group: event.Group, Action: event.Action}
+		}
+	}
+
+	n, err := w.eventNode(ctx, tx, event)
+	if err != nil {
+		return 0, err
+	}
+
+	return n.applyEvent(ctx, tx, event, recipients, notify)
+}
+
+// ApplyEvents applies the given events, in order, within a single
+// transaction, and answers the resulting document state after each.
+// If any event fails, none of them is applied, and the error of the
+// failing event is answered.
+//
+// Events are applied strictly in the order given, and never
+// concurrently.  An event on a document that an earlier event in the
+// batch has already transitioned, is applied from the state that the
+// earlier event resulted in, rather than from its own recorded state.
+// This allows a known history of actions to be replayed.  The given
+// events themselves are not modified.  Once a document forks, its
+// later events in the batch are applied from their recorded states.
+//
+// The given recipients are notified of each event, as in
+// `ApplyEvent`.
+func (w *Workflow) ApplyEvents(otx *sql.Tx, events []*DocEvent, recipients []GroupID) ([]DocStateID, error) {
+	return w.ApplyEventsContext(context.Background(), otx, events, recipients)
+}
+
+// ApplyEventsContext is the same as `ApplyEvents`, but runs its
+// queries under the given context.
+func (w *Workflow) ApplyEventsContext(ctx context.Context, otx *sql.Tx, events []*DocEvent, recipients []GroupID) ([]DocStateID, error) {
+	done, err := engine.track()
+	if err != nil {
+		return nil, err
+	}
+	defer done()
+
+	type docKey struct {
+		dtype DocTypeID
+		id    DocumentID
+	}
+	var res []DocStateID
+	var ts []transition
+	var ob *outbox
+	err = inTx(ctx, otx, func(tx *sql.Tx) error {
+		last := make(map[docKey]DocStateID)
+		forked := make(map[docKey]bool)
+		var octx context.Context
+		octx, ob = withOutbox(ctx)
+
+		res = make([]DocStateID, 0, len(events))
+		ts = make([]transition, 0, len(events))
+		for _, event := range events {
+			ev := *event
+			k := docKey{ev.DocType, ev.DocID}
+			if ds, ok := last[k]; ok {
+				ev.State = ds
+			}
+
+			nstate, err := w.applyEventTx(octx, tx, &ev, recipients, true)
+			if err != nil {
+				return err
+			}
+			res = append(res, nstate)
+			if nstate != DocStateJoinWaiting {
+				ts = append(ts, transition{ev.DocType, ev.DocID, ev.State, nstate, ev.Action})
+			}
+
+			// Parallel branches carry their own recorded states.  A
+			// fork leaves its document in the fork's state; other
+			// nodes may do so, too, through a self-loop.
+			if forked[k] {
+				continue
+			}
+			if nstate == DocStateJoinWaiting {
+				forked[k] = true
+				delete(last, k)
+				continue
+			}
+			if nstate == ev.State {
+				n, err := w.eventNode(octx, tx, &ev)
+				if err != nil {
+					return err
+				}
+				if n.NodeType == NodeTypeFork {
+					forked[k] = true
+					delete(last, k)
+					continue
+				}
+			}
+			last[k] = nstate
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	afterCommit(otx, func() {
+		fireHooks(ctx, ts)
+		ob.deliver(ctx)
+	})
+	return res, nil
+}
+
+// BulkApply applies the given action to every root document of the
+// given type that is currently in the given state, within a single
+// transaction, and answers the number of documents transitioned.
+// This helps migrate documents out of a state that is being retired.
+//
+// An event is raised for each document on behalf of its creator, and
+// the given recipients are notified of each, as in `ApplyEvent`.
+// Should any transition fail, nothing is applied, and the error
+// answered is an `*ErrBulkApply` identifying the document.  Documents
+// that have forked into parallel branches are skipped.
+func (_Workflows) BulkApply(otx *sql.Tx, dtype DocTypeID, from DocStateID, action DocActionID, recipients []GroupID) (int, error) {
+	return Workflows.BulkApplyContext(context.Background(), otx, dtype, from, action, recipients)
+}
+
+// BulkApplyContext is the same as `BulkApply`, but runs its queries
+// under the given context.
+func (_Workflows) BulkApplyContext(ctx context.Context, otx *sql.Tx, dtype DocTypeID, from DocStateID, action DocActionID,
+	recipients []GroupID) (int, error) {
+	done, err := engine.track()
+	if err != nil {
+		return 0, err
 	}
+	defer done()
 
-	n, err := Nodes.GetByState(w.DocType.ID, event.State)
+	w, err := Workflows.GetByDocTypeContext(ctx, dtype)
 	if err != nil {
 		return 0, err
 	}
 
 	var tx *sql.Tx
 	if otx == nil {
-		tx, err := db.Begin()
+		tx, err = db.BeginTx(ctx, nil)
 		if err != nil {
 			return 0, err
 		}
@@ -77,49 +490,1487 @@ func (w *Workflow) ApplyEvent(otx *sql.Tx, event *DocEvent, recipients []GroupID
 		tx = otx
 	}
 
-	nstate, err := n.applyEvent(tx, event, recipients)
+	type docGroup struct {
+		doc   DocumentID
+		group GroupID
+	}
+	q := `
+	SELECT docs.id, docs.group_id
+	FROM ` + DocTypes.docStorName(dtype) + ` docs
+	WHERE docs.docstate_id = ?
+	AND docs.path = ''
+	AND NOT EXISTS (
+		SELECT 1 FROM wf_document_active_states das
+		WHERE das.doctype_id = ?
+		AND das.doc_id = docs.id
+	)
+	ORDER BY docs.id
+	`
+	rows, err := tx.QueryContext(ctx, rebind(q), from, dtype)
 	if err != nil {
 		return 0, err
 	}
+	defer rows.Close()
+	var docs []docGroup
+	for rows.Next() {
+		var elem docGroup
+		if err = rows.Scan(&elem.doc, &elem.group); err != nil {
+			return 0, err
+		}
+		docs = append(docs, elem)
+	}
+	if err = rows.Err(); err != nil {
+		return 0, err
+	}
+	rows.Close()
+
+	ts := make([]transition, 0, len(docs))
+	octx, ob := withOutbox(ctx)
+	for _, dg := range docs {
+		in := &DocEventsNewInput{
+			DocTypeID:   dtype,
+			DocumentID:  dg.doc,
+			DocStateID:  from,
+			DocActionID: action,
+			GroupID:     dg.group,
+			Text:        "Applied in bulk.",
+		}
+		eid, err := DocEvents.New(tx, in)
+		if err != nil {
+			return 0, &ErrBulkApply{Doc: dg.doc, Err: err}
+		}
+		ev := &DocEvent{ID: eid, DocType: dtype, DocID: dg.doc, State: from, Action: action,
+			Group: dg.group, Text: in.Text, Status: EventStatusPending}
+
+		nstate, err := w.applyEventTx(octx, tx, ev, recipients, true)
+		if err != nil {
+			return 0, &ErrBulkApply{Doc: dg.doc, Err: err}
+		}
+		if nstate != DocStateJoinWaiting {
+			ts = append(ts, transition{dtype, dg.doc, from, nstate, action})
+		}
+	}
+
+	if otx == nil {
+		err = tx.Commit()
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	afterCommit(otx, func() {
+		fireHooks(ctx, ts)
+		ob.deliver(ctx)
+	})
+	return len(docs), nil
+}
+
+// AvailableActions answers the actions that can be performed on the
+// given document in its current state, in ascending order.  For a
+// document that has forked, the actions of all its active branches
+// are answered.
+//
+// When a positive group is given, only those actions that the group
+// may perform, as per `CanApply`, are answered.
+func (_Workflows) AvailableActions(dtype DocTypeID, doc DocumentID, gid GroupID) ([]DocActionID, error) {
+	return Workflows.AvailableActionsContext(context.Background(), dtype, doc, gid)
+}
+
+// AvailableActionsContext is the same as `AvailableActions`, but runs
+// its queries under the given context.
+func (_Workflows) AvailableActionsContext(ctx context.Context, dtype DocTypeID, doc DocumentID, gid GroupID) ([]DocActionID, error) {
+	wf, err := Documents.WorkflowContext(ctx, nil, dtype, doc)
+	if err != nil {
+		return nil, err
+	}
+	states, err := Documents.ActiveStatesContext(ctx, dtype, doc)
+	if err != nil {
+		return nil, err
+	}
+	if len(states) == 0 {
+		state, err := Documents.CurrentStateContext(ctx, nil, dtype, doc)
+		if err != nil {
+			return nil, err
+		}
+		states = []DocStateID{state}
+	}
+
+	seen := make(map[DocActionID]struct{})
+	ary := make([]DocActionID, 0, 4)
+	for _, state := range states {
+		tmap, err := DocTypes._Transitions(ctx, wf.ID, state)
+		if err != nil {
+			return nil, err
+		}
+		for action := range tmap {
+			if _, ok := seen[action]; ok {
+				continue
+			}
+			seen[action] = struct{}{}
+
+			if gid > 0 {
+				ok, err := Workflows.CanApplyContext(ctx, nil, &DocEvent{DocType: dtype, DocID: doc, Action: action}, gid)
+				if err != nil {
+					return nil, err
+				}
+				if !ok {
+					continue
+				}
+			}
+			ary = append(ary, action)
+		}
+	}
+
+	sort.Slice(ary, func(i, j int) bool { return ary[i] < ary[j] })
+	return ary, nil
+}
+
+// Transitions answers all the state transitions defined for the
+// given document type, in the latest version of its workflow, ordered
+// by their source states, and then by their actions.  An action
+// leading into several target states answers one transition for each,
+// in the order of their definition.
+func (_Workflows) Transitions(dtype DocTypeID) ([]Transition, error) {
+	return Workflows.TransitionsContext(context.Background(), dtype)
+}
+
+// TransitionsContext is the same as `Transitions`, but runs its
+// queries under the given context.
+func (_Workflows) TransitionsContext(ctx context.Context, dtype DocTypeID) ([]Transition, error) {
+	version, err := latestVersion(ctx, nil, dtype)
+	if err != nil {
+		return nil, err
+	}
+
+	q := `
+	SELECT dst.from_state_id, dsm1.name, dst.docaction_id, dam.name, dam.reconfirm, dst.to_state_id, dsm2.name, dst.metadata
+	FROM wf_docstate_transitions dst
+	JOIN wf_docstates_master dsm1 ON dsm1.id = dst.from_state_id
+	JOIN wf_docstates_master dsm2 ON dsm2.id = dst.to_state_id
+	JOIN wf_docactions_master dam ON dam.id = dst.docaction_id
+	WHERE dst.doctype_id = ?
+	AND dst.version = ?
+	ORDER BY dst.from_state_id, dst.docaction_id, dst.id
+	`
+	rows, err := db.QueryContext(ctx, rebind(q), dtype, version)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ary := make([]Transition, 0, 10)
+	for rows.Next() {
+		var t Transition
+		var md sql.NullString
+		err = rows.Scan(&t.From.ID, &t.From.Name, &t.Upon.ID, &t.Upon.Name, &t.Upon.Reconfirm, &t.To.ID, &t.To.Name, &md)
+		if err != nil {
+			return nil, err
+		}
+		if md.Valid {
+			t.Metadata = json.RawMessage(md.String)
+		}
+		ary = append(ary, t)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return ary, nil
+}
+
+// PeekEvent answers the state into which the given event would
+// transition its document, and the groups that the workflow would
+// notify, without applying the event.  Nothing is written to the
+// database.
+//
+// Recipients that a caller would pass explicitly to `ApplyEvent` are
+// not included in the answered groups.
+func (w *Workflow) PeekEvent(event *DocEvent) (DocStateID, []GroupID, error) {
+	return w.PeekEventContext(context.Background(), event)
+}
+
+// PeekEventContext is the same as `PeekEvent`, but runs its queries
+// under the given context.
+func (w *Workflow) PeekEventContext(ctx context.Context, event *DocEvent) (DocStateID, []GroupID, error) {
+	if !w.Active {
+		return 0, nil, ErrWorkflowInactive
+	}
+	if event.Status == EventStatusApplied {
+		return 0, nil, ErrDocEventAlreadyApplied
+	}
+	if w.DocType.ID != event.DocType {
+		return 0, nil, ErrDocEventDocTypeMismatch
+	}
+
+	// Read-only, and always rolled back.
+	tx, err := db.BeginTx(ctx, &sql.TxOptions{ReadOnly: true})
+	if err != nil {
+		return 0, nil, err
+	}
+	defer tx.Rollback()
+
+	n, err := w.eventNode(ctx, tx, event)
+	if err != nil {
+		return 0, nil, err
+	}
+	p, err := n.planEvent(ctx, tx, event)
+	if err != nil {
+		return 0, nil, err
+	}
+	if p.redundant {
+		return p.tstate, []GroupID{}, ErrDocEventRedundant
+	}
+
+	recv, err := p.recipients(ctx, tx, make(map[GroupID]struct{}), event)
+	if err != nil {
+		return 0, nil, err
+	}
+	gids := make([]GroupID, 0, len(recv))
+	for gid := range recv {
+		gids = append(gids, gid)
+	}
+	sort.Slice(gids, func(i, j int) bool { return gids[i] < gids[j] })
+
+	return p.tstate, gids, nil
+}
+
+// eventNode answers the node that should handle the given event.
+//
+// The document follows the version of the workflow under which it
+// was created, which may have been archived since.
+func (w *Workflow) eventNode(ctx context.Context, tx *sql.Tx, event *DocEvent) (*Node, error) {
+	wv, err := Documents.workflowVersion(ctx, tx, event.DocType, event.DocID)
+	if err != nil {
+		return nil, err
+	}
+	var vid WorkflowID
+	var active bool
+	q := `
+	SELECT id, active
+	FROM wf_workflows
+	WHERE doctype_id = ?
+	AND version = ?
+	`
+	err = tx.QueryRowContext(ctx, rebind(q), w.DocType.ID, wv).Scan(&vid, &active)
+	if err != nil {
+		return nil, err
+	}
+	if !active {
+		return nil, ErrWorkflowInactive
+	}
+
+	return Nodes.getByWorkflowState(ctx, tx, vid, event.State)
+}
+
+// Unexported type, only for convenience methods.
+type _Workflows struct{}
+
+// Workflows provides a resource-like interface to the workflows
+// defined in the system.
+var Workflows _Workflows
+
+// New creates and initialises a workflow definition using the given
+// name, the document type whose life cycle this workflow should
+// manage, and the initial document state in which this workflow
+// begins.
+//
+// N.B.  Workflow names must be globally-unique.
+func (_Workflows) New(otx *sql.Tx, name string, dtype DocTypeID, state DocStateID) (WorkflowID, error) {
+	return Workflows.NewContext(context.Background(), otx, name, dtype, state)
+}
+
+// NewContext is the same as `New`, but runs its queries under the
+// given context.
+func (_Workflows) NewContext(ctx context.Context, otx *sql.Tx, name string, dtype DocTypeID, state DocStateID) (WorkflowID, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return 0, errors.New("name should not be empty")
+	}
+	if dtype <= 0 {
+		return 0, errors.New("document type should be a positive integer")
+	}
+	if state <= 1 {
+		return 0, errors.New("initial document state should be an integer > 1")
+	}
+
+	var id int64
+	err := inTx(ctx, otx, func(tx *sql.Tx) error {
+		q := `
+		INSERT INTO wf_workflows(name, doctype_id, docstate_id, active)
+		VALUES(?, ?, ?, 1)
+		`
+		var err error
+		id, err = execInsert(ctx, tx, q, name, dtype, state)
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return WorkflowID(id), nil
+}
+
+// List answers a subset of the workflows defined in the system,
+// according to the given specification.
+//
+// Result set begins with ID >= `offset`, and has not more than
+// `limit` elements.  A value of `0` for `offset` fetches from the
+// beginning, while a value of `0` for `limit` fetches until the end.
+func (_Workflows) List(offset, limit int64) ([]*Workflow, error) {
+	return Workflows.ListContext(context.Background(), offset, limit)
+}
+
+// ListContext is the same as `List`, but runs its queries under the
+// given context.
+func (_Workflows) ListContext(ctx context.Context, offset, limit int64) ([]*Workflow, error) {
+	if offset < 0 || limit < 0 {
+		return nil, errors.New("offset and limit must be non-negative integers")
+	}
+	if limit == 0 {
+		limit = math.MaxInt64
+	}
+
+	q := `
+	SELECT wf.id, wf.name, dtm.id, dtm.name, dsm.id, dsm.name, wf.active, wf.version
+	FROM wf_workflows wf
+	JOIN wf_doctypes_master dtm ON wf.doctype_id = dtm.id
+	JOIN wf_docstates_master dsm ON wf.docstate_id = dsm.id
+	ORDER BY wf.id
+	LIMIT ? OFFSET ?
+	`
+	rows, err := db.QueryContext(ctx, rebind(q), limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanWorkflows(rows)
+}
+
+// WorkflowOrderField enumerates the columns by which workflow
+// listings can be ordered.
+type WorkflowOrderField uint8
+
+const (
+	// WorkflowOrderByID orders workflows by their identifiers.
+	WorkflowOrderByID WorkflowOrderField = iota
+	// WorkflowOrderByName orders workflows by their names.
+	WorkflowOrderByName
+	// WorkflowOrderByDocType orders workflows by the names of their document types.
+	WorkflowOrderByDocType
+)
+
+// workflowOrderCols maps the permitted order fields to their columns.
+var workflowOrderCols = map[WorkflowOrderField]string{
+	WorkflowOrderByID:      "wf.id",
+	WorkflowOrderByName:    "wf.name",
+	WorkflowOrderByDocType: "dtm.name",
+}
+
+// ListOrdered is the same as `List`, except that the workflows are
+// ordered by the given field, descending if `desc` is `true`.  Ties
+// are broken by ascending identifiers.
+func (_Workflows) ListOrdered(orderBy WorkflowOrderField, desc bool, offset, limit int64) ([]*Workflow, error) {
+	return Workflows.ListOrderedContext(context.Background(), orderBy, desc, offset, limit)
+}
+
+// ListOrderedContext is the same as `ListOrdered`, but runs its
+// queries under the given context.
+func (_Workflows) ListOrderedContext(ctx context.Context, orderBy WorkflowOrderField, desc bool, offset, limit int64) ([]*Workflow, error) {
+	col, ok := workflowOrderCols[orderBy]
+	if !ok {
+		return nil, fmt.Errorf("unknown workflow order field : %d", orderBy)
+	}
+	if offset < 0 || limit < 0 {
+		return nil, errors.New("offset and limit must be non-negative integers")
+	}
+	if limit == 0 {
+		limit = math.MaxInt64
+	}
+
+	dir := "ASC"
+	if desc {
+		dir = "DESC"
+	}
+	q := `
+	SELECT wf.id, wf.name, dtm.id, dtm.name, dsm.id, dsm.name, wf.active, wf.version
+	FROM wf_workflows wf
+	JOIN wf_doctypes_master dtm ON wf.doctype_id = dtm.id
+	JOIN wf_docstates_master dsm ON wf.docstate_id = dsm.id
+	ORDER BY ` + col + ` ` + dir + `, wf.id
+	LIMIT ? OFFSET ?
+	`
+	rows, err := db.QueryContext(ctx, rebind(q), limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanWorkflows(rows)
+}
+
+// Iterate invokes the given function with each workflow in the
+// subset specified, in the order of `List`, reading the workflows one
+// at a time.  Iteration stops at the first error answered by the
+// function, which error is then answered.
+//
+// `offset` and `limit` behave as they do in `List`.
+func (_Workflows) Iterate(offset, limit int64, fn func(*Workflow) error) error {
+	return Workflows.IterateContext(context.Background(), offset, limit, fn)
+}
+
+// IterateContext is the same as `Iterate`, but runs its queries under
+// the given context.
+func (_Workflows) IterateContext(ctx context.Context, offset, limit int64, fn func(*Workflow) error) error {
+	if offset < 0 || limit < 0 {
+		return errors.New("offset and limit must be non-negative integers")
+	}
+	if limit == 0 {
+		limit = math.MaxInt64
+	}
+
+	q := `
+	SELECT wf.id, wf.name, dtm.id, dtm.name, dsm.id, dsm.name, wf.active, wf.version
+	FROM wf_workflows wf
+	JOIN wf_doctypes_master dtm ON wf.doctype_id = dtm.id
+	JOIN wf_docstates_master dsm ON wf.docstate_id = dsm.id
+	ORDER BY wf.id
+	LIMIT ? OFFSET ?
+	`
+	rows, err := db.QueryContext(ctx, rebind(q), limit, offset)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var elem Workflow
+		err = rows.Scan(&elem.ID, &elem.Name, &elem.DocType.ID, &elem.DocType.Name,
+			&elem.BeginState.ID, &elem.BeginState.Name, &elem.Active, &elem.Version)
+		if err != nil {
+			return err
+		}
+		err = fn(&elem)
+		if err != nil {
+			return err
+		}
+	}
+
+	return rows.Err()
+}
+
+// ListActive answers a subset of the workflows that have not been
+// archived, according to the given specification.
+//
+// `offset` and `limit` behave as they do in `List`.
+func (_Workflows) ListActive(offset, limit int64) ([]*Workflow, error) {
+	return Workflows.ListActiveContext(context.Background(), offset, limit)
+}
+
+// ListActiveContext is the same as `ListActive`, but runs its queries
+// under the given context.
+func (_Workflows) ListActiveContext(ctx context.Context, offset, limit int64) ([]*Workflow, error) {
+	if offset < 0 || limit < 0 {
+		return nil, errors.New("offset and limit must be non-negative integers")
+	}
+	if limit == 0 {
+		limit = math.MaxInt64
+	}
+
+	q := `
+	SELECT wf.id, wf.name, dtm.id, dtm.name, dsm.id, dsm.name, wf.active, wf.version
+	FROM wf_workflows wf
+	JOIN wf_doctypes_master dtm ON wf.doctype_id = dtm.id
+	JOIN wf_docstates_master dsm ON wf.docstate_id = dsm.id
+	WHERE wf.active = 1
+	ORDER BY wf.id
+	LIMIT ? OFFSET ?
+	`
+	rows, err := db.QueryContext(ctx, rebind(q), limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanWorkflows(rows)
+}
+
+// ListByNamePrefix answers a subset of the workflows whose names
+// begin with the given prefix, such as a namespace like
+// `accounting/`.  The prefix is matched literally; `%` and `_` in it
+// are not wildcards.
+//
+// `offset` and `limit` behave as they do in `List`.
+func (_Workflows) ListByNamePrefix(prefix string, offset, limit int64) ([]*Workflow, error) {
+	return Workflows.ListByNamePrefixContext(context.Background(), prefix, offset, limit)
+}
+
+// ListByNamePrefixContext is the same as `ListByNamePrefix`, but runs
+// its queries under the given context.
+func (_Workflows) ListByNamePrefixContext(ctx context.Context, prefix string, offset, limit int64) ([]*Workflow, error) {
+	if offset < 0 || limit < 0 {
+		return nil, errors.New("offset and limit must be non-negative integers")
+	}
+	if limit == 0 {
+		limit = math.MaxInt64
+	}
+
+	q := `
+	SELECT wf.id, wf.name, dtm.id, dtm.name, dsm.id, dsm.name, wf.active, wf.version
+	FROM wf_workflows wf
+	JOIN wf_doctypes_master dtm ON wf.doctype_id = dtm.id
+	JOIN wf_docstates_master dsm ON wf.docstate_id = dsm.id
+	WHERE wf.name LIKE ? ESCAPE '!'
+	ORDER BY wf.id
+	LIMIT ? OFFSET ?
+	`
+	rows, err := db.QueryContext(ctx, rebind(q), likePrefix(prefix), limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanWorkflows(rows)
+}
+
+// ListByDocType answers a subset of the workflows defined for the
+// given document type, according to the given specification.
+//
+// `offset` and `limit` behave as they do in `List`.
+func (_Workflows) ListByDocType(dtype DocTypeID, offset, limit int64) ([]*Workflow, error) {
+	return Workflows.ListByDocTypeContext(context.Background(), dtype, offset, limit)
+}
+
+// ListByDocTypeContext is the same as `ListByDocType`, but runs its
+// queries under the given context.
+func (_Workflows) ListByDocTypeContext(ctx context.Context, dtype DocTypeID, offset, limit int64) ([]*Workflow, error) {
+	if offset < 0 || limit < 0 {
+		return nil, errors.New("offset and limit must be non-negative integers")
+	}
+	if limit == 0 {
+		limit = math.MaxInt64
+	}
+
+	q := `
+	SELECT wf.id, wf.name, dtm.id, dtm.name, dsm.id, dsm.name, wf.active, wf.version
+	FROM wf_workflows wf
+	JOIN wf_doctypes_master dtm ON wf.doctype_id = dtm.id
+	JOIN wf_docstates_master dsm ON wf.docstate_id = dsm.id
+	WHERE wf.doctype_id = ?
+	ORDER BY wf.id
+	LIMIT ? OFFSET ?
+	`
+	rows, err := db.QueryContext(ctx, rebind(q), dtype, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanWorkflows(rows)
+}
+
+// scanWorkflows reads the workflows in the given result set.  The
+// columns are expected in the order used by `List`.
+func scanWorkflows(rows *sql.Rows) ([]*Workflow, error) {
+	ary := make([]*Workflow, 0, 10)
+	for rows.Next() {
+		var elem Workflow
+		err := rows.Scan(&elem.ID, &elem.Name, &elem.DocType.ID, &elem.DocType.Name,
+			&elem.BeginState.ID, &elem.BeginState.Name, &elem.Active, &elem.Version)
+		if err != nil {
+			return nil, err
+		}
+		ary = append(ary, &elem)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return ary, nil
+}
+
+// ListByBeginState answers a subset of the workflows that begin in
+// the given document state, according to the given specification.
+// All versions of a workflow are included.
+//
+// `offset` and `limit` behave as they do in `List`.
+func (_Workflows) ListByBeginState(state DocStateID, offset, limit int64) ([]*Workflow, error) {
+	return Workflows.ListByBeginStateContext(context.Background(), state, offset, limit)
+}
+
+// ListByBeginStateContext is the same as `ListByBeginState`, but runs
+// its queries under the given context.
+func (_Workflows) ListByBeginStateContext(ctx context.Context, state DocStateID, offset, limit int64) ([]*Workflow, error) {
+	if offset < 0 || limit < 0 {
+		return nil, errors.New("offset and limit must be non-negative integers")
+	}
+	if limit == 0 {
+		limit = math.MaxInt64
+	}
+
+	q := `
+	SELECT wf.id, wf.name, dtm.id, dtm.name, dsm.id, dsm.name, wf.active, wf.version
+	FROM wf_workflows wf
+	JOIN wf_doctypes_master dtm ON wf.doctype_id = dtm.id
+	JOIN wf_docstates_master dsm ON wf.docstate_id = dsm.id
+	WHERE wf.docstate_id = ?
+	ORDER BY wf.id
+	LIMIT ? OFFSET ?
+	`
+	rows, err := db.QueryContext(ctx, rebind(q), state, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanWorkflows(rows)
+}
+
+// Count answers the total number of workflows defined.
+func (_Workflows) Count() (int64, error) {
+	return Workflows.CountContext(context.Background())
+}
+
+// CountContext is the same as `Count`, but runs its queries under the
+// given context.
+func (_Workflows) CountContext(ctx context.Context) (int64, error) {
+	var n int64
+	row := db.QueryRowContext(ctx, rebind("SELECT COUNT(*) FROM wf_workflows"))
+	err := row.Scan(&n)
+	if err != nil {
+		return 0, err
+	}
+
+	return n, nil
+}
+
+// CountByDocType answers the number of workflows defined for the
+// given document type.
+func (_Workflows) CountByDocType(dtype DocTypeID) (int64, error) {
+	return Workflows.CountByDocTypeContext(context.Background(), dtype)
+}
+
+// CountByDocTypeContext is the same as `CountByDocType`, but runs its
+// queries under the given context.
+func (_Workflows) CountByDocTypeContext(ctx context.Context, dtype DocTypeID) (int64, error) {
+	var n int64
+	row := db.QueryRowContext(ctx, rebind("SELECT COUNT(*) FROM wf_workflows WHERE doctype_id = ?"), dtype)
+	err := row.Scan(&n)
+	if err != nil {
+		return 0, err
+	}
+
+	return n, nil
+}
+
+// Get retrieves the details of the requested workflow from the
+// database.
+//
+// N.B.  This method retrieves the primary information of the
+// workflow.  Information of the nodes comprising this workflow have
+// to be fetched separately.
+func (_Workflows) Get(id WorkflowID) (*Workflow, error) {
+	return Workflows.GetContext(context.Background(), id)
+}
+
+// GetContext is the same as `Get`, but runs its queries under the
+// given context.
+func (_Workflows) GetContext(ctx context.Context, id WorkflowID) (*Workflow, error) {
+	q := `
+	SELECT wf.id, wf.name, dtm.id, dtm.name, dsm.id, dsm.name, wf.active, wf.version
+	FROM wf_workflows wf
+	JOIN wf_doctypes_master dtm ON dtm.id = wf.doctype_id
+	JOIN wf_docstates_master dsm ON dsm.id = wf.docstate_id
+	WHERE wf.id = ?
+	`
+	row := db.QueryRowContext(ctx, rebind(q), id)
+	var elem Workflow
+	err := row.Scan(&elem.ID, &elem.Name, &elem.DocType.ID, &elem.DocType.Name,
+		&elem.BeginState.ID, &elem.BeginState.Name, &elem.Active, &elem.Version)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+
+	return &elem, nil
+}
+
+// GetMany retrieves the details of the requested workflows from the
+// database, in a single query, and answers them by their identifiers.
+// Repeated identifiers are fetched once.  Workflows that do not exist
+// are absent from the answer, rather than answering `ErrNotFound`.
+// An empty map is answered for no identifiers.
+//
+// N.B.  As with `Get`, only the primary information of the workflows
+// is retrieved.
+func (_Workflows) GetMany(ids []WorkflowID) (map[WorkflowID]*Workflow, error) {
+	return Workflows.GetManyContext(context.Background(), ids)
+}
+
+// GetManyContext is the same as `GetMany`, but runs its query under
+// the given context.
+func (_Workflows) GetManyContext(ctx context.Context, ids []WorkflowID) (map[WorkflowID]*Workflow, error) {
+	res := make(map[WorkflowID]*Workflow, len(ids))
+	seen := make(map[WorkflowID]struct{}, len(ids))
+	args := make([]interface{}, 0, len(ids))
+	for _, id := range ids {
+		if id <= 0 {
+			return nil, errors.New("workflow IDs should be positive integers")
+		}
+		if _, ok := seen[id]; ok {
+			continue
+		}
+		seen[id] = struct{}{}
+		args = append(args, id)
+	}
+	if len(args) == 0 {
+		return res, nil
+	}
+
+	q := `
+	SELECT wf.id, wf.name, dtm.id, dtm.name, dsm.id, dsm.name, wf.active, wf.version
+	FROM wf_workflows wf
+	JOIN wf_doctypes_master dtm ON dtm.id = wf.doctype_id
+	JOIN wf_docstates_master dsm ON dsm.id = wf.docstate_id
+	WHERE wf.id IN (?` + strings.Repeat(",?", len(args)-1) + `)
+	`
+	rows, err := db.QueryContext(ctx, rebind(q), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var elem Workflow
+		err = rows.Scan(&elem.ID, &elem.Name, &elem.DocType.ID, &elem.DocType.Name,
+			&elem.BeginState.ID, &elem.BeginState.Name, &elem.Active, &elem.Version)
+		if err != nil {
+			return nil, err
+		}
+		res[elem.ID] = &elem
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return res, nil
+}
+
+// GetByDocType retrieves the details of the requested workflow from
+// the database.
+//
+// N.B.  This method retrieves the primary information of the
+// workflow.  Information of the nodes comprising this workflow have
+// to be fetched separately.
+func (_Workflows) GetByDocType(dtid DocTypeID) (*Workflow, error) {
+	return Workflows.GetByDocTypeContext(context.Background(), dtid)
+}
+
+// GetByDocTypeContext is the same as `GetByDocType`, but runs its
+// queries under the given context.
+func (_Workflows) GetByDocTypeContext(ctx context.Context, dtid DocTypeID) (*Workflow, error) {
+	q := `
+	SELECT wf.id, wf.name, dtm.id, dtm.name, dsm.id, dsm.name, wf.active, wf.version
+	FROM wf_workflows wf
+	JOIN wf_doctypes_master dtm ON dtm.id = wf.doctype_id
+	JOIN wf_docstates_master dsm ON dsm.id = wf.docstate_id
+	WHERE wf.doctype_id = ?
+	ORDER BY wf.version DESC
+	LIMIT 1
+	`
+	row := db.QueryRowContext(ctx, rebind(q), dtid)
+	var elem Workflow
+	err := row.Scan(&elem.ID, &elem.Name, &elem.DocType.ID, &elem.DocType.Name,
+		&elem.BeginState.ID, &elem.BeginState.Name, &elem.Active, &elem.Version)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+
+	return &elem, nil
+}
+
+// GetByName retrieves the details of the requested workflow from the
+// database.  Leading and trailing whitespace in the given name is
+// ignored.  `ErrNotFound` is answered if no workflow has that name.
+//
+// N.B.  This method retrieves the primary information of the
+// workflow.  Information of the nodes comprising this workflow have
+// to be fetched separately.
+func (_Workflows) GetByName(name string) (*Workflow, error) {
+	return Workflows.GetByNameContext(context.Background(), name)
+}
+
+// GetByNameContext is the same as `GetByName`, but runs its queries
+// under the given context.
+func (_Workflows) GetByNameContext(ctx context.Context, name string) (*Workflow, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return nil, errors.New("workflow name should not be empty")
+	}
+
+	q := `
+	SELECT wf.id, wf.name, dtm.id, dtm.name, dsm.id, dsm.name, wf.active, wf.version
+	FROM wf_workflows wf
+	JOIN wf_doctypes_master dtm ON wf.doctype_id = dtm.id
+	JOIN wf_docstates_master dsm ON wf.docstate_id = dsm.id
+	WHERE wf.name = ?
+	ORDER BY wf.version DESC
+	LIMIT 1
+	`
+	row := db.QueryRowContext(ctx, rebind(q), name)
+	var elem Workflow
+	err := row.Scan(&elem.ID, &elem.Name, &elem.DocType.ID, &elem.DocType.Name,
+		&elem.BeginState.ID, &elem.BeginState.Name, &elem.Active, &elem.Version)
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &elem, nil
+}
+
+// Rename assigns a new name to the given workflow.  Leading and
+// trailing whitespace in the name is ignored.  Since workflow names
+// are unique, `ErrWorkflowNameExists` is answered if another workflow
+// already has the given name.
+func (_Workflows) Rename(otx *sql.Tx, id WorkflowID, name string) error {
+	return Workflows.RenameContext(context.Background(), otx, id, name)
+}
+
+// RenameContext is the same as `Rename`, but runs its queries under
+// the given context.
+func (_Workflows) RenameContext(ctx context.Context, otx *sql.Tx, id WorkflowID, name string) error {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return errors.New("name should be non-empty")
+	}
+
+	var tx *sql.Tx
+	var err error
+	if otx == nil {
+		tx, err = db.BeginTx(ctx, nil)
+		if err != nil {
+			return err
+		}
+		defer tx.Rollback()
+	} else {
+		tx = otx
+	}
+
+	// Names are unique across workflows; report a clash distinctly,
+	// rather than as a driver-specific constraint violation.
+	// All versions of a workflow share its name.
+	var n int64
+	q := `
+	SELECT COUNT(*) FROM wf_workflows
+	WHERE name = ?
+	AND doctype_id <> (SELECT doctype_id FROM wf_workflows WHERE id = ?)
+	`
+	err = tx.QueryRowContext(ctx, rebind(q), name, id).Scan(&n)
+	if err != nil {
+		return err
+	}
+	if n > 0 {
+		return ErrWorkflowNameExists
+	}
+
+	q = `
+	UPDATE wf_workflows SET name = ?
+	WHERE doctype_id = (SELECT doctype_id FROM (SELECT doctype_id FROM wf_workflows WHERE id = ?) AS wfv)
+	`
+	_, err = tx.ExecContext(ctx, rebind(q), name, id)
+	if err != nil {
+		return err
+	}
+
+	if otx == nil {
+		err = tx.Commit()
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Archive retires the given workflow.  An archived workflow is not
+// answered by `ListActive`, and refuses to apply events, but remains
+// retrievable by its ID, so that historical documents continue to
+// resolve.
+//
+// N.B. Archival is the same as deactivation through `SetActive`.
+func (_Workflows) Archive(otx *sql.Tx, id WorkflowID) error {
+	return Workflows.SetActiveContext(context.Background(), otx, id, false)
+}
+
+// ArchiveContext is the same as `Archive`, but runs its queries under
+// the given context.
+func (_Workflows) ArchiveContext(ctx context.Context, otx *sql.Tx, id WorkflowID) error {
+	return Workflows.SetActiveContext(ctx, otx, id, false)
+}
+
+// Unarchive restores the given archived workflow to active use.
+func (_Workflows) Unarchive(otx *sql.Tx, id WorkflowID) error {
+	return Workflows.SetActiveContext(context.Background(), otx, id, true)
+}
+
+// UnarchiveContext is the same as `Unarchive`, but runs its queries
+// under the given context.
+func (_Workflows) UnarchiveContext(ctx context.Context, otx *sql.Tx, id WorkflowID) error {
+	return Workflows.SetActiveContext(ctx, otx, id, true)
+}
+
+// SetActive sets the status of the workflow as either active or
+// inactive, helping in workflow management and deprecation.
+func (_Workflows) SetActive(otx *sql.Tx, id WorkflowID, active bool) error {
+	return Workflows.SetActiveContext(context.Background(), otx, id, active)
+}
+
+// SetActiveContext is the same as `SetActive`, but runs its queries
+// under the given context.
+func (_Workflows) SetActiveContext(ctx context.Context, otx *sql.Tx, id WorkflowID, active bool) error {
+	var tx *sql.Tx
+	var err error
+	if otx == nil {
+		tx, err = db.BeginTx(ctx, nil)
+		if err != nil {
+			return err
+		}
+		defer tx.Rollback()
+	} else {
+		tx = otx
+	}
+
+	var flag int
+	if active {
+		flag = 1
+	}
+	q := `
+	UPDATE wf_workflows SET active = ?
+	WHERE id = ?
+	`
+	_, err = tx.ExecContext(ctx, rebind(q), flag, id)
+	if err != nil {
+		return err
+	}
+
+	if otx == nil {
+		err = tx.Commit()
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// SetBeginState alters the state in which documents managed by the
+// given workflow begin.  This is allowed only until the first
+// document is created under the workflow; thereafter,
+// `ErrWorkflowHasDocuments` is answered.  The workflow should have a
+// node for the given state.
+func (_Workflows) SetBeginState(otx *sql.Tx, id WorkflowID, state DocStateID) error {
+	return Workflows.SetBeginStateContext(context.Background(), otx, id, state)
+}
+
+// SetBeginStateContext is the same as `SetBeginState`, but runs its
+// queries under the given context.
+func (_Workflows) SetBeginStateContext(ctx context.Context, otx *sql.Tx, id WorkflowID, state DocStateID) error {
+	if state <= 1 {
+		return errors.New("initial document state should be an integer > 1")
+	}
+	wf, err := Workflows.GetContext(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	var tx *sql.Tx
+	if otx == nil {
+		tx, err = db.BeginTx(ctx, nil)
+		if err != nil {
+			return err
+		}
+		defer tx.Rollback()
+	} else {
+		tx = otx
+	}
+
+	var n int64
+	q := `
+	SELECT COUNT(*) FROM wf_workflow_nodes
+	WHERE workflow_id = ?
+	AND docstate_id = ?
+	`
+	err = tx.QueryRowContext(ctx, rebind(q), id, state).Scan(&n)
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return fmt.Errorf("workflow %d has no node for document state %d", id, state)
+	}
+
+	q = `SELECT COUNT(*) FROM ` + DocTypes.docStorName(wf.DocType.ID) + ` WHERE wf_version = ?`
+	err = tx.QueryRowContext(ctx, rebind(q), wf.Version).Scan(&n)
+	if err != nil {
+		return err
+	}
+	if n > 0 {
+		return ErrWorkflowHasDocuments
+	}
+
+	q = `UPDATE wf_workflows SET docstate_id = ? WHERE id = ?`
+	_, err = tx.ExecContext(ctx, rebind(q), state, id)
+	if err != nil {
+		return err
+	}
+
+	if otx == nil {
+		err = tx.Commit()
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// AddNode maps the given document state to the specified node.  This
+// map is consulted by the workflow when performing a state transition
+// of the system.  An access context of `0` leaves the node without a
+// specific access context.
+func (_Workflows) AddNode(otx *sql.Tx, dtype DocTypeID, state DocStateID,
+	ac AccessContextID, wid WorkflowID, name string, ntype NodeType) (NodeID, error) {
+	return Workflows.AddNodeContext(context.Background(), otx, dtype, state, ac, wid, name, ntype)
+}
+
+// AddNodeContext is the same as `AddNode`, but runs its queries under
+// the given context.
+func (_Workflows) AddNodeContext(ctx context.Context, otx *sql.Tx, dtype DocTypeID, state DocStateID,
+	ac AccessContextID, wid WorkflowID, name string, ntype NodeType) (NodeID, error) {
+	// Reject bad input before beginning a transaction.
+	if _, err := checkNode(name, ntype); err != nil {
+		return 0, err
+	}
+	defer alterNodes(otx, wid)()
+
+	var id NodeID
+	err := inTx(ctx, otx, func(tx *sql.Tx) error {
+		var err error
+		id, err = addNode(ctx, tx, dtype, state, ac, wid, name, ntype)
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return id, nil
+}
+
+// addNode adds a node to the given workflow, within the given
+// transaction.  The caller should hold the cached nodes of the
+// workflow, unless it is new.
+func addNode(ctx context.Context, tx *sql.Tx, dtype DocTypeID, state DocStateID,
+	ac AccessContextID, wid WorkflowID, name string, ntype NodeType) (NodeID, error) {
+	name, err := checkNode(name, ntype)
+	if err != nil {
+		return 0, err
+	}
+
+	err = checkMasters(ctx, tx, dtype, []DocStateID{state}, nil)
+	if err != nil {
+		return 0, err
+	}
+
+	q := `
+	INSERT INTO wf_workflow_nodes(doctype_id, docstate_id, ac_id, workflow_id, name, type)
+	VALUES(?, ?, ?, ?, ?, ?)
+	`
+	acID := sql.NullInt64{Int64: int64(ac), Valid: ac > 0}
+	id, err := execInsert(ctx, tx, q, dtype, state, acID, wid, name, string(ntype))
+	if err != nil {
+		return 0, err
+	}
+
+	return NodeID(id), nil
+}
+
+// checkNode validates the given name and type of a new node, and
+// answers the name trimmed.
+func checkNode(name string, ntype NodeType) (string, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return "", errors.New("name should not be empty")
+	}
+	if !ntype.IsValid() {
+		return "", fmt.Errorf("unknown node type : %q", ntype)
+	}
+
+	return name, nil
+}
+
+// NewVersion creates the next version of the given workflow, with a
+// copy of its nodes and transitions, and answers the identifier of the
+// new version.
+//
+// The new version becomes the one that `GetByDocType`, `GetByName`
+// and `Nodes.GetByState` answer, the one under which new documents
+// are created, and the one to which `DocTypes.AddTransition` adds.
+// Documents created earlier continue to be routed through the nodes
+// and transitions of their own versions, which should thereafter be
+// left unaltered.
+func (_Workflows) NewVersion(otx *sql.Tx, id WorkflowID) (WorkflowID, error) {
+	return Workflows.NewVersionContext(context.Background(), otx, id)
+}
+
+// NewVersionContext is the same as `NewVersion`, but runs its queries
+// under the given context.
+func (_Workflows) NewVersionContext(ctx context.Context, otx *sql.Tx, id WorkflowID) (WorkflowID, error) {
+	wf, err := Workflows.GetContext(ctx, id)
+	if err != nil {
+		return 0, err
+	}
+
+	var tx *sql.Tx
+	if otx == nil {
+		tx, err = db.BeginTx(ctx, nil)
+		if err != nil {
+			return 0, err
+		}
+		defer tx.Rollback()
+	} else {
+		tx = otx
+	}
+
+	version, err := nextVersion(ctx, tx, wf.DocType.ID)
+	if err != nil {
+		return 0, err
+	}
+	nid, err := copyWorkflow(ctx, tx, wf, wf.Name, version)
+	if err != nil {
+		return 0, err
+	}
+
+	if otx == nil {
+		err = tx.Commit()
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	return nid, nil
+}
+
+// nextVersion answers the version that the next version of the
+// workflow of the given document type should have.
+func nextVersion(ctx context.Context, tx *sql.Tx, dtype DocTypeID) (int, error) {
+	var version int
+	q := `SELECT MAX(version) FROM wf_workflows WHERE doctype_id = ?`
+	err := tx.QueryRowContext(ctx, rebind(q), dtype).Scan(&version)
+	if err != nil {
+		return 0, err
+	}
+
+	return version + 1, nil
+}
+
+// copyWorkflow copies the given workflow, together with its nodes,
+// their timeouts and its transitions, into the given version under the
+// given name.  The identifier of the copy is answered.
+func copyWorkflow(ctx context.Context, tx *sql.Tx, wf *Workflow, name string, version int) (WorkflowID, error) {
+	var flag int
+	if wf.Active {
+		flag = 1
+	}
+	q := `
+	INSERT INTO wf_workflows(name, doctype_id, docstate_id, active, version)
+	VALUES(?, ?, ?, ?, ?)
+	`
+	nid, err := execInsert(ctx, tx, q, name, wf.DocType.ID, wf.BeginState.ID, flag, version)
+	if err != nil {
+		return 0, err
+	}
+
+	q = `
+	INSERT INTO wf_workflow_nodes(doctype_id, docstate_id, ac_id, workflow_id, name, type)
+	SELECT doctype_id, docstate_id, ac_id, ?, name, type
+	FROM wf_workflow_nodes
+	WHERE workflow_id = ?
+	`
+	_, err = tx.ExecContext(ctx, rebind(q), nid, wf.ID)
+	if err != nil {
+		return 0, err
+	}
+
+	// A workflow has one node per state.
+	q = `
+	INSERT INTO wf_workflow_node_timeouts(node_id, after_secs, docaction_id)
+	SELECT wn2.id, wnt.after_secs, wnt.docaction_id
+	FROM wf_workflow_node_timeouts wnt
+	JOIN wf_workflow_nodes wn1 ON wn1.id = wnt.node_id
+	JOIN wf_workflow_nodes wn2 ON wn2.docstate_id = wn1.docstate_id
+	WHERE wn1.workflow_id = ?
+	AND wn2.workflow_id = ?
+	`
+	_, err = tx.ExecContext(ctx, rebind(q), wf.ID, nid)
+	if err != nil {
+		return 0, err
+	}
+
+	q = `
+	INSERT INTO wf_docstate_transitions(doctype_id, version, from_state_id, docaction_id, to_state_id, seq, metadata)
+	SELECT doctype_id, ?, from_state_id, docaction_id, to_state_id, seq, metadata
+	FROM wf_docstate_transitions
+	WHERE doctype_id = ?
+	AND version = ?
+	ORDER BY id
+	`
+	_, err = tx.ExecContext(ctx, rebind(q), version, wf.DocType.ID, wf.Version)
+	if err != nil {
+		return 0, err
+	}
+
+	return WorkflowID(nid), nil
+}
+
+// versionOf answers the version of the given workflow, reading it in
+// the given transaction.
+func versionOf(ctx context.Context, tx *sql.Tx, wid WorkflowID) (int, error) {
+	var version int
+	q := `SELECT version FROM wf_workflows WHERE id = ?`
+	err := tx.QueryRowContext(ctx, rebind(q), wid).Scan(&version)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return 0, ErrNotFound
+		}
+		return 0, err
+	}
+
+	return version, nil
+}
+
+// MigrateDocuments moves the documents that follow one version of a
+// workflow onto another version of the same workflow -- typically, the
+// one just published with `NewVersion`.  A document is migrated only
+// when its current state, and that of each of its open branches, has a
+// node in the target version; others are skipped, and continue to
+// follow their current version.  The numbers of documents migrated and
+// skipped are answered.
+//
+// Child documents have no state of their own, and are left alone.
+func (_Workflows) MigrateDocuments(otx *sql.Tx, fromVersion, toVersion WorkflowID) (migrated, skipped int, err error) {
+	return Workflows.MigrateDocumentsContext(context.Background(), otx, fromVersion, toVersion)
+}
+
+// MigrateDocumentsContext is the same as `MigrateDocuments`, but runs
+// its queries under the given context.
+func (_Workflows) MigrateDocumentsContext(ctx context.Context, otx *sql.Tx, fromVersion, toVersion WorkflowID) (migrated, skipped int, err error) {
+	if fromVersion == toVersion {
+		return 0, 0, errors.New("source and target versions should differ")
+	}
+	src, err := Workflows.GetContext(ctx, fromVersion)
+	if err != nil {
+		return 0, 0, err
+	}
+	dst, err := Workflows.GetContext(ctx, toVersion)
+	if err != nil {
+		return 0, 0, err
+	}
+	if src.DocType.ID != dst.DocType.ID {
+		return 0, 0, errors.New("workflows should be versions of the same document type's workflow")
+	}
+
+	var tx *sql.Tx
+	if otx == nil {
+		tx, err = db.BeginTx(ctx, nil)
+		if err != nil {
+			return 0, 0, err
+		}
+		defer tx.Rollback()
+	} else {
+		tx = otx
+	}
+
+	states := make(map[DocStateID]struct{})
+	q := `SELECT docstate_id FROM wf_workflow_nodes WHERE workflow_id = ?`
+	rows, err := tx.QueryContext(ctx, rebind(q), toVersion)
+	if err != nil {
+		return 0, 0, err
+	}
+	for rows.Next() {
+		var ds DocStateID
+		if err = rows.Scan(&ds); err != nil {
+			rows.Close()
+			return 0, 0, err
+		}
+		states[ds] = struct{}{}
+	}
+	if err = rows.Err(); err != nil {
+		rows.Close()
+		return 0, 0, err
+	}
+	rows.Close()
+
+	type docState struct {
+		id    DocumentID
+		state DocStateID
+	}
+	tbl := DocTypes.docStorName(src.DocType.ID)
+	q = `SELECT id, docstate_id FROM ` + tbl + `
+	WHERE wf_version = ?
+	AND path = ''
+	ORDER BY id
+	`
+	rows, err = tx.QueryContext(ctx, rebind(q), src.Version)
+	if err != nil {
+		return 0, 0, err
+	}
+	docs := make([]docState, 0, 16)
+	for rows.Next() {
+		var elem docState
+		if err = rows.Scan(&elem.id, &elem.state); err != nil {
+			rows.Close()
+			return 0, 0, err
+		}
+		docs = append(docs, elem)
+	}
+	if err = rows.Err(); err != nil {
+		rows.Close()
+		return 0, 0, err
+	}
+	rows.Close()
+
+	// The row version is advanced, so that an event being applied
+	// concurrently under the old version fails, rather than routing
+	// the document through stale nodes.
+	q = `UPDATE ` + tbl + ` SET wf_version = ?, version = version + 1 WHERE id = ?`
+	for _, d := range docs {
+		if _, ok := states[d.state]; !ok {
+			skipped++
+			continue
+		}
+
+		branches, err := Documents.activeStates(ctx, tx, src.DocType.ID, d.id)
+		if err != nil {
+			return 0, 0, err
+		}
+		ok := true
+		for _, ds := range branches {
+			if _, ok = states[ds]; !ok {
+				break
+			}
+		}
+		if !ok {
+			skipped++
+			continue
+		}
+
+		_, err = tx.ExecContext(ctx, rebind(q), dst.Version, d.id)
+		if err != nil {
+			return 0, 0, err
+		}
+		migrated++
+	}
+
+	if otx == nil {
+		err = tx.Commit()
+		if err != nil {
+			return 0, 0, err
+		}
+	}
+
+	return migrated, skipped, nil
+}
+
+// StateHistogram answers the number of documents in each state of the
+// given workflow version, for dashboards.  Every state that has a node
+// in the workflow is present, even if no document is in it; a state
+// without a node is present only if some document still is in it.
+//
+// Only root documents that follow this version are counted; child
+// documents have no state of their own.
+func (_Workflows) StateHistogram(wid WorkflowID) (map[DocStateID]int64, error) {
+	return Workflows.StateHistogramContext(context.Background(), wid)
+}
+
+// StateHistogramContext is the same as `StateHistogram`, but runs its
+// queries under the given context.
+func (_Workflows) StateHistogramContext(ctx context.Context, wid WorkflowID) (map[DocStateID]int64, error) {
+	wf, err := Workflows.GetContext(ctx, wid)
+	if err != nil {
+		return nil, err
+	}
+	ns, err := Nodes.ListContext(ctx, wid)
+	if err != nil {
+		return nil, err
+	}
+
+	res := make(map[DocStateID]int64, len(ns))
+	for _, n := range ns {
+		res[n.State] = 0
+	}
+
+	q := `SELECT docstate_id, COUNT(*) FROM ` + DocTypes.docStorName(wf.DocType.ID) + `
+	WHERE wf_version = ?
+	AND path = ''
+	GROUP BY docstate_id
+	`
+	rows, err := db.QueryContext(ctx, rebind(q), wf.Version)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
 
-	if otx == nil {
-		err = tx.Commit()
-		if err != nil {
-			return 0, err
+	for rows.Next() {
+		var ds DocStateID
+		var n int64
+		if err = rows.Scan(&ds, &n); err != nil {
+			return nil, err
 		}
+		res[ds] = n
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
 	}
 
-	return nstate, nil
+	return res, nil
 }
 
-// Unexported type, only for convenience methods.
-type _Workflows struct{}
-
-// Workflows provides a resource-like interface to the workflows
-// defined in the system.
-var Workflows _Workflows
-
-// New creates and initialises a workflow definition using the given
-// name, the document type whose life cycle this workflow should
-// manage, and the initial document state in which this workflow
-// begins.
+// Clone creates a new workflow with the given name, as a copy of the
+// given workflow, and answers its identifier.  The workflow, its
+// nodes and their timeouts, and its transitions are all copied in a
+// single transaction.
 //
-// N.B.  Workflow names must be globally-unique.
-func (_Workflows) New(otx *sql.Tx, name string, dtype DocTypeID, state DocStateID) (WorkflowID, error) {
+// Since a document type is governed by a single workflow, the clone
+// is the next version of the workflow of the source's document type,
+// as with `NewVersion`, but under the given name.  No other workflow
+// may have that name in that version.  The clone is independent of
+// its source : altering either does not affect the other.
+func (_Workflows) Clone(otx *sql.Tx, src WorkflowID, name string) (WorkflowID, error) {
+	return Workflows.CloneContext(context.Background(), otx, src, name)
+}
+
+// CloneContext is the same as `Clone`, but runs its queries under the
+// given context.
+func (_Workflows) CloneContext(ctx context.Context, otx *sql.Tx, src WorkflowID, name string) (WorkflowID, error) {
 	name = strings.TrimSpace(name)
 	if name == "" {
 		return 0, errors.New("name should not be empty")
 	}
-	if dtype <= 0 {
-		return 0, errors.New("document type should be a positive integer")
-	}
-	if state <= 1 {
-		return 0, errors.New("initial document state should be an integer > 1")
+	wf, err := Workflows.GetContext(ctx, src)
+	if err != nil {
+		return 0, err
 	}
 
 	var tx *sql.Tx
 	if otx == nil {
-		tx, err := db.Begin()
+		tx, err = db.BeginTx(ctx, nil)
 		if err != nil {
 			return 0, err
 		}
@@ -128,15 +1979,21 @@ func (_Workflows) New(otx *sql.Tx, name string, dtype DocTypeID, state DocStateI
 		tx = otx
 	}
 
-	q := `
-	INSERT INTO wf_workflows(name, doctype_id, docstate_id, active)
-	VALUES(?, ?, ?, 1)
-	`
-	res, err := tx.Exec(q, name, dtype, state)
+	version, err := nextVersion(ctx, tx, wf.DocType.ID)
+	if err != nil {
+		return 0, err
+	}
+	var n int64
+	q := `SELECT COUNT(*) FROM wf_workflows WHERE name = ? AND version = ?`
+	err = tx.QueryRowContext(ctx, rebind(q), name, version).Scan(&n)
 	if err != nil {
 		return 0, err
 	}
-	id, err := res.LastInsertId()
+	if n > 0 {
+		return 0, ErrWorkflowNameExists
+	}
+
+	nid, err := copyWorkflow(ctx, tx, wf, name, version)
 	if err != nil {
 		return 0, err
 	}
@@ -148,172 +2005,191 @@ func (_Workflows) New(otx *sql.Tx, name string, dtype DocTypeID, state DocStateI
 		}
 	}
 
-	return WorkflowID(id), nil
+	return nid, nil
 }
 
-// List answers a subset of the workflows defined in the system,
-// according to the given specification.
-//
-// Result set begins with ID >= `offset`, and has not more than
-// `limit` elements.  A value of `0` for `offset` fetches from the
-// beginning, while a value of `0` for `limit` fetches until the end.
-func (_Workflows) List(offset, limit int64) ([]*Workflow, error) {
-	if offset < 0 || limit < 0 {
-		return nil, errors.New("offset and limit must be non-negative integers")
-	}
-	if limit == 0 {
-		limit = math.MaxInt64
-	}
+// GetNode retrieves the requested node from the database.  Its
+// routing can be examined through `Node.Transitions`.
+func (_Workflows) GetNode(id NodeID) (*Node, error) {
+	return Nodes.GetContext(context.Background(), id)
+}
 
-	q := `
-	SELECT wf.id, wf.name, dtm.id, dtm.name, dsm.id, dsm.name, wf.active
-	FROM wf_workflows wf
-	JOIN wf_doctypes_master dtm ON wf.doctype_id = dtm.id
-	JOIN wf_docstates_master dsm ON wf.docstate_id = dsm.id
-	ORDER BY wf.id
-	LIMIT ? OFFSET ?
-	`
-	rows, err := db.Query(q, limit, offset)
-	if err != nil {
-		return nil, err
-	}
-	defer rows.Close()
+// GetNodeContext is the same as `GetNode`, but runs its queries under
+// the given context.
+func (_Workflows) GetNodeContext(ctx context.Context, id NodeID) (*Node, error) {
+	return Nodes.GetContext(ctx, id)
+}
 
-	ary := make([]*Workflow, 0, 10)
-	for rows.Next() {
-		var elem Workflow
-		err = rows.Scan(&elem.ID, &elem.Name, &elem.DocType.ID, &elem.DocType.Name,
-			&elem.BeginState.ID, &elem.BeginState.Name, &elem.Active)
-		if err != nil {
-			return nil, err
-		}
-		ary = append(ary, &elem)
-	}
-	if err = rows.Err(); err != nil {
-		return nil, err
-	}
+// NodeForState answers the node that governs documents of the given
+// type in the given state, in the latest version of their workflow
+// that maps it, as `Nodes.GetByState` does.  `ErrNotFound` is answered
+// if no node is mapped to the state.
+func (_Workflows) NodeForState(dtype DocTypeID, state DocStateID) (*Node, error) {
+	return Nodes.GetByStateContext(context.Background(), dtype, state)
+}
 
-	return ary, nil
+// NodeForStateContext is the same as `NodeForState`, but runs its
+// queries under the given context.
+func (_Workflows) NodeForStateContext(ctx context.Context, dtype DocTypeID, state DocStateID) (*Node, error) {
+	return Nodes.GetByStateContext(ctx, dtype, state)
 }
 
-// Get retrieves the details of the requested workflow from the
-// database.
-//
-// N.B.  This method retrieves the primary information of the
-// workflow.  Information of the nodes comprising this workflow have
-// to be fetched separately.
-func (_Workflows) Get(id WorkflowID) (*Workflow, error) {
-	q := `
-	SELECT wf.id, wf.name, dtm.id, dtm.name, dsm.id, dsm.name, wf.active
-	FROM wf_workflows wf
-	JOIN wf_doctypes_master dtm ON dtm.id = wf.doctype_id
-	JOIN wf_docstates_master dsm ON dsm.id = wf.docstate_id
-	WHERE wf.id = ?
-	`
-	row := db.QueryRow(q, id)
-	var elem Workflow
-	err := row.Scan(&elem.ID, &elem.Name, &elem.DocType.ID, &elem.DocType.Name,
-		&elem.BeginState.ID, &elem.BeginState.Name, &elem.Active)
-	if err != nil {
-		return nil, err
+// Nodes answers the nodes comprising the given workflow, ordered by
+// their document states.
+func (_Workflows) Nodes(wid WorkflowID) ([]*Node, error) {
+	return Workflows.NodesContext(context.Background(), wid)
+}
+
+// NodesContext is the same as `Nodes`, but runs its queries under the
+// given context.
+func (_Workflows) NodesContext(ctx context.Context, wid WorkflowID) ([]*Node, error) {
+	if wid <= 0 {
+		return nil, errors.New("workflow ID must be a positive integer")
 	}
 
-	return &elem, nil
+	return Nodes.ListContext(ctx, wid)
 }
 
-// GetByDocType retrieves the details of the requested workflow from
-// the database.
-//
-// N.B.  This method retrieves the primary information of the
-// workflow.  Information of the nodes comprising this workflow have
-// to be fetched separately.
-func (_Workflows) GetByDocType(dtid DocTypeID) (*Workflow, error) {
-	q := `
-	SELECT wf.id, wf.name, dtm.id, dtm.name, dsm.id, dsm.name, wf.active
-	FROM wf_workflows wf
-	JOIN wf_doctypes_master dtm ON dtm.id = wf.doctype_id
-	JOIN wf_docstates_master dsm ON dsm.id = wf.docstate_id
-	WHERE wf.doctype_id = ?
-	`
-	row := db.QueryRow(q, dtid)
-	var elem Workflow
-	err := row.Scan(&elem.ID, &elem.Name, &elem.DocType.ID, &elem.DocType.Name,
-		&elem.BeginState.ID, &elem.BeginState.Name, &elem.Active)
+// AddNodeFull is the same as `AddNode`, but answers the new node
+// itself, rather than only its identifier.
+func (_Workflows) AddNodeFull(otx *sql.Tx, dtype DocTypeID, state DocStateID,
+	ac AccessContextID, wid WorkflowID, name string, ntype NodeType) (*Node, error) {
+	return Workflows.AddNodeFullContext(context.Background(), otx, dtype, state, ac, wid, name, ntype)
+}
+
+// AddNodeFullContext is the same as `AddNodeFull`, but runs its
+// queries under the given context.
+func (_Workflows) AddNodeFullContext(ctx context.Context, otx *sql.Tx, dtype DocTypeID, state DocStateID,
+	ac AccessContextID, wid WorkflowID, name string, ntype NodeType) (*Node, error) {
+	id, err := Workflows.AddNodeContext(ctx, otx, dtype, state, ac, wid, name, ntype)
 	if err != nil {
 		return nil, err
 	}
 
-	return &elem, nil
+	return &Node{
+		ID:       id,
+		DocType:  dtype,
+		State:    state,
+		AccCtx:   ac,
+		Wflow:    wid,
+		Name:     strings.TrimSpace(name),
+		NodeType: ntype,
+		nfunc:    defNodeFunc,
+	}, nil
 }
 
-// GetByName retrieves the details of the requested workflow from the
-// database.
-//
-// N.B.  This method retrieves the primary information of the
-// workflow.  Information of the nodes comprising this workflow have
-// to be fetched separately.
-func (_Workflows) GetByName(name string) (*Workflow, error) {
-	q := `
-	SELECT wf.id, wf.name, dtm.id, dtm.name, dsm.id, dsm.name, wf.active
-	FROM wf_workflows wf
-	JOIN wf_doctypes_master dtm ON wf.doctype_id = dtm.id
-	JOIN wf_docstates_master dsm ON wf.docstate_id = dsm.id
-	WHERE wf.name = ?
-	`
-	row := db.QueryRow(q, name)
-	var elem Workflow
-	err := row.Scan(&elem.ID, &elem.Name, &elem.DocType.ID, &elem.DocType.Name,
-		&elem.BeginState.ID, &elem.BeginState.Name, &elem.Active)
-	if err != nil {
-		return nil, err
-	}
+// NodeSpec specifies a node to be added to a workflow through
+// `AddNodes`, together with the transitions out of its state.
+type NodeSpec struct {
+	DocType     DocTypeID                  // Document type which the workflow manages
+	State       DocStateID                 // Document state handled by this node
+	AccCtx      AccessContextID            // Specific access context of this node, if any
+	Name        string                     // Unique within its workflow
+	NodeType    NodeType                   // Topology type of this node
+	Transitions map[DocActionID]DocStateID // Target states, by action, out of `State`
+}
 
-	return &elem, nil
+// AddNodes adds the given nodes, and the transitions out of their
+// states, to the specified workflow, in a single transaction.  If any
+// of them fails, none is added.  The identifiers of the new nodes are
+// answered in the order of their specifications.
+func (_Workflows) AddNodes(otx *sql.Tx, wid WorkflowID, specs []NodeSpec) ([]NodeID, error) {
+	return Workflows.AddNodesContext(context.Background(), otx, wid, specs)
 }
 
-// Rename assigns a new name to the given workflow.
-func (_Workflows) Rename(otx *sql.Tx, id WorkflowID, name string) error {
-	name = strings.TrimSpace(name)
-	if name == "" {
-		return errors.New("name should be non-empty")
-	}
+// AddNodesContext is the same as `AddNodes`, but runs its queries
+// under the given context.
+func (_Workflows) AddNodesContext(ctx context.Context, otx *sql.Tx, wid WorkflowID, specs []NodeSpec) ([]NodeID, error) {
+	defer alterNodes(otx, wid)()
 
 	var tx *sql.Tx
+	var err error
 	if otx == nil {
-		tx, err := db.Begin()
+		tx, err = db.BeginTx(ctx, nil)
 		if err != nil {
-			return err
+			return nil, err
 		}
 		defer tx.Rollback()
 	} else {
 		tx = otx
 	}
 
-	q := `
-	UPDATE wf_workflows SET name = ?
-	WHERE id = ?
-	`
-	_, err := tx.Exec(q, name, id)
+	version, err := versionOf(ctx, tx, wid)
 	if err != nil {
-		return err
+		return nil, err
+	}
+
+	ids := make([]NodeID, 0, len(specs))
+	for _, spec := range specs {
+		id, err := addNode(ctx, tx, spec.DocType, spec.State, spec.AccCtx, wid, spec.Name, spec.NodeType)
+		if err != nil {
+			return nil, fmt.Errorf("node %q : %w", spec.Name, err)
+		}
+
+		err = addTransitions(ctx, tx, spec.DocType, version, spec.State, spec.Transitions)
+		if err != nil {
+			return nil, fmt.Errorf("node %q : %w", spec.Name, err)
+		}
+
+		ids = append(ids, id)
 	}
 
 	if otx == nil {
 		err = tx.Commit()
 		if err != nil {
-			return err
+			return nil, err
 		}
 	}
 
+	return ids, nil
+}
+
+// addTransitions defines the given transitions out of the given
+// state, in the given version of the workflow, in the order of their
+// actions.  A failure is answered wrapped, naming the offending
+// transition.
+func addTransitions(ctx context.Context, tx *sql.Tx, dtype DocTypeID, version int, state DocStateID, hash map[DocActionID]DocStateID) error {
+	for _, da := range sortedActions(hash) {
+		err := addTransition(ctx, tx, dtype, version, state, da, hash[da])
+		if err != nil {
+			return fmt.Errorf("transition from state %d upon action %d into state %d : %w", state, da, hash[da], err)
+		}
+	}
 	return nil
 }
 
-// SetActive sets the status of the workflow as either active or
-// inactive, helping in workflow management and deprecation.
-func (_Workflows) SetActive(otx *sql.Tx, id WorkflowID, active bool) error {
+// sortedActions answers the actions of the given transitions in
+// ascending order, so that transitions are inserted -- and their
+// identifiers generated -- in a stable order.
+func sortedActions(hash map[DocActionID]DocStateID) []DocActionID {
+	das := make([]DocActionID, 0, len(hash))
+	for da := range hash {
+		das = append(das, da)
+	}
+	sort.Slice(das, func(i, j int) bool { return das[i] < das[j] })
+	return das
+}
+
+// UpdateNodeTransitions replaces the transitions out of the given
+// node's state with those in the given map, atomically, in the node's
+// version of its workflow.  As with `DocTypes.AddTransition`, the
+// actions and target states must be defined.
+func (_Workflows) UpdateNodeTransitions(otx *sql.Tx, nid NodeID, hash map[DocActionID]DocStateID) error {
+	return Workflows.UpdateNodeTransitionsContext(context.Background(), otx, nid, hash)
+}
+
+// UpdateNodeTransitionsContext is the same as `UpdateNodeTransitions`,
+// but runs its queries under the given context.
+func (_Workflows) UpdateNodeTransitionsContext(ctx context.Context, otx *sql.Tx, nid NodeID, hash map[DocActionID]DocStateID) error {
+	n, err := Nodes.GetContext(ctx, nid)
+	if err != nil {
+		return err
+	}
+	defer alterNodes(otx, n.Wflow)()
+
 	var tx *sql.Tx
 	if otx == nil {
-		tx, err := db.Begin()
+		tx, err = db.BeginTx(ctx, nil)
 		if err != nil {
 			return err
 		}
@@ -322,15 +2198,23 @@ func (_Workflows) SetActive(otx *sql.Tx, id WorkflowID, active bool) error {
 		tx = otx
 	}
 
-	var flag int
-	if active {
-		flag = 1
+	version, err := versionOf(ctx, tx, n.Wflow)
+	if err != nil {
+		return err
 	}
+
 	q := `
-	UPDATE wf_workflows SET active = ?
-	WHERE id = ?
+	DELETE FROM wf_docstate_transitions
+	WHERE doctype_id = ?
+	AND version = ?
+	AND from_state_id = ?
 	`
-	_, err := tx.Exec(q, flag, id)
+	_, err = tx.ExecContext(ctx, rebind(q), n.DocType, version, n.State)
+	if err != nil {
+		return err
+	}
+
+	err = addTransitions(ctx, tx, n.DocType, version, n.State, hash)
 	if err != nil {
 		return err
 	}
@@ -345,71 +2229,131 @@ func (_Workflows) SetActive(otx *sql.Tx, id WorkflowID, active bool) error {
 	return nil
 }
 
-// AddNode maps the given document state to the specified node.  This
-// map is consulted by the workflow when performing a state transition
-// of the system.
-func (_Workflows) AddNode(otx *sql.Tx, dtype DocTypeID, state DocStateID,
-	ac AccessContextID, wid WorkflowID, name string, ntype NodeType) (NodeID, error) {
-	name = strings.TrimSpace(name)
-	if name == "" {
-		return 0, errors.New("name should not be empty")
-	}
+// RemoveNode unmaps the given document state to the specified node.
+// This map is consulted by the workflow when performing a state
+// transition of the system.
+//
+// Removal is refused with `*ErrNodeInUse` if any document of this
+// version of the workflow is currently in the node's state -- either
+// as its state, or in an active parallel branch -- or if any other
+// state of this version has a transition into it.  Otherwise, the node
+// is deleted together with the transitions out of its state.
+func (_Workflows) RemoveNode(otx *sql.Tx, wid WorkflowID, nid NodeID) error {
+	return Workflows.RemoveNodeContext(context.Background(), otx, wid, nid)
+}
+
+// RemoveNodeContext is the same as `RemoveNode`, but runs its queries
+// under the given context.
+func (_Workflows) RemoveNodeContext(ctx context.Context, otx *sql.Tx, wid WorkflowID, nid NodeID) error {
+	defer alterNodes(otx, wid)()
 
 	var tx *sql.Tx
+	var err error
 	if otx == nil {
-		tx, err := db.Begin()
+		tx, err = db.BeginTx(ctx, nil)
 		if err != nil {
-			return 0, err
+			return err
 		}
 		defer tx.Rollback()
 	} else {
 		tx = otx
 	}
 
+	var dtype DocTypeID
+	var state DocStateID
+	var version int
 	q := `
-	INSERT INTO wf_workflow_nodes(doctype_id, docstate_id, ac_id, workflow_id, name, type)
-	VALUES(?, ?, ?, ?, ?)
+	SELECT wn.doctype_id, wn.docstate_id, wf.version
+	FROM wf_workflow_nodes wn
+	JOIN wf_workflows wf ON wf.id = wn.workflow_id
+	WHERE wn.workflow_id = ?
+	AND wn.id = ?
 	`
-	res, err := tx.Exec(q, dtype, state, ac, wid, name, string(ntype))
+	err = tx.QueryRowContext(ctx, rebind(q), wid, nid).Scan(&dtype, &state, &version)
 	if err != nil {
-		return 0, err
+		if err == sql.ErrNoRows {
+			return ErrNotFound
+		}
+		return err
 	}
-	id, err := res.LastInsertId()
+
+	inUse := &ErrNodeInUse{Node: nid, Sources: []DocStateID{}}
+	q = `SELECT COUNT(*) FROM ` + DocTypes.docStorName(dtype) + `
+	WHERE docstate_id = ?
+	AND wf_version = ?
+	`
+	err = tx.QueryRowContext(ctx, rebind(q), state, version).Scan(&inUse.Documents)
 	if err != nil {
-		return 0, err
+		return err
 	}
 
-	if otx == nil {
-		err = tx.Commit()
-		if err != nil {
-			return 0, err
-		}
+	// Documents that have forked are in their branches' states too.
+	q = `
+	SELECT COUNT(*)
+	FROM wf_document_active_states das
+	JOIN ` + DocTypes.docStorName(dtype) + ` docs ON docs.id = das.doc_id
+	WHERE das.doctype_id = ?
+	AND das.docstate_id = ?
+	AND das.joined = FALSE
+	AND docs.wf_version = ?
+	`
+	err = tx.QueryRowContext(ctx, rebind(q), dtype, state, version).Scan(&inUse.Branches)
+	if err != nil {
+		return err
 	}
 
-	return NodeID(id), nil
-}
-
-// RemoveNode unmaps the given document state to the specified node.
-// This map is consulted by the workflow when performing a state
-// transition of the system.
-func (_Workflows) RemoveNode(otx *sql.Tx, wid WorkflowID, nid NodeID) error {
-	var tx *sql.Tx
-	if otx == nil {
-		tx, err := db.Begin()
-		if err != nil {
+	q = `
+	SELECT DISTINCT from_state_id
+	FROM wf_docstate_transitions
+	WHERE doctype_id = ?
+	AND version = ?
+	AND to_state_id = ?
+	AND from_state_id <> ?
+	ORDER BY from_state_id
+	`
+	rows, err := tx.QueryContext(ctx, rebind(q), dtype, version, state, state)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var ds DocStateID
+		if err = rows.Scan(&ds); err != nil {
 			return err
 		}
-		defer tx.Rollback()
-	} else {
-		tx = otx
+		inUse.Sources = append(inUse.Sources, ds)
+	}
+	if err = rows.Err(); err != nil {
+		return err
 	}
+	rows.Close()
 
-	q := `
+	if inUse.Documents > 0 || inUse.Branches > 0 || len(inUse.Sources) > 0 {
+		return inUse
+	}
+
+	q = `DELETE FROM wf_workflow_node_timeouts WHERE node_id = ?`
+	_, err = tx.ExecContext(ctx, rebind(q), nid)
+	if err != nil {
+		return err
+	}
+	q = `
 	DELETE FROM wf_workflow_nodes
 	WHERE workflow_id = ?
 	AND id = ?
 	`
-	_, err := tx.Exec(q, wid, nid)
+	_, err = tx.ExecContext(ctx, rebind(q), wid, nid)
+	if err != nil {
+		return err
+	}
+
+	q = `
+	DELETE FROM wf_docstate_transitions
+	WHERE doctype_id = ?
+	AND version = ?
+	AND from_state_id = ?
+	`
+	_, err = tx.ExecContext(ctx, rebind(q), dtype, version, state)
 	if err != nil {
 		return err
 	}